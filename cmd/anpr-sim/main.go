@@ -0,0 +1,110 @@
+// Command anpr-sim replays recorded ANPR camera reads against a running
+// server, for trying the camera ingest locally.
+//
+//	go run ./cmd/anpr-sim -key anpr_... -file cmd/anpr-sim/testdata/reads.jsonl
+//
+// Each line of the file is a read with its offset in seconds from the start
+// of the recording. Reads are sent with capturedAt set to now plus that
+// offset divided by -speed; -speed 0 sends them back to back.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+type recordedRead struct {
+	OffsetSeconds float64 `json:"offsetSeconds"`
+	Plate         string  `json:"plate"`
+	Confidence    float64 `json:"confidence"`
+	ImageRef      string  `json:"imageRef"`
+	Direction     string  `json:"direction"`
+}
+
+func main() {
+	url := flag.String("url", "http://localhost:8080", "server base URL")
+	key := flag.String("key", os.Getenv("ANPR_API_KEY"), "camera API key (default $ANPR_API_KEY)")
+	file := flag.String("file", "cmd/anpr-sim/testdata/reads.jsonl", "recorded reads, one JSON object per line")
+	speed := flag.Float64("speed", 60, "replay speed-up; 0 sends reads without waiting")
+	flag.Parse()
+
+	if *key == "" {
+		log.Fatal("a camera API key is required (-key or $ANPR_API_KEY)")
+	}
+	reads, err := load(*file)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	start := time.Now()
+	for i, r := range reads {
+		at := start
+		if *speed > 0 {
+			at = start.Add(time.Duration(r.OffsetSeconds / *speed * float64(time.Second)))
+			time.Sleep(time.Until(at))
+		}
+		status, body, err := send(client, *url+"/anpr/reads", *key, r, at)
+		if err != nil {
+			log.Fatalf("read %d: %v", i+1, err)
+		}
+		fmt.Printf("%2d %-14s %-5s %.2f -> %d %s\n", i+1, r.Plate, r.Direction, r.Confidence, status, body)
+	}
+}
+
+func load(path string) ([]recordedRead, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var reads []recordedRead
+	sc := bufio.NewScanner(f)
+	for line := 1; sc.Scan(); line++ {
+		if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+			continue
+		}
+		var r recordedRead
+		if err := json.Unmarshal(sc.Bytes(), &r); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		reads = append(reads, r)
+	}
+	return reads, sc.Err()
+}
+
+func send(client *http.Client, url, key string, r recordedRead, at time.Time) (int, string, error) {
+	payload, err := json.Marshal(map[string]interface{}{
+		"plate":      r.Plate,
+		"confidence": r.Confidence,
+		"imageRef":   r.ImageRef,
+		"direction":  r.Direction,
+		"capturedAt": at.Format(time.RFC3339Nano),
+	})
+	if err != nil {
+		return 0, "", err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return 0, "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-API-Key", key)
+
+	res, err := client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	return res.StatusCode, string(bytes.TrimSpace(body)), err
+}
@@ -0,0 +1,109 @@
+// Command mqtt-dev runs an embedded MQTT broker for trying the sensor and
+// barrier bridge locally, logging every message that passes through it.
+//
+//	go run ./cmd/mqtt-dev -sensor <lotId>/<spotId> -barrier <gateId>
+//	MQTT_URL=tcp://localhost:1883 go run ./cmd/server
+//
+// -sensor simulates a bay sensor that flips between occupied and free every
+// -interval; -barrier simulates a barrier controller's heartbeat. Both flags
+// may be repeated.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+	"github.com/mochi-mqtt/server/v2/packets"
+)
+
+type multiFlag []string
+
+func (m *multiFlag) String() string     { return strings.Join(*m, ",") }
+func (m *multiFlag) Set(v string) error { *m = append(*m, v); return nil }
+
+// logHook prints published messages.
+type logHook struct{ mqtt.HookBase }
+
+func (h *logHook) ID() string { return "log" }
+
+func (h *logHook) Provides(b byte) bool { return b == mqtt.OnPublished }
+
+func (h *logHook) OnPublished(cl *mqtt.Client, pk packets.Packet) {
+	log.Printf("%-20s %s %s", cl.ID, pk.TopicName, pk.Payload)
+}
+
+func main() {
+	addr := flag.String("addr", ":1883", "listen address")
+	prefix := flag.String("prefix", "parking", "topic prefix (MQTT_TOPIC_PREFIX)")
+	interval := flag.Duration("interval", 30*time.Second, "sensor flip and heartbeat interval")
+	var sensors, barriers multiFlag
+	flag.Var(&sensors, "sensor", "simulate a bay sensor for lotId/spotId")
+	flag.Var(&barriers, "barrier", "simulate a barrier controller for gateId")
+	flag.Parse()
+
+	server := mqtt.New(&mqtt.Options{InlineClient: true})
+	if err := server.AddHook(new(auth.AllowHook), nil); err != nil {
+		log.Fatal(err)
+	}
+	if err := server.AddHook(new(logHook), nil); err != nil {
+		log.Fatal(err)
+	}
+	if err := server.AddListener(listeners.NewTCP(listeners.Config{ID: "tcp", Address: *addr})); err != nil {
+		log.Fatal(err)
+	}
+	go func() {
+		if err := server.Serve(); err != nil {
+			log.Fatal(err)
+		}
+	}()
+	log.Printf("broker listening on %s", *addr)
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	tick := time.NewTicker(*interval)
+	defer tick.Stop()
+
+	occupied := false
+	for {
+		for _, s := range sensors {
+			lot, spot, ok := strings.Cut(s, "/")
+			if !ok {
+				log.Fatalf("-sensor %q: want lotId/spotId", s)
+			}
+			device := "sensor-" + spot
+			publish(server, fmt.Sprintf("%s/lots/%s/spots/%s/occupancy", *prefix, lot, spot),
+				map[string]interface{}{"occupied": occupied, "deviceId": device, "at": time.Now()})
+			publish(server, fmt.Sprintf("%s/devices/%s/heartbeat", *prefix, device),
+				map[string]interface{}{"kind": "SENSOR", "lotId": lot, "spotId": spot, "firmware": "sim-1.0"})
+		}
+		for _, gate := range barriers {
+			publish(server, fmt.Sprintf("%s/devices/barrier-%s/heartbeat", *prefix, gate),
+				map[string]interface{}{"kind": "BARRIER", "gateId": gate, "firmware": "sim-1.0"})
+		}
+		occupied = !occupied
+
+		select {
+		case <-stop:
+			server.Close()
+			return
+		case <-tick.C:
+		}
+	}
+}
+
+func publish(server *mqtt.Server, topic string, v interface{}) {
+	payload, _ := json.Marshal(v)
+	if err := server.Publish(topic, payload, false, 1); err != nil {
+		log.Printf("publish %s: %v", topic, err)
+	}
+}
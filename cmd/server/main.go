@@ -1,12 +1,28 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
+	_ "time/tzdata" // lot timezones must resolve on images without a zoneinfo database
 
 	"Backend-Go/internal/config"
 	"Backend-Go/internal/db"
+	"Backend-Go/internal/events"
+	"Backend-Go/internal/handlers"
+	"Backend-Go/internal/mailer"
+	"Backend-Go/internal/mqttbridge"
+	"Backend-Go/internal/notify"
+	"Backend-Go/internal/outbox"
+	"Backend-Go/internal/payments"
+	"Backend-Go/internal/qrtoken"
 	"Backend-Go/internal/router"
+	"Backend-Go/internal/worker"
 )
 
 func main() {
@@ -21,7 +37,111 @@ func main() {
 	}
 	defer database.Close()
 
-	r := router.Setup(database, cfg)
+	provider, err := payments.New(cfg.PaymentProvider)
+	if err != nil {
+		log.Fatal("payments error: ", err)
+	}
+
+	mail, err := mailer.New(cfg.Mailer, mailer.SMTPOptions{
+		Addr:     cfg.SMTPAddr,
+		Username: cfg.SMTPUsername,
+		Password: cfg.SMTPPassword,
+		From:     cfg.MailFrom,
+	})
+	if err != nil {
+		log.Fatal("mailer error: ", err)
+	}
+
+	var signer *qrtoken.Signer
+	switch {
+	case cfg.QRSigningKey != "":
+		signer, err = qrtoken.NewSigner(cfg.QRSigningKey)
+	case cfg.QRDevKey:
+		log.Println("QR_DEV_KEY set; signing gate QR tokens with a throwaway key that will not verify after a restart")
+		signer, err = qrtoken.Generate()
+	default:
+		log.Fatal("QR_SIGNING_KEY is required (QR_DEV_KEY=true generates a throwaway key for development)")
+	}
+	if err != nil {
+		log.Fatal("qr signing key error: ", err)
+	}
+
+	opts := []handler.Option{handler.WithPayments(provider), handler.WithQRSigner(signer), handler.WithMailer(mail)}
+
+	for _, n := range []struct{ channel, sender, url string }{
+		{notify.ChannelEmail, cfg.NotifyEmail, ""},
+		{notify.ChannelSMS, cfg.NotifySMS, cfg.NotifySMSURL},
+		{notify.ChannelPush, cfg.NotifyPush, cfg.NotifyPushURL},
+	} {
+		sender, err := notify.NewSender(n.sender, notify.Options{Mailer: mail, URL: n.url, File: cfg.NotifyFile})
+		if err != nil {
+			log.Fatal("notify error: ", n.channel, ": ", err)
+		}
+		opts = append(opts, handler.WithNotifier(n.channel, sender))
+	}
+
+	// the sensor/barrier bridge is optional
+	var bridge *mqttbridge.Bridge
+	if cfg.MQTTURL != "" {
+		bridge, err = mqttbridge.Connect(mqttbridge.Options{
+			URL:         cfg.MQTTURL,
+			ClientID:    cfg.MQTTClientID,
+			Username:    cfg.MQTTUsername,
+			Password:    cfg.MQTTPassword,
+			TopicPrefix: cfg.MQTTTopicPrefix,
+			ShareGroup:  cfg.MQTTShareGroup,
+		})
+		if err != nil {
+			log.Fatal("mqtt error: ", err)
+		}
+		defer bridge.Close()
+		opts = append(opts, handler.WithBarriers(bridge))
+	}
+
+	h := handler.New(database, cfg, opts...)
+	if bridge != nil {
+		if err := bridge.Subscribe(h); err != nil {
+			log.Fatal("mqtt subscribe error: ", err)
+		}
+		log.Printf("mqtt bridge connected to %s\n", cfg.MQTTURL)
+	}
+	r := router.Setup(database, cfg, h)
+
+	// domain events go to in-process subscribers, webhook subscriptions and
+	// any other sinks configured
+	subscribers := outbox.NewSubscribers()
+	h.SubscribeNotifications(subscribers)
+	sinks := []outbox.Sink{subscribers, h.WebhookSink()}
+	for _, name := range cfg.OutboxSinks {
+		sink, err := outbox.NewSink(name)
+		if err != nil {
+			log.Fatal("outbox error: ", err)
+		}
+		sinks = append(sinks, sink)
+	}
+	dispatcher := &outbox.Dispatcher{DB: database, Sinks: sinks}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// background jobs stop with the server
+	go worker.Every(ctx, "payments", 15*time.Second, h.SettlePayments)
+	go worker.Every(ctx, "waitlist-offers", 30*time.Second, h.ExpireWaitlistOffers)
+	go worker.Every(ctx, "occupancy-reconcile", time.Minute, h.ReconcileOccupancy)
+	go worker.Every(ctx, "lot-summaries", 15*time.Second, h.PublishLotSummaries)
+	go worker.Every(ctx, "occupancy-snapshots", time.Duration(cfg.OccupancySnapshotSeconds)*time.Second, h.SnapshotOccupancy)
+	go worker.Every(ctx, "occupancy-rollups", 10*time.Minute, h.RollupOccupancy)
+	go worker.Every(ctx, "pricing-forecasts", 5*time.Minute, h.RefreshPricingForecasts)
+	go worker.Every(ctx, "quote-prune", time.Hour, h.PruneQuotes)
+	go worker.Every(ctx, "report-schedules", time.Minute, h.RunDueReports)
+	go worker.Every(ctx, "outbox", time.Second, dispatcher.Dispatch)
+	go worker.Every(ctx, "outbox-prune", time.Hour, dispatcher.Prune)
+	go worker.Every(ctx, "webhooks", 5*time.Second, h.DeliverWebhooks)
+	go worker.Every(ctx, "notification-reminders", time.Minute, h.ScanNotifications)
+	go worker.Every(ctx, "notifications", 5*time.Second, h.SendNotifications)
+
+	// spot changes reach this replica's streams through Postgres NOTIFY
+	go events.Listen(ctx, cfg.DatabaseURL, h.Events)
 
 	// Determine port: cfg.Port -> $PORT -> 8080
 	port := cfg.Port
@@ -36,7 +156,19 @@ func main() {
 	addr := "0.0.0.0:" + port // important for containerized hosts like Railway/Fly
 	log.Printf("server starting on %s\n", addr)
 
-	if err := r.Run(addr); err != nil {
-		log.Fatal("server error: ", err)
+	srv := &http.Server{Addr: addr, Handler: r}
+	srv.RegisterOnShutdown(h.Events.Close) // end open streams so shutdown is not held up
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal("server error: ", err)
+		}
+	}()
+
+	<-ctx.Done()
+	log.Println("shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Println("shutdown error: ", err)
 	}
 }
@@ -0,0 +1,30 @@
+// Package anpr holds the plate handling shared by the camera ingest and its
+// simulator.
+package anpr
+
+import "strings"
+
+// Normalize uppercases a plate and keeps only letters and digits, so
+// "ka 01-ab 1234" becomes "KA01AB1234".
+func Normalize(plate string) string {
+	var b strings.Builder
+	for _, r := range strings.ToUpper(plate) {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// Canonical folds the characters cameras most often confuse (O/0, I/1) so
+// two reads of the same plate compare equal.
+func Canonical(plate string) string {
+	return strings.NewReplacer("O", "0", "I", "1").Replace(Normalize(plate))
+}
+
+// CanonicalSQL is Canonical as a Postgres expression over the column col.
+// Migration 0025 indexes this expression on vehicles.plate and
+// bookings.plate; changing it means re-creating those indexes.
+func CanonicalSQL(col string) string {
+	return "translate(regexp_replace(upper(" + col + "), '[^A-Z0-9]', '', 'g'), 'OI', '01')"
+}
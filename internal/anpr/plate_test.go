@@ -0,0 +1,44 @@
+package anpr
+
+import "testing"
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		plate, want string
+	}{
+		{"KA01AB1234", "KA01AB1234"},
+		{"ka 01-ab 1234", "KA01AB1234"},
+		{" MH.12/DE 1433 ", "MH12DE1433"},
+		{"DL 3C AB 0001", "DL3CAB0001"},
+		{"KA०१AB", "KAAB"}, // non-ASCII digits are dropped
+		{"", ""},
+		{"---", ""},
+	}
+	for _, tt := range tests {
+		if got := Normalize(tt.plate); got != tt.want {
+			t.Errorf("Normalize(%q) = %q, want %q", tt.plate, got, tt.want)
+		}
+	}
+}
+
+func TestCanonical(t *testing.T) {
+	tests := []struct {
+		plate, want string
+	}{
+		{"KA01AB1234", "KA01AB1234"},
+		{"KAO1AB1234", "KA01AB1234"},
+		{"ka 0i-ab 1234", "KA01AB1234"},
+		{"MH12DE1O33", "MH12DE1033"},
+		{"OI", "01"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := Canonical(tt.plate); got != tt.want {
+			t.Errorf("Canonical(%q) = %q, want %q", tt.plate, got, tt.want)
+		}
+	}
+	// reads that differ only by O/0 and I/1 match
+	if Canonical("TN09BO1I11") != Canonical("tn 09 b0 1111") {
+		t.Error("confusable reads of the same plate do not match")
+	}
+}
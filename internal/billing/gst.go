@@ -0,0 +1,127 @@
+// Package billing holds the money side of parking: charge calculation, GST
+// breakup, invoice numbering and receipt rendering. Amounts are integer paise.
+package billing
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// SACParking is the GST services accounting code for parking services.
+const SACParking = "996743"
+
+var gstinRe = regexp.MustCompile(`^[0-9]{2}[A-Z]{5}[0-9]{4}[A-Z][1-9A-Z]Z[0-9A-Z]$`)
+
+const gstinAlphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+// ValidGSTIN checks the format and the trailing mod-36 check character.
+func ValidGSTIN(s string) bool {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if !gstinRe.MatchString(s) {
+		return false
+	}
+	sum := 0
+	for i := 0; i < 14; i++ {
+		v := strings.IndexByte(gstinAlphabet, s[i]) * (i%2 + 1)
+		sum += v/36 + v%36
+	}
+	return gstinAlphabet[(36-sum%36)%36] == s[14]
+}
+
+// StateCode returns the two-digit state code a GSTIN was issued in.
+func StateCode(gstin string) string {
+	if len(gstin) < 2 {
+		return ""
+	}
+	return gstin[:2]
+}
+
+// IntraStateSupply reports whether a supply is taxed as CGST + SGST rather
+// than IGST. Parking is a service tied to immovable property, so under IGST
+// Act s.12(3) its place of supply is the state the lot is in, whoever the
+// customer is; the supply is intra-state when the supplier's GSTIN was
+// issued in that state.
+func IntraStateSupply(supplierGSTIN, lotState string) bool {
+	return StateCode(supplierGSTIN) == lotState
+}
+
+type TaxBreakup struct {
+	Taxable int64 `json:"taxablePaise"`
+	RateBP  int   `json:"rateBp"`
+	CGST    int64 `json:"cgstPaise"`
+	SGST    int64 `json:"sgstPaise"`
+	IGST    int64 `json:"igstPaise"`
+	Total   int64 `json:"totalPaise"`
+}
+
+// ComputeGST splits tax on taxable paise at rateBP basis points (1800 = 18%).
+// Intra-state supplies are split equally into CGST and SGST; inter-state
+// supplies carry the full rate as IGST.
+func ComputeGST(taxable int64, rateBP int, intraState bool) TaxBreakup {
+	t := TaxBreakup{Taxable: taxable, RateBP: rateBP}
+	if intraState {
+		half := roundDiv(taxable*int64(rateBP), 2*10000)
+		t.CGST, t.SGST = half, half
+	} else {
+		t.IGST = roundDiv(taxable*int64(rateBP), 10000)
+	}
+	t.Total = taxable + t.CGST + t.SGST + t.IGST
+	return t
+}
+
+func roundDiv(n, d int64) int64 {
+	if n < 0 {
+		return -roundDiv(-n, d)
+	}
+	return (n + d/2) / d
+}
+
+// FinancialYear returns the Indian financial year (April–March) containing t,
+// e.g. "2026-27".
+func FinancialYear(t time.Time) string {
+	y := t.Year()
+	if t.Month() < time.April {
+		y--
+	}
+	return fmt.Sprintf("%d-%02d", y, (y+1)%100)
+}
+
+var prefixRe = regexp.MustCompile(`^[A-Z0-9]{1,4}$`)
+
+// ValidInvoicePrefix reports whether p keeps invoice numbers within the
+// 16-character limit GST rules allow.
+func ValidInvoicePrefix(p string) bool { return prefixRe.MatchString(p) }
+
+// InvoiceNumber formats a per-lot sequential number, e.g. "BLR1/2627/000042".
+func InvoiceNumber(prefix, financialYear string, seq int64) string {
+	fy := strings.ReplaceAll(financialYear, "-", "")
+	if len(fy) == 6 {
+		fy = fy[2:]
+	}
+	return fmt.Sprintf("%s/%s/%06d", prefix, fy, seq)
+}
+
+// FormatRupees renders paise as "1,234.50" using Indian digit grouping.
+func FormatRupees(paise int64) string {
+	sign := ""
+	if paise < 0 {
+		sign = "-"
+		paise = -paise
+	}
+	rupees := fmt.Sprintf("%d", paise/100)
+	if len(rupees) > 3 {
+		head, tail := rupees[:len(rupees)-3], rupees[len(rupees)-3:]
+		var parts []string
+		for len(head) > 2 {
+			parts = append([]string{head[len(head)-2:]}, parts...)
+			head = head[:len(head)-2]
+		}
+		if head != "" {
+			parts = append([]string{head}, parts...)
+		}
+		rupees = strings.Join(parts, ",") + "," + tail
+	}
+	return fmt.Sprintf("%s%s.%02d", sign, rupees, paise%100)
+}
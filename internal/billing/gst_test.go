@@ -0,0 +1,117 @@
+package billing
+
+import (
+	"testing"
+	"time"
+)
+
+func TestValidGSTIN(t *testing.T) {
+	tests := []struct {
+		gstin string
+		want  bool
+	}{
+		{"27AAPFU0939F1ZV", true},
+		{"29AAGCB7383J1Z4", true},
+		{" 27aapfu0939f1zv ", true}, // trimmed and upper-cased
+		{"27AAPFU0939F1ZA", false},  // wrong check character
+		{"28AAPFU0939F1ZV", false},  // state code changed
+		{"27AAPFU0939F1Z", false},   // too short
+		{"27AAPFU0939F1XV", false},  // 14th character must be Z
+		{"27AAPFU0939F0ZV", false},  // entity number cannot be 0
+		{"2AAAPFU0939F1ZV", false},  // state code must be digits
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := ValidGSTIN(tt.gstin); got != tt.want {
+			t.Errorf("ValidGSTIN(%q) = %v, want %v", tt.gstin, got, tt.want)
+		}
+	}
+}
+
+func TestIntraStateSupply(t *testing.T) {
+	tests := []struct {
+		supplier, lotState string
+		want               bool
+	}{
+		{"27AAPFU0939F1ZV", "27", true},
+		{"27AAPFU0939F1ZV", "29", false},
+		{"", "27", false},
+	}
+	for _, tt := range tests {
+		if got := IntraStateSupply(tt.supplier, tt.lotState); got != tt.want {
+			t.Errorf("IntraStateSupply(%q, %q) = %v, want %v", tt.supplier, tt.lotState, got, tt.want)
+		}
+	}
+}
+
+func TestComputeGST(t *testing.T) {
+	tests := []struct {
+		name       string
+		taxable    int64
+		rateBP     int
+		intraState bool
+		want       TaxBreakup
+	}{
+		{"intra-state 18%", 10000, 1800, true,
+			TaxBreakup{Taxable: 10000, RateBP: 1800, CGST: 900, SGST: 900, Total: 11800}},
+		{"inter-state 18%", 10000, 1800, false,
+			TaxBreakup{Taxable: 10000, RateBP: 1800, IGST: 1800, Total: 11800}},
+		{"halves round half up", 4999, 1800, true,
+			TaxBreakup{Taxable: 4999, RateBP: 1800, CGST: 450, SGST: 450, Total: 5899}},
+		{"IGST rounds half up", 2775, 1800, false,
+			TaxBreakup{Taxable: 2775, RateBP: 1800, IGST: 500, Total: 3275}},
+		{"negative rounds away from zero", -4999, 1800, true,
+			TaxBreakup{Taxable: -4999, RateBP: 1800, CGST: -450, SGST: -450, Total: -5899}},
+		{"zero rate", 10000, 0, true,
+			TaxBreakup{Taxable: 10000, Total: 10000}},
+		{"zero amount", 0, 1800, false,
+			TaxBreakup{RateBP: 1800}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ComputeGST(tt.taxable, tt.rateBP, tt.intraState); got != tt.want {
+				t.Errorf("ComputeGST(%d, %d, %v) = %+v, want %+v", tt.taxable, tt.rateBP, tt.intraState, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFinancialYear(t *testing.T) {
+	tests := []struct {
+		at   time.Time
+		want string
+	}{
+		{time.Date(2026, time.March, 31, 23, 59, 0, 0, time.UTC), "2025-26"},
+		{time.Date(2026, time.April, 1, 0, 0, 0, 0, time.UTC), "2026-27"},
+		{time.Date(2099, time.December, 1, 0, 0, 0, 0, time.UTC), "2099-00"},
+	}
+	for _, tt := range tests {
+		if got := FinancialYear(tt.at); got != tt.want {
+			t.Errorf("FinancialYear(%v) = %q, want %q", tt.at, got, tt.want)
+		}
+	}
+}
+
+func TestInvoiceNumber(t *testing.T) {
+	if got, want := InvoiceNumber("BLR1", "2026-27", 42), "BLR1/2627/000042"; got != want {
+		t.Errorf("InvoiceNumber = %q, want %q", got, want)
+	}
+}
+
+func TestFormatRupees(t *testing.T) {
+	tests := []struct {
+		paise int64
+		want  string
+	}{
+		{0, "0.00"},
+		{5, "0.05"},
+		{123450, "1,234.50"},
+		{12345678900, "12,34,56,789.00"},
+		{-123450, "-1,234.50"},
+	}
+	for _, tt := range tests {
+		if got := FormatRupees(tt.paise); got != tt.want {
+			t.Errorf("FormatRupees(%d) = %q, want %q", tt.paise, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package billing
+
+// Promo is the pricing part of a promotion code.
+type Promo struct {
+	Code string
+	// Kind is PERCENT (Value in basis points) or FIXED (Value in paise).
+	Kind             string
+	Value            int64
+	MaxDiscountPaise int64 // 0 = uncapped
+	MinSpendPaise    int64
+}
+
+// Discount returns how much of amount (taxable paise) the promotion takes
+// off. Amounts below the minimum spend get no discount, and the discount
+// never exceeds the amount.
+func (p Promo) Discount(amount int64) int64 {
+	if amount <= 0 || amount < p.MinSpendPaise {
+		return 0
+	}
+	var d int64
+	switch p.Kind {
+	case "PERCENT":
+		d = roundDiv(amount*p.Value, 10000)
+	case "FIXED":
+		d = p.Value
+	}
+	if p.MaxDiscountPaise > 0 && d > p.MaxDiscountPaise {
+		d = p.MaxDiscountPaise
+	}
+	if d > amount {
+		d = amount
+	}
+	return d
+}
@@ -0,0 +1,220 @@
+package billing
+
+import (
+	"fmt"
+	"time"
+
+	"Backend-Go/internal/pdf"
+)
+
+type Party struct {
+	Name      string `json:"name"`
+	GSTIN     string `json:"gstin,omitempty"`
+	Address   string `json:"address,omitempty"`
+	StateCode string `json:"stateCode,omitempty"`
+	Email     string `json:"email,omitempty"`
+}
+
+type LineItem struct {
+	Description string `json:"description"`
+	SAC         string `json:"sac"`
+	Quantity    int64  `json:"quantity"`
+	Unit        string `json:"unit"`
+	RatePaise   int64  `json:"ratePaise"`
+	AmountPaise int64  `json:"amountPaise"`
+}
+
+// Receipt is a tax invoice, in the shape served as JSON and rendered to PDF.
+// It covers either one booking or, with Period set, a month of an
+// organisation's sessions.
+type Receipt struct {
+	InvoiceNo     string     `json:"invoiceNo"`
+	IssuedAt      time.Time  `json:"issuedAt"`
+	BookingID     string     `json:"bookingId,omitempty"`
+	Period        string     `json:"period,omitempty"`
+	Supplier      Party      `json:"supplier"`
+	Customer      Party      `json:"customer"`
+	PlaceOfSupply string     `json:"placeOfSupply"`
+	Lines         []LineItem `json:"lines"`
+	Tax           TaxBreakup `json:"tax"`
+	SessionStart  time.Time  `json:"sessionStart,omitzero"`
+	SessionEnd    time.Time  `json:"sessionEnd,omitzero"`
+	SpotNumber    string     `json:"spotNumber,omitempty"`
+	VehiclePlate  string     `json:"vehiclePlate,omitempty"`
+	ReverseCharge bool       `json:"reverseCharge"`
+	AmountInWords string     `json:"amountInWords"`
+	Refunds       []Refund   `json:"refunds"`
+	NetPaise      int64      `json:"netPaise"`
+	DisputeStatus string     `json:"disputeStatus,omitempty"`
+}
+
+// Refund is money paid back against the invoice after it was issued.
+type Refund struct {
+	ID          string    `json:"id"`
+	AmountPaise int64     `json:"amountPaise"`
+	ReasonCode  string    `json:"reasonCode"`
+	RefundedAt  time.Time `json:"refundedAt"`
+}
+
+// RenderPDF lays the receipt out on a single A4 page.
+func RenderPDF(r Receipt) []byte {
+	d := pdf.New()
+	d.AddPage()
+
+	const left, right = 50.0, 545.0
+	y := 60.0
+	d.Text(left, y, 16, true, "TAX INVOICE")
+	d.TextRight(right, y, 10, false, "Original for Recipient")
+	y += 28
+
+	d.Text(left, y, 11, true, r.Supplier.Name)
+	y += 14
+	if r.Supplier.Address != "" {
+		d.Text(left, y, 9, false, r.Supplier.Address)
+		y += 12
+	}
+	d.Text(left, y, 9, false, "GSTIN: "+r.Supplier.GSTIN+"   State code: "+r.Supplier.StateCode)
+	y += 22
+
+	d.Text(left, y, 9, true, "Invoice No:")
+	d.Text(left+70, y, 9, false, r.InvoiceNo)
+	d.Text(320, y, 9, true, "Date:")
+	d.Text(360, y, 9, false, r.IssuedAt.Format("02 Jan 2006 15:04 MST"))
+	y += 13
+	if r.BookingID != "" {
+		d.Text(left, y, 9, true, "Booking:")
+		d.Text(left+70, y, 9, false, r.BookingID)
+	} else {
+		d.Text(left, y, 9, true, "Period:")
+		d.Text(left+70, y, 9, false, r.Period)
+	}
+	y += 13
+	d.Text(left, y, 9, true, "Place of supply:")
+	d.Text(left+70, y, 9, false, r.PlaceOfSupply)
+	d.Text(320, y, 9, true, "Reverse charge:")
+	d.Text(395, y, 9, false, map[bool]string{true: "Yes", false: "No"}[r.ReverseCharge])
+	y += 22
+
+	d.Text(left, y, 9, true, "Billed to")
+	y += 13
+	d.Text(left, y, 9, false, r.Customer.Name)
+	y += 12
+	if r.Customer.Email != "" {
+		d.Text(left, y, 9, false, r.Customer.Email)
+		y += 12
+	}
+	if r.Customer.GSTIN != "" {
+		d.Text(left, y, 9, false, "GSTIN: "+r.Customer.GSTIN)
+		y += 12
+	}
+	y += 10
+
+	if r.BookingID != "" {
+		d.Text(left, y, 9, false, fmt.Sprintf("Spot %s   Vehicle %s", r.SpotNumber, r.VehiclePlate))
+		y += 12
+		d.Text(left, y, 9, false, "Parked "+r.SessionStart.Format("02 Jan 2006 15:04")+" to "+r.SessionEnd.Format("02 Jan 2006 15:04 MST"))
+		y += 18
+	}
+
+	d.Line(left, y, right, y)
+	y += 13
+	d.Text(left, y, 9, true, "Description")
+	d.Text(280, y, 9, true, "SAC")
+	d.TextRight(390, y, 9, true, "Qty")
+	d.TextRight(465, y, 9, true, "Rate")
+	d.TextRight(right, y, 9, true, "Amount")
+	y += 6
+	d.Line(left, y, right, y)
+	y += 13
+	for _, l := range r.Lines {
+		d.Text(left, y, 9, false, l.Description)
+		d.Text(280, y, 9, false, l.SAC)
+		if l.Quantity > 0 {
+			d.TextRight(390, y, 9, false, fmt.Sprintf("%d %s", l.Quantity, l.Unit))
+		}
+		if l.RatePaise > 0 {
+			d.TextRight(465, y, 9, false, FormatRupees(l.RatePaise))
+		}
+		d.TextRight(right, y, 9, false, FormatRupees(l.AmountPaise))
+		y += 13
+	}
+	d.Line(left, y, right, y)
+	y += 15
+
+	row := func(label string, paise int64, bold bool) {
+		d.TextRight(465, y, 9, bold, label)
+		d.TextRight(right, y, 9, bold, FormatRupees(paise))
+		y += 13
+	}
+	rate := fmt.Sprintf("%.2f%%", float64(r.Tax.RateBP)/100)
+	half := fmt.Sprintf("%.2f%%", float64(r.Tax.RateBP)/200)
+	row("Taxable value", r.Tax.Taxable, false)
+	if r.Tax.IGST > 0 {
+		row("IGST @ "+rate, r.Tax.IGST, false)
+	} else {
+		row("CGST @ "+half, r.Tax.CGST, false)
+		row("SGST @ "+half, r.Tax.SGST, false)
+	}
+	row("Total (INR)", r.Tax.Total, true)
+	if len(r.Refunds) > 0 {
+		for _, rf := range r.Refunds {
+			row("Refund "+rf.RefundedAt.Format("02 Jan 2006")+" ("+rf.ReasonCode+")", -rf.AmountPaise, false)
+		}
+		row("Net paid (INR)", r.NetPaise, true)
+	}
+	y += 6
+	d.Text(left, y, 9, false, "Amount in words: "+r.AmountInWords)
+	y += 30
+	d.Text(left, y, 8, false, "This is a computer generated invoice and does not require a signature.")
+
+	return d.Bytes()
+}
+
+var (
+	ones = []string{"", "One", "Two", "Three", "Four", "Five", "Six", "Seven", "Eight", "Nine", "Ten",
+		"Eleven", "Twelve", "Thirteen", "Fourteen", "Fifteen", "Sixteen", "Seventeen", "Eighteen", "Nineteen"}
+	tens = []string{"", "", "Twenty", "Thirty", "Forty", "Fifty", "Sixty", "Seventy", "Eighty", "Ninety"}
+)
+
+// AmountInWords spells paise out the way Indian invoices do, e.g.
+// "Rupees One Lakh Twenty Thousand and Fifty Paise Only".
+func AmountInWords(paise int64) string {
+	if paise < 0 {
+		return "Minus " + AmountInWords(-paise)
+	}
+	rupees, p := paise/100, paise%100
+	s := "Rupees " + words(rupees)
+	if rupees == 0 {
+		s = "Rupees Zero"
+	}
+	if p > 0 {
+		s += " and " + words(p) + " Paise"
+	}
+	return s + " Only"
+}
+
+func words(n int64) string {
+	switch {
+	case n == 0:
+		return ""
+	case n < 20:
+		return ones[n]
+	case n < 100:
+		return join(tens[n/10], ones[n%10])
+	case n < 1000:
+		return join(ones[n/100]+" Hundred", words(n%100))
+	case n < 100000:
+		return join(words(n/1000)+" Thousand", words(n%1000))
+	case n < 10000000:
+		return join(words(n/100000)+" Lakh", words(n%100000))
+	default:
+		return join(words(n/10000000)+" Crore", words(n%10000000))
+	}
+}
+
+func join(a, b string) string {
+	if b == "" {
+		return a
+	}
+	return a + " " + b
+}
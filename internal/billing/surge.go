@@ -0,0 +1,42 @@
+package billing
+
+import "sort"
+
+// SurgeStep raises the price once occupancy reaches ThresholdPct.
+type SurgeStep struct {
+	ThresholdPct int
+	MultiplierBP int // 10000 = 1x
+}
+
+// Surge is a lot's dynamic pricing: multipliers stepped by occupancy, kept
+// within MinBP and MaxBP.
+type Surge struct {
+	Steps        []SurgeStep
+	MinBP, MaxBP int
+}
+
+// Multiplier returns the multiplier of the highest step occupancyPct has
+// reached, or 1x below every step, clamped to the bounds.
+func (s Surge) Multiplier(occupancyPct float64) int {
+	steps := append([]SurgeStep(nil), s.Steps...)
+	sort.Slice(steps, func(i, j int) bool { return steps[i].ThresholdPct < steps[j].ThresholdPct })
+	bp := 10000
+	for _, st := range steps {
+		if occupancyPct >= float64(st.ThresholdPct) {
+			bp = st.MultiplierBP
+		}
+	}
+	if bp < s.MinBP {
+		bp = s.MinBP
+	}
+	if s.MaxBP > 0 && bp > s.MaxBP {
+		bp = s.MaxBP
+	}
+	return bp
+}
+
+// ApplyMultiplier scales a rate in paise by bp basis points, to the nearest
+// paisa.
+func ApplyMultiplier(rate int64, bp int) int64 {
+	return roundDiv(rate*int64(bp), 10000)
+}
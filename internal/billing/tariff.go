@@ -0,0 +1,37 @@
+package billing
+
+import "time"
+
+// Tariff is what a parking session is billed at.
+type Tariff struct {
+	RatePerHour int64
+	// PassValidUntil, when set, zero-rates the part of a session covered by
+	// an active pass.
+	PassValidUntil time.Time
+}
+
+// Evaluate returns the billed hours and taxable amount for a session. Time
+// covered by a pass is free; the rest is billed in started hours with a one
+// hour minimum.
+func (t Tariff) Evaluate(start, end time.Time) (hours int64, amount int64) {
+	if !t.PassValidUntil.IsZero() {
+		if !end.After(t.PassValidUntil) {
+			return 0, 0
+		}
+		if start.Before(t.PassValidUntil) {
+			start = t.PassValidUntil
+		}
+	}
+	return ParkingCharge(t.RatePerHour, start, end)
+}
+
+// ParkingCharge bills started hours at ratePerHour paise, with a one hour
+// minimum. It returns the billed hours and the taxable amount.
+func ParkingCharge(ratePerHour int64, start, end time.Time) (hours int64, amount int64) {
+	d := end.Sub(start)
+	hours = int64(d / time.Hour)
+	if d%time.Hour > 0 || hours == 0 {
+		hours++
+	}
+	return hours, hours * ratePerHour
+}
@@ -0,0 +1,37 @@
+package billing
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTariffEvaluate(t *testing.T) {
+	start := time.Date(2026, time.May, 4, 9, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) time.Time { return start.Add(d) }
+
+	tests := []struct {
+		name       string
+		tariff     Tariff
+		end        time.Time
+		wantHours  int64
+		wantAmount int64
+	}{
+		{"minimum hour", Tariff{RatePerHour: 4000}, at(10 * time.Minute), 1, 4000},
+		{"zero length still bills an hour", Tariff{RatePerHour: 4000}, start, 1, 4000},
+		{"exact hours", Tariff{RatePerHour: 4000}, at(2 * time.Hour), 2, 8000},
+		{"started hour", Tariff{RatePerHour: 4000}, at(2*time.Hour + time.Second), 3, 12000},
+		{"covered by pass", Tariff{RatePerHour: 4000, PassValidUntil: at(5 * time.Hour)}, at(3 * time.Hour), 0, 0},
+		{"ends as pass expires", Tariff{RatePerHour: 4000, PassValidUntil: at(3 * time.Hour)}, at(3 * time.Hour), 0, 0},
+		{"billed after pass expires", Tariff{RatePerHour: 4000, PassValidUntil: at(2 * time.Hour)}, at(3*time.Hour + 30*time.Minute), 2, 8000},
+		{"pass expired before start", Tariff{RatePerHour: 4000, PassValidUntil: at(-time.Hour)}, at(90 * time.Minute), 2, 8000},
+		{"free rate", Tariff{}, at(5 * time.Hour), 5, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hours, amount := tt.tariff.Evaluate(start, tt.end)
+			if hours != tt.wantHours || amount != tt.wantAmount {
+				t.Errorf("Evaluate = (%d, %d), want (%d, %d)", hours, amount, tt.wantHours, tt.wantAmount)
+			}
+		})
+	}
+}
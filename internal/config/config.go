@@ -2,8 +2,11 @@ package config
 
 import (
 	"errors"
+	"fmt"
+	"net"
 	"os"
 	"strconv"
+	"strings"
 
 	"github.com/joho/godotenv"
 )
@@ -13,6 +16,89 @@ type Config struct {
 	JWTSecret   string
 	BcryptCost  int
 	Port        string
+
+	// CORSOrigins are the browser origins allowed to call the API and open
+	// occupancy WebSockets (CORS_ORIGINS, comma-separated; default the
+	// local frontend on port 3000).
+	CORSOrigins []string
+
+	// TrustedProxies are the reverse proxies (IPs or CIDRs) whose
+	// X-Forwarded-For is believed when recording client IPs
+	// (TRUSTED_PROXIES, comma-separated). Unset, none are: the client IP is
+	// the connection's peer.
+	TrustedProxies []string
+
+	// PaymentProvider names the gateway fees are collected through ("manual").
+	PaymentProvider string
+
+	// WaitlistHoldMinutes is how long a freed spot is held for the next
+	// waitlisted vehicle before it moves down the queue.
+	WaitlistHoldMinutes int
+
+	// QRSigningKey is the base64 Ed25519 seed gate QR tokens are signed
+	// with, and is required. QRDevKey (QR_DEV_KEY=true) lets it be left
+	// empty in development, when a throwaway key is generated at startup.
+	// QRTokenTTLSeconds is how long each token stays valid.
+	QRSigningKey      string
+	QRDevKey          bool
+	QRTokenTTLSeconds int
+
+	// ANPRMinConfidence is the camera read confidence (0-1) below which a
+	// read goes to manual review instead of starting or ending a session.
+	ANPRMinConfidence float64
+
+	// MQTTURL enables the sensor/barrier bridge when set (tcp://host:1883).
+	// MQTTClientID prefixes the per-process client ID; replicas subscribe
+	// as the shared subscription group MQTTShareGroup ("" for none).
+	MQTTURL         string
+	MQTTClientID    string
+	MQTTUsername    string
+	MQTTPassword    string
+	MQTTTopicPrefix string
+	MQTTShareGroup  string
+	// DeviceOfflineSeconds is how long without a heartbeat before a device
+	// is reported offline.
+	DeviceOfflineSeconds int
+
+	// ReconcileGraceMinutes is how long sensor or gate data must disagree
+	// with a spot's bookings before the reconciler records a discrepancy.
+	ReconcileGraceMinutes int
+
+	// OccupancySnapshotSeconds is how often per-level occupancy is recorded.
+	// Raw snapshots are kept for OccupancySnapshotRetentionDays (at least 2,
+	// so daily rollups can be rebuilt) and hourly rollups for
+	// OccupancyHourlyRetentionDays; daily rollups are kept for good.
+	OccupancySnapshotSeconds       int
+	OccupancySnapshotRetentionDays int
+	OccupancyHourlyRetentionDays   int
+
+	// Mailer names how email is sent: "log" (default) or "smtp" through
+	// SMTPAddr as MailFrom.
+	Mailer       string
+	SMTPAddr     string
+	SMTPUsername string
+	SMTPPassword string
+	MailFrom     string
+
+	// OutboxSinks names the external sinks domain events are delivered to
+	// besides in-process subscribers (OUTBOX_SINKS, comma-separated: "log").
+	OutboxSinks []string
+
+	// NotifyEmail, NotifySMS and NotifyPush name how user notifications are
+	// sent on each channel: "mailer" (email only, through Mailer), "http"
+	// (POSTed to NotifySMSURL or NotifyPushURL), "file" (appended to
+	// NotifyFile) or "log". Defaults: mailer, log, log.
+	NotifyEmail   string
+	NotifySMS     string
+	NotifyPush    string
+	NotifySMSURL  string
+	NotifyPushURL string
+	NotifyFile    string
+	// OverstayWarningHours is how long a session runs before its driver is
+	// warned; PassExpiryNoticeDays is how long before a pass expires its
+	// holder is told.
+	OverstayWarningHours int
+	PassExpiryNoticeDays int
 }
 
 // LoadConfig reads environment variables (loads .env if present) and returns a Config.
@@ -23,6 +109,30 @@ func LoadConfig() (*Config, error) {
 	jwtSecret := os.Getenv("JWT_SECRET")
 	bcryptCostStr := os.Getenv("BCRYPT_COST")
 	port := os.Getenv("PORT")
+	corsOriginsStr := os.Getenv("CORS_ORIGINS")
+	trustedProxiesStr := os.Getenv("TRUSTED_PROXIES")
+	paymentProvider := os.Getenv("PAYMENT_PROVIDER")
+	waitlistHoldStr := os.Getenv("WAITLIST_HOLD_MINUTES")
+	qrSigningKey := os.Getenv("QR_SIGNING_KEY")
+	qrDevKeyStr := os.Getenv("QR_DEV_KEY")
+	qrTTLStr := os.Getenv("QR_TOKEN_TTL_SECONDS")
+	anprMinStr := os.Getenv("ANPR_MIN_CONFIDENCE")
+	mqttClientID := os.Getenv("MQTT_CLIENT_ID")
+	mqttTopicPrefix := os.Getenv("MQTT_TOPIC_PREFIX")
+	mqttShareGroup, mqttShareSet := os.LookupEnv("MQTT_SHARE_GROUP")
+	deviceOfflineStr := os.Getenv("DEVICE_OFFLINE_SECONDS")
+	reconcileGraceStr := os.Getenv("RECONCILE_GRACE_MINUTES")
+	snapshotStr := os.Getenv("OCCUPANCY_SNAPSHOT_SECONDS")
+	snapshotRetentionStr := os.Getenv("OCCUPANCY_SNAPSHOT_RETENTION_DAYS")
+	hourlyRetentionStr := os.Getenv("OCCUPANCY_HOURLY_RETENTION_DAYS")
+	mailerName := os.Getenv("MAILER")
+	outboxSinksStr := os.Getenv("OUTBOX_SINKS")
+	notifyEmail := os.Getenv("NOTIFY_EMAIL")
+	notifySMS := os.Getenv("NOTIFY_SMS")
+	notifyPush := os.Getenv("NOTIFY_PUSH")
+	notifyFile := os.Getenv("NOTIFY_FILE")
+	overstayStr := os.Getenv("OVERSTAY_WARNING_HOURS")
+	passNoticeStr := os.Getenv("PASS_EXPIRY_NOTICE_DAYS")
 
 	if dbURL == "" {
 		return nil, errors.New("DATABASE_URL is required")
@@ -33,6 +143,28 @@ func LoadConfig() (*Config, error) {
 	if port == "" {
 		port = "8080"
 	}
+	var corsOrigins []string
+	for _, o := range strings.Split(corsOriginsStr, ",") {
+		if o = strings.TrimSpace(o); o != "" {
+			corsOrigins = append(corsOrigins, o)
+		}
+	}
+	if len(corsOrigins) == 0 {
+		corsOrigins = []string{"http://localhost:3000", "http://127.0.0.1:3000"}
+	}
+	var trustedProxies []string
+	for _, p := range strings.Split(trustedProxiesStr, ",") {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(p); err != nil && net.ParseIP(p) == nil {
+			return nil, fmt.Errorf("TRUSTED_PROXIES: %q is not an IP or CIDR", p)
+		}
+		trustedProxies = append(trustedProxies, p)
+	}
+	if paymentProvider == "" {
+		paymentProvider = "manual"
+	}
 
 	bcryptCost := 10
 	if bcryptCostStr != "" {
@@ -41,10 +173,154 @@ func LoadConfig() (*Config, error) {
 		}
 	}
 
+	waitlistHold := 10
+	if waitlistHoldStr != "" {
+		if v, err := strconv.Atoi(waitlistHoldStr); err == nil && v > 0 {
+			waitlistHold = v
+		}
+	}
+
+	qrDevKey, _ := strconv.ParseBool(qrDevKeyStr)
+
+	qrTTL := 300
+	if qrTTLStr != "" {
+		if v, err := strconv.Atoi(qrTTLStr); err == nil && v > 0 {
+			qrTTL = v
+		}
+	}
+
+	anprMin := 0.85
+	if anprMinStr != "" {
+		if v, err := strconv.ParseFloat(anprMinStr, 64); err == nil && v >= 0 && v <= 1 {
+			anprMin = v
+		}
+	}
+
+	if mqttClientID == "" {
+		mqttClientID = "parking-backend"
+	}
+	if mqttTopicPrefix == "" {
+		mqttTopicPrefix = "parking"
+	}
+	if !mqttShareSet {
+		mqttShareGroup = "parking-backend"
+	}
+	deviceOffline := 90
+	if deviceOfflineStr != "" {
+		if v, err := strconv.Atoi(deviceOfflineStr); err == nil && v > 0 {
+			deviceOffline = v
+		}
+	}
+
+	reconcileGrace := 10
+	if reconcileGraceStr != "" {
+		if v, err := strconv.Atoi(reconcileGraceStr); err == nil && v > 0 {
+			reconcileGrace = v
+		}
+	}
+
+	snapshotEvery := 300
+	if snapshotStr != "" {
+		if v, err := strconv.Atoi(snapshotStr); err == nil && v > 0 {
+			snapshotEvery = v
+		}
+	}
+	snapshotRetention := 14
+	if snapshotRetentionStr != "" {
+		if v, err := strconv.Atoi(snapshotRetentionStr); err == nil && v >= 2 {
+			snapshotRetention = v
+		}
+	}
+	hourlyRetention := 180
+	if hourlyRetentionStr != "" {
+		if v, err := strconv.Atoi(hourlyRetentionStr); err == nil && v > 0 {
+			hourlyRetention = v
+		}
+	}
+
+	if mailerName == "" {
+		mailerName = "log"
+	}
+
+	var outboxSinks []string
+	for _, name := range strings.Split(outboxSinksStr, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			outboxSinks = append(outboxSinks, name)
+		}
+	}
+
+	if notifyEmail == "" {
+		notifyEmail = "mailer"
+	}
+	if notifySMS == "" {
+		notifySMS = "log"
+	}
+	if notifyPush == "" {
+		notifyPush = "log"
+	}
+	if notifyFile == "" {
+		notifyFile = "notifications.jsonl"
+	}
+	overstay := 12
+	if overstayStr != "" {
+		if v, err := strconv.Atoi(overstayStr); err == nil && v > 0 {
+			overstay = v
+		}
+	}
+	passNotice := 3
+	if passNoticeStr != "" {
+		if v, err := strconv.Atoi(passNoticeStr); err == nil && v > 0 {
+			passNotice = v
+		}
+	}
+
 	return &Config{
 		DatabaseURL: dbURL,
 		JWTSecret:   jwtSecret,
 		BcryptCost:  bcryptCost,
 		Port:        port,
+		CORSOrigins: corsOrigins,
+
+		TrustedProxies: trustedProxies,
+
+		PaymentProvider:     paymentProvider,
+		WaitlistHoldMinutes: waitlistHold,
+
+		QRSigningKey:      qrSigningKey,
+		QRDevKey:          qrDevKey,
+		QRTokenTTLSeconds: qrTTL,
+
+		ANPRMinConfidence: anprMin,
+
+		MQTTURL:              os.Getenv("MQTT_URL"),
+		MQTTClientID:         mqttClientID,
+		MQTTUsername:         os.Getenv("MQTT_USERNAME"),
+		MQTTPassword:         os.Getenv("MQTT_PASSWORD"),
+		MQTTTopicPrefix:      mqttTopicPrefix,
+		MQTTShareGroup:       mqttShareGroup,
+		DeviceOfflineSeconds: deviceOffline,
+
+		ReconcileGraceMinutes: reconcileGrace,
+
+		OccupancySnapshotSeconds:       snapshotEvery,
+		OccupancySnapshotRetentionDays: snapshotRetention,
+		OccupancyHourlyRetentionDays:   hourlyRetention,
+
+		Mailer:       mailerName,
+		SMTPAddr:     os.Getenv("SMTP_ADDR"),
+		SMTPUsername: os.Getenv("SMTP_USERNAME"),
+		SMTPPassword: os.Getenv("SMTP_PASSWORD"),
+		MailFrom:     os.Getenv("MAIL_FROM"),
+
+		OutboxSinks: outboxSinks,
+
+		NotifyEmail:          notifyEmail,
+		NotifySMS:            notifySMS,
+		NotifyPush:           notifyPush,
+		NotifySMSURL:         os.Getenv("NOTIFY_SMS_URL"),
+		NotifyPushURL:        os.Getenv("NOTIFY_PUSH_URL"),
+		NotifyFile:           notifyFile,
+		OverstayWarningHours: overstay,
+		PassExpiryNoticeDays: passNotice,
 	}, nil
 }
@@ -0,0 +1,155 @@
+// Package cron parses the five-field cron expressions report schedules use
+// (minute hour day-of-month month day-of-week) and finds their next run.
+//
+// Fields take *, numbers, ranges (1-5), steps (*/15, 1-31/2), lists of
+// those, and month and weekday names (JAN, MON). Weekday 0 and 7 are both
+// Sunday. As in classic cron, when both day fields are restricted a day
+// matching either one runs. @hourly, @daily, @weekly, @monthly and @yearly
+// are accepted as shorthands.
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed expression. Each field is a bit set of the values it
+// allows.
+type Schedule struct {
+	minute, hour, dom, month, dow uint64
+	domAny, dowAny                bool
+}
+
+type field struct {
+	name     string
+	min, max int
+	names    []string // names[i] is value min+i
+}
+
+var fields = []field{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12, names: []string{"JAN", "FEB", "MAR", "APR", "MAY", "JUN", "JUL", "AUG", "SEP", "OCT", "NOV", "DEC"}},
+	{name: "day of week", min: 0, max: 7, names: []string{"SUN", "MON", "TUE", "WED", "THU", "FRI", "SAT"}},
+}
+
+var shorthands = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+	"@yearly":  "0 0 1 1 *",
+}
+
+// Parse reads a five-field expression or shorthand.
+func Parse(expr string) (*Schedule, error) {
+	expr = strings.TrimSpace(expr)
+	if s, ok := shorthands[strings.ToLower(expr)]; ok {
+		expr = s
+	}
+	parts := strings.Fields(expr)
+	if len(parts) != 5 {
+		return nil, fmt.Errorf("cron: expected 5 fields, got %d", len(parts))
+	}
+	var sets [5]uint64
+	for i, p := range parts {
+		set, err := parseField(p, fields[i])
+		if err != nil {
+			return nil, err
+		}
+		sets[i] = set
+	}
+	if sets[4]&(1<<7) != 0 { // 7 is Sunday too
+		sets[4] = sets[4]&^(1<<7) | 1
+	}
+	return &Schedule{
+		minute: sets[0], hour: sets[1], dom: sets[2], month: sets[3], dow: sets[4],
+		domAny: parts[2] == "*", dowAny: parts[4] == "*",
+	}, nil
+}
+
+func parseField(s string, f field) (uint64, error) {
+	var set uint64
+	for _, item := range strings.Split(s, ",") {
+		rng, step := item, 1
+		if i := strings.IndexByte(item, '/'); i >= 0 {
+			n, err := strconv.Atoi(item[i+1:])
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("cron: bad step in %s field %q", f.name, item)
+			}
+			rng, step = item[:i], n
+		}
+		lo, hi := f.min, f.max
+		if rng != "*" {
+			bounds := strings.SplitN(rng, "-", 2)
+			var err error
+			if lo, err = f.value(bounds[0]); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = f.value(bounds[1]); err != nil {
+					return 0, err
+				}
+			} else if step > 1 {
+				hi = f.max // 5/15 means from 5 every 15
+			}
+			if hi < lo {
+				return 0, fmt.Errorf("cron: backwards range in %s field %q", f.name, item)
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+	return set, nil
+}
+
+func (f field) value(s string) (int, error) {
+	for i, n := range f.names {
+		if strings.EqualFold(s, n) {
+			return f.min + i, nil
+		}
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < f.min || v > f.max {
+		return 0, fmt.Errorf("cron: %s must be between %d and %d, got %q", f.name, f.min, f.max, s)
+	}
+	return v, nil
+}
+
+// Next is the first time after t, to the minute, that the schedule runs, in
+// t's location. Wall-clock times skipped by a DST change do not run. It
+// returns the zero time when nothing matches within five years (e.g. 31 FEB).
+func (s *Schedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case s.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		case !s.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, loc).Add(time.Hour)
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func (s *Schedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domAny || s.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
@@ -0,0 +1,99 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"* * * FOO *",
+		"@fortnightly",
+	}
+	for _, expr := range tests {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("Parse(%q) succeeded, want error", expr)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	// Monday 4 May 2026, 09:17:30
+	from := time.Date(2026, time.May, 4, 9, 17, 30, 0, time.UTC)
+	at := func(month time.Month, day, hour, min int) time.Time {
+		return time.Date(2026, month, day, hour, min, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"* * * * *", at(time.May, 4, 9, 18)},
+		{"*/15 * * * *", at(time.May, 4, 9, 30)},
+		{"5/15 * * * *", at(time.May, 4, 9, 20)},
+		{"0 * * * *", at(time.May, 4, 10, 0)},
+		{"@hourly", at(time.May, 4, 10, 0)},
+		{"17 9 * * *", at(time.May, 5, 9, 17)},
+		{"0 6,18 * * *", at(time.May, 4, 18, 0)},
+		{"30 8-10 * * *", at(time.May, 4, 9, 30)},
+		{"0 0 * * *", at(time.May, 5, 0, 0)},
+		{"@daily", at(time.May, 5, 0, 0)},
+		{"0 9 * * SAT", at(time.May, 9, 9, 0)},
+		{"0 9 * * 0", at(time.May, 10, 9, 0)},
+		{"0 9 * * 7", at(time.May, 10, 9, 0)},
+		{"0 9 * * sun", at(time.May, 10, 9, 0)},
+		{"0 9 * * MON-FRI", at(time.May, 5, 9, 0)},
+		{"@weekly", at(time.May, 10, 0, 0)},
+		{"0 0 1 * *", at(time.June, 1, 0, 0)},
+		{"@monthly", at(time.June, 1, 0, 0)},
+		{"0 0 31 * *", at(time.May, 31, 0, 0)},
+		{"0 0 1 JAN *", time.Date(2027, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		{"@yearly", time.Date(2027, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		// both day fields restricted: the 15th or any Friday
+		{"0 0 15 * FRI", at(time.May, 8, 0, 0)},
+		{"0 0 6 * FRI", at(time.May, 6, 0, 0)},
+		{"0 0 29 2 *", time.Date(2028, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 31 2 *", time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			s, err := Parse(tt.expr)
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if got := s.Next(from); !got.Equal(tt.want) {
+				t.Errorf("Next = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNextDST(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("no tzdata:", err)
+	}
+	s, err := Parse("30 2 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 02:30 does not exist on 8 March 2026, when clocks go from 02:00 to 03:00
+	from := time.Date(2026, time.March, 7, 3, 0, 0, 0, ny)
+	want := time.Date(2026, time.March, 9, 2, 30, 0, 0, ny)
+	if got := s.Next(from); !got.Equal(want) {
+		t.Errorf("Next = %v, want %v", got, want)
+	}
+	if got := s.Next(from); got.Location() != ny {
+		t.Errorf("Next is in %v, want %v", got.Location(), ny)
+	}
+}
@@ -0,0 +1,65 @@
+// Package devices describes the field hardware the service talks to: bay
+// occupancy sensors, barrier controllers and their heartbeats. Transports
+// such as the MQTT bridge implement Barriers and feed a Sink.
+package devices
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Barrier actions.
+const (
+	Open  = "OPEN"
+	Close = "CLOSE"
+)
+
+// Command tells a gate's barrier to move.
+type Command struct {
+	GateID    string    `json:"gateId"`
+	Action    string    `json:"action"`
+	BookingID string    `json:"bookingId,omitempty"`
+	Reason    string    `json:"reason"`
+	At        time.Time `json:"at"`
+}
+
+// Barriers sends commands to barrier controllers.
+type Barriers interface {
+	Send(ctx context.Context, cmd Command) error
+}
+
+// ErrNoBarriers is returned by NoBarriers: the command went nowhere.
+var ErrNoBarriers = errors.New("devices: no barrier hardware configured")
+
+// NoBarriers is used when no barrier hardware is connected; commands are
+// dropped with ErrNoBarriers.
+type NoBarriers struct{}
+
+func (NoBarriers) Send(context.Context, Command) error { return ErrNoBarriers }
+
+// SensorReading is a bay sensor reporting whether a car is on its spot.
+type SensorReading struct {
+	LotID    string
+	SpotID   string
+	DeviceID string
+	Occupied bool
+	At       time.Time
+}
+
+// Heartbeat is a device reporting that it is alive.
+type Heartbeat struct {
+	DeviceID string
+	Kind     string
+	LotID    string
+	SpotID   string
+	GateID   string
+	Firmware string
+	At       time.Time
+}
+
+// Sink receives what devices report.
+type Sink interface {
+	SpotSensor(ctx context.Context, r SensorReading) error
+	Heartbeat(ctx context.Context, hb Heartbeat) error
+}
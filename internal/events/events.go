@@ -0,0 +1,208 @@
+// Package events carries live spot and lot changes to stream subscribers.
+//
+// A change is announced with Notify inside the transaction that makes it,
+// as a Postgres NOTIFY on Channel, so it goes out only if the transaction
+// commits. Every replica runs Listen, which feeds the notifications into its
+// in-process Bus; stream handlers subscribe to the Bus. Events that only
+// matter to this replica's clients (periodic summaries) are published to the
+// Bus directly.
+package events
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Channel is the Postgres NOTIFY channel events travel on.
+const Channel = "parking_events"
+
+// Event types.
+const (
+	SpotStatus = "spot.status"
+	LotSummary = "lot.summary"
+)
+
+// Event is one change pushed to subscribers.
+type Event struct {
+	Type      string    `json:"type"`
+	LotID     string    `json:"lotId"`
+	SpotID    string    `json:"spotId,omitempty"`
+	Status    string    `json:"status,omitempty"`
+	BookingID string    `json:"bookingId,omitempty"`
+	Summary   *Summary  `json:"summary,omitempty"`
+	At        time.Time `json:"at"`
+}
+
+// Summary counts spots by status. OccupancyRate is over spots in service,
+// i.e. not DISABLED.
+type Summary struct {
+	Total         int     `json:"total"`
+	Available     int     `json:"available"`
+	Occupied      int     `json:"occupied"`
+	Reserved      int     `json:"reserved"`
+	Held          int     `json:"held"`
+	Disabled      int     `json:"disabled"`
+	OccupancyRate float64 `json:"occupancyRate"`
+}
+
+// Add counts n spots of status.
+func (s *Summary) Add(status string, n int) {
+	s.Total += n
+	switch status {
+	case "AVAILABLE":
+		s.Available += n
+	case "OCCUPIED":
+		s.Occupied += n
+	case "RESERVED":
+		s.Reserved += n
+	case "HELD":
+		s.Held += n
+	case "DISABLED":
+		s.Disabled += n
+	}
+	s.OccupancyRate = 0
+	if inService := s.Total - s.Disabled; inService > 0 {
+		s.OccupancyRate = float64(s.Occupied) / float64(inService)
+	}
+}
+
+// subscriberBuffer is how far a subscriber may fall behind before it is
+// dropped; a dropped client reconnects and starts from a fresh summary.
+const subscriberBuffer = 64
+
+type subscriber struct {
+	lotID string
+	ch    chan Event
+}
+
+// Bus fans events out to in-process subscribers. It never blocks a
+// publisher on a slow subscriber.
+type Bus struct {
+	mu     sync.Mutex
+	subs   map[*subscriber]struct{}
+	closed bool
+}
+
+func NewBus() *Bus {
+	return &Bus{subs: make(map[*subscriber]struct{})}
+}
+
+// Subscribe returns a channel of events for lotID ("" for every lot) and a
+// function that ends the subscription. The channel is closed when the
+// subscription ends, the subscriber falls too far behind, or the bus closes.
+func (b *Bus) Subscribe(lotID string) (<-chan Event, func()) {
+	s := &subscriber{lotID: lotID, ch: make(chan Event, subscriberBuffer)}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(s.ch)
+		return s.ch, func() {}
+	}
+	b.subs[s] = struct{}{}
+	return s.ch, func() { b.drop(s) }
+}
+
+func (b *Bus) drop(s *subscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subs[s]; ok {
+		delete(b.subs, s)
+		close(s.ch)
+	}
+}
+
+// Publish delivers e to this replica's subscribers.
+func (b *Bus) Publish(e Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for s := range b.subs {
+		if s.lotID != "" && s.lotID != e.LotID {
+			continue
+		}
+		select {
+		case s.ch <- e:
+		default:
+			delete(b.subs, s)
+			close(s.ch)
+		}
+	}
+}
+
+// Subscribers reports how many subscriptions are open, so periodic work can
+// be skipped when nobody is watching.
+func (b *Bus) Subscribers() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subs)
+}
+
+// Close ends every subscription, e.g. so streams finish on shutdown.
+func (b *Bus) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for s := range b.subs {
+		delete(b.subs, s)
+		close(s.ch)
+	}
+}
+
+// Execer is satisfied by *sql.DB and *sql.Tx.
+type Execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// Notify announces e to every replica. Given a transaction, it is delivered
+// only if the transaction commits.
+func Notify(x Execer, e Event) error {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = x.Exec(`SELECT pg_notify($1, $2)`, Channel, string(payload))
+	return err
+}
+
+// Listen feeds notifications on Channel into bus until ctx is done. It holds
+// its own connection, reconnecting after a pause if it drops.
+func Listen(ctx context.Context, databaseURL string, bus *Bus) {
+	for ctx.Err() == nil {
+		if err := listen(ctx, databaseURL, bus); err != nil && ctx.Err() == nil {
+			log.Printf("events: listen: %v", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(5 * time.Second):
+			}
+		}
+	}
+}
+
+func listen(ctx context.Context, databaseURL string, bus *Bus) error {
+	conn, err := pgx.Connect(ctx, databaseURL)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+Channel); err != nil {
+		return err
+	}
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		var e Event
+		if err := json.Unmarshal([]byte(n.Payload), &e); err != nil {
+			log.Printf("events: bad payload: %v", err)
+			continue
+		}
+		bus.Publish(e)
+	}
+}
@@ -0,0 +1,176 @@
+// Package forecast predicts a lot's hourly occupancy from its own history
+// with seasonal baselines, in-process and without a trained model.
+//
+// Each future hour is predicted from the same local hour on the same
+// weekday in past weeks, weighted towards recent weeks. Holidays are their
+// own season: a holiday hour is predicted from past holidays at that hour,
+// topped up with Sundays when there are too few, and holidays are left out
+// of ordinary weekday baselines. The prediction is then nudged towards the
+// lot's current occupancy, an effect that fades over the following hours.
+// Bands come from the spread of the weighted samples.
+package forecast
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// HalfLifeWeeks is how quickly older weeks lose weight.
+	HalfLifeWeeks = 4.0
+	// minSamples is how many samples a baseline wants before it is trusted;
+	// below it bands widen and holidays borrow Sundays.
+	minSamples = 3
+	// levelDecayHours is the time constant of the pull towards current
+	// occupancy.
+	levelDecayHours = 2.0
+	// z80 gives an 80% band for a normal spread.
+	z80 = 1.2816
+)
+
+// Sample is a past hour: its local start and the average number of spots
+// occupied during it.
+type Sample struct {
+	At       time.Time
+	Occupied float64
+}
+
+type Input struct {
+	Now      time.Time // in the lot's timezone
+	Capacity int       // spots in service
+	Current  float64   // spots occupied now
+	History  []Sample  // hours before Now's hour, in the lot's timezone
+	// Holidays holds the lot's holiday dates as YYYY-MM-DD.
+	Holidays map[string]bool
+}
+
+// Point is the prediction for the hour starting At.
+type Point struct {
+	At       time.Time
+	Expected float64
+	Low      float64 // 80% band
+	High     float64
+	Samples  int
+	Holiday  bool
+}
+
+type slot struct {
+	holiday bool
+	weekday time.Weekday
+	hour    int
+}
+
+func (in *Input) slotOf(t time.Time) slot {
+	if in.Holidays[t.Format("2006-01-02")] {
+		return slot{holiday: true, hour: t.Hour()}
+	}
+	return slot{weekday: t.Weekday(), hour: t.Hour()}
+}
+
+type weighted struct{ v, w float64 }
+
+// Predict returns one point per hour for the next hours hours, starting
+// with the hour Now falls in.
+func Predict(in Input, hours int) []Point {
+	bySlot := map[slot][]weighted{}
+	sundays := map[int][]weighted{}
+	for _, s := range in.History {
+		weeks := in.Now.Sub(s.At).Hours() / (24 * 7)
+		w := weighted{s.Occupied, math.Pow(0.5, weeks/HalfLifeWeeks)}
+		k := in.slotOf(s.At)
+		bySlot[k] = append(bySlot[k], w)
+		if !k.holiday && k.weekday == time.Sunday {
+			sundays[k.hour] = append(sundays[k.hour], w)
+		}
+	}
+	samplesFor := func(k slot) []weighted {
+		got := bySlot[k]
+		if k.holiday && len(got) < minSamples {
+			got = append(append([]weighted{}, got...), sundays[k.hour]...)
+		}
+		return got
+	}
+
+	capacity := float64(in.Capacity)
+	start := time.Date(in.Now.Year(), in.Now.Month(), in.Now.Day(), in.Now.Hour(), 0, 0, 0, in.Now.Location())
+
+	// how far the lot is running above or below its usual level right now
+	nowMean, _, nowN := stats(samplesFor(in.slotOf(start)))
+	var offset float64
+	if nowN > 0 {
+		offset = in.Current - nowMean
+	}
+
+	points := make([]Point, 0, hours)
+	for i := 0; i < hours; i++ {
+		at := start.Add(time.Duration(i) * time.Hour)
+		k := in.slotOf(at)
+		mean, sd, n := stats(samplesFor(k))
+		if n == 0 {
+			mean = in.Current // nothing to go on but now
+		}
+		if n < minSamples {
+			sd = math.Max(sd, 0.15*capacity)
+		}
+		ahead := at.Add(30 * time.Minute).Sub(in.Now).Hours()
+		mean += offset * math.Exp(-math.Max(ahead, 0)/levelDecayHours)
+		points = append(points, Point{
+			At:       at,
+			Expected: clamp(mean, capacity),
+			Low:      clamp(mean-z80*sd, capacity),
+			High:     clamp(mean+z80*sd, capacity),
+			Samples:  n,
+			Holiday:  k.holiday,
+		})
+	}
+	return points
+}
+
+// stats is the weighted mean and standard deviation of ws.
+func stats(ws []weighted) (mean, sd float64, n int) {
+	var sw, sv float64
+	for _, x := range ws {
+		sw += x.w
+		sv += x.w * x.v
+	}
+	if sw == 0 {
+		return 0, 0, 0
+	}
+	mean = sv / sw
+	var ss float64
+	for _, x := range ws {
+		ss += x.w * (x.v - mean) * (x.v - mean)
+	}
+	return mean, math.Sqrt(ss / sw), len(ws)
+}
+
+func clamp(v, capacity float64) float64 {
+	return math.Max(0, math.Min(v, capacity))
+}
+
+// FullAt is when occupancy, starting from current at now and passing
+// through each point's value at the middle of its hour, first reaches
+// level, rounded to five minutes. It returns false when it never does.
+func FullAt(now time.Time, current float64, points []Point, value func(Point) float64, level float64) (time.Time, bool) {
+	if current >= level {
+		return now, true
+	}
+	t0, v0 := now, current
+	for _, p := range points {
+		t1 := p.At.Add(30 * time.Minute)
+		if !t1.After(t0) {
+			continue
+		}
+		v1 := value(p)
+		if v1 >= level {
+			frac := (level - v0) / (v1 - v0)
+			at := t0.Add(time.Duration(frac * float64(t1.Sub(t0))))
+			if at = at.Add(150 * time.Second).Truncate(5 * time.Minute); at.Before(now) {
+				at = now
+			}
+			return at, true
+		}
+		t0, v0 = t1, v1
+	}
+	return time.Time{}, false
+}
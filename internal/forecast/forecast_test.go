@@ -0,0 +1,157 @@
+package forecast
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// Monday 4 May 2026, 10:00
+var now = time.Date(2026, time.May, 4, 10, 0, 0, 0, time.UTC)
+
+// weekly is occupied spots at hour on the same weekday as now, for each of
+// the given weeks back.
+func weekly(hour int, occupied float64, weeks ...int) []Sample {
+	var out []Sample
+	for _, w := range weeks {
+		day := now.AddDate(0, 0, -7*w)
+		out = append(out, Sample{At: time.Date(day.Year(), day.Month(), day.Day(), hour, 0, 0, 0, time.UTC), Occupied: occupied})
+	}
+	return out
+}
+
+func near(a, b float64) bool { return math.Abs(a-b) < 1e-9 }
+
+func TestPredictSteadyHistory(t *testing.T) {
+	var history []Sample
+	for h := 10; h < 13; h++ {
+		history = append(history, weekly(h, 20, 1, 2, 3, 4)...)
+	}
+	points := Predict(Input{Now: now.Add(20 * time.Minute), Capacity: 50, Current: 20, History: history}, 3)
+	if len(points) != 3 {
+		t.Fatalf("got %d points, want 3", len(points))
+	}
+	for i, p := range points {
+		if want := now.Add(time.Duration(i) * time.Hour); !p.At.Equal(want) {
+			t.Errorf("point %d At = %v, want %v", i, p.At, want)
+		}
+		if !near(p.Expected, 20) || !near(p.Low, 20) || !near(p.High, 20) {
+			t.Errorf("point %d = %.2f [%.2f, %.2f], want 20 with no spread", i, p.Expected, p.Low, p.High)
+		}
+		if p.Samples != 4 || p.Holiday {
+			t.Errorf("point %d Samples = %d, Holiday = %v, want 4, false", i, p.Samples, p.Holiday)
+		}
+	}
+}
+
+func TestPredictPullsTowardsCurrent(t *testing.T) {
+	var history []Sample
+	for h := 10; h < 16; h++ {
+		history = append(history, weekly(h, 20, 1, 2, 3)...)
+	}
+	points := Predict(Input{Now: now, Capacity: 50, Current: 30, History: history}, 6)
+	if want := 20 + 10*math.Exp(-0.5/levelDecayHours); !near(points[0].Expected, want) {
+		t.Errorf("first hour = %.3f, want %.3f", points[0].Expected, want)
+	}
+	for i := 1; i < len(points); i++ {
+		if p := points[i]; p.Expected >= points[i-1].Expected || p.Expected <= 20 {
+			t.Errorf("hour %d = %.3f, want between 20 and %.3f", i, p.Expected, points[i-1].Expected)
+		}
+	}
+}
+
+func TestPredictWeightsRecentWeeks(t *testing.T) {
+	history := append(weekly(10, 40, 1), weekly(10, 0, 2)...)
+	p := Predict(Input{Now: now, Capacity: 50, Current: 40, History: history}, 1)[0]
+	w1, w2 := math.Pow(0.5, 1/HalfLifeWeeks), math.Pow(0.5, 2/HalfLifeWeeks)
+	mean := 40 * w1 / (w1 + w2)
+	if want := mean + (40-mean)*math.Exp(-0.5/levelDecayHours); !near(p.Expected, want) {
+		t.Errorf("Expected = %.3f, want %.3f", p.Expected, want)
+	}
+	// too few samples to trust: the band is at least 15% of capacity wide
+	if p.High-p.Expected < z80*0.15*50-1e-9 {
+		t.Errorf("band [%.2f, %.2f] around %.2f is narrower than the minimum", p.Low, p.High, p.Expected)
+	}
+}
+
+func TestPredictWithoutHistory(t *testing.T) {
+	p := Predict(Input{Now: now, Capacity: 40, Current: 12}, 1)[0]
+	if p.Samples != 0 || !near(p.Expected, 12) {
+		t.Errorf("got %.2f from %d samples, want current occupancy 12", p.Expected, p.Samples)
+	}
+	if want := 12 - z80*0.15*40; !near(p.Low, want) {
+		t.Errorf("Low = %.3f, want %.3f", p.Low, want)
+	}
+}
+
+func TestPredictClampsToCapacity(t *testing.T) {
+	history := weekly(10, 10, 1, 2, 3)
+	p := Predict(Input{Now: now, Capacity: 50, Current: 90, History: history}, 1)[0]
+	if p.Expected != 50 || p.High != 50 {
+		t.Errorf("got %.2f [%.2f, %.2f], want clamped to 50", p.Expected, p.Low, p.High)
+	}
+	p = Predict(Input{Now: now, Capacity: 50, Current: -30, History: history}, 1)[0]
+	if p.Expected != 0 || p.Low != 0 {
+		t.Errorf("got %.2f [%.2f, %.2f], want clamped to 0", p.Expected, p.Low, p.High)
+	}
+}
+
+func TestPredictHolidays(t *testing.T) {
+	day := func(d int) string { return now.AddDate(0, 0, d).Format("2006-01-02") }
+	at10 := func(d int, occupied float64) Sample {
+		return Sample{At: now.AddDate(0, 0, d), Occupied: occupied}
+	}
+	// Mondays a week ago (a holiday), two and three weeks ago; Sundays the
+	// day before and eight days before
+	history := []Sample{at10(-7, 45), at10(-14, 20), at10(-21, 20), at10(-1, 5), at10(-8, 5)}
+
+	ordinary := Predict(Input{Now: now, Capacity: 50, Current: 20, History: history,
+		Holidays: map[string]bool{day(-7): true}}, 1)[0]
+	if ordinary.Holiday || ordinary.Samples != 2 || !near(ordinary.Expected, 20) {
+		t.Errorf("ordinary Monday = %.2f from %d samples (holiday %v), want 20 from the 2 non-holiday Mondays",
+			ordinary.Expected, ordinary.Samples, ordinary.Holiday)
+	}
+
+	holiday := Predict(Input{Now: now, Capacity: 50, Current: 20, History: history,
+		Holidays: map[string]bool{day(0): true, day(-7): true}}, 1)[0]
+	if !holiday.Holiday || holiday.Samples != 3 {
+		t.Errorf("holiday = %d samples (holiday %v), want the past holiday plus 2 Sundays", holiday.Samples, holiday.Holiday)
+	}
+
+	enough := map[string]bool{day(0): true, day(-7): true, day(-14): true, day(-21): true}
+	holiday = Predict(Input{Now: now, Capacity: 50, Current: 20, History: history, Holidays: enough}, 1)[0]
+	if holiday.Samples != 3 {
+		t.Errorf("holiday = %d samples, want only the 3 past holidays", holiday.Samples)
+	}
+}
+
+func TestFullAt(t *testing.T) {
+	points := []Point{
+		{At: now.Add(-time.Hour), Expected: 50}, // already over
+		{At: now, Expected: 20},
+		{At: now.Add(time.Hour), Expected: 40},
+	}
+	expected := func(p Point) float64 { return p.Expected }
+
+	tests := []struct {
+		name    string
+		current float64
+		level   float64
+		want    time.Time
+		ok      bool
+	}{
+		{"already full", 30, 30, now, true},
+		{"within first half hour", 10, 15, now.Add(15 * time.Minute), true},
+		{"between midpoints", 10, 30, now.Add(time.Hour), true},
+		{"rounded to five minutes", 10, 33, now.Add(70 * time.Minute), true},
+		{"never", 10, 45, time.Time{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := FullAt(now, tt.current, points, expected, tt.level)
+			if ok != tt.ok || !got.Equal(tt.want) {
+				t.Errorf("FullAt = %v, %v; want %v, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+
+	"Backend-Go/internal/billing"
+
+	"github.com/gin-gonic/gin"
+)
+
+type billingProfileReq struct {
+	LegalName        string `json:"legalName" binding:"required"`
+	GSTIN            string `json:"gstin" binding:"required"`
+	Address          string `json:"address"`
+	SACCode          string `json:"sacCode"`
+	GSTRateBP        *int   `json:"gstRateBp" binding:"omitempty,min=0,max=2800"`
+	RatePerHourPaise int64  `json:"ratePerHourPaise" binding:"min=0"`
+	InvoicePrefix    string `json:"invoicePrefix" binding:"required"`
+	// LotStateCode is the GST state code of where the lot is, its place of
+	// supply; it defaults to the GSTIN's state.
+	LotStateCode string `json:"lotStateCode"`
+}
+
+// SetLotBilling creates or replaces the GST billing profile of a lot.
+func (h *Handler) SetLotBilling(c *gin.Context) {
+	lotID := c.Param("id")
+	var req billingProfileReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "invalid request body", err.Error())
+		return
+	}
+	req.GSTIN = strings.ToUpper(strings.TrimSpace(req.GSTIN))
+	req.InvoicePrefix = strings.ToUpper(strings.TrimSpace(req.InvoicePrefix))
+	if !billing.ValidGSTIN(req.GSTIN) {
+		writeError(c, http.StatusBadRequest, "INVALID_GSTIN", "gstin is not a valid GSTIN", nil)
+		return
+	}
+	stateCode := billing.StateCode(req.GSTIN)
+	if !billing.ValidStateCode(stateCode) {
+		writeError(c, http.StatusBadRequest, "INVALID_GSTIN", "gstin has an unknown state code", nil)
+		return
+	}
+	if req.LotStateCode == "" {
+		req.LotStateCode = stateCode
+	}
+	if !billing.ValidStateCode(req.LotStateCode) {
+		writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "lotStateCode is not a known GST state code", nil)
+		return
+	}
+	if !billing.ValidInvoicePrefix(req.InvoicePrefix) {
+		writeError(c, http.StatusBadRequest, "INVALID_INVOICE_PREFIX", "invoicePrefix must be 1-4 letters or digits", nil)
+		return
+	}
+	if req.SACCode == "" {
+		req.SACCode = billing.SACParking
+	}
+	rateBP := 1800
+	if req.GSTRateBP != nil {
+		rateBP = *req.GSTRateBP
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_BEGIN_FAILED", "could not start transaction", nil)
+		return
+	}
+	defer tx.Rollback()
+
+	before, err := auditSnapshot(tx, "lot_billing", lotID)
+	if err != nil {
+		writeAuditError(c, err)
+		return
+	}
+	res, err := tx.Exec(`
+		INSERT INTO lot_billing_profiles (lot_id, legal_name, gstin, address, state_code, sac_code, gst_rate_bp, rate_per_hour_paise, invoice_prefix, lot_state_code)
+		SELECT id, $2, $3, $4, $5, $6, $7, $8, $9, $10 FROM parking_lots WHERE id = $1
+		ON CONFLICT (lot_id) DO UPDATE SET
+			legal_name = EXCLUDED.legal_name,
+			gstin = EXCLUDED.gstin,
+			address = EXCLUDED.address,
+			state_code = EXCLUDED.state_code,
+			sac_code = EXCLUDED.sac_code,
+			gst_rate_bp = EXCLUDED.gst_rate_bp,
+			rate_per_hour_paise = EXCLUDED.rate_per_hour_paise,
+			invoice_prefix = EXCLUDED.invoice_prefix,
+			lot_state_code = EXCLUDED.lot_state_code,
+			updated_at = now()
+	`, lotID, req.LegalName, req.GSTIN, req.Address, stateCode, req.SACCode, rateBP, req.RatePerHourPaise, req.InvoicePrefix, req.LotStateCode)
+	if err != nil {
+		writeError(c, http.StatusBadRequest, "SET_BILLING_FAILED", "could not save billing profile", err.Error())
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		writeError(c, http.StatusNotFound, "LOT_NOT_FOUND", "lot not found", nil)
+		return
+	}
+	if err := auditRow(tx, c, "lot_billing.set", "lot_billing", lotID, before); err != nil {
+		writeAuditError(c, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_COMMIT_FAILED", "failed to commit billing profile", err.Error())
+		return
+	}
+
+	writeOK(c, gin.H{"data": gin.H{
+		"lotId":            lotID,
+		"legalName":        req.LegalName,
+		"gstin":            req.GSTIN,
+		"address":          req.Address,
+		"stateCode":        stateCode,
+		"lotStateCode":     req.LotStateCode,
+		"sacCode":          req.SACCode,
+		"gstRateBp":        rateBP,
+		"ratePerHourPaise": req.RatePerHourPaise,
+		"invoicePrefix":    req.InvoicePrefix,
+	}})
+}
@@ -0,0 +1,84 @@
+package handler
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type createCameraReq struct {
+	Name string `json:"name" binding:"required"`
+}
+
+// CreateCamera registers an ANPR camera on a gate and returns its API key.
+// Only a hash of the key is kept, so it is shown this once.
+func (h *Handler) CreateCamera(c *gin.Context) {
+	var req createCameraReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "invalid request body", err.Error())
+		return
+	}
+	gateID := c.Param("id")
+
+	raw := make([]byte, 24)
+	if _, err := rand.Read(raw); err != nil {
+		writeError(c, http.StatusInternalServerError, "KEY_GENERATION_FAILED", "failed to generate API key", err.Error())
+		return
+	}
+	key := "anpr_" + hex.EncodeToString(raw)
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_BEGIN_FAILED", "could not start transaction", nil)
+		return
+	}
+	defer tx.Rollback()
+
+	var id string
+	err = tx.QueryRow(`
+		INSERT INTO anpr_cameras (gate_id, name, key_hash) VALUES ($1, $2, $3) RETURNING id
+	`, gateID, req.Name, hashToken(key)).Scan(&id)
+	if err != nil {
+		writeError(c, http.StatusBadRequest, "CREATE_CAMERA_FAILED", "could not create camera (maybe unknown gate)", err.Error())
+		return
+	}
+	if err := auditRow(tx, c, "camera.create", "camera", id, nil); err != nil {
+		writeAuditError(c, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_COMMIT_FAILED", "failed to commit camera", err.Error())
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"data": gin.H{
+		"id": id, "gateId": gateID, "name": req.Name, "apiKey": key, "active": true,
+	}})
+}
+
+// ListCameras lists the ANPR cameras on a gate.
+func (h *Handler) ListCameras(c *gin.Context) {
+	rows, err := h.DB.Query(`
+		SELECT id, name, active, created_at FROM anpr_cameras WHERE gate_id = $1 ORDER BY name
+	`, c.Param("id"))
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "CAMERAS_FETCH_FAILED", "failed to fetch cameras", err.Error())
+		return
+	}
+	defer rows.Close()
+
+	items := make([]gin.H, 0, 4)
+	for rows.Next() {
+		var id, name string
+		var active bool
+		var created time.Time
+		if err := rows.Scan(&id, &name, &active, &created); err != nil {
+			writeError(c, http.StatusInternalServerError, "SCAN_ERROR", "failed to read row", err.Error())
+			return
+		}
+		items = append(items, gin.H{"id": id, "name": name, "active": active, "createdAt": toIST(created)})
+	}
+	writeOK(c, gin.H{"items": items})
+}
@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type createGateReq struct {
+	Name      string `json:"name" binding:"required"`
+	Direction string `json:"direction" binding:"required,oneof=ENTRY EXIT BOTH"`
+}
+
+// CreateGate registers an entry, exit or two-way gate on a lot.
+func (h *Handler) CreateGate(c *gin.Context) {
+	var req createGateReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "invalid request body", err.Error())
+		return
+	}
+	lotID := c.Param("id")
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_BEGIN_FAILED", "could not start transaction", nil)
+		return
+	}
+	defer tx.Rollback()
+
+	var id string
+	err = tx.QueryRow(`
+		INSERT INTO gates (lot_id, name, direction) VALUES ($1, $2, $3) RETURNING id
+	`, lotID, req.Name, req.Direction).Scan(&id)
+	if err != nil {
+		writeError(c, http.StatusBadRequest, "CREATE_GATE_FAILED", "could not create gate (maybe unknown lot or duplicate name)", err.Error())
+		return
+	}
+	if err := auditRow(tx, c, "gate.create", "gate", id, nil); err != nil {
+		writeAuditError(c, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_COMMIT_FAILED", "failed to commit gate", err.Error())
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"data": gin.H{
+		"id": id, "lotId": lotID, "name": req.Name, "direction": req.Direction, "active": true,
+	}})
+}
+
+// ListGates lists a lot's gates.
+func (h *Handler) ListGates(c *gin.Context) {
+	rows, err := h.DB.Query(`
+		SELECT id, name, direction, active, created_at FROM gates WHERE lot_id = $1 ORDER BY name
+	`, c.Param("id"))
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "GATES_FETCH_FAILED", "failed to fetch gates", err.Error())
+		return
+	}
+	defer rows.Close()
+
+	items := make([]gin.H, 0, 4)
+	for rows.Next() {
+		var id, name, direction string
+		var active bool
+		var created time.Time
+		if err := rows.Scan(&id, &name, &direction, &active, &created); err != nil {
+			writeError(c, http.StatusInternalServerError, "SCAN_ERROR", "failed to read row", err.Error())
+			return
+		}
+		items = append(items, gin.H{
+			"id": id, "name": name, "direction": direction, "active": active, "createdAt": toIST(created),
+		})
+	}
+	writeOK(c, gin.H{"items": items})
+}
@@ -2,12 +2,15 @@ package handler
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
 type createLotReq struct {
 	Name string `json:"name" binding:"required"`
+	// Timezone is the IANA zone reports for the lot use; default Asia/Kolkata.
+	Timezone string `json:"timezone"`
 }
 
 func (h *Handler) CreateLot(c *gin.Context) {
@@ -16,11 +19,81 @@ func (h *Handler) CreateLot(c *gin.Context) {
         writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "invalid request body", err.Error())
         return
     }
+    if req.Timezone == "" {
+        req.Timezone = "Asia/Kolkata"
+    }
+    if _, err := time.LoadLocation(req.Timezone); err != nil {
+        writeError(c, http.StatusBadRequest, "INVALID_TIMEZONE", "timezone is not a known IANA zone", nil)
+        return
+    }
+    tx, err := h.DB.Begin()
+    if err != nil {
+        writeError(c, http.StatusInternalServerError, "TX_BEGIN_FAILED", "could not start transaction", nil)
+        return
+    }
+    defer tx.Rollback()
+
     var id string
-    err := h.DB.QueryRow(`INSERT INTO parking_lots (name) VALUES ($1) RETURNING id`, req.Name).Scan(&id)
+    err = tx.QueryRow(`INSERT INTO parking_lots (name, timezone) VALUES ($1, $2) RETURNING id`, req.Name, req.Timezone).Scan(&id)
     if err != nil {
         writeError(c, http.StatusBadRequest, "CREATE_LOT_FAILED", "could not create lot (maybe duplicate name)", err.Error())
         return
     }
-    c.JSON(http.StatusCreated, gin.H{"data": gin.H{"id": id, "name": req.Name}})
+    if err := auditRow(tx, c, "lot.create", "lot", id, nil); err != nil {
+        writeAuditError(c, err)
+        return
+    }
+    if err := tx.Commit(); err != nil {
+        writeError(c, http.StatusInternalServerError, "TX_COMMIT_FAILED", "failed to commit lot", err.Error())
+        return
+    }
+    c.JSON(http.StatusCreated, gin.H{"data": gin.H{"id": id, "name": req.Name, "timezone": req.Timezone}})
+}
+
+type lotTimezoneReq struct {
+	Timezone string `json:"timezone" binding:"required"`
+}
+
+// SetLotTimezone changes the timezone a lot's reports are cut in.
+func (h *Handler) SetLotTimezone(c *gin.Context) {
+	var req lotTimezoneReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "invalid request body", err.Error())
+		return
+	}
+	if _, err := time.LoadLocation(req.Timezone); err != nil {
+		writeError(c, http.StatusBadRequest, "INVALID_TIMEZONE", "timezone is not a known IANA zone", nil)
+		return
+	}
+	id := c.Param("id")
+	tx, err := h.DB.Begin()
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_BEGIN_FAILED", "could not start transaction", nil)
+		return
+	}
+	defer tx.Rollback()
+
+	before, err := auditSnapshot(tx, "lot", id)
+	if err != nil {
+		writeAuditError(c, err)
+		return
+	}
+	res, err := tx.Exec(`UPDATE parking_lots SET timezone = $2 WHERE id = $1`, id, req.Timezone)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "SET_TIMEZONE_FAILED", "failed to update timezone", err.Error())
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		writeError(c, http.StatusNotFound, "LOT_NOT_FOUND", "lot not found", nil)
+		return
+	}
+	if err := auditRow(tx, c, "lot.set_timezone", "lot", id, before); err != nil {
+		writeAuditError(c, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_COMMIT_FAILED", "failed to commit timezone", err.Error())
+		return
+	}
+	writeOK(c, gin.H{"data": gin.H{"id": id, "timezone": req.Timezone}})
 }
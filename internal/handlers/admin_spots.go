@@ -11,6 +11,8 @@ type createSpotReq struct {
 	LotID   string `json:"lotId" binding:"required"`
 	LevelID string `json:"levelId" binding:"required"`
 	Number  string `json:"number" binding:"required"`
+	// VehicleType limits the spot to one vehicle type; empty accepts any.
+	VehicleType string `json:"vehicleType"`
 }
 
 func (h *Handler) CreateSpot(c *gin.Context) {
@@ -19,18 +21,39 @@ func (h *Handler) CreateSpot(c *gin.Context) {
         writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "invalid request body", err.Error())
         return
     }
+    tx, err := h.DB.Begin()
+    if err != nil {
+        writeError(c, http.StatusInternalServerError, "TX_BEGIN_FAILED", "could not start transaction", nil)
+        return
+    }
+    defer tx.Rollback()
+
     var id string
-    err := h.DB.QueryRow(`
-        INSERT INTO parking_spots (lot_id, level_id, number, status)
-        VALUES ($1, $2, $3, 'AVAILABLE')
+    err = tx.QueryRow(`
+        INSERT INTO parking_spots (lot_id, level_id, number, status, vehicle_type)
+        VALUES ($1, $2, $3, 'AVAILABLE', $4)
         RETURNING id
-    `, req.LotID, req.LevelID, req.Number).Scan(&id)
+    `, req.LotID, req.LevelID, req.Number, nullIfEmpty(req.VehicleType)).Scan(&id)
     if err != nil {
         writeError(c, http.StatusBadRequest, "CREATE_SPOT_FAILED", "could not create spot", err.Error())
         return
     }
+    if err := spotChanged(tx, req.LotID, id, "AVAILABLE", ""); err != nil {
+        writeError(c, http.StatusInternalServerError, "CREATE_SPOT_FAILED", "could not record spot event", err.Error())
+        return
+    }
+    if err := auditRow(tx, c, "spot.create", "spot", id, nil); err != nil {
+        writeAuditError(c, err)
+        return
+    }
+    if err := tx.Commit(); err != nil {
+        writeError(c, http.StatusInternalServerError, "TX_COMMIT_FAILED", "failed to commit spot", err.Error())
+        return
+    }
+    h.announceSpot(req.LotID, id, "AVAILABLE")
     c.JSON(http.StatusCreated, gin.H{"data": gin.H{
         "id": id, "lotId": req.LotID, "levelId": req.LevelID, "number": req.Number, "status": "AVAILABLE",
+        "vehicleType": nullIfEmpty(req.VehicleType),
     }})
 }
 
@@ -40,9 +63,16 @@ func (h *Handler) DeleteSpot(c *gin.Context) {
         writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "id is required", nil)
         return
     }
+    tx, err := h.DB.Begin()
+    if err != nil {
+        writeError(c, http.StatusInternalServerError, "TX_BEGIN_FAILED", "could not start transaction", nil)
+        return
+    }
+    defer tx.Rollback()
+
     // disallow delete if spot is occupied
     var status string
-    err := h.DB.QueryRow(`SELECT status FROM parking_spots WHERE id = $1`, id).Scan(&status)
+    err = tx.QueryRow(`SELECT status FROM parking_spots WHERE id = $1 FOR UPDATE`, id).Scan(&status)
     if err == sql.ErrNoRows {
         writeError(c, http.StatusNotFound, "SPOT_NOT_FOUND", "spot not found", nil)
         return
@@ -54,16 +84,44 @@ func (h *Handler) DeleteSpot(c *gin.Context) {
         writeError(c, http.StatusConflict, "SPOT_OCCUPIED", "cannot delete an occupied spot", nil)
         return
     }
-    res, err := h.DB.Exec(`DELETE FROM parking_spots WHERE id = $1`, id)
+    if status == "HELD" {
+        writeError(c, http.StatusConflict, "SPOT_HELD", "cannot delete a spot held for a waitlist offer", nil)
+        return
+    }
+    if passID, err := reservingPass(tx, id); err != nil {
+        writeError(c, http.StatusInternalServerError, "FETCH_SPOT_FAILED", "failed to check spot reservations", err.Error())
+        return
+    } else if passID != "" {
+        writeError(c, http.StatusConflict, "SPOT_RESERVED", "cannot delete a spot reserved for an active pass", nil)
+        return
+    }
+    before, err := auditSnapshot(tx, "spot", id)
     if err != nil {
-        writeError(c, http.StatusInternalServerError, "DELETE_SPOT_FAILED", "failed to delete spot", err.Error())
+        writeAuditError(c, err)
         return
     }
-    n, _ := res.RowsAffected()
-    if n == 0 {
+    var lotID string
+    err = tx.QueryRow(`DELETE FROM parking_spots WHERE id = $1 RETURNING lot_id`, id).Scan(&lotID)
+    if err == sql.ErrNoRows {
         writeError(c, http.StatusNotFound, "SPOT_NOT_FOUND", "spot not found", nil)
         return
+    } else if err != nil {
+        writeError(c, http.StatusInternalServerError, "DELETE_SPOT_FAILED", "failed to delete spot", err.Error())
+        return
+    }
+    if err := spotChanged(tx, lotID, id, "DELETED", ""); err != nil {
+        writeError(c, http.StatusInternalServerError, "DELETE_SPOT_FAILED", "could not record spot event", err.Error())
+        return
+    }
+    if err := audit(tx, c, "spot.delete", "spot", id, before, nil); err != nil {
+        writeAuditError(c, err)
+        return
+    }
+    if err := tx.Commit(); err != nil {
+        writeError(c, http.StatusInternalServerError, "TX_COMMIT_FAILED", "failed to commit spot deletion", err.Error())
+        return
     }
+    h.announceSpot(lotID, id, "DELETED")
     writeOK(c, gin.H{"data": gin.H{"id": id, "deleted": true}})
 }
 
@@ -0,0 +1,52 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type setRoleReq struct {
+	Role string `json:"role" binding:"required,oneof=user admin operator"`
+}
+
+// SetUserRole changes a user's role, e.g. to make gate staff operators. It
+// takes effect at the user's next login.
+func (h *Handler) SetUserRole(c *gin.Context) {
+	var req setRoleReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "invalid request body", err.Error())
+		return
+	}
+	id := c.Param("id")
+	tx, err := h.DB.Begin()
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_BEGIN_FAILED", "could not start transaction", nil)
+		return
+	}
+	defer tx.Rollback()
+
+	before, err := auditSnapshot(tx, "user", id)
+	if err != nil {
+		writeAuditError(c, err)
+		return
+	}
+	res, err := tx.Exec(`UPDATE users SET role = $2 WHERE id = $1`, id, req.Role)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "SET_ROLE_FAILED", "failed to update role", err.Error())
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		writeError(c, http.StatusNotFound, "USER_NOT_FOUND", "user not found", nil)
+		return
+	}
+	if err := auditRow(tx, c, "user.set_role", "user", id, before); err != nil {
+		writeAuditError(c, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_COMMIT_FAILED", "failed to commit role", err.Error())
+		return
+	}
+	writeOK(c, gin.H{"data": gin.H{"id": id, "role": req.Role}})
+}
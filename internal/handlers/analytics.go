@@ -0,0 +1,149 @@
+package handler
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// heatmapSQL averages, for each weekday and hour of the lot's day, how many
+// spots were occupied and how many sessions began. Every local hour in the
+// span that has started is one sample.
+const heatmapSQL = reportSessionsSQL + `, slots AS (
+		SELECT h AS local_hour,
+		       h AT TIME ZONE $1::text AS start_at,
+		       (h + interval '1 hour') AT TIME ZONE $1::text AS end_at
+		FROM generate_series($2::text::timestamp, $3::text::timestamp - interval '1 hour', interval '1 hour') h
+	), per_slot AS (
+		SELECT s.local_hour,
+		       COALESCE(SUM(EXTRACT(EPOCH FROM
+		           LEAST(COALESCE(x.end_time, now()), s.end_at) - GREATEST(x.start_time, s.start_at))), 0)::float8
+		           / EXTRACT(EPOCH FROM LEAST(s.end_at, now()) - s.start_at)::float8 AS occupied,
+		       COUNT(x.id) FILTER (WHERE x.start_time >= s.start_at) AS arrivals
+		FROM slots s
+		LEFT JOIN sess x ON x.start_time < s.end_at AND COALESCE(x.end_time, now()) > s.start_at
+		WHERE s.start_at < now()
+		GROUP BY s.local_hour, s.start_at, s.end_at
+	)
+	SELECT EXTRACT(ISODOW FROM local_hour)::int, EXTRACT(HOUR FROM local_hour)::int,
+	       COUNT(*), AVG(occupied), MAX(occupied), AVG(arrivals)::float8
+	FROM per_slot
+	GROUP BY 1, 2
+	ORDER BY 1, 2`
+
+// dwellSQL buckets completed sessions that started in the span by length,
+// with each bucket's share and the running share up to it.
+const dwellSQL = reportSessionsSQL + `, buckets (idx, label, lo, hi) AS (
+		VALUES (1, '<15m', 0, 15), (2, '15-30m', 15, 30), (3, '30-60m', 30, 60),
+		       (4, '1-2h', 60, 120), (5, '2-4h', 120, 240), (6, '4-8h', 240, 480),
+		       (7, '8-24h', 480, 1440), (8, '24h+', 1440, NULL)
+	)
+	SELECT b.label, b.lo, b.hi, COUNT(x.id),
+	       COALESCE(COUNT(x.id)::float8 / NULLIF(SUM(COUNT(x.id)) OVER (), 0), 0),
+	       COALESCE(SUM(COUNT(x.id)) OVER (ORDER BY b.idx)::float8 / NULLIF(SUM(COUNT(x.id)) OVER (), 0), 0)
+	FROM buckets b
+	LEFT JOIN sess x ON x.in_span AND x.end_time IS NOT NULL
+	                AND x.mins >= b.lo AND (b.hi IS NULL OR x.mins < b.hi)
+	GROUP BY b.idx, b.label, b.lo, b.hi
+	ORDER BY b.idx`
+
+// Heatmap returns an hour-of-day by day-of-week occupancy heatmap and a
+// dwell-time histogram for the sessions picked by the report filters
+// (?lotId=, ?from=, ?to=, ...; see parseReportFilter). Hours and weekdays
+// are in the report's timezone; dayOfWeek runs from 1 (Monday) to 7.
+func (h *Handler) Heatmap(c *gin.Context) {
+	f, ok := h.parseReportFilter(c)
+	if !ok {
+		return
+	}
+	f.GroupBy = ""
+
+	var spots int
+	if err := h.DB.QueryRow(reportSessionsSQL+`
+		SELECT COUNT(*) FROM spots WHERE status <> 'DISABLED'
+	`, f.args()...).Scan(&spots); err != nil {
+		writeError(c, http.StatusInternalServerError, "ANALYTICS_FAILED", "failed to count spots", err.Error())
+		return
+	}
+
+	rows, err := h.DB.Query(heatmapSQL, f.args()...)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "ANALYTICS_FAILED", "failed to compute heatmap", err.Error())
+		return
+	}
+	defer rows.Close()
+
+	cells := make([]gin.H, 0, 7*24)
+	var peak gin.H
+	var peakOccupied float64
+	for rows.Next() {
+		var dow, hour, samples int
+		var avgOccupied, maxOccupied, avgArrivals float64
+		if err := rows.Scan(&dow, &hour, &samples, &avgOccupied, &maxOccupied, &avgArrivals); err != nil {
+			writeError(c, http.StatusInternalServerError, "SCAN_ERROR", "failed to read row", err.Error())
+			return
+		}
+		cell := gin.H{
+			"dayOfWeek":     dow,
+			"hour":          hour,
+			"samples":       samples,
+			"avgOccupied":   avgOccupied,
+			"peakOccupied":  maxOccupied,
+			"occupancyRate": nil,
+			"avgArrivals":   avgArrivals,
+		}
+		if spots > 0 {
+			cell["occupancyRate"] = avgOccupied / float64(spots)
+		}
+		if peak == nil || avgOccupied > peakOccupied {
+			peak, peakOccupied = cell, avgOccupied
+		}
+		cells = append(cells, cell)
+	}
+	if err := rows.Err(); err != nil {
+		writeError(c, http.StatusInternalServerError, "ANALYTICS_FAILED", "failed to compute heatmap", err.Error())
+		return
+	}
+
+	dwell, err := h.DB.Query(dwellSQL, f.args()...)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "ANALYTICS_FAILED", "failed to compute dwell times", err.Error())
+		return
+	}
+	defer dwell.Close()
+
+	buckets := make([]gin.H, 0, 8)
+	completed := 0
+	for dwell.Next() {
+		var label string
+		var lo int
+		var hi sql.NullInt64
+		var n int
+		var share, cumulative float64
+		if err := dwell.Scan(&label, &lo, &hi, &n, &share, &cumulative); err != nil {
+			writeError(c, http.StatusInternalServerError, "SCAN_ERROR", "failed to read row", err.Error())
+			return
+		}
+		completed += n
+		buckets = append(buckets, gin.H{
+			"label":           label,
+			"minMins":         lo,
+			"maxMins":         nullInt(hi),
+			"sessions":        n,
+			"share":           share,
+			"cumulativeShare": cumulative,
+		})
+	}
+
+	writeOK(c, gin.H{"data": gin.H{
+		"filters": f.echo(),
+		"spots":   spots,
+		"heatmap": cells,
+		"peak":    peak,
+		"dwell": gin.H{
+			"completedSessions": completed,
+			"buckets":           buckets,
+		},
+	}})
+}
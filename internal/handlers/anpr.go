@@ -0,0 +1,510 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"Backend-Go/internal/anpr"
+	"Backend-Go/internal/devices"
+
+	"github.com/gin-gonic/gin"
+)
+
+// anprDedupeWindow swallows repeat reads of the same plate by one camera;
+// cameras fire several times as a car approaches.
+const anprDedupeWindow = 60 * time.Second
+
+type anprCamera struct {
+	ID            string
+	GateID        string
+	LotID         string
+	GateDirection string
+}
+
+// CameraAuth authenticates ANPR cameras by their X-API-Key header.
+func (h *Handler) CameraAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader("X-API-Key")
+		if key == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": gin.H{"code": "UNAUTHORIZED", "message": "missing API key"}})
+			return
+		}
+		var cam anprCamera
+		err := h.DB.QueryRow(`
+			SELECT c.id, c.gate_id, g.lot_id, g.direction
+			FROM anpr_cameras c
+			JOIN gates g ON g.id = c.gate_id
+			WHERE c.key_hash = $1 AND c.active AND g.active
+		`, hashToken(key)).Scan(&cam.ID, &cam.GateID, &cam.LotID, &cam.GateDirection)
+		if err == sql.ErrNoRows {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": gin.H{"code": "UNAUTHORIZED", "message": "invalid API key"}})
+			return
+		} else if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": gin.H{"code": "CAMERA_AUTH_FAILED", "message": "failed to check API key"}})
+			return
+		}
+		c.Set("camera", cam)
+		c.Next()
+	}
+}
+
+type anprRead struct {
+	Plate      string
+	Confidence float64
+	ImageRef   string
+	Direction  string
+	CapturedAt time.Time
+}
+
+// anprOutcome is what became of a read.
+type anprOutcome struct {
+	ReadID       string `json:"readId"`
+	Status       string `json:"status"`
+	Action       string `json:"action"`
+	ReviewReason string `json:"reviewReason,omitempty"`
+	VehicleID    string `json:"vehicleId,omitempty"`
+	BookingID    string `json:"bookingId,omitempty"`
+	GateEventID  string `json:"gateEventId,omitempty"`
+	// BarrierOpened is set when the read let the car through.
+	BarrierOpened bool `json:"barrierOpened"`
+}
+
+// errReadNotInReview is returned when a read being resolved was resolved or
+// dismissed by someone else first.
+var errReadNotInReview = errors.New("plate read is not waiting for review")
+
+// ingestRead processes one plate read. Confident reads of a known vehicle
+// start or end its session and every processed read is logged as a gate
+// event; anything doubtful goes to the review queue. Reviewers resolve a
+// queued read (readID) by running it again with reviewerID set, which skips
+// the duplicate and confidence checks. Session changes are audited against
+// the caller of c: the camera, or the reviewer.
+func (h *Handler) ingestRead(c *gin.Context, cam anprCamera, read anprRead, readID, reviewerID string) (*anprOutcome, error) {
+	ctx := c.Request.Context()
+	out := &anprOutcome{ReadID: readID, Action: "NONE"}
+	read.Plate = anpr.Normalize(read.Plate)
+
+	if reviewerID == "" {
+		var dup bool
+		err := h.DB.QueryRow(`
+			SELECT EXISTS (
+				SELECT 1 FROM anpr_reads
+				WHERE camera_id = $1 AND plate = $2 AND status <> 'DUPLICATE'
+				  AND captured_at > $3 AND captured_at <= $4
+			)
+		`, cam.ID, read.Plate, read.CapturedAt.Add(-anprDedupeWindow), read.CapturedAt).Scan(&dup)
+		if err != nil {
+			return nil, err
+		}
+		if dup {
+			out.Status = "DUPLICATE"
+			return out, saveRead(h.DB, cam, read, out, "")
+		}
+		if read.Confidence < h.Cfg.ANPRMinConfidence {
+			out.Status = "REVIEW"
+			out.ReviewReason = "LOW_CONFIDENCE"
+			return out, saveRead(h.DB, cam, read, out, "")
+		}
+	}
+
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if readID != "" {
+		// two reviewers resolving the same read: the second waits here and
+		// then finds it already done
+		var status string
+		if err := tx.QueryRow(`SELECT status FROM anpr_reads WHERE id = $1 FOR UPDATE`, readID).Scan(&status); err != nil {
+			return nil, err
+		}
+		if status != "REVIEW" {
+			return nil, errReadNotInReview
+		}
+	}
+
+	berr, err := h.applyRead(c, tx, cam, read, out)
+	if err != nil {
+		return nil, err
+	}
+	if berr != nil {
+		// nothing the read did stands; queue it with the reason
+		tx.Rollback()
+		*out = anprOutcome{ReadID: readID, Status: "REVIEW", Action: "NONE", ReviewReason: berr.code, VehicleID: out.VehicleID}
+		return out, saveRead(h.DB, cam, read, out, reviewerID)
+	}
+
+	out.Status = "PROCESSED"
+	if reviewerID != "" {
+		out.Status = "RESOLVED"
+	}
+	var before json.RawMessage
+	if readID != "" {
+		if before, err = auditSnapshot(tx, "anpr_read", readID); err != nil {
+			return nil, err
+		}
+	}
+	if err := saveRead(tx, cam, read, out, reviewerID); err != nil {
+		return nil, err
+	}
+	if readID != "" {
+		if err := auditRow(tx, c, "anpr_read.apply", "anpr_read", readID, before); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	// let known cars in, and cars out once their session is closed; an exit
+	// matched to a still-open walk-in ticket waits for payment
+	if out.Action == "STARTED" || out.Action == "ENDED" || (read.Direction == "ENTRY" && out.BookingID != "") {
+		out.BarrierOpened = h.moveBarrier(ctx, cam.GateID, devices.Open, out.BookingID, "ANPR")
+	}
+	return out, nil
+}
+
+// applyRead acts on a read inside tx. A *bookingError means the read could
+// not be applied and needs a person to look at it.
+func (h *Handler) applyRead(c *gin.Context, tx *sql.Tx, cam anprCamera, read anprRead, out *anprOutcome) (*bookingError, error) {
+	rows, err := tx.Query(`
+		SELECT id, user_id, org_id IS NOT NULL, type FROM vehicles
+		WHERE `+anpr.CanonicalSQL("plate")+` = $1
+		LIMIT 2
+	`, anpr.Canonical(read.Plate))
+	if err != nil {
+		return nil, err
+	}
+	type vehicle struct {
+		id, vtype string
+		userID    sql.NullString
+		fleet     bool
+	}
+	var matches []vehicle
+	for rows.Next() {
+		var v vehicle
+		if err := rows.Scan(&v.id, &v.userID, &v.fleet, &v.vtype); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		matches = append(matches, v)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(matches) > 1 {
+		return &bookingError{http.StatusConflict, "AMBIGUOUS_PLATE", "plate matches more than one vehicle", nil}, nil
+	}
+
+	out.Action = "EVENT_ONLY"
+	if len(matches) == 1 {
+		v := matches[0]
+		out.VehicleID = v.id
+
+		var bookingID, spotID, lotID string
+		err := tx.QueryRow(`
+			SELECT b.id, b.spot_id, s.lot_id
+			FROM bookings b
+			JOIN parking_spots s ON s.id = b.spot_id
+			WHERE b.vehicle_id = $1 AND b.end_time IS NULL
+			FOR UPDATE OF b
+		`, v.id).Scan(&bookingID, &spotID, &lotID)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, err
+		}
+		active := err == nil
+
+		switch {
+		case active && lotID != cam.LotID:
+			return &bookingError{http.StatusConflict, "VEHICLE_ACTIVE_ELSEWHERE", "vehicle has an open session in another lot", nil}, nil
+
+		case read.Direction == "ENTRY" && active:
+			// booked ahead in the app; the read just marks the entry
+			out.BookingID = bookingID
+
+		case read.Direction == "ENTRY" && v.userID.Valid && !v.fleet:
+			// fleet vehicles are left to the member who books them
+			spot, err := pickFreeSpot(tx, cam.LotID, v.vtype)
+			if err != nil {
+				return nil, err
+			}
+			if spot == "" {
+				return &bookingError{http.StatusConflict, "LOT_FULL", "no free spot in this lot", nil}, nil
+			}
+			b, berr := h.startBooking(tx, bookingParams{UserID: v.userID.String, VehicleID: v.id, SpotID: spot})
+			if berr != nil {
+				return berr, nil
+			}
+			if err := auditRow(tx, c, "booking.start", "booking", b.ID, nil); err != nil {
+				return nil, err
+			}
+			out.BookingID = b.ID
+			out.Action = "STARTED"
+
+		case read.Direction == "EXIT" && active:
+			before, err := auditSnapshot(tx, "booking", bookingID)
+			if err != nil {
+				return nil, err
+			}
+			if _, err := tx.Exec(`UPDATE bookings SET end_time = now() WHERE id = $1`, bookingID); err != nil {
+				return nil, err
+			}
+			if _, berr := h.finishBooking(tx, bookingID, spotID); berr != nil {
+				return berr, nil
+			}
+			if err := auditRow(tx, c, "booking.anpr_exit", "booking", bookingID, before); err != nil {
+				return nil, err
+			}
+			out.BookingID = bookingID
+			out.Action = "ENDED"
+		}
+	}
+
+	ev, berr := recordGateEvent(tx, gateEvent{
+		GateID:     cam.GateID,
+		Direction:  read.Direction,
+		Method:     "ANPR",
+		Plate:      read.Plate,
+		BookingID:  out.BookingID,
+		OccurredAt: read.CapturedAt,
+	})
+	if berr != nil {
+		return berr, nil
+	}
+	out.GateEventID = ev.ID
+	if out.BookingID == "" {
+		// e.g. a walk-in ticket matched by plate
+		out.BookingID = ev.BookingID
+	}
+	return nil, nil
+}
+
+// saveRead stores a new read, or updates the queued one a reviewer resolved
+// as long as it is still queued.
+func saveRead(q queryRower, cam anprCamera, read anprRead, out *anprOutcome, reviewerID string) error {
+	if out.ReadID != "" {
+		err := q.QueryRow(`
+			UPDATE anpr_reads
+			SET plate = $2, status = $3, action = $4, review_reason = $5,
+			    vehicle_id = $6, booking_id = $7, gate_event_id = $8,
+			    reviewed_by = $9, reviewed_at = now()
+			WHERE id = $1 AND status = 'REVIEW'
+			RETURNING id
+		`, out.ReadID, read.Plate, out.Status, out.Action, nullIfEmpty(out.ReviewReason),
+			nullIfEmpty(out.VehicleID), nullIfEmpty(out.BookingID), nullIfEmpty(out.GateEventID),
+			nullIfEmpty(reviewerID)).Scan(&out.ReadID)
+		if err == sql.ErrNoRows {
+			return errReadNotInReview
+		}
+		return err
+	}
+	return q.QueryRow(`
+		INSERT INTO anpr_reads (camera_id, lot_id, plate, confidence, image_ref, direction, captured_at,
+		                        status, action, review_reason, vehicle_id, booking_id, gate_event_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		RETURNING id
+	`, cam.ID, cam.LotID, read.Plate, read.Confidence, nullIfEmpty(read.ImageRef), read.Direction, read.CapturedAt,
+		out.Status, out.Action, nullIfEmpty(out.ReviewReason),
+		nullIfEmpty(out.VehicleID), nullIfEmpty(out.BookingID), nullIfEmpty(out.GateEventID)).Scan(&out.ReadID)
+}
+
+type anprReadReq struct {
+	Plate      string     `json:"plate" binding:"required"`
+	Confidence *float64   `json:"confidence" binding:"required,min=0,max=1"`
+	ImageRef   string     `json:"imageRef"`
+	Direction  string     `json:"direction" binding:"omitempty,oneof=ENTRY EXIT"`
+	CapturedAt *time.Time `json:"capturedAt"`
+}
+
+// IngestANPRRead accepts one plate read from an authenticated camera.
+func (h *Handler) IngestANPRRead(c *gin.Context) {
+	var req anprReadReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "invalid request body", err.Error())
+		return
+	}
+	cam := c.MustGet("camera").(anprCamera)
+
+	read := anprRead{
+		Plate:      req.Plate,
+		Confidence: *req.Confidence,
+		ImageRef:   req.ImageRef,
+		Direction:  req.Direction,
+		CapturedAt: time.Now(),
+	}
+	if anpr.Normalize(read.Plate) == "" {
+		writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "plate has no letters or digits", nil)
+		return
+	}
+	if cam.GateDirection != "BOTH" {
+		read.Direction = cam.GateDirection
+	} else if read.Direction == "" {
+		writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "direction is required for a camera on a two-way gate", nil)
+		return
+	}
+	if req.CapturedAt != nil {
+		if req.CapturedAt.After(read.CapturedAt.Add(5 * time.Minute)) {
+			writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "capturedAt is in the future", nil)
+			return
+		}
+		read.CapturedAt = *req.CapturedAt
+	}
+
+	out, err := h.ingestRead(c, cam, read, "", "")
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "ANPR_INGEST_FAILED", "failed to process plate read", err.Error())
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"data": out})
+}
+
+// ANPRReviews lists reads waiting for a person, oldest first (?lotId=).
+func (h *Handler) ANPRReviews(c *gin.Context) {
+	rows, err := h.DB.Query(`
+		SELECT r.id, r.camera_id, r.lot_id, r.plate, r.confidence, r.image_ref, r.direction,
+		       r.captured_at, r.review_reason, r.vehicle_id
+		FROM anpr_reads r
+		WHERE r.status = 'REVIEW' AND ($1 = '' OR r.lot_id::text = $1)
+		ORDER BY r.created_at
+		LIMIT 200
+	`, c.Query("lotId"))
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "ANPR_FETCH_FAILED", "failed to fetch review queue", err.Error())
+		return
+	}
+	defer rows.Close()
+
+	items := make([]gin.H, 0, 20)
+	for rows.Next() {
+		var id, cameraID, lotID, plate, direction string
+		var confidence float64
+		var imageRef, reason, vehicleID sql.NullString
+		var captured time.Time
+		if err := rows.Scan(&id, &cameraID, &lotID, &plate, &confidence, &imageRef, &direction, &captured, &reason, &vehicleID); err != nil {
+			writeError(c, http.StatusInternalServerError, "SCAN_ERROR", "failed to read row", err.Error())
+			return
+		}
+		items = append(items, gin.H{
+			"id":           id,
+			"cameraId":     cameraID,
+			"lotId":        lotID,
+			"plate":        plate,
+			"confidence":   confidence,
+			"imageRef":     nullString(imageRef),
+			"direction":    direction,
+			"capturedAt":   toIST(captured),
+			"reviewReason": nullString(reason),
+			"vehicleId":    nullString(vehicleID),
+		})
+	}
+	if err := rows.Err(); err != nil {
+		writeError(c, http.StatusInternalServerError, "ANPR_FETCH_FAILED", "failed to fetch review queue", err.Error())
+		return
+	}
+	writeOK(c, gin.H{"items": items})
+}
+
+type resolveReadReq struct {
+	Action string `json:"action" binding:"required,oneof=APPLY DISMISS"`
+	// Plate corrects what the camera read; APPLY uses the stored plate
+	// otherwise.
+	Plate string `json:"plate"`
+}
+
+// ResolveANPRRead clears a queued read: APPLY acts on it as if it had been
+// read confidently (optionally with a corrected plate), DISMISS drops it.
+func (h *Handler) ResolveANPRRead(c *gin.Context) {
+	var req resolveReadReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "invalid request body", err.Error())
+		return
+	}
+	id := c.Param("id")
+	claims := GetClaims(c)
+
+	var cam anprCamera
+	var read anprRead
+	var imageRef sql.NullString
+	var status string
+	err := h.DB.QueryRow(`
+		SELECT c.id, c.gate_id, g.lot_id, g.direction,
+		       r.plate, r.confidence, r.image_ref, r.direction, r.captured_at, r.status
+		FROM anpr_reads r
+		JOIN anpr_cameras c ON c.id = r.camera_id
+		JOIN gates g ON g.id = c.gate_id
+		WHERE r.id = $1
+	`, id).Scan(&cam.ID, &cam.GateID, &cam.LotID, &cam.GateDirection,
+		&read.Plate, &read.Confidence, &imageRef, &read.Direction, &read.CapturedAt, &status)
+	if err == sql.ErrNoRows {
+		writeError(c, http.StatusNotFound, "READ_NOT_FOUND", "plate read not found", nil)
+		return
+	} else if err != nil {
+		writeError(c, http.StatusInternalServerError, "ANPR_FETCH_FAILED", "failed to fetch plate read", err.Error())
+		return
+	}
+	if status != "REVIEW" {
+		writeError(c, http.StatusConflict, "READ_NOT_IN_REVIEW", errReadNotInReview.Error(), nil)
+		return
+	}
+	read.ImageRef = imageRef.String
+
+	if req.Action == "DISMISS" {
+		tx, err := h.DB.Begin()
+		if err != nil {
+			writeError(c, http.StatusInternalServerError, "TX_BEGIN_FAILED", "could not start transaction", nil)
+			return
+		}
+		defer tx.Rollback()
+
+		before, err := auditSnapshot(tx, "anpr_read", id)
+		if err != nil {
+			writeAuditError(c, err)
+			return
+		}
+		res, err := tx.Exec(`
+			UPDATE anpr_reads SET status = 'DISMISSED', reviewed_by = $2, reviewed_at = now()
+			WHERE id = $1 AND status = 'REVIEW'
+		`, id, claims.UserID)
+		if err != nil {
+			writeError(c, http.StatusInternalServerError, "ANPR_UPDATE_FAILED", "failed to dismiss plate read", err.Error())
+			return
+		}
+		if n, _ := res.RowsAffected(); n != 1 {
+			writeError(c, http.StatusConflict, "READ_NOT_IN_REVIEW", errReadNotInReview.Error(), nil)
+			return
+		}
+		if err := auditRow(tx, c, "anpr_read.dismiss", "anpr_read", id, before); err != nil {
+			writeAuditError(c, err)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			writeError(c, http.StatusInternalServerError, "TX_COMMIT_FAILED", "failed to commit dismissal", err.Error())
+			return
+		}
+		writeOK(c, gin.H{"data": anprOutcome{ReadID: id, Status: "DISMISSED", Action: "NONE"}})
+		return
+	}
+
+	if req.Plate != "" {
+		if anpr.Normalize(req.Plate) == "" {
+			writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "plate has no letters or digits", nil)
+			return
+		}
+		read.Plate = req.Plate
+	}
+	out, err := h.ingestRead(c, cam, read, id, claims.UserID)
+	if errors.Is(err, errReadNotInReview) {
+		writeError(c, http.StatusConflict, "READ_NOT_IN_REVIEW", err.Error(), nil)
+		return
+	} else if err != nil {
+		writeError(c, http.StatusInternalServerError, "ANPR_INGEST_FAILED", "failed to process plate read", err.Error())
+		return
+	}
+	writeOK(c, gin.H{"data": out})
+}
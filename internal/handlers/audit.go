@@ -0,0 +1,236 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// auditEntities maps the entity types in the audit log to the query their
+// snapshots are read with, which takes the entity's ID as $1.
+var auditEntities = map[string]string{
+	"booking":          auditRowQuery("bookings", "id"),
+	"spot":             auditRowQuery("parking_spots", "id"),
+	"lot":              auditRowQuery("parking_lots", "id"),
+	"lot_billing":      auditRowQuery("lot_billing_profiles", "lot_id"),
+	"occupancy_policy": auditRowQuery("lot_occupancy_policies", "lot_id"),
+	"user":             auditRowQuery("users", "id"),
+	"vehicle":          auditRowQuery("vehicles", "id"),
+	"gate":             auditRowQuery("gates", "id"),
+	"gate_event":       auditRowQuery("gate_events", "id"),
+	"camera":           auditRowQuery("anpr_cameras", "id"),
+	"anpr_read":        auditRowQuery("anpr_reads", "id"),
+	"pass":             auditRowQuery("passes", "id"),
+	"pass_product":     auditRowQuery("pass_products", "id"),
+	"org":              auditRowQuery("organizations", "id"),
+	"org_invoice":      auditRowQuery("org_invoices", "id"),
+	"org_member":       `SELECT to_jsonb(m) FROM org_members m WHERE m.org_id::text || ':' || m.user_id::text = $1`,
+	"promo_code":       auditRowQuery("promo_codes", "id"),
+	"waitlist_entry":   auditRowQuery("waitlist_entries", "id"),
+	"discrepancy":      auditRowQuery("occupancy_discrepancies", "id"),
+	"report_schedule":  auditRowQuery("report_schedules", "id"),
+	"holiday":          auditRowQuery("holidays", "id"),
+	"outbox_event":     auditRowQuery("outbox_events", "id"),
+	"webhook":          auditRowQuery("webhook_subscriptions", "id"),
+	"webhook_delivery": auditRowQuery("webhook_deliveries", "id"),
+	"push_token":       auditRowQuery("push_tokens", "token"),
+	"notification_settings": `
+		SELECT jsonb_build_object('locale', u.locale, 'phone', u.phone, 'channels', COALESCE((
+			SELECT jsonb_object_agg(p.kind, p.channels) FROM notification_preferences p WHERE p.user_id = u.id),
+			'{}'::jsonb))
+		FROM users u WHERE u.id::text = $1`,
+	"pricing_policy": `
+		SELECT to_jsonb(p) || jsonb_build_object('steps', COALESCE((
+			SELECT jsonb_agg(jsonb_build_object('thresholdPct', s.threshold_pct, 'multiplierBp', s.multiplier_bp)
+			                 ORDER BY s.threshold_pct)
+			FROM lot_pricing_steps s WHERE s.lot_id = p.lot_id), '[]'::jsonb))
+		FROM lot_pricing_policies p WHERE p.lot_id::text = $1`,
+}
+
+func auditRowQuery(table, key string) string {
+	return `SELECT to_jsonb(t) FROM ` + table + ` t WHERE t.` + key + `::text = $1`
+}
+
+// auditRedacted are columns never copied into the audit log.
+const auditRedacted = `ARRAY['password_hash', 'key_hash', 'secret']`
+
+// execer is satisfied by both *sql.DB and *sql.Tx.
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// auditSnapshot reads the row of entity id as JSON, or nil if there is none.
+// Take it inside the transaction making the change, before and after.
+func auditSnapshot(q queryRower, entity, id string) (json.RawMessage, error) {
+	query, ok := auditEntities[entity]
+	if !ok {
+		panic("audit: unknown entity " + entity)
+	}
+	var row []byte
+	err := q.QueryRow(`SELECT x.j - `+auditRedacted+` FROM (`+query+`) x(j)`, id).Scan(&row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return row, err
+}
+
+// audit appends a change made by the caller of c to the audit log, through
+// the transaction that makes it. before and after are marshalled to JSON; a
+// nil one is stored as NULL.
+func audit(q execer, c *gin.Context, action, entity, id string, before, after interface{}) error {
+	claims := GetClaims(c)
+	role := claims.Role
+	if role == "" {
+		if _, ok := c.Get("camera"); ok {
+			role = "camera"
+		} else {
+			role = "anonymous"
+		}
+	}
+	b, err := auditJSON(before)
+	if err != nil {
+		return err
+	}
+	a, err := auditJSON(after)
+	if err != nil {
+		return err
+	}
+	_, err = q.Exec(`
+		INSERT INTO audit_log (actor_id, actor_role, action, entity_type, entity_id, before, after, request_id, ip)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`, nullIfEmpty(claims.UserID), role, action, entity, id, b, a, nullIfEmpty(c.GetString("request_id")),
+		nullIfEmpty(c.ClientIP()))
+	return err
+}
+
+func auditJSON(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case json.RawMessage:
+		if v == nil {
+			return nil, nil
+		}
+		return string(v), nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// auditRow appends a change to the row of entity id, reading its after
+// state now; before is the snapshot taken ahead of the change, if any.
+func auditRow(tx *sql.Tx, c *gin.Context, action, entity, id string, before json.RawMessage) error {
+	after, err := auditSnapshot(tx, entity, id)
+	if err != nil {
+		return err
+	}
+	return audit(tx, c, action, entity, id, before, after)
+}
+
+// writeAuditError answers a change that could not be audited, and so was
+// not made.
+func writeAuditError(c *gin.Context, err error) {
+	writeError(c, http.StatusInternalServerError, "AUDIT_FAILED", "failed to record change in audit log", err.Error())
+}
+
+// ListAuditLog pages through the audit log, newest first, filtered by
+// ?actorId=, ?action=, ?entityType=, ?entityId=, ?requestId= and a ?from= /
+// ?to= time range (RFC 3339).
+func (h *Handler) ListAuditLog(c *gin.Context) {
+	page, size, ok := pageParams(c)
+	if !ok {
+		return
+	}
+	var from, to interface{}
+	for _, p := range []struct {
+		name string
+		dst  *interface{}
+	}{{"from", &from}, {"to", &to}} {
+		if s := c.Query(p.name); s != "" {
+			t, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", p.name+" must be an RFC 3339 time", nil)
+				return
+			}
+			*p.dst = t
+		}
+	}
+
+	const where = `
+		WHERE ($1 = '' OR actor_id::text = $1)
+		  AND ($2 = '' OR action = $2)
+		  AND ($3 = '' OR entity_type = $3)
+		  AND ($4 = '' OR entity_id = $4)
+		  AND ($5 = '' OR request_id = $5)
+		  AND ($6::timestamptz IS NULL OR at >= $6)
+		  AND ($7::timestamptz IS NULL OR at < $7)`
+	args := []interface{}{c.Query("actorId"), c.Query("action"), c.Query("entityType"), c.Query("entityId"),
+		c.Query("requestId"), from, to}
+
+	var total int
+	if err := h.DB.QueryRow(`SELECT COUNT(*) FROM audit_log`+where, args...).Scan(&total); err != nil {
+		writeError(c, http.StatusInternalServerError, "AUDIT_FETCH_FAILED", "failed to count audit entries", err.Error())
+		return
+	}
+	rows, err := h.DB.Query(`
+		SELECT id, at, actor_id, actor_role, action, entity_type, entity_id, before, after, request_id, ip
+		FROM audit_log`+where+`
+		ORDER BY at DESC, id DESC
+		LIMIT $8 OFFSET $9
+	`, append(args, size, (page-1)*size)...)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "AUDIT_FETCH_FAILED", "failed to fetch audit entries", err.Error())
+		return
+	}
+	defer rows.Close()
+
+	items := make([]gin.H, 0, size)
+	for rows.Next() {
+		var id int64
+		var at time.Time
+		var role, action, entityType, entityID string
+		var actorID, requestID, ip sql.NullString
+		var before, after []byte
+		if err := rows.Scan(&id, &at, &actorID, &role, &action, &entityType, &entityID, &before, &after,
+			&requestID, &ip); err != nil {
+			writeError(c, http.StatusInternalServerError, "SCAN_ERROR", "failed to read row", err.Error())
+			return
+		}
+		items = append(items, gin.H{
+			"id":         id,
+			"at":         toIST(at),
+			"actorId":    nullString(actorID),
+			"actorRole":  role,
+			"action":     action,
+			"entityType": entityType,
+			"entityId":   entityID,
+			"before":     rawJSON(before),
+			"after":      rawJSON(after),
+			"requestId":  nullString(requestID),
+			"ip":         nullString(ip),
+		})
+	}
+	writeOK(c, gin.H{
+		"items": items,
+		"pagination": gin.H{
+			"page":       page,
+			"pageSize":   size,
+			"total":      total,
+			"totalPages": (total + size - 1) / size,
+		},
+	})
+}
+
+// rawJSON passes stored JSON through to a response, or nil.
+func rawJSON(b []byte) interface{} {
+	if b == nil {
+		return nil
+	}
+	return json.RawMessage(b)
+}
@@ -3,8 +3,11 @@ package handler
 import (
     "database/sql"
     "net/http"
+    "strings"
     "time"
 
+    "Backend-Go/internal/billing"
+
     "golang.org/x/crypto/bcrypt"
     "github.com/gin-gonic/gin"
     "github.com/golang-jwt/jwt/v5"
@@ -14,6 +17,7 @@ type signupReq struct {
 	Name     string `json:"name" binding:"required,min=2"`
 	Email    string `json:"email" binding:"required,email"`
 	Password string `json:"password" binding:"required,min=6"`
+	GSTIN    string `json:"gstin"`
 }
 
 type loginReq struct {
@@ -40,6 +44,17 @@ func (h *Handler) Signup(c *gin.Context) {
         return
     }
 
+    // optional GSTIN for business customers, printed on their tax invoices
+    var gstin interface{}
+    if req.GSTIN != "" {
+        req.GSTIN = strings.ToUpper(strings.TrimSpace(req.GSTIN))
+        if !billing.ValidGSTIN(req.GSTIN) {
+            writeError(c, http.StatusBadRequest, "INVALID_GSTIN", "gstin is not a valid GSTIN", nil)
+            return
+        }
+        gstin = req.GSTIN
+    }
+
     // hash
     hashed, err := bcrypt.GenerateFromPassword([]byte(req.Password), h.Cfg.BcryptCost)
     if err != nil {
@@ -47,17 +62,32 @@ func (h *Handler) Signup(c *gin.Context) {
         return
     }
 
+    tx, err := h.DB.Begin()
+    if err != nil {
+        writeError(c, http.StatusInternalServerError, "TX_BEGIN_FAILED", "could not start transaction", nil)
+        return
+    }
+    defer tx.Rollback()
+
     // insert user
     var id string
-    err = h.DB.QueryRow(`
-        INSERT INTO users (name, email, password_hash, role)
-        VALUES ($1, $2, $3, 'user')
+    err = tx.QueryRow(`
+        INSERT INTO users (name, email, password_hash, role, gstin)
+        VALUES ($1, $2, $3, 'user', $4)
         RETURNING id
-    `, req.Name, req.Email, string(hashed)).Scan(&id)
+    `, req.Name, req.Email, string(hashed), gstin).Scan(&id)
     if err != nil {
         writeError(c, http.StatusBadRequest, "SIGNUP_FAILED", "could not create user (maybe email exists)", err.Error())
         return
     }
+    if err := auditRow(tx, c, "user.signup", "user", id, nil); err != nil {
+        writeAuditError(c, err)
+        return
+    }
+    if err := tx.Commit(); err != nil {
+        writeError(c, http.StatusInternalServerError, "TX_COMMIT_FAILED", "failed to commit user", err.Error())
+        return
+    }
 
     token, _ := h.signJWT(id, req.Email, "user")
     c.JSON(http.StatusCreated, authResp{
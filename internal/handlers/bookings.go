@@ -1,76 +1,348 @@
 package handler
 
 import (
+	"context"
 	"database/sql"
 	"net/http"
+	"strings"
 	"time"
 
+	"Backend-Go/internal/outbox"
+
 	"github.com/gin-gonic/gin"
 )
 
 type bookReq struct {
 	SpotID    string `json:"spotId" binding:"required"`
 	VehicleID string `json:"vehicleId" binding:"required"`
+	PromoCode string `json:"promoCode"`
+	// QuoteID books at the rate of an unexpired quote for this spot.
+	QuoteID string `json:"quoteId"`
 }
 
-func (h *Handler) BookSpot(c *gin.Context) {
-	var req bookReq
-	if err := c.ShouldBindJSON(&req); err != nil {
-		writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "invalid request body", err.Error())
-		return
+// bookingError is a booking refused or failed, carrying the response to send.
+type bookingError struct {
+	status  int
+	code    string
+	message string
+	details interface{}
+}
+
+func (e *bookingError) Error() string { return e.message }
+
+func (e *bookingError) write(c *gin.Context) { writeError(c, e.status, e.code, e.message, e.details) }
+
+func internalBookingError(code, message string, err error) *bookingError {
+	return &bookingError{http.StatusInternalServerError, code, message, err.Error()}
+}
+
+type bookingParams struct {
+	UserID    string
+	VehicleID string
+	SpotID    string
+	PromoCode string
+	QuoteID   string
+	// ClaimHold lets a waitlist offer take the HELD spot it was given.
+	ClaimHold bool
+	// Plate and TicketCode identify a walk-in session, which has no user or
+	// vehicle.
+	Plate      string
+	TicketCode string
+}
+
+type startedBooking struct {
+	ID      string
+	Start   time.Time
+	LotID   string
+	PassID  string
+	PromoID string
+	OrgID   sql.NullString
+	// Price is the dynamic price the session was locked at, if any.
+	Price *priceDecision
+}
+
+// startBooking opens a session on a spot inside the caller's transaction,
+// applying spot status, vehicle ownership, fleet limits, passes, promo
+// codes and dynamic pricing the same way for every entry point.
+func (h *Handler) startBooking(tx *sql.Tx, p bookingParams) (*startedBooking, *bookingError) {
+	b := &startedBooking{}
+
+	// 1) lock spot row and ensure AVAILABLE (or RESERVED, checked against passes below)
+	var status string
+	err := tx.QueryRow(`SELECT status, lot_id FROM parking_spots WHERE id = $1 FOR UPDATE`, p.SpotID).Scan(&status, &b.LotID)
+	if err == sql.ErrNoRows {
+		return nil, &bookingError{http.StatusBadRequest, "SPOT_NOT_FOUND", "spot does not exist", nil}
+	} else if err != nil {
+		return nil, internalBookingError("SPOT_CHECK_FAILED", "failed to check spot", err)
+	}
+	if status != "AVAILABLE" && status != "RESERVED" && !(status == "HELD" && p.ClaimHold) {
+		return nil, &bookingError{http.StatusConflict, "SPOT_NOT_AVAILABLE", "spot is not available", nil}
 	}
-	claims := GetClaims(c)
 
-	tx, err := h.DB.Begin()
+	// 2) account bookings check the vehicle, passes and promo codes; walk-ins
+	// hold no pass, so cannot take a spot still reserved for one
+	if p.VehicleID != "" {
+		if berr := authorizeVehicle(tx, p, b, status); berr != nil {
+			return nil, berr
+		}
+	} else if status == "RESERVED" {
+		reservedFor, err := reservingPass(tx, p.SpotID)
+		if err != nil {
+			return nil, internalBookingError("PASS_CHECK_FAILED", "failed to check passes", err)
+		}
+		if reservedFor != "" {
+			return nil, &bookingError{http.StatusConflict, "SPOT_RESERVED", "spot is reserved for a pass holder", nil}
+		}
+	}
+
+	// 3) lock the price, for lots with dynamic pricing
+	price, berr := h.lockPrice(tx, p, b.LotID)
+	if berr != nil {
+		return nil, berr
+	}
+	b.Price = price
+
+	// 4) insert booking and get DB start_time
+	err = tx.QueryRow(`
+		INSERT INTO bookings (user_id, vehicle_id, spot_id, pass_id, promo_code_id, org_id, plate, ticket_code)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, start_time
+	`, nullIfEmpty(p.UserID), nullIfEmpty(p.VehicleID), p.SpotID, nullIfEmpty(b.PassID), nullIfEmpty(b.PromoID), b.OrgID,
+		nullIfEmpty(p.Plate), nullIfEmpty(p.TicketCode)).Scan(&b.ID, &b.Start)
 	if err != nil {
-		writeError(c, http.StatusInternalServerError, "TX_BEGIN_FAILED", "could not start transaction", nil)
-		return
+		return nil, &bookingError{http.StatusConflict, "BOOKING_CONFLICT", "active booking exists for spot or vehicle", err.Error()}
 	}
-	defer tx.Rollback()
 
-	// 1) lock spot row and ensure AVAILABLE
-	var status string
-	err = tx.QueryRow(`SELECT status FROM parking_spots WHERE id = $1 FOR UPDATE`, req.SpotID).Scan(&status)
+	if b.Price != nil {
+		if err = recordPrice(tx, b.ID, b.LotID, b.Price); err != nil {
+			return nil, internalBookingError("PRICING_FAILED", "failed to record price", err)
+		}
+	}
+
+	// 5) mark spot OCCUPIED
+	if err = setSpotStatus(tx, p.SpotID, "OCCUPIED", b.ID); err != nil {
+		return nil, internalBookingError("SPOT_UPDATE_FAILED", "failed to mark spot occupied", err)
+	}
+
+	err = outbox.Write(tx, outbox.BookingStarted, "booking", b.ID, outbox.BookingData{
+		BookingID: b.ID, LotID: b.LotID, SpotID: p.SpotID, UserID: p.UserID, VehicleID: p.VehicleID,
+		Plate: p.Plate, OrgID: b.OrgID.String, PassID: b.PassID, StartTime: b.Start,
+	})
+	if err != nil {
+		return nil, internalBookingError("EVENT_FAILED", "failed to record booking event", err)
+	}
+	return b, nil
+}
+
+// usableVehicle reports whether userID may book with vehicleID: their own
+// vehicle, or a fleet vehicle of an organisation they belong to. It is the
+// ownership rule authorizeVehicle applies.
+func usableVehicle(q queryRower, vehicleID, userID string) (bool, error) {
+	var ok bool
+	err := q.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM vehicles v
+			LEFT JOIN org_members m ON m.org_id = v.org_id AND m.user_id = $2
+			WHERE v.id::text = $1 AND ((v.org_id IS NULL AND v.user_id = $2) OR m.user_id IS NOT NULL)
+		)
+	`, vehicleID, userID).Scan(&ok)
+	return ok, err
+}
+
+// authorizeVehicle runs the account-holder checks for a booking: the vehicle
+// is the user's own or a fleet vehicle of theirs, fleet limits, passes and
+// promo codes.
+func authorizeVehicle(tx *sql.Tx, p bookingParams, b *startedBooking, spotStatus string) *bookingError {
+	// ensure vehicle belongs to user, or is a fleet vehicle of one of their organisations
+	var monthlyLimit sql.NullInt64
+	err := tx.QueryRow(`
+		SELECT v.org_id, m.monthly_limit_paise
+		FROM vehicles v
+		LEFT JOIN org_members m ON m.org_id = v.org_id AND m.user_id = $2
+		WHERE v.id = $1 AND ((v.org_id IS NULL AND v.user_id = $2) OR m.user_id IS NOT NULL)
+	`, p.VehicleID, p.UserID).Scan(&b.OrgID, &monthlyLimit)
 	if err == sql.ErrNoRows {
-		writeError(c, http.StatusBadRequest, "SPOT_NOT_FOUND", "spot does not exist", nil)
-		return
+		return &bookingError{http.StatusForbidden, "VEHICLE_NOT_OWNED", "vehicle does not belong to user", nil}
 	} else if err != nil {
-		writeError(c, http.StatusInternalServerError, "SPOT_CHECK_FAILED", "failed to check spot", err.Error())
-		return
+		return internalBookingError("VEHICLE_CHECK_FAILED", "failed to check vehicle", err)
 	}
-	if status != "AVAILABLE" {
-		writeError(c, http.StatusConflict, "SPOT_NOT_AVAILABLE", "spot is not available", nil)
-		return
+
+	// fleet bookings count against the member's monthly spending limit:
+	// released sessions at their charge with GST, open ones at what they
+	// have run up so far. The member row is locked so concurrent starts by
+	// the same member are checked one after the other. The limit is only
+	// checked as a session starts; one already running is not cut short.
+	if b.OrgID.Valid && monthlyLimit.Valid {
+		err = tx.QueryRow(`
+			SELECT monthly_limit_paise FROM org_members WHERE org_id = $1 AND user_id = $2 FOR UPDATE
+		`, b.OrgID.String, p.UserID).Scan(&monthlyLimit)
+		if err != nil {
+			return internalBookingError("LIMIT_CHECK_FAILED", "failed to check spending limit", err)
+		}
+		var spent int64
+		err = tx.QueryRow(`
+			SELECT COALESCE(SUM(ROUND(
+				CASE
+					WHEN b.end_time IS NOT NULL THEN COALESCE(b.taxable_paise, 0)
+					WHEN b.pass_id IS NOT NULL THEN 0
+					ELSE CEIL(GREATEST(EXTRACT(EPOCH FROM now() - b.start_time) / 3600, 1))
+					     * COALESCE(bp.rate_paise, lbp.rate_per_hour_paise, 0)
+				END * (10000 + COALESCE(lbp.gst_rate_bp, 0)) / 10000.0
+			)), 0)::bigint
+			FROM bookings b
+			JOIN parking_spots s ON s.id = b.spot_id
+			LEFT JOIN lot_billing_profiles lbp ON lbp.lot_id = s.lot_id
+			LEFT JOIN booking_pricing bp ON bp.booking_id = b.id
+			WHERE b.org_id = $1 AND b.user_id = $2 AND b.start_time >= $3
+		`, b.OrgID.String, p.UserID, monthStart(nowIST())).Scan(&spent)
+		if err != nil {
+			return internalBookingError("LIMIT_CHECK_FAILED", "failed to check spending limit", err)
+		}
+		if monthlyLimit.Valid && spent >= monthlyLimit.Int64 {
+			return &bookingError{http.StatusForbidden, "SPENDING_LIMIT_REACHED", "monthly spending limit for this organisation reached",
+				gin.H{"limitPaise": monthlyLimit.Int64, "spentPaise": spent}}
+		}
 	}
 
-	// 2) ensure vehicle belongs to user
-	var vcount int
-	err = tx.QueryRow(`SELECT COUNT(1) FROM vehicles WHERE id = $1 AND user_id = $2`, req.VehicleID, claims.UserID).Scan(&vcount)
+	// an active pass for this vehicle zero-rates the session; a reserved
+	// spot only admits the pass it is reserved for
+	b.PassID, _, err = activePass(tx, p.VehicleID, b.LotID, p.SpotID)
 	if err != nil {
-		writeError(c, http.StatusInternalServerError, "VEHICLE_CHECK_FAILED", "failed to check vehicle", err.Error())
-		return
+		return internalBookingError("PASS_CHECK_FAILED", "failed to check passes", err)
+	}
+	if spotStatus == "RESERVED" {
+		reservedFor, err := reservingPass(tx, p.SpotID)
+		if err != nil {
+			return internalBookingError("PASS_CHECK_FAILED", "failed to check passes", err)
+		}
+		if reservedFor != "" && reservedFor != b.PassID {
+			return &bookingError{http.StatusConflict, "SPOT_RESERVED", "spot is reserved for a pass holder", nil}
+		}
+	}
+
+	// a promo code is validated now and applied when the invoice is issued
+	if p.PromoCode != "" {
+		promo, err := checkPromo(tx, p.PromoCode, p.UserID, b.LotID, time.Now(), false)
+		if pe, ok := err.(*promoError); ok {
+			return &bookingError{http.StatusBadRequest, pe.code, pe.message, nil}
+		} else if err != nil {
+			return internalBookingError("PROMO_CHECK_FAILED", "failed to check promo code", err)
+		}
+		b.PromoID = promo.ID
+	}
+
+	return nil
+}
+
+type finishedBooking struct {
+	Invoice *invoiceSummary
+	// Payment is the invoice total, PENDING until settleFinished captures
+	// it after the caller commits.
+	Payment *paymentSummary
+	// HeldFor is the waitlist entry the freed spot was offered to, if any.
+	HeldFor string
+}
+
+type paymentSummary struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+}
+
+// finishBooking runs everything that follows closing a session: freeing the
+// spot (back to its pass, the waitlist, or AVAILABLE), invoicing and
+// recording the payment due. The caller has already set the booking's
+// end_time.
+func (h *Handler) finishBooking(tx *sql.Tx, bookingID, spotID string) (*finishedBooking, *bookingError) {
+	f := &finishedBooking{}
+
+	// mark spot AVAILABLE, or back to RESERVED if a pass still holds it, or
+	// HELD for the next compatible vehicle on the lot's waitlist
+	idle, heldFor, err := h.freeSpot(tx, spotID)
+	if err != nil {
+		return nil, internalBookingError("SPOT_RELEASE_FAILED", "failed to work out spot status", err)
+	}
+	f.HeldFor = heldFor
+	if err = setSpotStatus(tx, spotID, idle, bookingID); err != nil {
+		return nil, internalBookingError("SPOT_UPDATE_FAILED", "failed to mark spot available", err)
+	}
+
+	f.Invoice, err = issueInvoice(tx, bookingID)
+	if err != nil {
+		return nil, internalBookingError("INVOICE_FAILED", "failed to issue invoice", err)
+	}
+	if err := bookingEnded(tx, bookingID, f.Invoice); err != nil {
+		return nil, internalBookingError("EVENT_FAILED", "failed to record booking event", err)
+	}
+	if f.Invoice != nil {
+		id, err := h.pendingPayment(tx, bookingID, "", f.Invoice.TotalPaise)
+		if err != nil {
+			return nil, internalBookingError("PAYMENT_RECORD_FAILED", "failed to record payment", err)
+		}
+		f.Payment = &paymentSummary{ID: id, Status: paymentPending}
+	}
+	return f, nil
+}
+
+// settleFinished captures the payment finishBooking recorded, once the
+// caller has committed. One that fails stays PENDING for SettlePayments.
+func (h *Handler) settleFinished(ctx context.Context, f *finishedBooking) {
+	if f.Payment != nil {
+		f.Payment.Status = h.settlePayment(ctx, f.Payment.ID)
 	}
-	if vcount == 0 {
-		writeError(c, http.StatusForbidden, "VEHICLE_NOT_OWNED", "vehicle does not belong to user", nil)
+}
+
+// bookingEnded records a booking.ended event for a closed booking inside tx.
+func bookingEnded(tx *sql.Tx, bookingID string, inv *invoiceSummary) error {
+	d := outbox.BookingData{BookingID: bookingID}
+	var userID, vehicleID, plate, orgID, passID sql.NullString
+	var end sql.NullTime
+	err := tx.QueryRow(`
+		SELECT s.lot_id, b.spot_id, b.user_id, b.vehicle_id, b.plate, b.org_id, b.pass_id, b.start_time, b.end_time
+		FROM bookings b
+		JOIN parking_spots s ON s.id = b.spot_id
+		WHERE b.id = $1
+	`, bookingID).Scan(&d.LotID, &d.SpotID, &userID, &vehicleID, &plate, &orgID, &passID, &d.StartTime, &end)
+	if err != nil {
+		return err
+	}
+	d.UserID, d.VehicleID, d.Plate, d.OrgID, d.PassID = userID.String, vehicleID.String, plate.String, orgID.String, passID.String
+	if end.Valid {
+		d.EndTime = &end.Time
+	}
+	if inv != nil {
+		d.InvoiceNo, d.TotalPaise = inv.InvoiceNo, inv.TotalPaise
+	}
+	return outbox.Write(tx, outbox.BookingEnded, "booking", bookingID, d)
+}
+
+func (h *Handler) BookSpot(c *gin.Context) {
+	var req bookReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "invalid request body", err.Error())
 		return
 	}
+	claims := GetClaims(c)
 
-	// 3) insert booking and get DB start_time
-	var bookingID string
-	var start time.Time
-	err = tx.QueryRow(`
-		INSERT INTO bookings (user_id, vehicle_id, spot_id)
-		VALUES ($1, $2, $3)
-		RETURNING id, start_time
-	`, claims.UserID, req.VehicleID, req.SpotID).Scan(&bookingID, &start)
+	tx, err := h.DB.Begin()
 	if err != nil {
-		writeError(c, http.StatusConflict, "BOOKING_CONFLICT", "active booking exists for spot or vehicle", err.Error())
+		writeError(c, http.StatusInternalServerError, "TX_BEGIN_FAILED", "could not start transaction", nil)
 		return
 	}
+	defer tx.Rollback()
 
-	// 4) mark spot OCCUPIED
-	if _, err = tx.Exec(`UPDATE parking_spots SET status = 'OCCUPIED' WHERE id = $1`, req.SpotID); err != nil {
-		writeError(c, http.StatusInternalServerError, "SPOT_UPDATE_FAILED", "failed to mark spot occupied", err.Error())
+	b, berr := h.startBooking(tx, bookingParams{
+		UserID:    claims.UserID,
+		VehicleID: req.VehicleID,
+		SpotID:    req.SpotID,
+		PromoCode: req.PromoCode,
+		QuoteID:   req.QuoteID,
+	})
+	if berr != nil {
+		berr.write(c)
+		return
+	}
+	if err := auditRow(tx, c, "booking.start", "booking", b.ID, nil); err != nil {
+		writeAuditError(c, err)
 		return
 	}
 
@@ -79,13 +351,22 @@ func (h *Handler) BookSpot(c *gin.Context) {
 		return
 	}
 
+	var pricing interface{}
+	if b.Price != nil {
+		pricing = b.Price.json()
+	}
+
 	writeOK(c, gin.H{"data": gin.H{
-		"bookingId": bookingID,
+		"bookingId": b.ID,
 		"userId":    claims.UserID,
 		"vehicleId": req.VehicleID,
 		"spotId":    req.SpotID,
 		"status":    "ACTIVE",
-		"startTime": toIST(start),
+		"startTime": toIST(b.Start),
+		"passId":    nullIfEmpty(b.PassID),
+		"promoCode": nullIfEmpty(strings.ToUpper(req.PromoCode)),
+		"orgId":     nullString(b.OrgID),
+		"pricing":   pricing,
 	}})
 }
 
@@ -105,13 +386,17 @@ func (h *Handler) Release(c *gin.Context) {
 	defer tx.Rollback()
 
 	// close active booking for this spot owned by this user and get DB end_time
+	// (and the row as it was, for the audit log)
+	var bookingID string
 	var end time.Time
+	var before []byte
 	err = tx.QueryRow(`
-		UPDATE bookings
+		UPDATE bookings b
 		SET end_time = now()
-		WHERE spot_id = $1 AND end_time IS NULL AND user_id = $2
-		RETURNING end_time
-	`, spotID, claims.UserID).Scan(&end)
+		FROM bookings old
+		WHERE old.id = b.id AND b.spot_id = $1 AND b.end_time IS NULL AND b.user_id = $2
+		RETURNING b.id, b.end_time, to_jsonb(old)
+	`, spotID, claims.UserID).Scan(&bookingID, &end, &before)
 	if err == sql.ErrNoRows {
 		writeError(c, http.StatusConflict, "NO_ACTIVE_BOOKING", "no active booking found for this spot and user", nil)
 		return
@@ -120,9 +405,13 @@ func (h *Handler) Release(c *gin.Context) {
 		return
 	}
 
-	// mark spot AVAILABLE
-	if _, err = tx.Exec(`UPDATE parking_spots SET status = 'AVAILABLE' WHERE id = $1`, spotID); err != nil {
-		writeError(c, http.StatusInternalServerError, "SPOT_UPDATE_FAILED", "failed to mark spot available", err.Error())
+	f, berr := h.finishBooking(tx, bookingID, spotID)
+	if berr != nil {
+		berr.write(c)
+		return
+	}
+	if err := auditRow(tx, c, "booking.release", "booking", bookingID, before); err != nil {
+		writeAuditError(c, err)
 		return
 	}
 
@@ -130,12 +419,17 @@ func (h *Handler) Release(c *gin.Context) {
 		writeError(c, http.StatusInternalServerError, "TX_COMMIT_FAILED", "failed to commit release", err.Error())
 		return
 	}
+	h.settleFinished(c.Request.Context(), f)
 
 	writeOK(c, gin.H{"data": gin.H{
-		"spotId":   spotID,
-		"userId":   claims.UserID,
-		"endTime":  toIST(end),
-		"released": true,
+		"bookingId":       bookingID,
+		"spotId":          spotID,
+		"userId":          claims.UserID,
+		"endTime":         toIST(end),
+		"released":        true,
+		"invoice":         f.Invoice,
+		"payment":         f.Payment,
+		"heldForWaitlist": f.HeldFor != "",
 	}})
 }
 
@@ -143,7 +437,7 @@ func (h *Handler) UserHistory(c *gin.Context) {
 	claims := GetClaims(c)
 
 	rows, err := h.DB.Query(`
-		SELECT id, spot_id, vehicle_id, start_time, end_time
+		SELECT id, spot_id, vehicle_id, start_time, end_time, dispute_status
 		FROM bookings
 		WHERE user_id = $1
 		ORDER BY start_time DESC
@@ -157,10 +451,10 @@ func (h *Handler) UserHistory(c *gin.Context) {
 
 	items := make([]gin.H, 0, 20)
 	for rows.Next() {
-		var id, spotID, vehicleID string
+		var id, spotID, vehicleID, dispute string
 		var start time.Time
 		var end sql.NullTime
-		if err := rows.Scan(&id, &spotID, &vehicleID, &start, &end); err != nil {
+		if err := rows.Scan(&id, &spotID, &vehicleID, &start, &end, &dispute); err != nil {
 			writeError(c, http.StatusInternalServerError, "SCAN_ERROR", "failed to read row", err.Error())
 			return
 		}
@@ -177,6 +471,7 @@ func (h *Handler) UserHistory(c *gin.Context) {
 			"startTime": toIST(start),
 			"endTime":   endVal,
 			"status":    map[bool]string{true: "COMPLETED", false: "ACTIVE"}[end.Valid],
+			"dispute":   dispute,
 		})
 	}
 	writeOK(c, gin.H{"items": items})
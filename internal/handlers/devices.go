@@ -0,0 +1,179 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"Backend-Go/internal/devices"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SpotSensor records what a bay sensor sees. It leaves the booking-driven
+// spot status alone; the two are compared by reconciliation.
+func (h *Handler) SpotSensor(ctx context.Context, r devices.SensorReading) error {
+	_, err := h.DB.ExecContext(ctx, `
+		UPDATE parking_spots
+		SET sensor_occupied = $3, sensor_updated_at = $4
+		WHERE id::text = $1 AND lot_id::text = $2
+		  AND (sensor_updated_at IS NULL OR sensor_updated_at <= $4)
+	`, r.SpotID, r.LotID, r.Occupied, r.At)
+	if err != nil || r.DeviceID == "" {
+		return err
+	}
+	return h.Heartbeat(ctx, devices.Heartbeat{DeviceID: r.DeviceID, Kind: "SENSOR", LotID: r.LotID, SpotID: r.SpotID, At: r.At})
+}
+
+// Heartbeat marks a device as seen, registering it on first contact.
+func (h *Handler) Heartbeat(ctx context.Context, hb devices.Heartbeat) error {
+	kind := hb.Kind
+	if kind != "SENSOR" && kind != "BARRIER" {
+		kind = "OTHER"
+	}
+	_, err := h.DB.ExecContext(ctx, `
+		INSERT INTO devices (id, kind, lot_id, spot_id, gate_id, firmware, last_seen_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (id) DO UPDATE
+		SET kind = EXCLUDED.kind,
+		    lot_id = COALESCE(EXCLUDED.lot_id, devices.lot_id),
+		    spot_id = COALESCE(EXCLUDED.spot_id, devices.spot_id),
+		    gate_id = COALESCE(EXCLUDED.gate_id, devices.gate_id),
+		    firmware = COALESCE(EXCLUDED.firmware, devices.firmware),
+		    last_seen_at = GREATEST(devices.last_seen_at, EXCLUDED.last_seen_at)
+	`, hb.DeviceID, kind, nullIfEmpty(hb.LotID), nullIfEmpty(hb.SpotID), nullIfEmpty(hb.GateID),
+		nullIfEmpty(hb.Firmware), hb.At)
+	return err
+}
+
+// moveBarrier tells a gate's barrier to open or close. The session change it
+// follows is already committed, so a hardware failure is logged, not
+// returned.
+func (h *Handler) moveBarrier(ctx context.Context, gateID, action, bookingID, reason string) bool {
+	if gateID == "" {
+		return false
+	}
+	err := h.Barriers.Send(ctx, devices.Command{
+		GateID:    gateID,
+		Action:    action,
+		BookingID: bookingID,
+		Reason:    reason,
+		At:        time.Now(),
+	})
+	if errors.Is(err, devices.ErrNoBarriers) {
+		return false
+	} else if err != nil {
+		log.Printf("barrier %s %s: %v", gateID, action, err)
+		return false
+	}
+	return true
+}
+
+type barrierReq struct {
+	Action string `json:"action" binding:"required,oneof=OPEN CLOSE"`
+}
+
+// BarrierCommand lets an operator open or close a gate's barrier by hand.
+func (h *Handler) BarrierCommand(c *gin.Context) {
+	var req barrierReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "invalid request body", err.Error())
+		return
+	}
+	gateID := c.Param("id")
+
+	var exists bool
+	if err := h.DB.QueryRow(`SELECT EXISTS (SELECT 1 FROM gates WHERE id = $1)`, gateID).Scan(&exists); err != nil {
+		writeError(c, http.StatusInternalServerError, "GATE_FETCH_FAILED", "failed to fetch gate", err.Error())
+		return
+	}
+	if !exists {
+		writeError(c, http.StatusNotFound, "GATE_NOT_FOUND", "gate not found", nil)
+		return
+	}
+
+	err := h.Barriers.Send(c.Request.Context(), devices.Command{
+		GateID: gateID,
+		Action: req.Action,
+		Reason: "MANUAL",
+		At:     time.Now(),
+	})
+	if errors.Is(err, devices.ErrNoBarriers) {
+		writeError(c, http.StatusServiceUnavailable, "BARRIERS_NOT_CONFIGURED", "no barrier hardware is connected", nil)
+		return
+	} else if err != nil {
+		writeError(c, http.StatusBadGateway, "BARRIER_COMMAND_FAILED", "failed to send barrier command", err.Error())
+		return
+	}
+	// the barrier has already moved, so a failure to log it is only logged
+	if err := audit(h.DB, c, "gate.barrier", "gate", gateID, nil, gin.H{"action": req.Action}); err != nil {
+		log.Printf("audit barrier %s %s: %v", gateID, req.Action, err)
+	}
+	writeOK(c, gin.H{"data": gin.H{"gateId": gateID, "action": req.Action, "sent": true}})
+}
+
+// ListDevices lists known devices with whether they are online, i.e. have
+// sent a heartbeat recently (?lotId=, ?offline=true).
+func (h *Handler) ListDevices(c *gin.Context) {
+	cutoff := time.Now().Add(-time.Duration(h.Cfg.DeviceOfflineSeconds) * time.Second)
+	rows, err := h.DB.Query(`
+		SELECT d.id, d.kind, d.lot_id, d.spot_id, d.gate_id, d.firmware, d.last_seen_at,
+		       s.sensor_occupied, s.status
+		FROM devices d
+		LEFT JOIN parking_spots s ON s.id = d.spot_id
+		WHERE ($1 = '' OR d.lot_id::text = $1)
+		  AND (NOT $2 OR d.last_seen_at < $3)
+		ORDER BY d.lot_id, d.kind, d.id
+	`, c.Query("lotId"), c.Query("offline") == "true", cutoff)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "DEVICES_FETCH_FAILED", "failed to fetch devices", err.Error())
+		return
+	}
+	defer rows.Close()
+
+	items := make([]gin.H, 0, 20)
+	online := 0
+	for rows.Next() {
+		var id, kind string
+		var lotID, spotID, gateID, firmware, spotStatus sql.NullString
+		var seen time.Time
+		var occupied sql.NullBool
+		if err := rows.Scan(&id, &kind, &lotID, &spotID, &gateID, &firmware, &seen, &occupied, &spotStatus); err != nil {
+			writeError(c, http.StatusInternalServerError, "SCAN_ERROR", "failed to read row", err.Error())
+			return
+		}
+		item := gin.H{
+			"id":         id,
+			"kind":       kind,
+			"lotId":      nullString(lotID),
+			"spotId":     nullString(spotID),
+			"gateId":     nullString(gateID),
+			"firmware":   nullString(firmware),
+			"lastSeenAt": toIST(seen),
+			"online":     seen.After(cutoff),
+		}
+		if seen.After(cutoff) {
+			online++
+		}
+		if kind == "SENSOR" && spotID.Valid {
+			var occ interface{}
+			if occupied.Valid {
+				occ = occupied.Bool
+			}
+			item["sensorOccupied"] = occ
+			item["spotStatus"] = nullString(spotStatus)
+		}
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		writeError(c, http.StatusInternalServerError, "DEVICES_FETCH_FAILED", "failed to fetch devices", err.Error())
+		return
+	}
+	writeOK(c, gin.H{
+		"summary": gin.H{"total": len(items), "online": online, "offline": len(items) - online},
+		"items":   items,
+	})
+}
@@ -0,0 +1,314 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"Backend-Go/internal/xlsx"
+
+	"github.com/gin-gonic/gin"
+)
+
+// exportFlushRows is how many rows an export buffers before sending them on.
+const exportFlushRows = 500
+
+// exportTable is a spreadsheet being streamed to the client.
+type exportTable interface {
+	Header(names ...string) error
+	Row(cells ...interface{}) error
+	Flush() error
+	Close() error
+}
+
+type csvTable struct{ w *csv.Writer }
+
+func (t csvTable) Header(names ...string) error { return t.w.Write(names) }
+
+func (t csvTable) Row(cells ...interface{}) error {
+	rec := make([]string, len(cells))
+	for i, v := range cells {
+		switch v := v.(type) {
+		case nil:
+		case string:
+			rec[i] = csvText(v)
+		case float64:
+			rec[i] = strconv.FormatFloat(v, 'f', -1, 64)
+		case int, int64:
+			rec[i] = fmt.Sprint(v)
+		default:
+			rec[i] = csvText(fmt.Sprint(v))
+		}
+	}
+	return t.w.Write(rec)
+}
+
+// csvText keeps text such as plates and notes from being run as a formula
+// when the file is opened in a spreadsheet, by quoting it with a leading '
+// when it starts with a character that would begin one.
+func csvText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+func (t csvTable) Flush() error {
+	t.w.Flush()
+	return t.w.Error()
+}
+
+func (t csvTable) Close() error { return t.Flush() }
+
+// newExportTable starts a csv or xlsx table on w; sheet names the XLSX
+// worksheet.
+func newExportTable(w io.Writer, format, sheet string) (exportTable, error) {
+	if format == "xlsx" {
+		return xlsx.NewWriter(w, sheet)
+	}
+	return csvTable{csv.NewWriter(w)}, nil
+}
+
+func exportContentType(format string) string {
+	if format == "xlsx" {
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+// exportFilename names a download of name covering f's date range.
+func exportFilename(name string, f *reportFilter, format string) string {
+	return fmt.Sprintf("%s_%s_%s.%s", name, f.From.Format("2006-01-02"), f.To.Format("2006-01-02"), format)
+}
+
+// startExport sends the headers of a ?format=csv (default) or xlsx download
+// named name plus f's date range, and returns the table to write it to.
+func startExport(c *gin.Context, name string, f *reportFilter) (exportTable, bool) {
+	format := c.DefaultQuery("format", "csv")
+	if format != "csv" && format != "xlsx" {
+		writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "format must be csv or xlsx", nil)
+		return nil, false
+	}
+	c.Header("Content-Disposition", `attachment; filename="`+exportFilename(name, f, format)+`"`)
+	c.Header("Content-Type", exportContentType(format))
+	c.Header("Cache-Control", "no-store")
+	c.Status(http.StatusOK)
+	t, err := newExportTable(c.Writer, format, name)
+	if err != nil {
+		log.Printf("export %s: %v", name, err)
+		c.Abort()
+		return nil, false
+	}
+	return t, true
+}
+
+// streamExport copies rows to a download. Once the first byte is out the
+// status can no longer change, so a failure part-way is logged and the
+// download cut short: an XLSX file is left unreadable rather than silently
+// partial.
+func streamExport(c *gin.Context, t exportTable, name string, header []string, rows *sql.Rows, row func() ([]interface{}, error)) {
+	if _, err := copyRows(t, header, rows, row); err != nil {
+		log.Printf("export %s: %v", name, err)
+		c.Abort()
+	}
+}
+
+// copyRows writes a header and then one row per result of rows, as built by
+// row, flushing as it goes, and finishes the table. It returns the number
+// of rows written.
+func copyRows(t exportTable, header []string, rows *sql.Rows, row func() ([]interface{}, error)) (int, error) {
+	defer rows.Close()
+	if err := t.Header(header...); err != nil {
+		return 0, err
+	}
+	n := 0
+	for rows.Next() {
+		cells, err := row()
+		if err != nil {
+			return n, err
+		}
+		if err := t.Row(cells...); err != nil {
+			return n, err
+		}
+		if n++; n%exportFlushRows == 0 {
+			if err := t.Flush(); err != nil {
+				return n, err
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return n, err
+	}
+	return n, t.Close()
+}
+
+// exportTime formats t as a local wall-clock time in loc, or nil.
+func exportTime(t sql.NullTime, loc *time.Location) interface{} {
+	if !t.Valid {
+		return nil
+	}
+	return t.Time.In(loc).Format("2006-01-02 15:04:05")
+}
+
+// rupees turns paise into a rupee amount for spreadsheets.
+func rupees(paise int64) float64 { return float64(paise) / 100 }
+
+// ExportBookings downloads the sessions the JSON reports count — those that
+// started in the range and match the report filters (see parseReportFilter)
+// — one row each, with times in the report's timezone.
+func (h *Handler) ExportBookings(c *gin.Context) {
+	f, ok := h.parseReportFilter(c)
+	if !ok {
+		return
+	}
+	rows, err := h.DB.Query(reportSessionsSQL+`
+		SELECT x.id, l.name, s.level_id, s.number, v.type, COALESCE(b.plate, v.plate),
+		       b.user_id, x.ticket_code, x.start_time, x.end_time, x.mins,
+		       i.invoice_no, x.invoiced, x.refunded, p.code, x.dispute_status, bp.multiplier_bp / 10000.0
+		FROM sess x
+		JOIN bookings b ON b.id = x.id
+		JOIN parking_spots s ON s.id = b.spot_id
+		JOIN parking_lots l ON l.id = s.lot_id
+		LEFT JOIN vehicles v ON v.id = b.vehicle_id
+		LEFT JOIN invoices i ON i.booking_id = x.id
+		LEFT JOIN promo_codes p ON p.id = b.promo_code_id
+		LEFT JOIN booking_pricing bp ON bp.booking_id = x.id
+		WHERE x.in_span
+		ORDER BY x.start_time, x.id
+	`, f.args()...)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "EXPORT_FAILED", "failed to fetch bookings", err.Error())
+		return
+	}
+	t, ok := startExport(c, "bookings", f)
+	if !ok {
+		rows.Close()
+		return
+	}
+	tz := " (" + f.Loc.String() + ")"
+	header := []string{"Booking ID", "Lot", "Level", "Spot", "Vehicle type", "Plate", "User ID", "Ticket",
+		"Start" + tz, "End" + tz, "Duration (mins)", "Invoice no", "Invoiced (INR)", "Refunded (INR)",
+		"Net (INR)", "Promo code", "Dispute", "Price multiplier"}
+	streamExport(c, t, "bookings", header, rows, func() ([]interface{}, error) {
+		var id, lot, level, spot string
+		var vehicleType, plate, userID, ticket, invoiceNo, promo, dispute sql.NullString
+		var start time.Time
+		var end sql.NullTime
+		var mins, multiplier sql.NullFloat64
+		var invoiced, refunded int64
+		if err := rows.Scan(&id, &lot, &level, &spot, &vehicleType, &plate, &userID, &ticket, &start, &end,
+			&mins, &invoiceNo, &invoiced, &refunded, &promo, &dispute, &multiplier); err != nil {
+			return nil, err
+		}
+		return []interface{}{id, lot, level, spot, nullString(vehicleType), nullString(plate), nullString(userID),
+			nullString(ticket), exportTime(sql.NullTime{Time: start, Valid: true}, f.Loc), exportTime(end, f.Loc),
+			nullFloat(mins), nullString(invoiceNo), rupees(invoiced), rupees(refunded), rupees(invoiced - refunded),
+			nullString(promo), nullString(dispute), nullFloat(multiplier)}, nil
+	})
+}
+
+// ExportPayments downloads the booking and pass payments taken in the
+// range, using the report filters; level and vehicle type filters match
+// booking payments only.
+func (h *Handler) ExportPayments(c *gin.Context) {
+	f, ok := h.parseReportFilter(c)
+	if !ok {
+		return
+	}
+	rows, err := h.DB.Query(`
+		WITH span AS (
+			SELECT ($2::text::timestamp AT TIME ZONE $1::text) AS start_at,
+			       ($3::text::timestamp AT TIME ZONE $1::text) AS end_at
+		)
+		SELECT pay.id, pay.created_at, pay.booking_id, pay.pass_id, l.name,
+		       COALESCE(b.user_id, ps.user_id), pay.provider, COALESCE(pay.provider_ref, ''),
+		       pay.amount_paise, pay.refunded_paise, pay.status
+		FROM payments pay
+		CROSS JOIN span
+		LEFT JOIN bookings b ON b.id = pay.booking_id
+		LEFT JOIN parking_spots s ON s.id = b.spot_id
+		LEFT JOIN vehicles v ON v.id = b.vehicle_id
+		LEFT JOIN passes ps ON ps.id = pay.pass_id
+		JOIN parking_lots l ON l.id = COALESCE(s.lot_id, ps.lot_id)
+		WHERE pay.created_at >= span.start_at AND pay.created_at < span.end_at
+		  AND ($4 = '' OR l.id::text = $4)
+		  AND ($5 = '' OR s.level_id::text = $5)
+		  AND ($6 = '' OR v.type = $6)
+		  AND ($7 = '' OR COALESCE(b.user_id, ps.user_id)::text = $7)
+		ORDER BY pay.created_at, pay.id
+	`, f.args()...)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "EXPORT_FAILED", "failed to fetch payments", err.Error())
+		return
+	}
+	t, ok := startExport(c, "payments", f)
+	if !ok {
+		rows.Close()
+		return
+	}
+	header := []string{"Payment ID", "Taken (" + f.Loc.String() + ")", "Booking ID", "Pass ID", "Lot", "User ID",
+		"Provider", "Provider ref", "Amount (INR)", "Refunded (INR)", "Net (INR)", "Status"}
+	streamExport(c, t, "payments", header, rows, func() ([]interface{}, error) {
+		var id, lot, provider, ref, status string
+		var created time.Time
+		var bookingID, passID, userID sql.NullString
+		var amount, refunded int64
+		if err := rows.Scan(&id, &created, &bookingID, &passID, &lot, &userID, &provider, &ref,
+			&amount, &refunded, &status); err != nil {
+			return nil, err
+		}
+		return []interface{}{id, exportTime(sql.NullTime{Time: created, Valid: true}, f.Loc), nullString(bookingID),
+			nullString(passID), lot, nullString(userID), provider, ref,
+			rupees(amount), rupees(refunded), rupees(amount - refunded), status}, nil
+	})
+}
+
+// ExportReports downloads the report metrics one row per ?groupBy= period
+// (day by default; none for a single row covering the range).
+func (h *Handler) ExportReports(c *gin.Context) {
+	f, ok := h.parseReportFilter(c)
+	if !ok {
+		return
+	}
+	periods, err := h.reportMetrics(f)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "EXPORT_FAILED", "failed to compute report", err.Error())
+		return
+	}
+	t, ok := startExport(c, "reports", f)
+	if !ok {
+		return
+	}
+	if err := writeReportMetrics(t, f, periods); err != nil {
+		log.Printf("export reports: %v", err)
+		c.Abort()
+	}
+}
+
+// writeReportMetrics writes periods, computed for f, as a finished table.
+func writeReportMetrics(t exportTable, f *reportFilter, periods []reportMetrics) error {
+	if err := t.Header("Period", "Sessions", "Total duration (mins)", "Avg duration (mins)",
+		"Median duration (mins)", "P90 duration (mins)", "Invoiced (INR)", "Refunded (INR)", "Net (INR)",
+		"Spots", "Utilisation (%)", "Turnover per spot", "No-shows", "No-show rate"); err != nil {
+		return err
+	}
+	for _, m := range periods {
+		period := m.Period.Format("2006-01-02")
+		if f.GroupBy == "" {
+			period = period + " to " + f.To.Format("2006-01-02")
+		}
+		if err := t.Row(period, m.Sessions, m.TotalMins, nullFloat(m.AvgMins), nullFloat(m.MedianMins),
+			nullFloat(m.P90Mins), rupees(m.InvoicedPaise), rupees(m.RefundedPaise),
+			rupees(m.InvoicedPaise-m.RefundedPaise), m.Spots, m.utilisationPct(), m.turnoverPerSpot(),
+			m.NoShows, m.noShowRate()); err != nil {
+			return err
+		}
+	}
+	return t.Close()
+}
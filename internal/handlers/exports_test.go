@@ -0,0 +1,33 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/csv"
+	"testing"
+)
+
+func TestCSVRowEscapesFormulas(t *testing.T) {
+	var buf bytes.Buffer
+	table := csvTable{csv.NewWriter(&buf)}
+	if err := table.Row("=HYPERLINK(\"http://x\")", "+91 98", "-2+3", "@SUM(A1)", "\tcmd", "\rcmd",
+		"KA01AB1234", "", nil, int64(-500), -12.5, 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := table.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	got, err := csv.NewReader(&buf).Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"'=HYPERLINK(\"http://x\")", "'+91 98", "'-2+3", "'@SUM(A1)", "'\tcmd", "'\rcmd",
+		"KA01AB1234", "", "", "-500", "-12.5", "3"}
+	if len(got) != len(want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("cell %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,401 @@
+package handler
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"Backend-Go/internal/forecast"
+
+	"github.com/gin-gonic/gin"
+)
+
+// forecastFullRate is the share of spots in use at which a lot counts as
+// full.
+const forecastFullRate = 0.95
+
+// forecastHistorySQL averages, for every local hour from $3 up to $4 (lot
+// timezone $2), how many of lot $1's spots were occupied, from bookings.
+const forecastHistorySQL = `
+	WITH slots AS (
+		SELECT h AS local_hour,
+		       h AT TIME ZONE $2::text AS start_at,
+		       (h + interval '1 hour') AT TIME ZONE $2::text AS end_at
+		FROM generate_series($3::text::timestamp, $4::text::timestamp - interval '1 hour', interval '1 hour') h
+	), sess AS (
+		SELECT b.start_time, COALESCE(b.end_time, now()) AS end_time
+		FROM bookings b
+		JOIN parking_spots s ON s.id = b.spot_id
+		WHERE s.lot_id = $1
+		  AND b.start_time < $4::text::timestamp AT TIME ZONE $2::text
+		  AND COALESCE(b.end_time, now()) > $3::text::timestamp AT TIME ZONE $2::text
+	)
+	SELECT sl.local_hour,
+	       COALESCE(SUM(EXTRACT(EPOCH FROM LEAST(x.end_time, sl.end_at) - GREATEST(x.start_time, sl.start_at))), 0)::float8
+	           / EXTRACT(EPOCH FROM sl.end_at - sl.start_at)::float8
+	FROM slots sl
+	LEFT JOIN sess x ON x.start_time < sl.end_at AND x.end_time > sl.start_at
+	GROUP BY sl.local_hour, sl.start_at, sl.end_at
+	ORDER BY sl.local_hour`
+
+// intQuery reads an optional integer query parameter between lo and hi.
+func intQuery(c *gin.Context, name string, def, lo, hi int) (int, bool) {
+	s := c.Query(name)
+	if s == "" {
+		return def, true
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < lo || v > hi {
+		writeError(c, http.StatusBadRequest, "VALIDATION_ERROR",
+			name+" must be between "+strconv.Itoa(lo)+" and "+strconv.Itoa(hi), nil)
+		return 0, false
+	}
+	return v, true
+}
+
+// lotForecast is a lot's predicted occupancy from now on.
+type lotForecast struct {
+	Loc      *time.Location
+	Now      time.Time // in Loc
+	Capacity int
+	Active   int
+	Points   []forecast.Point
+}
+
+// Forecast limits: how far ahead and how far back a forecast can look.
+const (
+	forecastMaxHours = 48
+	forecastMaxWeeks = 8
+)
+
+// forecastHistory is the costly part of a forecast, a lot's past hourly
+// occupancy and its holidays, which only moves on when the hour does.
+type forecastHistory struct {
+	samples  []forecast.Sample
+	holidays map[string]bool
+}
+
+type forecastKey struct {
+	lotID string
+	weeks int
+	hour  int64 // Unix start of the lot's current hour
+}
+
+// forecastCache keeps each lot's forecast history for the hour it was read
+// in, so repeated forecasts only re-read current occupancy. The zero value
+// is ready to use.
+type forecastCache struct {
+	mu sync.Mutex
+	m  map[forecastKey]*forecastHistory
+}
+
+func (fc *forecastCache) get(k forecastKey) *forecastHistory {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return fc.m[k]
+}
+
+// put stores hist for k and drops histories from earlier hours.
+func (fc *forecastCache) put(k forecastKey, hist *forecastHistory) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	if fc.m == nil {
+		fc.m = map[forecastKey]*forecastHistory{}
+	}
+	for old := range fc.m {
+		if old.hour < k.hour {
+			delete(fc.m, old)
+		}
+	}
+	fc.m[k] = hist
+}
+
+// reset drops every history, e.g. when holidays change.
+func (fc *forecastCache) reset() {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	fc.m = nil
+}
+
+// forecastLot predicts lotID's occupancy for the next hours hours (up to
+// forecastMaxHours) from the last weeks weeks of bookings. It returns
+// sql.ErrNoRows for an unknown lot.
+func (h *Handler) forecastLot(lotID string, hours, weeks int) (*lotForecast, error) {
+	var tz string
+	fc := &lotForecast{}
+	err := h.DB.QueryRow(`
+		SELECT l.timezone,
+		       (SELECT COUNT(*) FROM parking_spots s WHERE s.lot_id = l.id AND s.status <> 'DISABLED'),
+		       (SELECT COUNT(*) FROM bookings b JOIN parking_spots s ON s.id = b.spot_id
+		        WHERE s.lot_id = l.id AND b.end_time IS NULL)
+		FROM parking_lots l
+		WHERE l.id::text = $1
+	`, lotID).Scan(&tz, &fc.Capacity, &fc.Active)
+	if err != nil {
+		return nil, err
+	}
+	if fc.Loc, err = time.LoadLocation(tz); err != nil {
+		fc.Loc = istLoc
+	}
+
+	now := time.Now().In(fc.Loc)
+	thisHour := time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), 0, 0, 0, fc.Loc)
+	fc.Now = now
+
+	key := forecastKey{lotID: lotID, weeks: weeks, hour: thisHour.Unix()}
+	hist := h.forecasts.get(key)
+	if hist == nil {
+		if hist, err = h.loadForecastHistory(lotID, fc.Loc, thisHour, weeks); err != nil {
+			return nil, err
+		}
+		h.forecasts.put(key, hist)
+	}
+
+	fc.Points = forecast.Predict(forecast.Input{
+		Now: now, Capacity: fc.Capacity, Current: float64(fc.Active), History: hist.samples, Holidays: hist.holidays,
+	}, hours)
+	return fc, nil
+}
+
+// loadForecastHistory reads lotID's hourly occupancy over the weeks weeks
+// before thisHour, and its holidays from then until forecastMaxHours after.
+func (h *Handler) loadForecastHistory(lotID string, loc *time.Location, thisHour time.Time, weeks int) (*forecastHistory, error) {
+	from := thisHour.AddDate(0, 0, -7*weeks)
+	const local = "2006-01-02 15:04:05"
+	hist := &forecastHistory{holidays: map[string]bool{}}
+
+	rows, err := h.DB.Query(forecastHistorySQL, lotID, loc.String(), from.Format(local), thisHour.Format(local))
+	if err != nil {
+		return nil, fmt.Errorf("fetch booking history: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var at time.Time
+		var occupied float64
+		if err := rows.Scan(&at, &occupied); err != nil {
+			return nil, err
+		}
+		// local_hour is a wall-clock time; read it in the lot's zone
+		at = time.Date(at.Year(), at.Month(), at.Day(), at.Hour(), 0, 0, 0, loc)
+		hist.samples = append(hist.samples, forecast.Sample{At: at, Occupied: occupied})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("fetch booking history: %w", err)
+	}
+
+	days, err := h.DB.Query(`
+		SELECT to_char(day, 'YYYY-MM-DD') FROM holidays
+		WHERE (lot_id IS NULL OR lot_id::text = $1) AND day >= $2::date AND day <= $3::date
+	`, lotID, from.Format("2006-01-02"), thisHour.Add((forecastMaxHours+24)*time.Hour).Format("2006-01-02"))
+	if err != nil {
+		return nil, fmt.Errorf("fetch holidays: %w", err)
+	}
+	defer days.Close()
+	for days.Next() {
+		var day string
+		if err := days.Scan(&day); err != nil {
+			return nil, err
+		}
+		hist.holidays[day] = true
+	}
+	if err := days.Err(); err != nil {
+		return nil, fmt.Errorf("fetch holidays: %w", err)
+	}
+	return hist, nil
+}
+
+// Forecast predicts a lot's occupancy hour by hour (?lotId=, ?hours= up to
+// 48, default 24) from the last ?weeks= (2-8, default 8) of bookings, with
+// 80% bands and when the lot is likely to fill. Hours are in the lot's
+// timezone.
+func (h *Handler) Forecast(c *gin.Context) {
+	lotID := c.Query("lotId")
+	if lotID == "" {
+		writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "lotId is required", nil)
+		return
+	}
+	hours, ok := intQuery(c, "hours", 24, 1, forecastMaxHours)
+	if !ok {
+		return
+	}
+	weeks, ok := intQuery(c, "weeks", 8, 2, forecastMaxWeeks)
+	if !ok {
+		return
+	}
+
+	fc, err := h.forecastLot(lotID, hours, weeks)
+	if err == sql.ErrNoRows {
+		writeError(c, http.StatusNotFound, "LOT_NOT_FOUND", "lot not found", nil)
+		return
+	} else if err != nil {
+		writeError(c, http.StatusInternalServerError, "FORECAST_FAILED", "failed to compute forecast", err.Error())
+		return
+	}
+	capacity := fc.Capacity
+
+	out := make([]gin.H, 0, len(fc.Points))
+	for _, p := range fc.Points {
+		point := gin.H{
+			"at":                p.At,
+			"expectedOccupied":  p.Expected,
+			"occupiedLow":       p.Low,
+			"occupiedHigh":      p.High,
+			"expectedAvailable": float64(capacity) - p.Expected,
+			"availableLow":      float64(capacity) - p.High,
+			"availableHigh":     float64(capacity) - p.Low,
+			"occupancyRate":     nil,
+			"samples":           p.Samples,
+			"holiday":           p.Holiday,
+		}
+		if capacity > 0 {
+			point["occupancyRate"] = p.Expected / float64(capacity)
+		}
+		out = append(out, point)
+	}
+
+	// "likely full by" follows the expected line, "may be full by" the top
+	// of the band
+	full := forecastFullRate * float64(capacity)
+	var likelyFull, possiblyFull interface{}
+	if capacity > 0 {
+		current := float64(fc.Active)
+		if at, ok := forecast.FullAt(fc.Now, current, fc.Points, func(p forecast.Point) float64 { return p.Expected }, full); ok {
+			likelyFull = at
+		}
+		if at, ok := forecast.FullAt(fc.Now, current, fc.Points, func(p forecast.Point) float64 { return p.High }, full); ok {
+			possiblyFull = at
+		}
+	}
+
+	writeOK(c, gin.H{"data": gin.H{
+		"lotId":          lotID,
+		"timezone":       fc.Loc.String(),
+		"generatedAt":    fc.Now,
+		"capacity":       capacity,
+		"occupied":       fc.Active,
+		"available":      capacity - fc.Active,
+		"historyWeeks":   weeks,
+		"fullRate":       forecastFullRate,
+		"likelyFullAt":   likelyFull,
+		"possiblyFullAt": possiblyFull,
+		"points":         out,
+	}})
+}
+
+type holidayReq struct {
+	Day   string  `json:"day" binding:"required"`
+	Name  string  `json:"name" binding:"required,max=100"`
+	LotID *string `json:"lotId"`
+}
+
+// CreateHoliday marks a day (YYYY-MM-DD) as a holiday for one lot or, with
+// no lotId, for every lot.
+func (h *Handler) CreateHoliday(c *gin.Context) {
+	var req holidayReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "invalid request body", err.Error())
+		return
+	}
+	if _, err := time.Parse("2006-01-02", req.Day); err != nil {
+		writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "day must be a date (YYYY-MM-DD)", nil)
+		return
+	}
+	tx, err := h.DB.Begin()
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_BEGIN_FAILED", "could not start transaction", nil)
+		return
+	}
+	defer tx.Rollback()
+
+	var id string
+	err = tx.QueryRow(`
+		INSERT INTO holidays (day, lot_id, name) VALUES ($1, $2, $3) RETURNING id
+	`, req.Day, req.LotID, req.Name).Scan(&id)
+	if err != nil {
+		writeError(c, http.StatusBadRequest, "CREATE_HOLIDAY_FAILED", "could not add holiday (maybe duplicate or unknown lot)", err.Error())
+		return
+	}
+	if err := auditRow(tx, c, "holiday.create", "holiday", id, nil); err != nil {
+		writeAuditError(c, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_COMMIT_FAILED", "failed to commit holiday", err.Error())
+		return
+	}
+	h.forecasts.reset()
+	c.JSON(http.StatusCreated, gin.H{"data": gin.H{"id": id, "day": req.Day, "name": req.Name, "lotId": req.LotID}})
+}
+
+// ListHolidays lists holidays, optionally those that apply to ?lotId= and
+// in ?year=.
+func (h *Handler) ListHolidays(c *gin.Context) {
+	lotID := c.Query("lotId")
+	year, ok := intQuery(c, "year", 0, 2000, 2100)
+	if !ok {
+		return
+	}
+	rows, err := h.DB.Query(`
+		SELECT id, to_char(day, 'YYYY-MM-DD'), name, lot_id
+		FROM holidays
+		WHERE ($1 = '' OR lot_id IS NULL OR lot_id::text = $1)
+		  AND ($2 = 0 OR EXTRACT(YEAR FROM day) = $2)
+		ORDER BY day, lot_id NULLS FIRST
+	`, lotID, year)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "HOLIDAYS_FETCH_FAILED", "failed to fetch holidays", err.Error())
+		return
+	}
+	defer rows.Close()
+
+	items := make([]gin.H, 0, 20)
+	for rows.Next() {
+		var id, day, name string
+		var lot sql.NullString
+		if err := rows.Scan(&id, &day, &name, &lot); err != nil {
+			writeError(c, http.StatusInternalServerError, "SCAN_ERROR", "failed to read row", err.Error())
+			return
+		}
+		items = append(items, gin.H{"id": id, "day": day, "name": name, "lotId": nullString(lot)})
+	}
+	writeOK(c, gin.H{"items": items})
+}
+
+// DeleteHoliday removes a holiday.
+func (h *Handler) DeleteHoliday(c *gin.Context) {
+	id := c.Param("id")
+	tx, err := h.DB.Begin()
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_BEGIN_FAILED", "could not start transaction", nil)
+		return
+	}
+	defer tx.Rollback()
+
+	before, err := auditSnapshot(tx, "holiday", id)
+	if err != nil {
+		writeAuditError(c, err)
+		return
+	}
+	res, err := tx.Exec(`DELETE FROM holidays WHERE id = $1`, id)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "DELETE_HOLIDAY_FAILED", "failed to delete holiday", err.Error())
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		writeError(c, http.StatusNotFound, "HOLIDAY_NOT_FOUND", "holiday not found", nil)
+		return
+	}
+	if err := audit(tx, c, "holiday.delete", "holiday", id, before, nil); err != nil {
+		writeAuditError(c, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_COMMIT_FAILED", "failed to commit holiday deletion", err.Error())
+		return
+	}
+	h.forecasts.reset()
+	writeOK(c, gin.H{"message": "holiday deleted"})
+}
@@ -0,0 +1,391 @@
+package handler
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"Backend-Go/internal/anpr"
+
+	"github.com/gin-gonic/gin"
+)
+
+// exitMatchWindow is how long after a session is closed its car may still be
+// seen leaving.
+const exitMatchWindow = 30 * time.Minute
+
+type gateEvent struct {
+	GateID     string
+	Direction  string // ENTRY or EXIT; may be left empty for one-way gates
+	Method     string // QR, ANPR or MANUAL
+	Plate      string
+	BookingID  string // known for QR scans; otherwise correlated by plate
+	OccurredAt time.Time
+	RecordedBy string
+}
+
+type recordedGateEvent struct {
+	ID        string
+	LotID     string
+	Direction string
+	Plate     string
+	BookingID string
+}
+
+// recordGateEvent stores a barrier crossing inside the caller's transaction
+// and ties it to the booking it belongs to, if one can be found.
+func recordGateEvent(tx *sql.Tx, ev gateEvent) (*recordedGateEvent, *bookingError) {
+	r := &recordedGateEvent{Direction: ev.Direction, Plate: anpr.Normalize(ev.Plate), BookingID: ev.BookingID}
+
+	var gateDirection string
+	var active bool
+	err := tx.QueryRow(`SELECT lot_id, direction, active FROM gates WHERE id = $1`, ev.GateID).Scan(&r.LotID, &gateDirection, &active)
+	if err == sql.ErrNoRows {
+		return nil, &bookingError{http.StatusNotFound, "GATE_NOT_FOUND", "gate not found", nil}
+	} else if err != nil {
+		return nil, internalBookingError("GATE_FETCH_FAILED", "failed to fetch gate", err)
+	}
+	if !active {
+		return nil, &bookingError{http.StatusConflict, "GATE_INACTIVE", "gate is not active", nil}
+	}
+	switch {
+	case r.Direction == "" && gateDirection == "BOTH":
+		return nil, &bookingError{http.StatusBadRequest, "VALIDATION_ERROR", "direction is required for a two-way gate", nil}
+	case r.Direction == "":
+		r.Direction = gateDirection
+	case gateDirection != "BOTH" && r.Direction != gateDirection:
+		return nil, &bookingError{http.StatusBadRequest, "WRONG_DIRECTION", "gate only records " + gateDirection + " events", nil}
+	}
+
+	if r.BookingID != "" {
+		var lotID, plate string
+		err := tx.QueryRow(`
+			SELECT s.lot_id, COALESCE(v.plate, b.plate, '')
+			FROM bookings b
+			JOIN parking_spots s ON s.id = b.spot_id
+			LEFT JOIN vehicles v ON v.id = b.vehicle_id
+			WHERE b.id = $1
+		`, r.BookingID).Scan(&lotID, &plate)
+		if err == sql.ErrNoRows {
+			return nil, &bookingError{http.StatusNotFound, "BOOKING_NOT_FOUND", "booking not found", nil}
+		} else if err != nil {
+			return nil, internalBookingError("BOOKING_FETCH_FAILED", "failed to fetch booking", err)
+		}
+		if lotID != r.LotID {
+			return nil, &bookingError{http.StatusConflict, "BOOKING_WRONG_LOT", "booking is for a different lot than this gate", nil}
+		}
+		if r.Plate == "" {
+			r.Plate = anpr.Normalize(plate)
+		}
+	} else if r.Plate != "" {
+		if r.BookingID, err = correlateGateEvent(tx, r.LotID, r.Direction, r.Plate, ev.OccurredAt); err != nil {
+			return nil, internalBookingError("GATE_EVENT_FAILED", "failed to match gate event to a booking", err)
+		}
+	}
+
+	err = tx.QueryRow(`
+		INSERT INTO gate_events (gate_id, lot_id, direction, method, plate, occurred_at, booking_id, recorded_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id
+	`, ev.GateID, r.LotID, r.Direction, ev.Method, nullIfEmpty(r.Plate), ev.OccurredAt,
+		nullIfEmpty(r.BookingID), nullIfEmpty(ev.RecordedBy)).Scan(&r.ID)
+	if err != nil {
+		return nil, internalBookingError("GATE_EVENT_FAILED", "failed to record gate event", err)
+	}
+	return r, nil
+}
+
+// correlateGateEvent finds the booking a plate seen at a gate belongs to,
+// comparing plates with O/0 and I/1 folded together. An
+// entry matches an open session not yet seen entering; an exit matches an
+// open session, or one closed shortly before, not yet seen leaving.
+func correlateGateEvent(tx *sql.Tx, lotID, direction, plate string, at time.Time) (string, error) {
+	// vehicle bookings by the vehicle's plate, walk-ins by their own; each
+	// arm is served by a canonical plate index
+	query := `
+		SELECT b.id
+		FROM bookings b
+		JOIN parking_spots s ON s.id = b.spot_id
+		WHERE s.lot_id = $1
+		  AND b.id IN (
+			SELECT id FROM bookings
+			WHERE vehicle_id IN (SELECT id FROM vehicles WHERE ` + anpr.CanonicalSQL("plate") + ` = $2)
+			UNION ALL
+			SELECT id FROM bookings
+			WHERE vehicle_id IS NULL AND ` + anpr.CanonicalSQL("plate") + ` = $2
+		  )
+		  AND NOT EXISTS (SELECT 1 FROM gate_events e WHERE e.booking_id = b.id AND e.direction = $3)
+	`
+	args := []interface{}{lotID, anpr.Canonical(plate), direction}
+	if direction == "ENTRY" {
+		query += ` AND b.end_time IS NULL ORDER BY b.start_time DESC LIMIT 1`
+	} else {
+		query += ` AND (b.end_time IS NULL OR b.end_time >= $4)
+		ORDER BY (b.end_time IS NULL) DESC, b.start_time DESC LIMIT 1`
+		args = append(args, at.Add(-exitMatchWindow))
+	}
+
+	var id string
+	err := tx.QueryRow(query, args...).Scan(&id)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return id, err
+}
+
+type gateEventReq struct {
+	Direction  string     `json:"direction" binding:"omitempty,oneof=ENTRY EXIT"`
+	Method     string     `json:"method" binding:"required,oneof=ANPR MANUAL QR"`
+	Plate      string     `json:"plate"`
+	BookingID  string     `json:"bookingId"`
+	OccurredAt *time.Time `json:"occurredAt"`
+}
+
+// RecordGateEvent logs a vehicle crossing a gate. Gates that buffered events
+// while offline send the time the crossing happened in occurredAt.
+func (h *Handler) RecordGateEvent(c *gin.Context) {
+	var req gateEventReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "invalid request body", err.Error())
+		return
+	}
+	if req.Plate == "" && req.BookingID == "" {
+		writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "plate or bookingId is required", nil)
+		return
+	}
+	at := time.Now()
+	if req.OccurredAt != nil {
+		if req.OccurredAt.After(at.Add(5 * time.Minute)) {
+			writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "occurredAt is in the future", nil)
+			return
+		}
+		at = *req.OccurredAt
+	}
+	claims := GetClaims(c)
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_BEGIN_FAILED", "could not start transaction", nil)
+		return
+	}
+	defer tx.Rollback()
+
+	ev, berr := recordGateEvent(tx, gateEvent{
+		GateID:     c.Param("id"),
+		Direction:  req.Direction,
+		Method:     req.Method,
+		Plate:      req.Plate,
+		BookingID:  req.BookingID,
+		OccurredAt: at,
+		RecordedBy: claims.UserID,
+	})
+	if berr != nil {
+		berr.write(c)
+		return
+	}
+	if err := auditRow(tx, c, "gate_event.record", "gate_event", ev.ID, nil); err != nil {
+		writeAuditError(c, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_COMMIT_FAILED", "failed to commit gate event", err.Error())
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"data": gin.H{
+		"id":         ev.ID,
+		"gateId":     c.Param("id"),
+		"lotId":      ev.LotID,
+		"direction":  ev.Direction,
+		"method":     req.Method,
+		"plate":      nullIfEmpty(ev.Plate),
+		"occurredAt": toIST(at),
+		"bookingId":  nullIfEmpty(ev.BookingID),
+		"matched":    ev.BookingID != "",
+	}})
+}
+
+// timeRange reads ?from= and ?to= (RFC 3339), defaulting to the last 24
+// hours.
+func timeRange(c *gin.Context) (time.Time, time.Time, bool) {
+	to := time.Now()
+	from := to.Add(-24 * time.Hour)
+	if s := c.Query("from"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "from must be an RFC 3339 time", nil)
+			return from, to, false
+		}
+		from = t
+	}
+	if s := c.Query("to"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "to must be an RFC 3339 time", nil)
+			return from, to, false
+		}
+		to = t
+	}
+	if !to.After(from) {
+		writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "to must be after from", nil)
+		return from, to, false
+	}
+	return from, to, true
+}
+
+// ListGateEvents lists gate events for ?lotId= between ?from= and ?to=;
+// ?unmatched=true keeps only events not tied to a booking.
+func (h *Handler) ListGateEvents(c *gin.Context) {
+	from, to, ok := timeRange(c)
+	if !ok {
+		return
+	}
+	rows, err := h.DB.Query(`
+		SELECT e.id, e.gate_id, g.name, e.lot_id, e.direction, e.method, e.plate, e.occurred_at, e.booking_id
+		FROM gate_events e
+		JOIN gates g ON g.id = e.gate_id
+		WHERE e.occurred_at >= $1 AND e.occurred_at < $2
+		  AND ($3 = '' OR e.lot_id::text = $3)
+		  AND (NOT $4 OR e.booking_id IS NULL)
+		ORDER BY e.occurred_at DESC
+		LIMIT 500
+	`, from, to, c.Query("lotId"), c.Query("unmatched") == "true")
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "GATE_EVENTS_FETCH_FAILED", "failed to fetch gate events", err.Error())
+		return
+	}
+	defer rows.Close()
+
+	items := make([]gin.H, 0, 50)
+	for rows.Next() {
+		var id, gateID, gateName, lotID, direction, method string
+		var plate, bookingID sql.NullString
+		var at time.Time
+		if err := rows.Scan(&id, &gateID, &gateName, &lotID, &direction, &method, &plate, &at, &bookingID); err != nil {
+			writeError(c, http.StatusInternalServerError, "SCAN_ERROR", "failed to read row", err.Error())
+			return
+		}
+		items = append(items, gin.H{
+			"id":         id,
+			"gateId":     gateID,
+			"gateName":   gateName,
+			"lotId":      lotID,
+			"direction":  direction,
+			"method":     method,
+			"plate":      nullString(plate),
+			"occurredAt": toIST(at),
+			"bookingId":  nullString(bookingID),
+		})
+	}
+	if err := rows.Err(); err != nil {
+		writeError(c, http.StatusInternalServerError, "GATE_EVENTS_FETCH_FAILED", "failed to fetch gate events", err.Error())
+		return
+	}
+	writeOK(c, gin.H{"items": items})
+}
+
+// entryGrace is how long a new session may go without an entry event before
+// reconciliation flags it.
+const entryGrace = 15 * time.Minute
+
+// Reconciliation compares a lot's sessions with its gate events between
+// ?from= and ?to= and flags sessions no car was seen entering for, cars that
+// left while their session stayed open, and crossings no session explains.
+func (h *Handler) Reconciliation(c *gin.Context) {
+	lotID := c.Param("id")
+	from, to, ok := timeRange(c)
+	if !ok {
+		return
+	}
+
+	noEntry, err := h.reconcileRows(reconcileKeys{"bookingId", "startTime", "endTime"}, `
+		SELECT b.id, COALESCE(v.plate, b.plate, ''), b.start_time, b.end_time, NULL::text
+		FROM bookings b
+		JOIN parking_spots s ON s.id = b.spot_id
+		LEFT JOIN vehicles v ON v.id = b.vehicle_id
+		WHERE s.lot_id = $1 AND b.start_time >= $2 AND b.start_time < $3 AND b.start_time < $4
+		  AND NOT EXISTS (SELECT 1 FROM gate_events e WHERE e.booking_id = b.id AND e.direction = 'ENTRY')
+		ORDER BY b.start_time
+	`, lotID, from, to, time.Now().Add(-entryGrace))
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "RECONCILIATION_FAILED", "failed to reconcile sessions", err.Error())
+		return
+	}
+
+	exitedOpen, err := h.reconcileRows(reconcileKeys{"bookingId", "startTime", "exitedAt"}, `
+		SELECT b.id, COALESCE(v.plate, b.plate, ''), b.start_time, e.occurred_at, e.gate_id::text
+		FROM gate_events e
+		JOIN bookings b ON b.id = e.booking_id
+		LEFT JOIN vehicles v ON v.id = b.vehicle_id
+		WHERE e.lot_id = $1 AND e.direction = 'EXIT' AND e.occurred_at >= $2 AND e.occurred_at < $3
+		  AND b.end_time IS NULL
+		ORDER BY e.occurred_at
+	`, lotID, from, to)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "RECONCILIATION_FAILED", "failed to reconcile exits", err.Error())
+		return
+	}
+
+	unmatched, err := h.reconcileRows(reconcileKeys{"eventId", "occurredAt", ""}, `
+		SELECT e.id, COALESCE(e.plate, ''), e.occurred_at, NULL::timestamptz, e.gate_id::text
+		FROM gate_events e
+		WHERE e.lot_id = $1 AND e.booking_id IS NULL AND e.occurred_at >= $2 AND e.occurred_at < $3
+		ORDER BY e.occurred_at
+	`, lotID, from, to)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "RECONCILIATION_FAILED", "failed to reconcile gate events", err.Error())
+		return
+	}
+
+	writeOK(c, gin.H{"data": gin.H{
+		"lotId": lotID,
+		"from":  toIST(from),
+		"to":    toIST(to),
+		"summary": gin.H{
+			"bookingsWithoutEntry": len(noEntry),
+			"exitedWithoutClosing": len(exitedOpen),
+			"eventsWithoutBooking": len(unmatched),
+		},
+		"bookingsWithoutEntry": noEntry,
+		"exitedWithoutClosing": exitedOpen,
+		"eventsWithoutBooking": unmatched,
+	}})
+}
+
+// reconcileKeys name the id and two time columns of a reconciliation row.
+type reconcileKeys struct{ id, at, until string }
+
+// reconcileRows runs a reconciliation query returning (id, plate, at, until,
+// gate id).
+func (h *Handler) reconcileRows(keys reconcileKeys, query string, args ...interface{}) ([]gin.H, error) {
+	rows, err := h.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]gin.H, 0, 10)
+	for rows.Next() {
+		var id, plate string
+		var at time.Time
+		var until sql.NullTime
+		var gateID sql.NullString
+		if err := rows.Scan(&id, &plate, &at, &until, &gateID); err != nil {
+			return nil, err
+		}
+		item := gin.H{keys.id: id, "plate": nullIfEmpty(plate), keys.at: toIST(at)}
+		if keys.until != "" {
+			var v interface{}
+			if until.Valid {
+				v = toIST(until.Time)
+			}
+			item[keys.until] = v
+		}
+		if gateID.Valid {
+			item["gateId"] = gateID.String
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
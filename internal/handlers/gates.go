@@ -0,0 +1,301 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+
+	"Backend-Go/internal/devices"
+	"Backend-Go/internal/qrtoken"
+
+	"github.com/gin-gonic/gin"
+)
+
+// BookingQR issues a fresh signed token for the caller's active booking, to
+// be shown as a QR code at the gate. Tokens are short-lived, so apps fetch a
+// new one when the old one is about to expire.
+func (h *Handler) BookingQR(c *gin.Context) {
+	bookingID := c.Param("id")
+	claims := GetClaims(c)
+
+	var spotID, lotID string
+	var end sql.NullTime
+	err := h.DB.QueryRow(`
+		SELECT b.spot_id, s.lot_id, b.end_time
+		FROM bookings b
+		JOIN parking_spots s ON s.id = b.spot_id
+		WHERE b.id = $1 AND b.user_id = $2
+	`, bookingID, claims.UserID).Scan(&spotID, &lotID, &end)
+	if err == sql.ErrNoRows {
+		writeError(c, http.StatusNotFound, "BOOKING_NOT_FOUND", "booking not found", nil)
+		return
+	} else if err != nil {
+		writeError(c, http.StatusInternalServerError, "BOOKING_FETCH_FAILED", "failed to fetch booking", err.Error())
+		return
+	}
+	if end.Valid {
+		writeError(c, http.StatusConflict, "BOOKING_CLOSED", "booking has already ended", nil)
+		return
+	}
+
+	ttl := time.Duration(h.Cfg.QRTokenTTLSeconds) * time.Second
+	token, tc, err := h.QR.Issue(bookingID, spotID, lotID, time.Now(), ttl)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "TOKEN_ISSUE_FAILED", "failed to issue gate token", err.Error())
+		return
+	}
+	writeOK(c, gin.H{"data": gin.H{
+		"bookingId": bookingID,
+		"token":     token,
+		"keyId":     tc.KeyID,
+		"expiresAt": toIST(time.Unix(tc.ExpiresAt, 0)),
+	}})
+}
+
+// QRKey publishes the public key gates use to verify tokens offline.
+func (h *Handler) QRKey(c *gin.Context) {
+	writeOK(c, gin.H{"data": gin.H{
+		"alg":       "Ed25519",
+		"keyId":     h.QR.KeyID(),
+		"publicKey": h.QR.PublicKey(),
+	}})
+}
+
+type gateTokenReq struct {
+	Token string `json:"token" binding:"required"`
+	// GateID, when given, is the registered gate the token was scanned at;
+	// the crossing is logged as a gate event.
+	GateID string `json:"gateId"`
+}
+
+// writeTokenError maps a verification failure to a response.
+func writeTokenError(c *gin.Context, err error) {
+	if errors.Is(err, qrtoken.ErrExpired) {
+		writeError(c, http.StatusUnauthorized, "TOKEN_EXPIRED", err.Error(), nil)
+		return
+	}
+	writeError(c, http.StatusUnauthorized, "TOKEN_INVALID", err.Error(), nil)
+}
+
+func claimsView(tc qrtoken.Claims) gin.H {
+	return gin.H{
+		"bookingId": tc.BookingID,
+		"spotId":    tc.SpotID,
+		"lotId":     tc.LotID,
+		"issuedAt":  toIST(time.Unix(tc.IssuedAt, 0)),
+		"expiresAt": toIST(time.Unix(tc.ExpiresAt, 0)),
+	}
+}
+
+// VerifyGateToken checks only a token's signature and validity window, the
+// same check a gate makes offline with the public key. It neither consumes
+// the token nor looks at the booking.
+func (h *Handler) VerifyGateToken(c *gin.Context) {
+	var req gateTokenReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "invalid request body", err.Error())
+		return
+	}
+	tc, err := h.QR.Verify(req.Token, time.Now())
+	if err != nil {
+		writeTokenError(c, err)
+		return
+	}
+	writeOK(c, gin.H{"data": gin.H{"valid": true, "claims": claimsView(tc)}})
+}
+
+type gateBooking struct {
+	claims      qrtoken.Claims
+	checkedIn   bool
+	checkedInAt time.Time
+}
+
+// redeemGateToken verifies a token online and consumes it for action, inside
+// the caller's transaction. A token is accepted once; any further use is a
+// replay. The booking is locked and must still be active on the token's spot.
+func (h *Handler) redeemGateToken(tx *sql.Tx, req gateTokenReq, action string) (*gateBooking, *bookingError) {
+	tc, err := h.QR.Verify(req.Token, time.Now())
+	if err != nil {
+		code := "TOKEN_INVALID"
+		if errors.Is(err, qrtoken.ErrExpired) {
+			code = "TOKEN_EXPIRED"
+		}
+		return nil, &bookingError{http.StatusUnauthorized, code, err.Error(), nil}
+	}
+
+	var spotID string
+	var end, checkedIn sql.NullTime
+	err = tx.QueryRow(`
+		SELECT spot_id, end_time, checked_in_at FROM bookings WHERE id = $1 FOR UPDATE
+	`, tc.BookingID).Scan(&spotID, &end, &checkedIn)
+	if err == sql.ErrNoRows {
+		return nil, &bookingError{http.StatusNotFound, "BOOKING_NOT_FOUND", "booking not found", nil}
+	} else if err != nil {
+		return nil, internalBookingError("BOOKING_FETCH_FAILED", "failed to fetch booking", err)
+	}
+	if spotID != tc.SpotID {
+		return nil, &bookingError{http.StatusConflict, "TOKEN_MISMATCH", "token does not match the booking's spot", nil}
+	}
+	if end.Valid {
+		return nil, &bookingError{http.StatusConflict, "BOOKING_CLOSED", "booking has already ended", nil}
+	}
+
+	res, err := tx.Exec(`
+		INSERT INTO qr_token_uses (jti, booking_id, action, gate_id)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (jti) DO NOTHING
+	`, tc.Nonce, tc.BookingID, action, nullIfEmpty(req.GateID))
+	if err != nil {
+		return nil, internalBookingError("TOKEN_RECORD_FAILED", "failed to record token use", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, &bookingError{http.StatusConflict, "TOKEN_REPLAYED", "token has already been used", nil}
+	}
+	return &gateBooking{claims: tc, checkedIn: checkedIn.Valid, checkedInAt: checkedIn.Time}, nil
+}
+
+// GateCheckIn admits a vehicle at the entry gate on a booking's token.
+func (h *Handler) GateCheckIn(c *gin.Context) {
+	var req gateTokenReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "invalid request body", err.Error())
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_BEGIN_FAILED", "could not start transaction", nil)
+		return
+	}
+	defer tx.Rollback()
+
+	gb, berr := h.redeemGateToken(tx, req, "CHECK_IN")
+	if berr != nil {
+		berr.write(c)
+		return
+	}
+	if req.GateID != "" {
+		if _, berr := recordGateEvent(tx, gateEvent{
+			GateID:     req.GateID,
+			Direction:  "ENTRY",
+			Method:     "QR",
+			BookingID:  gb.claims.BookingID,
+			OccurredAt: time.Now(),
+			RecordedBy: GetClaims(c).UserID,
+		}); berr != nil {
+			berr.write(c)
+			return
+		}
+	}
+	if gb.checkedIn {
+		writeError(c, http.StatusConflict, "ALREADY_CHECKED_IN", "booking is already checked in", gin.H{"checkedInAt": toIST(gb.checkedInAt)})
+		return
+	}
+
+	before, err := auditSnapshot(tx, "booking", gb.claims.BookingID)
+	if err != nil {
+		writeAuditError(c, err)
+		return
+	}
+	var at time.Time
+	err = tx.QueryRow(`
+		UPDATE bookings SET checked_in_at = now() WHERE id = $1 RETURNING checked_in_at
+	`, gb.claims.BookingID).Scan(&at)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "CHECK_IN_FAILED", "failed to check in", err.Error())
+		return
+	}
+	if err := auditRow(tx, c, "booking.check_in", "booking", gb.claims.BookingID, before); err != nil {
+		writeAuditError(c, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_COMMIT_FAILED", "failed to commit check-in", err.Error())
+		return
+	}
+
+	data := claimsView(gb.claims)
+	data["checkedInAt"] = toIST(at)
+	data["gateId"] = nullIfEmpty(req.GateID)
+	data["barrierOpened"] = h.moveBarrier(c.Request.Context(), req.GateID, devices.Open, gb.claims.BookingID, "CHECK_IN")
+	writeOK(c, gin.H{"data": data})
+}
+
+// GateCheckOut lets a vehicle out on a booking's token and ends the session,
+// invoicing and collecting payment as Release does.
+func (h *Handler) GateCheckOut(c *gin.Context) {
+	var req gateTokenReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "invalid request body", err.Error())
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_BEGIN_FAILED", "could not start transaction", nil)
+		return
+	}
+	defer tx.Rollback()
+
+	gb, berr := h.redeemGateToken(tx, req, "CHECK_OUT")
+	if berr != nil {
+		berr.write(c)
+		return
+	}
+	if req.GateID != "" {
+		if _, berr := recordGateEvent(tx, gateEvent{
+			GateID:     req.GateID,
+			Direction:  "EXIT",
+			Method:     "QR",
+			BookingID:  gb.claims.BookingID,
+			OccurredAt: time.Now(),
+			RecordedBy: GetClaims(c).UserID,
+		}); berr != nil {
+			berr.write(c)
+			return
+		}
+	}
+
+	before, err := auditSnapshot(tx, "booking", gb.claims.BookingID)
+	if err != nil {
+		writeAuditError(c, err)
+		return
+	}
+	var end time.Time
+	err = tx.QueryRow(`
+		UPDATE bookings SET end_time = now(), checked_out_at = now()
+		WHERE id = $1
+		RETURNING end_time
+	`, gb.claims.BookingID).Scan(&end)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "BOOKING_CLOSE_FAILED", "failed to close booking", err.Error())
+		return
+	}
+
+	f, berr := h.finishBooking(tx, gb.claims.BookingID, gb.claims.SpotID)
+	if berr != nil {
+		berr.write(c)
+		return
+	}
+	if err := auditRow(tx, c, "booking.check_out", "booking", gb.claims.BookingID, before); err != nil {
+		writeAuditError(c, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_COMMIT_FAILED", "failed to commit check-out", err.Error())
+		return
+	}
+	h.settleFinished(c.Request.Context(), f)
+
+	data := claimsView(gb.claims)
+	data["endTime"] = toIST(end)
+	data["gateId"] = nullIfEmpty(req.GateID)
+	data["invoice"] = f.Invoice
+	data["payment"] = f.Payment
+	data["heldForWaitlist"] = f.HeldFor != ""
+	data["barrierOpened"] = h.moveBarrier(c.Request.Context(), req.GateID, devices.Open, gb.claims.BookingID, "CHECK_OUT")
+	writeOK(c, gin.H{"data": data})
+}
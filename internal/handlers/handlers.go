@@ -1,22 +1,93 @@
 package handler
 
 import (
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"net/http"
 	"time"
 
 	"Backend-Go/internal/config"
+	"Backend-Go/internal/devices"
+	"Backend-Go/internal/events"
+	"Backend-Go/internal/mailer"
+	"Backend-Go/internal/notify"
+	"Backend-Go/internal/payments"
+	"Backend-Go/internal/qrtoken"
 
 	"github.com/gin-gonic/gin"
 )
 
 type Handler struct {
-	DB  *sql.DB
-	Cfg *config.Config
+	DB       *sql.DB
+	Cfg      *config.Config
+	Payments payments.Provider
+	QR       *qrtoken.Signer
+	Barriers devices.Barriers
+	Events   *events.Bus
+	Mailer   mailer.Mailer
+	// Notifiers sends user notifications, by channel.
+	Notifiers map[string]notify.Sender
+
+	forecasts forecastCache
+}
+
+// Option overrides one of the Handler's collaborators.
+type Option func(*Handler)
+
+// WithPayments sets the provider fees are captured and refunded through.
+func WithPayments(p payments.Provider) Option {
+	return func(h *Handler) { h.Payments = p }
+}
+
+// WithQRSigner sets the key gate QR tokens are signed with.
+func WithQRSigner(s *qrtoken.Signer) Option {
+	return func(h *Handler) { h.QR = s }
+}
+
+// WithBarriers sets where barrier open/close commands are sent.
+func WithBarriers(b devices.Barriers) Option {
+	return func(h *Handler) { h.Barriers = b }
+}
+
+// WithEvents sets the bus live occupancy streams subscribe to.
+func WithEvents(b *events.Bus) Option {
+	return func(h *Handler) { h.Events = b }
+}
+
+// WithMailer sets how email, such as scheduled reports, is sent.
+func WithMailer(m mailer.Mailer) Option {
+	return func(h *Handler) { h.Mailer = m }
 }
 
-func New(db *sql.DB, cfg *config.Config) *Handler {
-	return &Handler{DB: db, Cfg: cfg}
+// WithNotifier sets how user notifications on channel (EMAIL, SMS, PUSH)
+// are sent.
+func WithNotifier(channel string, s notify.Sender) Option {
+	return func(h *Handler) { h.Notifiers[channel] = s }
+}
+
+func New(db *sql.DB, cfg *config.Config, opts ...Option) *Handler {
+	h := &Handler{DB: db, Cfg: cfg, Payments: payments.Manual{}, Barriers: devices.NoBarriers{}, Events: events.NewBus(), Mailer: mailer.Log{},
+		Notifiers: map[string]notify.Sender{}}
+	for _, opt := range opts {
+		opt(h)
+	}
+	if h.Notifiers[notify.ChannelEmail] == nil {
+		h.Notifiers[notify.ChannelEmail] = notify.Mail{Mailer: h.Mailer}
+	}
+	for _, channel := range notify.Channels {
+		if h.Notifiers[channel] == nil {
+			h.Notifiers[channel] = notify.Log{}
+		}
+	}
+	if h.QR == nil {
+		s, err := qrtoken.Generate()
+		if err != nil {
+			panic("qrtoken: " + err.Error())
+		}
+		h.QR = s
+	}
+	return h
 }
 
 type ErrorResponse struct {
@@ -75,3 +146,16 @@ func init() {
 
 func nowIST() time.Time { return time.Now().In(istLoc) }
 func toIST(t time.Time) time.Time { return t.In(istLoc) }
+
+// hashToken is how bearer secrets (camera API keys, stream tickets) are
+// stored and looked up: a hex sha256, as they are random and long enough
+// not to need a slow hash.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// monthStart is midnight on the first of t's month, in t's location.
+func monthStart(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+}
@@ -0,0 +1,322 @@
+package handler
+
+import (
+	"database/sql"
+	"net/http"
+	"strings"
+	"time"
+
+	"Backend-Go/internal/billing"
+
+	"github.com/gin-gonic/gin"
+)
+
+type invoiceSummary struct {
+	InvoiceNo   string `json:"invoiceNo"`
+	TotalPaise  int64  `json:"totalPaise"`
+	BilledHours int64  `json:"billedHours"`
+}
+
+// issueInvoice bills a closed booking inside the caller's transaction. Lots
+// without a billing profile are not invoiced and yield (nil, nil).
+func issueInvoice(tx *sql.Tx, bookingID string) (*invoiceSummary, error) {
+	var lotID, userID, userName, userEmail, userGSTIN string
+	var promoID, orgID sql.NullString
+	var start time.Time
+	var end, passUntil sql.NullTime
+	err := tx.QueryRow(`
+		SELECT s.lot_id, COALESCE(b.user_id::text, ''), COALESCE(u.name, ''), COALESCE(u.email, ''), COALESCE(u.gstin, ''),
+		       b.start_time, b.end_time, p.expires_at, b.promo_code_id, b.org_id
+		FROM bookings b
+		JOIN parking_spots s ON s.id = b.spot_id
+		LEFT JOIN users u ON u.id = b.user_id
+		LEFT JOIN passes p ON p.id = b.pass_id
+		WHERE b.id = $1
+	`, bookingID).Scan(&lotID, &userID, &userName, &userEmail, &userGSTIN, &start, &end, &passUntil, &promoID, &orgID)
+	if err != nil {
+		return nil, err
+	}
+	if !end.Valid {
+		return nil, nil
+	}
+
+	var legalName, gstin, address, stateCode, lotState, sac, prefix string
+	var rateBP int
+	var ratePerHour int64
+	// a dynamically priced booking pays the rate it was locked at
+	err = tx.QueryRow(`
+		SELECT lbp.legal_name, lbp.gstin, lbp.address, lbp.state_code, lbp.lot_state_code, lbp.sac_code, lbp.gst_rate_bp,
+		       COALESCE(bp.rate_paise, lbp.rate_per_hour_paise), lbp.invoice_prefix
+		FROM lot_billing_profiles lbp
+		LEFT JOIN booking_pricing bp ON bp.booking_id = $2
+		WHERE lbp.lot_id = $1
+	`, lotID, bookingID).Scan(&legalName, &gstin, &address, &stateCode, &lotState, &sac, &rateBP, &ratePerHour, &prefix)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	tariff := billing.Tariff{RatePerHour: ratePerHour, PassValidUntil: passUntil.Time}
+	hours, taxable := tariff.Evaluate(start, end.Time)
+
+	// fold in adjustments and waivers made while the booking was active
+	var waived bool
+	var adjusted int64
+	err = tx.QueryRow(`
+		SELECT COALESCE(bool_or(kind = 'WAIVER'), false), COALESCE(SUM(amount_paise), 0)
+		FROM booking_adjustments
+		WHERE booking_id = $1 AND invoice_id IS NULL AND refund_id IS NULL
+	`, bookingID).Scan(&waived, &adjusted)
+	if err != nil {
+		return nil, err
+	}
+	taxable += adjusted
+	if waived || taxable < 0 {
+		taxable = 0
+	}
+
+	// a promo code attached at booking is honoured if it was valid when the
+	// session started and its usage limits still allow another redemption
+	var discount int64
+	if promoID.Valid && taxable > 0 {
+		promo, err := checkPromoByID(tx, promoID.String, userID, lotID, start, true)
+		if _, ok := err.(*promoError); err != nil && !ok {
+			return nil, err
+		}
+		if promo != nil {
+			discount = promo.Discount(taxable)
+			taxable -= discount
+		}
+	}
+	if discount > 0 {
+		_, err = tx.Exec(`
+			INSERT INTO promo_redemptions (promo_id, booking_id, user_id, discount_paise)
+			VALUES ($1, $2, $3, $4)
+		`, promoID.String, bookingID, nullIfEmpty(userID), discount)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := tx.Exec(`UPDATE bookings SET taxable_paise = $2 WHERE id = $1`, bookingID, taxable); err != nil {
+		return nil, err
+	}
+	// fleet sessions go on the organisation's monthly consolidated invoice
+	if orgID.Valid {
+		return nil, nil
+	}
+	if taxable == 0 {
+		_, err := tx.Exec(`UPDATE bookings SET amount_paise = 0 WHERE id = $1`, bookingID)
+		return nil, err
+	}
+
+	// parking is supplied where the lot is, whoever the customer; a
+	// registered customer's GSTIN is still shown on the invoice
+	pos := lotState
+	var customerGSTIN interface{}
+	if billing.ValidGSTIN(userGSTIN) {
+		customerGSTIN = strings.ToUpper(userGSTIN)
+	}
+	tax := billing.ComputeGST(taxable, rateBP, billing.IntraStateSupply(gstin, lotState))
+
+	fy := billing.FinancialYear(toIST(end.Time))
+	invoiceNo, err := nextInvoiceNumber(tx, lotID, prefix, fy)
+	if err != nil {
+		return nil, err
+	}
+
+	var invoiceID string
+	err = tx.QueryRow(`
+		INSERT INTO invoices (booking_id, lot_id, invoice_no, financial_year,
+		                      supplier_name, supplier_gstin, supplier_address, supplier_state,
+		                      customer_name, customer_email, customer_gstin, place_of_supply,
+		                      sac_code, billed_hours, rate_paise, gst_rate_bp,
+		                      taxable_paise, cgst_paise, sgst_paise, igst_paise, total_paise)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21)
+		RETURNING id
+	`, bookingID, lotID, invoiceNo, fy,
+		legalName, gstin, address, stateCode,
+		userName, userEmail, customerGSTIN, pos,
+		sac, hours, ratePerHour, rateBP,
+		tax.Taxable, tax.CGST, tax.SGST, tax.IGST, tax.Total).Scan(&invoiceID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE booking_adjustments SET invoice_id = $2
+		WHERE booking_id = $1 AND invoice_id IS NULL AND refund_id IS NULL
+	`, bookingID, invoiceID)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec(`UPDATE bookings SET amount_paise = $2 WHERE id = $1`, bookingID, tax.Total); err != nil {
+		return nil, err
+	}
+	return &invoiceSummary{InvoiceNo: invoiceNo, TotalPaise: tax.Total, BilledHours: hours}, nil
+}
+
+// nextInvoiceNumber takes the lot's next number for the financial year. The
+// sequence row stays locked until the caller's transaction ends, so numbers
+// are gapless.
+func nextInvoiceNumber(tx *sql.Tx, lotID, prefix, fy string) (string, error) {
+	var seq int64
+	err := tx.QueryRow(`
+		INSERT INTO invoice_sequences (lot_id, financial_year, last_no)
+		VALUES ($1, $2, 1)
+		ON CONFLICT (lot_id, financial_year) DO UPDATE SET last_no = invoice_sequences.last_no + 1
+		RETURNING last_no
+	`, lotID, fy).Scan(&seq)
+	if err != nil {
+		return "", err
+	}
+	return billing.InvoiceNumber(prefix, fy, seq), nil
+}
+
+// loadReceipt rebuilds the receipt for a booking's invoice, along with the
+// booking owner so callers can authorise access.
+func (h *Handler) loadReceipt(bookingID string) (billing.Receipt, string, error) {
+	var r billing.Receipt
+	var invoiceID string
+	var ownerID, customerGSTIN, plate sql.NullString
+	var rate, hours int64
+	var sac string
+	err := h.DB.QueryRow(`
+		SELECT i.id, i.invoice_no, i.issued_at, i.booking_id,
+		       i.supplier_name, i.supplier_gstin, i.supplier_address, i.supplier_state,
+		       i.customer_name, i.customer_email, i.customer_gstin, i.place_of_supply,
+		       i.sac_code, i.billed_hours, i.rate_paise, i.gst_rate_bp,
+		       i.taxable_paise, i.cgst_paise, i.sgst_paise, i.igst_paise, i.total_paise,
+		       b.user_id, b.start_time, b.end_time, b.dispute_status, s.number, COALESCE(v.plate, b.plate)
+		FROM invoices i
+		JOIN bookings b ON b.id = i.booking_id
+		JOIN parking_spots s ON s.id = b.spot_id
+		LEFT JOIN vehicles v ON v.id = b.vehicle_id
+		WHERE i.booking_id = $1
+	`, bookingID).Scan(&invoiceID, &r.InvoiceNo, &r.IssuedAt, &r.BookingID,
+		&r.Supplier.Name, &r.Supplier.GSTIN, &r.Supplier.Address, &r.Supplier.StateCode,
+		&r.Customer.Name, &r.Customer.Email, &customerGSTIN, &r.PlaceOfSupply,
+		&sac, &hours, &rate, &r.Tax.RateBP,
+		&r.Tax.Taxable, &r.Tax.CGST, &r.Tax.SGST, &r.Tax.IGST, &r.Tax.Total,
+		&ownerID, &r.SessionStart, &r.SessionEnd, &r.DisputeStatus, &r.SpotNumber, &plate)
+	if err != nil {
+		return r, "", err
+	}
+
+	r.Customer.GSTIN = customerGSTIN.String
+	if r.Customer.GSTIN != "" {
+		r.Customer.StateCode = billing.StateCode(r.Customer.GSTIN)
+	}
+	r.VehiclePlate = plate.String
+	r.PlaceOfSupply = billing.PlaceOfSupply(r.PlaceOfSupply)
+	r.IssuedAt = toIST(r.IssuedAt)
+	r.SessionStart = toIST(r.SessionStart)
+	r.SessionEnd = toIST(r.SessionEnd)
+	r.Lines = []billing.LineItem{{
+		Description: "Parking charges",
+		SAC:         sac,
+		Quantity:    hours,
+		Unit:        "hr",
+		RatePaise:   rate,
+		AmountPaise: hours * rate,
+	}}
+
+	adj, err := h.DB.Query(`
+		SELECT amount_paise, reason_code
+		FROM booking_adjustments
+		WHERE invoice_id = $1
+		ORDER BY created_at
+	`, invoiceID)
+	if err != nil {
+		return r, "", err
+	}
+	defer adj.Close()
+	for adj.Next() {
+		var reason string
+		var amount int64
+		if err := adj.Scan(&amount, &reason); err != nil {
+			return r, "", err
+		}
+		r.Lines = append(r.Lines, billing.LineItem{Description: "Adjustment (" + reason + ")", SAC: sac, AmountPaise: amount})
+	}
+	if err := adj.Err(); err != nil {
+		return r, "", err
+	}
+
+	var promo string
+	var discount int64
+	err = h.DB.QueryRow(`
+		SELECT p.code, r.discount_paise
+		FROM promo_redemptions r
+		JOIN promo_codes p ON p.id = r.promo_id
+		WHERE r.booking_id = $1
+	`, bookingID).Scan(&promo, &discount)
+	if err == nil {
+		r.Lines = append(r.Lines, billing.LineItem{Description: "Discount (" + promo + ")", SAC: sac, AmountPaise: -discount})
+	} else if err != sql.ErrNoRows {
+		return r, "", err
+	}
+
+	refunds, err := h.DB.Query(`
+		SELECT id, amount_paise, reason_code, created_at
+		FROM refunds
+		WHERE booking_id = $1 AND status <> 'FAILED'
+		ORDER BY created_at
+	`, bookingID)
+	if err != nil {
+		return r, "", err
+	}
+	defer refunds.Close()
+	r.NetPaise = r.Tax.Total
+	for refunds.Next() {
+		var rf billing.Refund
+		if err := refunds.Scan(&rf.ID, &rf.AmountPaise, &rf.ReasonCode, &rf.RefundedAt); err != nil {
+			return r, "", err
+		}
+		rf.RefundedAt = toIST(rf.RefundedAt)
+		r.Refunds = append(r.Refunds, rf)
+		r.NetPaise -= rf.AmountPaise
+	}
+	if err := refunds.Err(); err != nil {
+		return r, "", err
+	}
+
+	r.AmountInWords = billing.AmountInWords(r.Tax.Total)
+	return r, ownerID.String, nil
+}
+
+// Receipt serves a booking's tax invoice as JSON, or as a PDF when asked for
+// with ?format=pdf or an Accept: application/pdf header.
+func (h *Handler) Receipt(c *gin.Context) {
+	bookingID := c.Param("id")
+	claims := GetClaims(c)
+
+	r, ownerID, err := h.loadReceipt(bookingID)
+	if err == sql.ErrNoRows {
+		writeError(c, http.StatusNotFound, "RECEIPT_NOT_FOUND", "no invoice exists for this booking", nil)
+		return
+	} else if err != nil {
+		writeError(c, http.StatusInternalServerError, "RECEIPT_FETCH_FAILED", "failed to fetch receipt", err.Error())
+		return
+	}
+	if ownerID != claims.UserID && claims.Role != "admin" {
+		writeError(c, http.StatusNotFound, "RECEIPT_NOT_FOUND", "no invoice exists for this booking", nil)
+		return
+	}
+
+	writeReceipt(c, r)
+}
+
+// writeReceipt sends a receipt as JSON, or as a PDF when ?format=pdf or the
+// Accept header asks for one.
+func writeReceipt(c *gin.Context, r billing.Receipt) {
+	if c.Query("format") == "pdf" || strings.Contains(c.GetHeader("Accept"), "application/pdf") {
+		filename := strings.ReplaceAll(r.InvoiceNo, "/", "-") + ".pdf"
+		c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+		c.Data(http.StatusOK, "application/pdf", billing.RenderPDF(r))
+		return
+	}
+	writeOK(c, gin.H{"data": r})
+}
@@ -0,0 +1,383 @@
+package handler
+
+import (
+	"database/sql"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"Backend-Go/internal/notify"
+
+	"github.com/gin-gonic/gin"
+)
+
+// phonePattern is an E.164 number: +, country code and subscriber number.
+var phonePattern = regexp.MustCompile(`^\+[1-9][0-9]{7,14}$`)
+
+// notificationChannels parses a comma-joined channel list, in the order of
+// notify.Channels.
+func notificationChannels(joined string) []string {
+	set := map[string]bool{}
+	for _, ch := range strings.Split(joined, ",") {
+		set[ch] = true
+	}
+	out := []string{}
+	for _, ch := range notify.Channels {
+		if set[ch] {
+			out = append(out, ch)
+		}
+	}
+	return out
+}
+
+// notificationSettings is a user's language, phone and channel preferences:
+// the channels set per kind (and DEFAULT), and those each kind is sent on.
+func notificationSettings(q querier, userID string) (gin.H, error) {
+	var locale string
+	var phone sql.NullString
+	var tokens int
+	err := q.QueryRow(`
+		SELECT locale, phone, (SELECT COUNT(*) FROM push_tokens WHERE user_id = u.id)
+		FROM users u WHERE id = $1
+	`, userID).Scan(&locale, &phone, &tokens)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := q.Query(`
+		SELECT kind, array_to_string(channels, ',') FROM notification_preferences WHERE user_id = $1
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	set := map[string][]string{}
+	for rows.Next() {
+		var kind, channels string
+		if err := rows.Scan(&kind, &channels); err != nil {
+			return nil, err
+		}
+		set[kind] = notificationChannels(channels)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	effective := gin.H{}
+	for _, kind := range notify.Kinds {
+		channels, ok := set[kind]
+		if !ok {
+			if channels, ok = set["DEFAULT"]; !ok {
+				channels = defaultChannels
+			}
+		}
+		effective[kind] = channels
+	}
+	return gin.H{
+		"locale":     locale,
+		"phone":      nullString(phone),
+		"channels":   set,
+		"effective":  effective,
+		"pushTokens": tokens,
+		"locales":    notify.Locales(),
+	}, nil
+}
+
+// NotificationPreferences returns the caller's notification settings.
+func (h *Handler) NotificationPreferences(c *gin.Context) {
+	settings, err := notificationSettings(h.DB, GetClaims(c).UserID)
+	if err == sql.ErrNoRows {
+		writeError(c, http.StatusNotFound, "USER_NOT_FOUND", "user not found", nil)
+		return
+	} else if err != nil {
+		writeError(c, http.StatusInternalServerError, "PREFERENCES_FETCH_FAILED", "failed to fetch notification preferences", err.Error())
+		return
+	}
+	writeOK(c, gin.H{"data": settings})
+}
+
+type notificationPrefsReq struct {
+	Locale *string `json:"locale"`
+	// Phone is E.164; "" removes it.
+	Phone *string `json:"phone"`
+	// Channels sets the channels for a kind, or DEFAULT; an empty list turns
+	// the kind off and null goes back to the default.
+	Channels map[string]*[]string `json:"channels"`
+}
+
+// UpdateNotificationPreferences changes the caller's language, phone and
+// per-kind channels. Fields left out are unchanged.
+func (h *Handler) UpdateNotificationPreferences(c *gin.Context) {
+	var req notificationPrefsReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "invalid request body", err.Error())
+		return
+	}
+	if req.Locale != nil && !notify.HasLocale(*req.Locale) {
+		writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "unsupported locale", gin.H{"locales": notify.Locales()})
+		return
+	}
+	if req.Phone != nil && *req.Phone != "" && !phonePattern.MatchString(*req.Phone) {
+		writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "phone must be in E.164 form, e.g. +919812345678", nil)
+		return
+	}
+	kinds := map[string]bool{"DEFAULT": true}
+	for _, k := range notify.Kinds {
+		kinds[k] = true
+	}
+	channels := map[string]bool{}
+	for _, ch := range notify.Channels {
+		channels[ch] = true
+	}
+	for kind, list := range req.Channels {
+		if !kinds[kind] {
+			writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "unknown notification kind "+kind,
+				gin.H{"kinds": append([]string{"DEFAULT"}, notify.Kinds...)})
+			return
+		}
+		if list == nil {
+			continue
+		}
+		for _, ch := range *list {
+			if !channels[ch] {
+				writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "unknown channel "+ch, gin.H{"channels": notify.Channels})
+				return
+			}
+		}
+	}
+	userID := GetClaims(c).UserID
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_BEGIN_FAILED", "could not start transaction", nil)
+		return
+	}
+	defer tx.Rollback()
+
+	before, err := auditSnapshot(tx, "notification_settings", userID)
+	if err != nil {
+		writeAuditError(c, err)
+		return
+	}
+	if before == nil {
+		writeError(c, http.StatusNotFound, "USER_NOT_FOUND", "user not found", nil)
+		return
+	}
+	if req.Locale != nil {
+		if _, err := tx.Exec(`UPDATE users SET locale = $2 WHERE id = $1`, userID, *req.Locale); err != nil {
+			writeError(c, http.StatusInternalServerError, "PREFERENCES_UPDATE_FAILED", "failed to update locale", err.Error())
+			return
+		}
+	}
+	if req.Phone != nil {
+		if _, err := tx.Exec(`UPDATE users SET phone = $2 WHERE id = $1`, userID, nullIfEmpty(*req.Phone)); err != nil {
+			writeError(c, http.StatusInternalServerError, "PREFERENCES_UPDATE_FAILED", "failed to update phone", err.Error())
+			return
+		}
+	}
+	for kind, list := range req.Channels {
+		if list == nil {
+			_, err = tx.Exec(`DELETE FROM notification_preferences WHERE user_id = $1 AND kind = $2`, userID, kind)
+		} else {
+			_, err = tx.Exec(`
+				INSERT INTO notification_preferences (user_id, kind, channels)
+				VALUES ($1, $2, string_to_array($3, ','))
+				ON CONFLICT (user_id, kind) DO UPDATE SET channels = EXCLUDED.channels
+			`, userID, kind, strings.Join(notificationChannels(strings.Join(*list, ",")), ","))
+		}
+		if err != nil {
+			writeError(c, http.StatusInternalServerError, "PREFERENCES_UPDATE_FAILED", "failed to update channels", err.Error())
+			return
+		}
+	}
+
+	var smsWithoutPhone bool
+	err = tx.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM notification_preferences p JOIN users u ON u.id = p.user_id
+			WHERE u.id = $1 AND u.phone IS NULL AND 'SMS' = ANY (p.channels)
+		)
+	`, userID).Scan(&smsWithoutPhone)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "PREFERENCES_UPDATE_FAILED", "failed to check channels", err.Error())
+		return
+	}
+	if smsWithoutPhone {
+		writeError(c, http.StatusBadRequest, "PHONE_REQUIRED", "add a phone number to get SMS notifications", nil)
+		return
+	}
+
+	if err := auditRow(tx, c, "notification_settings.update", "notification_settings", userID, before); err != nil {
+		writeAuditError(c, err)
+		return
+	}
+	settings, err := notificationSettings(tx, userID)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "PREFERENCES_FETCH_FAILED", "failed to fetch notification preferences", err.Error())
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_COMMIT_FAILED", "failed to commit preferences", err.Error())
+		return
+	}
+	writeOK(c, gin.H{"data": settings})
+}
+
+type pushTokenReq struct {
+	Token    string `json:"token" binding:"required,max=4096"`
+	Platform string `json:"platform" binding:"required,oneof=ANDROID IOS WEB"`
+}
+
+// RegisterPushToken adds a device's push token for the caller. A token
+// already registered moves to the caller, as the device has changed hands.
+func (h *Handler) RegisterPushToken(c *gin.Context) {
+	var req pushTokenReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "invalid request body", err.Error())
+		return
+	}
+	tx, err := h.DB.Begin()
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_BEGIN_FAILED", "could not start transaction", nil)
+		return
+	}
+	defer tx.Rollback()
+
+	before, err := auditSnapshot(tx, "push_token", req.Token)
+	if err != nil {
+		writeAuditError(c, err)
+		return
+	}
+	_, err = tx.Exec(`
+		INSERT INTO push_tokens (token, user_id, platform) VALUES ($1, $2, $3)
+		ON CONFLICT (token) DO UPDATE SET user_id = EXCLUDED.user_id, platform = EXCLUDED.platform, created_at = now()
+	`, req.Token, GetClaims(c).UserID, req.Platform)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "PUSH_TOKEN_SAVE_FAILED", "failed to save push token", err.Error())
+		return
+	}
+	if err := auditRow(tx, c, "push_token.register", "push_token", req.Token, before); err != nil {
+		writeAuditError(c, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_COMMIT_FAILED", "failed to commit push token", err.Error())
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"data": gin.H{"token": req.Token, "platform": req.Platform}})
+}
+
+// DeletePushToken stops push notifications to one of the caller's devices.
+func (h *Handler) DeletePushToken(c *gin.Context) {
+	token := c.Param("token")
+	userID := GetClaims(c).UserID
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_BEGIN_FAILED", "could not start transaction", nil)
+		return
+	}
+	defer tx.Rollback()
+
+	before, err := auditSnapshot(tx, "push_token", token)
+	if err != nil {
+		writeAuditError(c, err)
+		return
+	}
+	res, err := tx.Exec(`DELETE FROM push_tokens WHERE token = $1 AND user_id = $2`, token, userID)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "PUSH_TOKEN_DELETE_FAILED", "failed to delete push token", err.Error())
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		writeError(c, http.StatusNotFound, "PUSH_TOKEN_NOT_FOUND", "push token not found", nil)
+		return
+	}
+	if err := audit(tx, c, "push_token.delete", "push_token", token, before, nil); err != nil {
+		writeAuditError(c, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_COMMIT_FAILED", "failed to commit push token", err.Error())
+		return
+	}
+	writeOK(c, gin.H{"data": gin.H{"token": token, "deleted": true}})
+}
+
+// MyNotifications pages through the caller's notifications, newest first,
+// filtered by ?status= (PENDING, SENT or FAILED) and ?kind=.
+func (h *Handler) MyNotifications(c *gin.Context) {
+	page, size, ok := pageParams(c)
+	if !ok {
+		return
+	}
+	status := c.Query("status")
+	if status != "" && status != "PENDING" && status != "SENT" && status != "FAILED" {
+		writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "status must be PENDING, SENT or FAILED", nil)
+		return
+	}
+
+	where := `
+		WHERE user_id = $1
+		  AND ($2 = '' OR status = $2)
+		  AND ($3 = '' OR kind = $3)`
+	args := []interface{}{GetClaims(c).UserID, status, c.Query("kind")}
+
+	var total int
+	if err := h.DB.QueryRow(`SELECT COUNT(*) FROM notifications`+where, args...).Scan(&total); err != nil {
+		writeError(c, http.StatusInternalServerError, "NOTIFICATIONS_FETCH_FAILED", "failed to count notifications", err.Error())
+		return
+	}
+	rows, err := h.DB.Query(`
+		SELECT id, kind, channel, destination, locale, subject, body, status, attempts, last_error, created_at, sent_at
+		FROM notifications`+where+`
+		ORDER BY created_at DESC, id
+		LIMIT $4 OFFSET $5
+	`, append(args, size, (page-1)*size)...)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "NOTIFICATIONS_FETCH_FAILED", "failed to fetch notifications", err.Error())
+		return
+	}
+	defer rows.Close()
+
+	items := make([]gin.H, 0, size)
+	for rows.Next() {
+		var id, kind, channel, dest, locale, subject, body, st string
+		var attempts int
+		var lastError sql.NullString
+		var created time.Time
+		var sent sql.NullTime
+		if err := rows.Scan(&id, &kind, &channel, &dest, &locale, &subject, &body, &st, &attempts, &lastError,
+			&created, &sent); err != nil {
+			writeError(c, http.StatusInternalServerError, "SCAN_ERROR", "failed to read row", err.Error())
+			return
+		}
+		item := gin.H{
+			"id":          id,
+			"kind":        kind,
+			"channel":     channel,
+			"destination": dest,
+			"locale":      locale,
+			"subject":     subject,
+			"body":        body,
+			"status":      st,
+			"attempts":    attempts,
+			"lastError":   nullString(lastError),
+			"createdAt":   toIST(created),
+		}
+		if sent.Valid {
+			item["sentAt"] = toIST(sent.Time)
+		}
+		items = append(items, item)
+	}
+	writeOK(c, gin.H{
+		"items": items,
+		"pagination": gin.H{
+			"page":       page,
+			"pageSize":   size,
+			"total":      total,
+			"totalPages": (total + size - 1) / size,
+		},
+	})
+}
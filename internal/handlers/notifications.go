@@ -0,0 +1,407 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"Backend-Go/internal/notify"
+	"Backend-Go/internal/outbox"
+)
+
+const (
+	// notificationBatch is how many notifications are claimed, and sent in
+	// parallel, at a time; notificationLease is how long a claim holds.
+	notificationBatch = 50
+	notificationLease = 2 * time.Minute
+	// notificationMaxAttempts is how many times a notification is tried
+	// before it is marked failed.
+	notificationMaxAttempts = 5
+)
+
+// defaultChannels are used for kinds a user has set no preference for.
+var defaultChannels = []string{notify.ChannelEmail, notify.ChannelPush}
+
+// lotTime is t on the wall clock of a lot in timezone tz.
+func lotTime(tz string, t time.Time) time.Time {
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		loc = istLoc
+	}
+	return t.In(loc)
+}
+
+// notifyUser queues a notification of kind for userID on each channel they
+// want it on, rendered in their language. key names what is being notified
+// (booking:<id>:confirmed); a key is notified once, however often it comes
+// up, and is used up even when the user has turned the kind off.
+func (h *Handler) notifyUser(ctx context.Context, userID, kind, key string, d notify.Data) error {
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var email, locale string
+	var phone, channels sql.NullString
+	err = tx.QueryRow(`
+		SELECT COALESCE(u.name, ''), u.email, u.locale, u.phone,
+		       (SELECT array_to_string(p.channels, ',') FROM notification_preferences p
+		        WHERE p.user_id = u.id AND p.kind IN ($2, 'DEFAULT')
+		        ORDER BY p.kind = 'DEFAULT'
+		        LIMIT 1)
+		FROM users u WHERE u.id = $1
+	`, userID, kind).Scan(&d.Name, &email, &locale, &phone, &channels)
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	res, err := tx.Exec(`
+		INSERT INTO notification_triggers (dedupe_key, user_id, kind) VALUES ($1, $2, $3)
+		ON CONFLICT (dedupe_key) DO NOTHING
+	`, key, userID, kind)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil
+	}
+
+	want := defaultChannels
+	if channels.Valid {
+		want = splitNonEmpty(channels.String)
+	}
+	r, err := notify.Render(kind, locale, d)
+	if err != nil {
+		return err
+	}
+	for _, channel := range want {
+		var to []string
+		switch channel {
+		case notify.ChannelEmail:
+			to = []string{email}
+		case notify.ChannelSMS:
+			if phone.Valid {
+				to = []string{phone.String}
+			}
+		case notify.ChannelPush:
+			if to, err = pushTokens(tx, userID); err != nil {
+				return err
+			}
+		}
+		subject, body := r.Text(channel)
+		for _, dest := range to {
+			if _, err := tx.Exec(`
+				INSERT INTO notifications (user_id, dedupe_key, kind, channel, destination, locale, subject, body)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+			`, userID, key, kind, channel, dest, locale, subject, body); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+func pushTokens(q querier, userID string) ([]string, error) {
+	rows, err := q.Query(`SELECT token FROM push_tokens WHERE user_id = $1 ORDER BY created_at`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []string
+	for rows.Next() {
+		var token string
+		if err := rows.Scan(&token); err != nil {
+			return nil, err
+		}
+		out = append(out, token)
+	}
+	return out, rows.Err()
+}
+
+// SubscribeNotifications has subs queue user notifications for the domain
+// events that warrant one. Reminders nothing happens at, like overstays and
+// expiring passes, are found by ScanNotifications instead.
+func (h *Handler) SubscribeNotifications(subs *outbox.Subscribers) {
+	subs.On(outbox.BookingStarted, h.notifyBookingStarted)
+	subs.On(outbox.PaymentCaptured, h.notifyPaymentCaptured)
+	subs.On(outbox.WaitlistOffered, h.notifyWaitlistOffered)
+}
+
+func (h *Handler) notifyBookingStarted(ctx context.Context, e outbox.Event) error {
+	var b outbox.BookingData
+	if err := json.Unmarshal(e.Data, &b); err != nil {
+		return err
+	}
+	if b.UserID == "" {
+		// walk-in ticket
+		return nil
+	}
+	d := notify.Data{Plate: b.Plate}
+	var tz string
+	err := h.DB.QueryRowContext(ctx, `
+		SELECT l.name, l.timezone, s.number::text
+		FROM parking_spots s JOIN parking_lots l ON l.id = s.lot_id
+		WHERE s.id = $1
+	`, b.SpotID).Scan(&d.Lot, &tz, &d.Spot)
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	d.At = lotTime(tz, b.StartTime)
+	return h.notifyUser(ctx, b.UserID, notify.BookingConfirmed, "booking:"+b.BookingID+":confirmed", d)
+}
+
+func (h *Handler) notifyPaymentCaptured(ctx context.Context, e outbox.Event) error {
+	var p outbox.PaymentData
+	if err := json.Unmarshal(e.Data, &p); err != nil {
+		return err
+	}
+	if p.AmountPaise <= 0 {
+		return nil
+	}
+	d := notify.Data{AmountPaise: p.AmountPaise, Reference: p.ProviderRef}
+	var userID sql.NullString
+	var tz string
+	var err error
+	if p.PassID != "" {
+		err = h.DB.QueryRowContext(ctx, `
+			SELECT p.user_id, pp.name, l.name, l.timezone
+			FROM passes p
+			JOIN pass_products pp ON pp.id = p.product_id
+			JOIN parking_lots l ON l.id = p.lot_id
+			WHERE p.id = $1
+		`, p.PassID).Scan(&userID, &d.Pass, &d.Lot, &tz)
+	} else {
+		err = h.DB.QueryRowContext(ctx, `
+			SELECT b.user_id, l.name, l.timezone, COALESCE(i.invoice_no, '')
+			FROM bookings b
+			JOIN parking_spots s ON s.id = b.spot_id
+			JOIN parking_lots l ON l.id = s.lot_id
+			LEFT JOIN invoices i ON i.booking_id = b.id
+			WHERE b.id = $1
+		`, p.BookingID).Scan(&userID, &d.Lot, &tz, &d.InvoiceNo)
+	}
+	if err == sql.ErrNoRows || (err == nil && !userID.Valid) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	d.At = lotTime(tz, e.OccurredAt)
+	return h.notifyUser(ctx, userID.String, notify.PaymentReceipt, "payment:"+p.PaymentID, d)
+}
+
+func (h *Handler) notifyWaitlistOffered(ctx context.Context, e outbox.Event) error {
+	var w outbox.WaitlistData
+	if err := json.Unmarshal(e.Data, &w); err != nil {
+		return err
+	}
+	var d notify.Data
+	var tz string
+	err := h.DB.QueryRowContext(ctx, `
+		SELECT l.name, l.timezone, s.number::text, v.plate
+		FROM parking_spots s
+		JOIN parking_lots l ON l.id = s.lot_id
+		JOIN vehicles v ON v.id = $2
+		WHERE s.id = $1
+	`, w.SpotID, w.VehicleID).Scan(&d.Lot, &tz, &d.Spot, &d.Plate)
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	d.At = lotTime(tz, w.ExpiresAt)
+	return h.notifyUser(ctx, w.UserID, notify.SpotHeld, "waitlist:"+w.EntryID+":held", d)
+}
+
+// reminder is a notification found by ScanNotifications.
+type reminder struct {
+	userID, kind, key string
+	data              notify.Data
+}
+
+// ScanNotifications queues reminders that are due: overstay warnings for
+// sessions open longer than OVERSTAY_WARNING_HOURS and notices for passes
+// expiring within PASS_EXPIRY_NOTICE_DAYS. Each is sent once; a renewed
+// pass is noticed again before its new expiry.
+func (h *Handler) ScanNotifications(ctx context.Context) error {
+	var due []reminder
+
+	rows, err := h.DB.QueryContext(ctx, `
+		SELECT b.id, b.user_id, b.start_time, l.name, l.timezone, s.number::text, COALESCE(v.plate, '')
+		FROM bookings b
+		JOIN parking_spots s ON s.id = b.spot_id
+		JOIN parking_lots l ON l.id = s.lot_id
+		LEFT JOIN vehicles v ON v.id = b.vehicle_id
+		WHERE b.end_time IS NULL AND b.user_id IS NOT NULL AND b.pass_id IS NULL
+		  AND b.start_time <= now() - make_interval(hours => $1)
+		  AND NOT EXISTS (
+			SELECT 1 FROM notification_triggers t WHERE t.dedupe_key = 'booking:' || b.id || ':overstay'
+		  )
+	`, h.Cfg.OverstayWarningHours)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var id, userID, tz string
+		var start time.Time
+		var d notify.Data
+		if err := rows.Scan(&id, &userID, &start, &d.Lot, &tz, &d.Spot, &d.Plate); err != nil {
+			rows.Close()
+			return err
+		}
+		d.At = lotTime(tz, start)
+		d.Hours = int(time.Since(start).Hours())
+		due = append(due, reminder{userID, notify.OverstayWarning, "booking:" + id + ":overstay", d})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = h.DB.QueryContext(ctx, `
+		SELECT p.id, p.user_id, p.expires_at, pp.name, l.name, l.timezone
+		FROM passes p
+		JOIN pass_products pp ON pp.id = p.product_id
+		JOIN parking_lots l ON l.id = p.lot_id
+		WHERE p.status = 'ACTIVE' AND p.expires_at > now()
+		  AND p.expires_at <= now() + make_interval(days => $1)
+		  AND NOT EXISTS (
+			SELECT 1 FROM notification_triggers t
+			WHERE t.dedupe_key = 'pass:' || p.id || ':expiring:' || floor(extract(epoch FROM p.expires_at))::bigint
+		  )
+	`, h.Cfg.PassExpiryNoticeDays)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var id, userID, tz string
+		var expires time.Time
+		var d notify.Data
+		if err := rows.Scan(&id, &userID, &expires, &d.Pass, &d.Lot, &tz); err != nil {
+			rows.Close()
+			return err
+		}
+		d.At = lotTime(tz, expires)
+		key := fmt.Sprintf("pass:%s:expiring:%d", id, expires.Unix())
+		due = append(due, reminder{userID, notify.PassExpiring, key, d})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, r := range due {
+		if err := h.notifyUser(ctx, r.userID, r.kind, r.key, r.data); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			log.Printf("notification %s: %v", r.key, err)
+		}
+	}
+	return nil
+}
+
+// claimedNotification is a notification leased for sending.
+type claimedNotification struct {
+	notify.Message
+	Attempts int
+}
+
+// SendNotifications sends due notifications until none are left. It runs
+// as a background job on every replica; notifications are leased, so each
+// attempt is made by one of them.
+func (h *Handler) SendNotifications(ctx context.Context) error {
+	for ctx.Err() == nil {
+		batch, err := h.claimNotifications(ctx)
+		if err != nil {
+			return err
+		}
+		var wg sync.WaitGroup
+		for _, n := range batch {
+			wg.Add(1)
+			go func(n claimedNotification) {
+				defer wg.Done()
+				if err := h.sendNotification(ctx, n); err != nil {
+					log.Printf("notification %s: %v", n.ID, err)
+				}
+			}(n)
+		}
+		wg.Wait()
+		if len(batch) < notificationBatch {
+			return nil
+		}
+	}
+	return nil
+}
+
+func (h *Handler) claimNotifications(ctx context.Context) ([]claimedNotification, error) {
+	rows, err := h.DB.QueryContext(ctx, `
+		UPDATE notifications
+		SET locked_until = now() + $2 * interval '1 second', attempts = attempts + 1
+		WHERE id IN (
+			SELECT id FROM notifications
+			WHERE status = 'PENDING' AND next_attempt_at <= now()
+			  AND (locked_until IS NULL OR locked_until < now())
+			ORDER BY next_attempt_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, kind, channel, destination, subject, body, attempts
+	`, notificationBatch, notificationLease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []claimedNotification
+	for rows.Next() {
+		var n claimedNotification
+		if err := rows.Scan(&n.ID, &n.Kind, &n.Channel, &n.To, &n.Subject, &n.Body, &n.Attempts); err != nil {
+			return nil, err
+		}
+		out = append(out, n)
+	}
+	return out, rows.Err()
+}
+
+// sendNotification makes one attempt at n through its channel's sender and
+// records the outcome, retrying with the outbox backoff until it has used
+// its attempts.
+func (h *Handler) sendNotification(ctx context.Context, n claimedNotification) error {
+	sendErr := errors.New("no sender for channel " + n.Channel)
+	if s := h.Notifiers[n.Channel]; s != nil {
+		sendErr = s.Send(ctx, n.Message)
+	}
+	if ctx.Err() != nil {
+		// shutting down; the lease lapses and the attempt is made again
+		return nil
+	}
+
+	var err error
+	switch {
+	case sendErr == nil:
+		_, err = h.DB.Exec(`
+			UPDATE notifications SET status = 'SENT', sent_at = now(), locked_until = NULL, last_error = NULL
+			WHERE id = $1
+		`, n.ID)
+	case n.Attempts >= notificationMaxAttempts:
+		_, err = h.DB.Exec(`
+			UPDATE notifications SET status = 'FAILED', locked_until = NULL, last_error = $2 WHERE id = $1
+		`, n.ID, sendErr.Error())
+	default:
+		_, err = h.DB.Exec(`
+			UPDATE notifications
+			SET next_attempt_at = now() + $2 * interval '1 second', locked_until = NULL, last_error = $3
+			WHERE id = $1
+		`, n.ID, outbox.Backoff(n.Attempts).Seconds(), sendErr.Error())
+	}
+	return err
+}
@@ -1,51 +1,190 @@
 package handler
 
 import (
+    "database/sql"
     "net/http"
+    "strconv"
     "time"
 
+    "Backend-Go/internal/events"
+
     "github.com/gin-gonic/gin"
 )
 
+const (
+    defaultPageSize = 50
+    maxPageSize     = 200
+)
+
+// pageParams reads ?page= (from 1) and ?pageSize= (default 50, at most
+// 200).
+func pageParams(c *gin.Context) (page, size int, ok bool) {
+    page, size = 1, defaultPageSize
+    if s := c.Query("page"); s != "" {
+        v, err := strconv.Atoi(s)
+        if err != nil || v < 1 {
+            writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "page must be a positive integer", nil)
+            return 0, 0, false
+        }
+        page = v
+    }
+    if s := c.Query("pageSize"); s != "" {
+        v, err := strconv.Atoi(s)
+        if err != nil || v < 1 || v > maxPageSize {
+            writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "pageSize must be between 1 and 200", nil)
+            return 0, 0, false
+        }
+        size = v
+    }
+    return page, size, true
+}
+
+type levelOccupancy struct {
+    LevelID string `json:"levelId"`
+    events.Summary
+    Types []typeOccupancy `json:"types,omitempty"`
+}
+
+type typeOccupancy struct {
+    // VehicleType is null for spots that take any vehicle.
+    VehicleType interface{} `json:"vehicleType"`
+    events.Summary
+}
+
+type lotOccupancy struct {
+    LotID   string `json:"lotId"`
+    LotName string `json:"lotName"`
+    events.Summary
+    Levels []*levelOccupancy `json:"levels"`
+}
+
+// Occupancy reports spot counts by status for all lots and broken down by
+// lot and level (?lotId= for one lot, ?byType=true to split levels by
+// vehicle type), with a page of the active sessions (?page=, ?pageSize=).
 func (h *Handler) Occupancy(c *gin.Context) {
-    // summary
-    var total, available, occupied int
-    _ = h.DB.QueryRow(`SELECT COUNT(*), 
-                              COUNT(*) FILTER (WHERE status='AVAILABLE'),
-                              COUNT(*) FILTER (WHERE status='OCCUPIED')
-                       FROM parking_spots`).Scan(&total, &available, &occupied)
-
-    // active list
+    lotID := c.Query("lotId")
+    byType := c.Query("byType") == "true"
+    page, size, ok := pageParams(c)
+    if !ok {
+        return
+    }
+
     rows, err := h.DB.Query(`
-        SELECT b.id, b.user_id, b.vehicle_id, b.spot_id, b.start_time
-        FROM bookings b
-        WHERE b.end_time IS NULL
-        ORDER BY b.start_time DESC
-    `)
+        SELECT s.lot_id, l.name, s.level_id, s.vehicle_type, s.status, COUNT(*)
+        FROM parking_spots s
+        JOIN parking_lots l ON l.id = s.lot_id
+        WHERE ($1 = '' OR s.lot_id::text = $1)
+        GROUP BY s.lot_id, l.name, s.level_id, s.vehicle_type, s.status
+        ORDER BY l.name, s.lot_id, s.level_id, s.vehicle_type NULLS FIRST
+    `, lotID)
     if err != nil {
-        writeError(c, http.StatusInternalServerError, "OCCUPANCY_FETCH_FAILED", "failed to fetch active bookings", err.Error())
+        writeError(c, http.StatusInternalServerError, "OCCUPANCY_FETCH_FAILED", "failed to fetch spot counts", err.Error())
         return
     }
     defer rows.Close()
 
-    active := make([]gin.H, 0, 20)
+    var total events.Summary
+    lots := make([]*lotOccupancy, 0, 4)
     for rows.Next() {
-        var id, uid, vid, sid string
+        var lid, lotName, levelID, status string
+        var vehicleType sql.NullString
+        var n int
+        if err := rows.Scan(&lid, &lotName, &levelID, &vehicleType, &status, &n); err != nil {
+            writeError(c, http.StatusInternalServerError, "SCAN_ERROR", "failed to read row", err.Error())
+            return
+        }
+        // rows arrive grouped by lot, then level, then type
+        if len(lots) == 0 || lots[len(lots)-1].LotID != lid {
+            lots = append(lots, &lotOccupancy{LotID: lid, LotName: lotName, Levels: make([]*levelOccupancy, 0, 4)})
+        }
+        lot := lots[len(lots)-1]
+        if len(lot.Levels) == 0 || lot.Levels[len(lot.Levels)-1].LevelID != levelID {
+            lot.Levels = append(lot.Levels, &levelOccupancy{LevelID: levelID})
+        }
+        level := lot.Levels[len(lot.Levels)-1]
+        if byType {
+            vt := nullString(vehicleType)
+            if len(level.Types) == 0 || level.Types[len(level.Types)-1].VehicleType != vt {
+                level.Types = append(level.Types, typeOccupancy{VehicleType: vt})
+            }
+            level.Types[len(level.Types)-1].Add(status, n)
+        }
+        level.Add(status, n)
+        lot.Add(status, n)
+        total.Add(status, n)
+    }
+    if err := rows.Err(); err != nil {
+        writeError(c, http.StatusInternalServerError, "OCCUPANCY_FETCH_FAILED", "failed to fetch spot counts", err.Error())
+        return
+    }
+
+    var activeTotal int
+    if err := h.DB.QueryRow(`
+        SELECT COUNT(*)
+        FROM bookings b
+        JOIN parking_spots s ON s.id = b.spot_id
+        WHERE b.end_time IS NULL AND ($1 = '' OR s.lot_id::text = $1)
+    `, lotID).Scan(&activeTotal); err != nil {
+        writeError(c, http.StatusInternalServerError, "OCCUPANCY_FETCH_FAILED", "failed to count active bookings", err.Error())
+        return
+    }
+
+    active, err := h.DB.Query(`
+        SELECT b.id, b.user_id, b.vehicle_id, b.plate, b.spot_id, s.lot_id, s.level_id, b.start_time
+        FROM bookings b
+        JOIN parking_spots s ON s.id = b.spot_id
+        WHERE b.end_time IS NULL AND ($1 = '' OR s.lot_id::text = $1)
+        ORDER BY b.start_time DESC, b.id
+        LIMIT $2 OFFSET $3
+    `, lotID, size, (page-1)*size)
+    if err != nil {
+        writeError(c, http.StatusInternalServerError, "OCCUPANCY_FETCH_FAILED", "failed to fetch active bookings", err.Error())
+        return
+    }
+    defer active.Close()
+
+    items := make([]gin.H, 0, size)
+    for active.Next() {
+        var id, sid, lid, levelID string
+        var uid, vid, plate sql.NullString // walk-in tickets have a plate instead of a user and vehicle
         var start time.Time
-        if err := rows.Scan(&id, &uid, &vid, &sid, &start); err != nil {
+        if err := active.Scan(&id, &uid, &vid, &plate, &sid, &lid, &levelID, &start); err != nil {
             writeError(c, http.StatusInternalServerError, "SCAN_ERROR", "failed to read row", err.Error())
             return
         }
-        active = append(active, gin.H{"bookingId": id, "userId": uid, "vehicleId": vid, "spotId": sid, "startTime": start})
+        items = append(items, gin.H{
+            "bookingId": id,
+            "userId":    nullString(uid),
+            "vehicleId": nullString(vid),
+            "plate":     nullString(plate),
+            "spotId":    sid,
+            "lotId":     lid,
+            "levelId":   levelID,
+            "startTime": start,
+        })
+    }
+    if err := active.Err(); err != nil {
+        writeError(c, http.StatusInternalServerError, "OCCUPANCY_FETCH_FAILED", "failed to fetch active bookings", err.Error())
+        return
     }
 
     writeOK(c, gin.H{
         "summary": gin.H{
-            "totalSpots":    total,
-            "available":     available,
-            "occupied":      occupied,
-            "occupancyRate": func() float64 { if total == 0 { return 0 }; return float64(occupied) / float64(total) }(),
+            "totalSpots":    total.Total,
+            "available":     total.Available,
+            "occupied":      total.Occupied,
+            "reserved":      total.Reserved,
+            "held":          total.Held,
+            "disabled":      total.Disabled,
+            "occupancyRate": total.OccupancyRate,
+        },
+        "lots":   lots,
+        "active": items,
+        "pagination": gin.H{
+            "page":       page,
+            "pageSize":   size,
+            "total":      activeTotal,
+            "totalPages": (activeTotal + size - 1) / size,
         },
-        "active": active,
     })
 }
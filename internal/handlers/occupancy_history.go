@@ -0,0 +1,247 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SnapshotOccupancy records each level's spot counts. Snapshot times are
+// aligned to the interval, so replicas taking the same snapshot write it
+// once. It runs as a background job.
+func (h *Handler) SnapshotOccupancy(ctx context.Context) error {
+	_, err := h.DB.ExecContext(ctx, `
+		INSERT INTO occupancy_snapshots (taken_at, lot_id, level_id, total, available, occupied, reserved, held, disabled)
+		SELECT to_timestamp(floor(extract(epoch FROM now()) / $1) * $1), lot_id, level_id,
+		       COUNT(*),
+		       COUNT(*) FILTER (WHERE status = 'AVAILABLE'),
+		       COUNT(*) FILTER (WHERE status = 'OCCUPIED'),
+		       COUNT(*) FILTER (WHERE status = 'RESERVED'),
+		       COUNT(*) FILTER (WHERE status = 'HELD'),
+		       COUNT(*) FILTER (WHERE status = 'DISABLED')
+		FROM parking_spots
+		GROUP BY lot_id, level_id
+		ON CONFLICT DO NOTHING
+	`, h.Cfg.OccupancySnapshotSeconds)
+	return err
+}
+
+// occupancyRollup is one granularity of rollups. bucket gives the start of
+// the bucket holding a time expression.
+type occupancyRollup struct {
+	granularity string
+	bucket      func(t string) string
+}
+
+// Days are IST days, like invoices and reports.
+var occupancyRollups = []occupancyRollup{
+	{"HOUR", func(t string) string { return "date_trunc('hour', " + t + ")" }},
+	{"DAY", func(t string) string {
+		return "date_trunc('day', " + t + " AT TIME ZONE 'Asia/Kolkata') AT TIME ZONE 'Asia/Kolkata'"
+	}},
+}
+
+// sql rebuilds the rollups from the raw snapshots taken since $1, per level
+// and for the whole lot (level_id NULL).
+func (r occupancyRollup) sql() string {
+	return fmt.Sprintf(`
+		WITH samples AS (
+			SELECT taken_at, lot_id, level_id, total, available, occupied, reserved, held, disabled
+			FROM occupancy_snapshots
+			WHERE taken_at >= $1
+			UNION ALL
+			SELECT taken_at, lot_id, NULL, SUM(total), SUM(available), SUM(occupied), SUM(reserved), SUM(held), SUM(disabled)
+			FROM occupancy_snapshots
+			WHERE taken_at >= $1
+			GROUP BY taken_at, lot_id
+		)
+		INSERT INTO occupancy_rollups (granularity, bucket_start, lot_id, level_id, samples,
+		                               total, available, occupied, reserved, held, disabled, peak_occupied, peak_rate)
+		SELECT '%[1]s', %[2]s AS bucket, lot_id, level_id, COUNT(*),
+		       AVG(total), AVG(available), AVG(occupied), AVG(reserved), AVG(held), AVG(disabled),
+		       MAX(occupied), COALESCE(MAX(occupied::float8 / NULLIF(total - disabled, 0)), 0)
+		FROM samples
+		GROUP BY bucket, lot_id, level_id
+		ON CONFLICT (granularity, lot_id, level_id, bucket_start) DO UPDATE SET
+			samples = EXCLUDED.samples,
+			total = EXCLUDED.total,
+			available = EXCLUDED.available,
+			occupied = EXCLUDED.occupied,
+			reserved = EXCLUDED.reserved,
+			held = EXCLUDED.held,
+			disabled = EXCLUDED.disabled,
+			peak_occupied = EXCLUDED.peak_occupied,
+			peak_rate = EXCLUDED.peak_rate
+	`, r.granularity, r.bucket("taken_at"))
+}
+
+// rollupOccupancy rebuilds r's buckets from the one holding its high-water
+// mark, or from the oldest snapshot kept when it has none, and moves the
+// mark to the newest snapshot. Its state row is locked throughout, so
+// replicas take turns.
+func (h *Handler) rollupOccupancy(ctx context.Context, r occupancyRollup) error {
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO occupancy_rollup_state (granularity) VALUES ($1) ON CONFLICT DO NOTHING
+	`, r.granularity); err != nil {
+		return err
+	}
+	var since time.Time
+	err = tx.QueryRowContext(ctx, `
+		SELECT `+r.bucket(`GREATEST(COALESCE(rolled_to, '-infinity'), now() - make_interval(days => $2))`)+`
+		FROM occupancy_rollup_state WHERE granularity = $1
+		FOR UPDATE
+	`, r.granularity, h.Cfg.OccupancySnapshotRetentionDays).Scan(&since)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, r.sql(), since); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE occupancy_rollup_state
+		SET rolled_to = COALESCE((SELECT MAX(taken_at) FROM occupancy_snapshots WHERE taken_at >= $2), rolled_to)
+		WHERE granularity = $1
+	`, r.granularity, since); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RollupOccupancy brings the hourly and daily rollups up to date and drops
+// snapshots and hourly rollups past retention. It runs as a background job.
+func (h *Handler) RollupOccupancy(ctx context.Context) error {
+	for _, r := range occupancyRollups {
+		if err := h.rollupOccupancy(ctx, r); err != nil {
+			return fmt.Errorf("%s rollup: %w", strings.ToLower(r.granularity), err)
+		}
+	}
+	if _, err := h.DB.ExecContext(ctx, `
+		DELETE FROM occupancy_snapshots WHERE taken_at < now() - make_interval(days => $1)
+	`, h.Cfg.OccupancySnapshotRetentionDays); err != nil {
+		return fmt.Errorf("prune snapshots: %w", err)
+	}
+	if _, err := h.DB.ExecContext(ctx, `
+		DELETE FROM occupancy_rollups WHERE granularity = 'HOUR' AND bucket_start < now() - make_interval(days => $1)
+	`, h.Cfg.OccupancyHourlyRetentionDays); err != nil {
+		return fmt.Errorf("prune hourly rollups: %w", err)
+	}
+	return nil
+}
+
+// maxHistoryPoints bounds one history response.
+const maxHistoryPoints = 5000
+
+// OccupancyHistory returns a lot's occupancy over time (?lotId=, optional
+// ?levelId=) for points starting between ?from= and ?to=, at ?granularity=
+// raw, hour (default) or day. Raw points are exact; hour and day points
+// average the snapshots in each bucket and report the busiest one as peak.
+func (h *Handler) OccupancyHistory(c *gin.Context) {
+	lotID := c.Query("lotId")
+	if lotID == "" {
+		writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "lotId is required", nil)
+		return
+	}
+	levelID := c.Query("levelId")
+	from, to, ok := timeRange(c)
+	if !ok {
+		return
+	}
+
+	var step time.Duration
+	granularity := c.DefaultQuery("granularity", "hour")
+	switch granularity {
+	case "raw":
+		step = time.Duration(h.Cfg.OccupancySnapshotSeconds) * time.Second
+	case "hour":
+		step = time.Hour
+	case "day":
+		step = 24 * time.Hour
+	default:
+		writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "granularity must be raw, hour or day", nil)
+		return
+	}
+	if to.Sub(from)/step > maxHistoryPoints {
+		writeError(c, http.StatusBadRequest, "RANGE_TOO_LARGE", "too many points; narrow the range or use a coarser granularity", nil)
+		return
+	}
+
+	query := `
+		SELECT bucket_start, samples, total, available, occupied, reserved, held, disabled, peak_occupied, peak_rate
+		FROM occupancy_rollups
+		WHERE granularity = $5 AND lot_id = $1
+		  AND (($2 = '' AND level_id IS NULL) OR level_id::text = $2)
+		  AND bucket_start >= $3 AND bucket_start < $4
+		ORDER BY bucket_start
+	`
+	args := []interface{}{lotID, levelID, from, to}
+	if granularity == "raw" {
+		query = `
+			SELECT taken_at, 1,
+			       SUM(total)::float8, SUM(available)::float8, SUM(occupied)::float8,
+			       SUM(reserved)::float8, SUM(held)::float8, SUM(disabled)::float8,
+			       SUM(occupied),
+			       COALESCE(SUM(occupied)::float8 / NULLIF(SUM(total) - SUM(disabled), 0), 0)
+			FROM occupancy_snapshots
+			WHERE lot_id = $1 AND ($2 = '' OR level_id::text = $2)
+			  AND taken_at >= $3 AND taken_at < $4
+			GROUP BY taken_at
+			ORDER BY taken_at
+		`
+	} else {
+		args = append(args, map[string]string{"hour": "HOUR", "day": "DAY"}[granularity])
+	}
+
+	rows, err := h.DB.Query(query, args...)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "HISTORY_FETCH_FAILED", "failed to fetch occupancy history", err.Error())
+		return
+	}
+	defer rows.Close()
+
+	points := make([]gin.H, 0, 64)
+	for rows.Next() {
+		var at time.Time
+		var samples, peakOccupied int
+		var total, available, occupied, reserved, held, disabled, peakRate float64
+		if err := rows.Scan(&at, &samples, &total, &available, &occupied, &reserved, &held, &disabled,
+			&peakOccupied, &peakRate); err != nil {
+			writeError(c, http.StatusInternalServerError, "SCAN_ERROR", "failed to read row", err.Error())
+			return
+		}
+		var rate float64
+		if inService := total - disabled; inService > 0 {
+			rate = occupied / inService
+		}
+		points = append(points, gin.H{
+			"at":            toIST(at),
+			"samples":       samples,
+			"total":         total,
+			"available":     available,
+			"occupied":      occupied,
+			"reserved":      reserved,
+			"held":          held,
+			"disabled":      disabled,
+			"occupancyRate": rate,
+			"peakOccupied":  peakOccupied,
+			"peakRate":      peakRate,
+		})
+	}
+	writeOK(c, gin.H{
+		"lotId":       lotID,
+		"levelId":     nullIfEmpty(levelID),
+		"granularity": granularity,
+		"from":        toIST(from),
+		"to":          toIST(to),
+		"points":      points,
+	})
+}
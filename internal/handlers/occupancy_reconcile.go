@@ -0,0 +1,363 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Physical occupancy comes from bay sensors and gate exits; logical
+// occupancy from bookings. Where they disagree for longer than
+// ReconcileGraceMinutes the reconciler records a discrepancy:
+//
+//	UNBOOKED_VEHICLE     sensor sees a car in a spot with no session
+//	EMPTY_OCCUPIED_SPOT  sensor has read empty since after the session began
+//	EXITED_OPEN_BOOKING  the session's vehicle left through an exit gate
+//
+// A discrepancy is cleared once the two agree again. Lots with an
+// auto-close policy have sessions of the last two kinds ended for them.
+
+// ReconcileOccupancy records and clears discrepancies, then auto-closes
+// stale sessions where the lot's policy allows. It runs as a background job.
+func (h *Handler) ReconcileOccupancy(ctx context.Context) error {
+	if err := h.detectDiscrepancies(ctx); err != nil {
+		return err
+	}
+	for ctx.Err() == nil {
+		done, err := h.autoCloseOne(ctx)
+		if err != nil || done {
+			return err
+		}
+	}
+	return ctx.Err()
+}
+
+func (h *Handler) detectDiscrepancies(ctx context.Context) error {
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// a sensor is only believed while it is reporting
+	_, err = tx.ExecContext(ctx, `
+		WITH trusted AS (
+			SELECT s.id, s.lot_id, s.status, s.sensor_occupied, s.sensor_updated_at
+			FROM parking_spots s
+			WHERE s.sensor_occupied IS NOT NULL
+			  AND (s.sensor_updated_at > now() - make_interval(secs => $1)
+			       OR EXISTS (
+			           SELECT 1 FROM devices d
+			           WHERE d.spot_id = s.id AND d.kind = 'SENSOR'
+			             AND d.last_seen_at > now() - make_interval(secs => $1)))
+		), found AS (
+			SELECT t.lot_id, t.id AS spot_id, 'UNBOOKED_VEHICLE' AS kind, 'SENSOR' AS source,
+			       NULL::uuid AS booking_id, t.sensor_updated_at AS since
+			FROM trusted t
+			WHERE t.sensor_occupied AND t.status <> 'OCCUPIED'
+			  AND t.sensor_updated_at <= now() - make_interval(mins => $2)
+			UNION ALL
+			SELECT t.lot_id, t.id, 'EMPTY_OCCUPIED_SPOT', 'SENSOR', b.id, t.sensor_updated_at
+			FROM trusted t
+			JOIN bookings b ON b.spot_id = t.id AND b.end_time IS NULL
+			WHERE NOT t.sensor_occupied AND b.start_time < t.sensor_updated_at
+			  AND t.sensor_updated_at <= now() - make_interval(mins => $2)
+			UNION ALL
+			SELECT s.lot_id, s.id, 'EXITED_OPEN_BOOKING', 'GATE', b.id, min(e.occurred_at)
+			FROM bookings b
+			JOIN parking_spots s ON s.id = b.spot_id
+			JOIN gate_events e ON e.booking_id = b.id AND e.direction = 'EXIT' AND e.occurred_at > b.start_time
+			WHERE b.end_time IS NULL
+			GROUP BY s.lot_id, s.id, b.id
+			HAVING min(e.occurred_at) <= now() - make_interval(mins => $2)
+		)
+		INSERT INTO occupancy_discrepancies (lot_id, spot_id, kind, source, booking_id, since)
+		SELECT DISTINCT ON (spot_id, kind) lot_id, spot_id, kind, source, booking_id, since
+		FROM found
+		ORDER BY spot_id, kind, since
+		ON CONFLICT (spot_id, kind) WHERE resolved_at IS NULL DO UPDATE
+		SET last_seen_at = now(),
+		    since = EXCLUDED.since,
+		    booking_id = EXCLUDED.booking_id,
+		    auto_close_error = CASE
+		        WHEN occupancy_discrepancies.booking_id IS DISTINCT FROM EXCLUDED.booking_id THEN NULL
+		        ELSE occupancy_discrepancies.auto_close_error END
+	`, h.Cfg.DeviceOfflineSeconds, h.Cfg.ReconcileGraceMinutes)
+	if err != nil {
+		return err
+	}
+
+	// anything not seen in this pass has been put right
+	_, err = tx.ExecContext(ctx, `
+		UPDATE occupancy_discrepancies
+		SET resolved_at = now(), resolution = 'CLEARED'
+		WHERE resolved_at IS NULL AND last_seen_at < now()
+	`)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// autoCloseOne ends one session the lot's policy says is stale, billing it
+// up to when the bay emptied or the vehicle exited.
+func (h *Handler) autoCloseOne(ctx context.Context) (bool, error) {
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var id, bookingID string
+	var since time.Time
+	err = tx.QueryRow(`
+		SELECT d.id, d.booking_id, d.since
+		FROM occupancy_discrepancies d
+		JOIN lot_occupancy_policies p ON p.lot_id = d.lot_id
+		WHERE d.resolved_at IS NULL AND d.auto_close_error IS NULL
+		  AND d.kind IN ('EMPTY_OCCUPIED_SPOT', 'EXITED_OPEN_BOOKING')
+		  AND d.booking_id IS NOT NULL
+		  AND d.since <= now() - make_interval(mins => p.auto_close_empty_minutes)
+		ORDER BY d.since
+		LIMIT 1
+		FOR UPDATE OF d SKIP LOCKED
+	`).Scan(&id, &bookingID, &since)
+	if err == sql.ErrNoRows {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	var spotID string
+	err = tx.QueryRow(`
+		UPDATE bookings
+		SET end_time = GREATEST(start_time, $2)
+		WHERE id = $1 AND end_time IS NULL
+		RETURNING spot_id
+	`, bookingID, since).Scan(&spotID)
+	if err == sql.ErrNoRows {
+		// ended some other way since detection
+		if _, err := tx.Exec(`
+			UPDATE occupancy_discrepancies SET resolved_at = now(), resolution = 'CLEARED' WHERE id = $1
+		`, id); err != nil {
+			return false, err
+		}
+		return false, tx.Commit()
+	} else if err != nil {
+		return false, err
+	}
+
+	if _, berr := h.finishBooking(tx, bookingID, spotID); berr != nil {
+		// leave the session open for an operator and move on
+		tx.Rollback()
+		_, err := h.DB.ExecContext(ctx, `
+			UPDATE occupancy_discrepancies SET auto_close_error = $2 WHERE id = $1
+		`, id, berr.code+": "+berr.message)
+		return false, err
+	}
+	if _, err := tx.Exec(`
+		UPDATE occupancy_discrepancies SET resolved_at = now(), resolution = 'AUTO_CLOSED' WHERE id = $1
+	`, id); err != nil {
+		return false, err
+	}
+	return false, tx.Commit()
+}
+
+// ListDiscrepancies reports physical vs booking occupancy discrepancies
+// (?lotId=, ?kind=). ?status= is open (default), resolved or all; the last
+// two are limited to those detected between ?from= and ?to=.
+func (h *Handler) ListDiscrepancies(c *gin.Context) {
+	status := c.DefaultQuery("status", "open")
+	if status != "open" && status != "resolved" && status != "all" {
+		writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "status must be open, resolved or all", nil)
+		return
+	}
+	from, to := time.Time{}, time.Now()
+	if status != "open" {
+		var ok bool
+		if from, to, ok = timeRange(c); !ok {
+			return
+		}
+	}
+
+	rows, err := h.DB.Query(`
+		SELECT d.id, d.lot_id, d.spot_id, s.number, s.status, d.kind, d.source, d.booking_id,
+		       COALESCE(v.plate, b.plate), d.since, d.detected_at, d.last_seen_at,
+		       d.acknowledged_at, d.note, d.auto_close_error, d.resolved_at, d.resolution
+		FROM occupancy_discrepancies d
+		JOIN parking_spots s ON s.id = d.spot_id
+		LEFT JOIN bookings b ON b.id = d.booking_id
+		LEFT JOIN vehicles v ON v.id = b.vehicle_id
+		WHERE ($1 = '' OR d.lot_id::text = $1)
+		  AND ($2 = '' OR d.kind = $2)
+		  AND ($3 = 'all' OR ($3 = 'open') = (d.resolved_at IS NULL))
+		  AND ($3 = 'open' OR d.detected_at BETWEEN $4 AND $5)
+		ORDER BY d.detected_at DESC
+	`, c.Query("lotId"), c.Query("kind"), status, from, to)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "DISCREPANCIES_FETCH_FAILED", "failed to fetch discrepancies", err.Error())
+		return
+	}
+	defer rows.Close()
+
+	items := make([]gin.H, 0, 10)
+	byKind := map[string]int{"UNBOOKED_VEHICLE": 0, "EMPTY_OCCUPIED_SPOT": 0, "EXITED_OPEN_BOOKING": 0}
+	for rows.Next() {
+		var id, lotID, spotID, spotNumber, spotStatus, kind, source string
+		var bookingID, plate, note, autoCloseErr, resolution sql.NullString
+		var since, detected, lastSeen time.Time
+		var acked, resolved sql.NullTime
+		if err := rows.Scan(&id, &lotID, &spotID, &spotNumber, &spotStatus, &kind, &source, &bookingID,
+			&plate, &since, &detected, &lastSeen, &acked, &note, &autoCloseErr, &resolved, &resolution); err != nil {
+			writeError(c, http.StatusInternalServerError, "SCAN_ERROR", "failed to read row", err.Error())
+			return
+		}
+		item := gin.H{
+			"id":             id,
+			"lotId":          lotID,
+			"spotId":         spotID,
+			"spotNumber":     spotNumber,
+			"spotStatus":     spotStatus,
+			"kind":           kind,
+			"source":         source,
+			"bookingId":      nullString(bookingID),
+			"plate":          nullString(plate),
+			"since":          toIST(since),
+			"detectedAt":     toIST(detected),
+			"lastSeenAt":     toIST(lastSeen),
+			"acknowledged":   acked.Valid,
+			"note":           nullString(note),
+			"autoCloseError": nullString(autoCloseErr),
+			"resolution":     nullString(resolution),
+		}
+		if resolved.Valid {
+			item["resolvedAt"] = toIST(resolved.Time)
+		}
+		byKind[kind]++
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		writeError(c, http.StatusInternalServerError, "DISCREPANCIES_FETCH_FAILED", "failed to fetch discrepancies", err.Error())
+		return
+	}
+	writeOK(c, gin.H{
+		"summary": gin.H{"total": len(items), "byKind": byKind},
+		"items":   items,
+	})
+}
+
+type acknowledgeDiscrepancyReq struct {
+	Note string `json:"note"`
+}
+
+// AcknowledgeDiscrepancy marks an open discrepancy as seen by an operator.
+// It stays open until sensor/gate data and bookings agree again.
+func (h *Handler) AcknowledgeDiscrepancy(c *gin.Context) {
+	var req acknowledgeDiscrepancyReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "invalid request body", err.Error())
+		return
+	}
+	claims := GetClaims(c)
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_BEGIN_FAILED", "could not start transaction", nil)
+		return
+	}
+	defer tx.Rollback()
+
+	before, err := auditSnapshot(tx, "discrepancy", c.Param("id"))
+	if err != nil {
+		writeAuditError(c, err)
+		return
+	}
+	var acked time.Time
+	err = tx.QueryRow(`
+		UPDATE occupancy_discrepancies
+		SET acknowledged_at = now(), acknowledged_by = $2, note = $3
+		WHERE id = $1 AND resolved_at IS NULL
+		RETURNING acknowledged_at
+	`, c.Param("id"), claims.UserID, nullIfEmpty(req.Note)).Scan(&acked)
+	if err == sql.ErrNoRows {
+		var exists bool
+		if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM occupancy_discrepancies WHERE id = $1)`, c.Param("id")).Scan(&exists); err != nil {
+			writeError(c, http.StatusInternalServerError, "DISCREPANCY_FETCH_FAILED", "failed to fetch discrepancy", err.Error())
+			return
+		}
+		if exists {
+			writeError(c, http.StatusConflict, "DISCREPANCY_RESOLVED", "discrepancy is already resolved", nil)
+			return
+		}
+		writeError(c, http.StatusNotFound, "DISCREPANCY_NOT_FOUND", "discrepancy not found", nil)
+		return
+	} else if err != nil {
+		writeError(c, http.StatusInternalServerError, "DISCREPANCY_UPDATE_FAILED", "failed to acknowledge discrepancy", err.Error())
+		return
+	}
+	if err := auditRow(tx, c, "discrepancy.acknowledge", "discrepancy", c.Param("id"), before); err != nil {
+		writeAuditError(c, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_COMMIT_FAILED", "failed to commit acknowledgement", err.Error())
+		return
+	}
+	writeOK(c, gin.H{"data": gin.H{"id": c.Param("id"), "acknowledgedAt": toIST(acked), "note": req.Note}})
+}
+
+type occupancyPolicyReq struct {
+	// nil turns auto-close off
+	AutoCloseEmptyMinutes *int `json:"autoCloseEmptyMinutes" binding:"omitempty,min=1"`
+}
+
+// SetOccupancyPolicy sets whether and after how long a lot's sessions are
+// auto-closed once their bay reads empty or the vehicle has exited.
+func (h *Handler) SetOccupancyPolicy(c *gin.Context) {
+	lotID := c.Param("id")
+	var req occupancyPolicyReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "invalid request body", err.Error())
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_BEGIN_FAILED", "could not start transaction", nil)
+		return
+	}
+	defer tx.Rollback()
+
+	before, err := auditSnapshot(tx, "occupancy_policy", lotID)
+	if err != nil {
+		writeAuditError(c, err)
+		return
+	}
+	res, err := tx.Exec(`
+		INSERT INTO lot_occupancy_policies (lot_id, auto_close_empty_minutes)
+		SELECT id, $2 FROM parking_lots WHERE id = $1
+		ON CONFLICT (lot_id) DO UPDATE SET
+			auto_close_empty_minutes = EXCLUDED.auto_close_empty_minutes,
+			updated_at = now()
+	`, lotID, req.AutoCloseEmptyMinutes)
+	if err != nil {
+		writeError(c, http.StatusBadRequest, "SET_POLICY_FAILED", "could not save occupancy policy", err.Error())
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		writeError(c, http.StatusNotFound, "LOT_NOT_FOUND", "lot not found", nil)
+		return
+	}
+	if err := auditRow(tx, c, "occupancy_policy.set", "occupancy_policy", lotID, before); err != nil {
+		writeAuditError(c, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_COMMIT_FAILED", "failed to commit occupancy policy", err.Error())
+		return
+	}
+	writeOK(c, gin.H{"data": gin.H{"lotId": lotID, "autoCloseEmptyMinutes": req.AutoCloseEmptyMinutes}})
+}
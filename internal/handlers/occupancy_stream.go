@@ -0,0 +1,295 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"Backend-Go/internal/events"
+	"Backend-Go/internal/outbox"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// setSpotStatus changes a spot's status inside tx, records a
+// spot.status_changed event and announces it to live occupancy streams on
+// every replica once tx commits.
+func setSpotStatus(tx *sql.Tx, spotID, status, bookingID string) error {
+	var lotID string
+	err := tx.QueryRow(`
+		UPDATE parking_spots SET status = $2 WHERE id = $1 RETURNING lot_id
+	`, spotID, status).Scan(&lotID)
+	if err != nil {
+		return err
+	}
+	if err := spotChanged(tx, lotID, spotID, status, bookingID); err != nil {
+		return err
+	}
+	return events.Notify(tx, events.Event{
+		Type:      events.SpotStatus,
+		LotID:     lotID,
+		SpotID:    spotID,
+		Status:    status,
+		BookingID: bookingID,
+		At:        time.Now(),
+	})
+}
+
+// spotChanged records a spot.status_changed event inside tx.
+func spotChanged(tx *sql.Tx, lotID, spotID, status, bookingID string) error {
+	return outbox.Write(tx, outbox.SpotStatusChanged, "spot", spotID, outbox.SpotData{
+		SpotID: spotID, LotID: lotID, Status: status, BookingID: bookingID,
+	})
+}
+
+// announceSpot tells live streams about a spot added or removed outside a
+// booking transaction. The change is already saved, so failure is only
+// logged.
+func (h *Handler) announceSpot(lotID, spotID, status string) {
+	err := events.Notify(h.DB, events.Event{
+		Type:   events.SpotStatus,
+		LotID:  lotID,
+		SpotID: spotID,
+		Status: status,
+		At:     time.Now(),
+	})
+	if err != nil {
+		log.Printf("events: announce spot %s: %v", spotID, err)
+	}
+}
+
+// lotSummaries counts spots by status for lotID, or for every lot when
+// lotID is empty.
+func lotSummaries(db *sql.DB, lotID string) ([]events.Event, error) {
+	rows, err := db.Query(`
+		SELECT lot_id, status, COUNT(*)
+		FROM parking_spots
+		WHERE ($1 = '' OR lot_id::text = $1)
+		GROUP BY lot_id, status
+		ORDER BY lot_id
+	`, lotID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	out := make([]events.Event, 0, 4)
+	for rows.Next() {
+		var id, status string
+		var n int
+		if err := rows.Scan(&id, &status, &n); err != nil {
+			return nil, err
+		}
+		if len(out) == 0 || out[len(out)-1].LotID != id {
+			out = append(out, events.Event{Type: events.LotSummary, LotID: id, Summary: &events.Summary{}, At: now})
+		}
+		out[len(out)-1].Summary.Add(status, n)
+	}
+	return out, rows.Err()
+}
+
+// PublishLotSummaries sends this replica's stream subscribers a summary of
+// every lot. It runs as a background job and does nothing while nobody is
+// subscribed.
+func (h *Handler) PublishLotSummaries(ctx context.Context) error {
+	if h.Events.Subscribers() == 0 {
+		return nil
+	}
+	summaries, err := lotSummaries(h.DB, "")
+	if err != nil {
+		return err
+	}
+	for _, e := range summaries {
+		h.Events.Publish(e)
+	}
+	return nil
+}
+
+// streamPing keeps idle streams open through proxies.
+const streamPing = 15 * time.Second
+
+// streamTicketTTL is how long a stream ticket can wait before it is used.
+const streamTicketTTL = 30 * time.Second
+
+// CreateStreamTicket issues a one-time ticket for opening an occupancy
+// stream as the caller, so the JWT never goes in a URL. Only a hash of the
+// ticket is kept.
+func (h *Handler) CreateStreamTicket(c *gin.Context) {
+	raw := make([]byte, 24)
+	if _, err := rand.Read(raw); err != nil {
+		writeError(c, http.StatusInternalServerError, "TICKET_GENERATION_FAILED", "failed to generate stream ticket", err.Error())
+		return
+	}
+	ticket := hex.EncodeToString(raw)
+	expiresAt := time.Now().Add(streamTicketTTL)
+
+	// unused tickets are swept as new ones are issued
+	if _, err := h.DB.Exec(`DELETE FROM stream_tickets WHERE expires_at < now()`); err != nil {
+		writeError(c, http.StatusInternalServerError, "TICKET_CREATE_FAILED", "failed to create stream ticket", err.Error())
+		return
+	}
+	_, err := h.DB.Exec(`
+		INSERT INTO stream_tickets (token_hash, user_id, email, role, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`, hashToken(ticket), c.GetString("user_id"), c.GetString("email"), c.GetString("role"), expiresAt)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "TICKET_CREATE_FAILED", "failed to create stream ticket", err.Error())
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"data": gin.H{"ticket": ticket, "expiresAt": toIST(expiresAt)}})
+}
+
+// StreamAuth authenticates stream requests by a one-time ?ticket= from
+// CreateStreamTicket, falling back to next (the usual bearer token check)
+// for clients that can set headers.
+func (h *Handler) StreamAuth(next gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ticket := c.Query("ticket")
+		if ticket == "" {
+			next(c)
+			return
+		}
+		var userID, email, role string
+		err := h.DB.QueryRow(`
+			DELETE FROM stream_tickets
+			WHERE token_hash = $1 AND expires_at > now()
+			RETURNING user_id, email, role
+		`, hashToken(ticket)).Scan(&userID, &email, &role)
+		if err == sql.ErrNoRows {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": gin.H{"code": "UNAUTHORIZED", "message": "invalid or expired stream ticket"}})
+			return
+		} else if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": gin.H{"code": "TICKET_CHECK_FAILED", "message": "failed to check stream ticket"}})
+			return
+		}
+		c.Set("user_id", userID)
+		c.Set("email", email)
+		c.Set("role", role)
+		c.Next()
+	}
+}
+
+// upgrader accepts WebSockets from the CORS allow-list, and from non-browser
+// clients, which send no Origin. CORS itself does not cover WebSockets.
+func (h *Handler) upgrader() *websocket.Upgrader {
+	return &websocket.Upgrader{CheckOrigin: func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+		for _, o := range h.Cfg.CORSOrigins {
+			if strings.EqualFold(o, origin) {
+				return true
+			}
+		}
+		return false
+	}}
+}
+
+// OccupancyStream pushes spot status changes and periodic lot summaries,
+// starting with a summary of each lot (?lotId= to follow one lot). Clients
+// get Server-Sent Events, or JSON messages over a WebSocket when they ask to
+// upgrade.
+func (h *Handler) OccupancyStream(c *gin.Context) {
+	lotID := c.Query("lotId")
+	ch, cancel := h.Events.Subscribe(lotID)
+	defer cancel()
+
+	initial, err := lotSummaries(h.DB, lotID)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "OCCUPANCY_FETCH_FAILED", "failed to fetch occupancy", err.Error())
+		return
+	}
+
+	if websocket.IsWebSocketUpgrade(c.Request) {
+		streamWebSocket(c, h.upgrader(), initial, ch)
+		return
+	}
+	streamSSE(c, initial, ch)
+}
+
+func streamSSE(c *gin.Context, initial []events.Event, ch <-chan events.Event) {
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	for _, e := range initial {
+		c.SSEvent(e.Type, e)
+	}
+	c.Writer.Flush()
+
+	ping := time.NewTicker(streamPing)
+	defer ping.Stop()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case e, ok := <-ch:
+			if !ok {
+				return false
+			}
+			c.SSEvent(e.Type, e)
+			return true
+		case <-ping.C:
+			_, err := io.WriteString(w, ": ping\n\n")
+			return err == nil
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
+
+func streamWebSocket(c *gin.Context, upgrader *websocket.Upgrader, initial []events.Event, ch <-chan events.Event) {
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		return // the upgrader has already replied
+	}
+	defer conn.Close()
+
+	// the client sends nothing; reading notices when it goes away
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	write := func(e events.Event) bool {
+		conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		return conn.WriteJSON(e) == nil
+	}
+	for _, e := range initial {
+		if !write(e) {
+			return
+		}
+	}
+
+	ping := time.NewTicker(streamPing)
+	defer ping.Stop()
+	for {
+		select {
+		case e, ok := <-ch:
+			if !ok {
+				conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseGoingAway, ""), time.Now().Add(time.Second))
+				return
+			}
+			if !write(e) {
+				return
+			}
+		case <-ping.C:
+			if conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second)) != nil {
+				return
+			}
+		case <-gone:
+			return
+		}
+	}
+}
@@ -0,0 +1,602 @@
+package handler
+
+import (
+	"database/sql"
+	"net/http"
+	"strings"
+	"time"
+
+	"Backend-Go/internal/billing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// orgRole returns the caller's role in an organisation, or "" if they are not
+// a member. Platform admins act as organisation admins everywhere.
+func (h *Handler) orgRole(orgID string, claims AuthClaims) (string, error) {
+	if claims.Role == "admin" {
+		return "ADMIN", nil
+	}
+	var role string
+	err := h.DB.QueryRow(`SELECT role FROM org_members WHERE org_id = $1 AND user_id = $2`, orgID, claims.UserID).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return role, err
+}
+
+// requireOrgAdmin writes an error and returns false unless the caller
+// administers the organisation in the :id path parameter.
+func (h *Handler) requireOrgAdmin(c *gin.Context) bool {
+	role, err := h.orgRole(c.Param("id"), GetClaims(c))
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "ORG_CHECK_FAILED", "failed to check organisation membership", err.Error())
+		return false
+	}
+	if role != "ADMIN" {
+		// don't reveal whether other organisations exist
+		writeError(c, http.StatusNotFound, "ORG_NOT_FOUND", "organisation not found", nil)
+		return false
+	}
+	return true
+}
+
+// parseMonth turns "2026-09" into the IST bounds of that month.
+func parseMonth(s string) (time.Time, time.Time, error) {
+	start, err := time.ParseInLocation("2006-01", s, istLoc)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	return start, start.AddDate(0, 1, 0), nil
+}
+
+type createOrgReq struct {
+	Name         string `json:"name" binding:"required"`
+	GSTIN        string `json:"gstin"`
+	BillingEmail string `json:"billingEmail" binding:"omitempty,email"`
+	AdminEmail   string `json:"adminEmail" binding:"required,email"`
+}
+
+// CreateOrg registers an organisation with an existing user as its first
+// admin.
+func (h *Handler) CreateOrg(c *gin.Context) {
+	var req createOrgReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "invalid request body", err.Error())
+		return
+	}
+	var gstin interface{}
+	if req.GSTIN != "" {
+		req.GSTIN = strings.ToUpper(strings.TrimSpace(req.GSTIN))
+		if !billing.ValidGSTIN(req.GSTIN) {
+			writeError(c, http.StatusBadRequest, "INVALID_GSTIN", "gstin is not a valid GSTIN", nil)
+			return
+		}
+		gstin = req.GSTIN
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_BEGIN_FAILED", "could not start transaction", nil)
+		return
+	}
+	defer tx.Rollback()
+
+	var adminID string
+	err = tx.QueryRow(`SELECT id FROM users WHERE lower(email) = lower($1)`, req.AdminEmail).Scan(&adminID)
+	if err == sql.ErrNoRows {
+		writeError(c, http.StatusBadRequest, "USER_NOT_FOUND", "no user with adminEmail exists", nil)
+		return
+	} else if err != nil {
+		writeError(c, http.StatusInternalServerError, "USER_FETCH_FAILED", "failed to fetch user", err.Error())
+		return
+	}
+
+	var id string
+	err = tx.QueryRow(`
+		INSERT INTO organizations (name, gstin, billing_email) VALUES ($1, $2, $3) RETURNING id
+	`, req.Name, gstin, req.BillingEmail).Scan(&id)
+	if err != nil {
+		writeError(c, http.StatusBadRequest, "CREATE_ORG_FAILED", "could not create organisation", err.Error())
+		return
+	}
+	if _, err := tx.Exec(`INSERT INTO org_members (org_id, user_id, role) VALUES ($1, $2, 'ADMIN')`, id, adminID); err != nil {
+		writeError(c, http.StatusInternalServerError, "CREATE_ORG_FAILED", "could not add organisation admin", err.Error())
+		return
+	}
+	if err := auditRow(tx, c, "org.create", "org", id, nil); err != nil {
+		writeAuditError(c, err)
+		return
+	}
+	if err := auditRow(tx, c, "org_member.add", "org_member", id+":"+adminID, nil); err != nil {
+		writeAuditError(c, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_COMMIT_FAILED", "failed to commit organisation", err.Error())
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"data": gin.H{
+		"id":           id,
+		"name":         req.Name,
+		"gstin":        gstin,
+		"billingEmail": req.BillingEmail,
+		"adminUserId":  adminID,
+	}})
+}
+
+// MyOrgs lists the organisations the caller belongs to.
+func (h *Handler) MyOrgs(c *gin.Context) {
+	claims := GetClaims(c)
+	rows, err := h.DB.Query(`
+		SELECT o.id, o.name, m.role, m.monthly_limit_paise
+		FROM org_members m
+		JOIN organizations o ON o.id = m.org_id
+		WHERE m.user_id = $1
+		ORDER BY o.name
+	`, claims.UserID)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "ORGS_FETCH_FAILED", "failed to fetch organisations", err.Error())
+		return
+	}
+	defer rows.Close()
+
+	items := make([]gin.H, 0, 4)
+	for rows.Next() {
+		var id, name, role string
+		var limit sql.NullInt64
+		if err := rows.Scan(&id, &name, &role, &limit); err != nil {
+			writeError(c, http.StatusInternalServerError, "SCAN_ERROR", "failed to read row", err.Error())
+			return
+		}
+		items = append(items, gin.H{"id": id, "name": name, "role": role, "monthlyLimitPaise": nullInt(limit)})
+	}
+	writeOK(c, gin.H{"items": items})
+}
+
+type orgMemberReq struct {
+	Email             string `json:"email" binding:"required,email"`
+	Role              string `json:"role" binding:"omitempty,oneof=ADMIN MEMBER"`
+	MonthlyLimitPaise *int64 `json:"monthlyLimitPaise" binding:"omitempty,min=0"`
+}
+
+// AddOrgMember adds a user to the organisation, or updates their role and
+// spending limit if they are already a member.
+func (h *Handler) AddOrgMember(c *gin.Context) {
+	if !h.requireOrgAdmin(c) {
+		return
+	}
+	orgID := c.Param("id")
+	var req orgMemberReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "invalid request body", err.Error())
+		return
+	}
+	if req.Role == "" {
+		req.Role = "MEMBER"
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_BEGIN_FAILED", "could not start transaction", nil)
+		return
+	}
+	defer tx.Rollback()
+
+	var userID string
+	err = tx.QueryRow(`SELECT id FROM users WHERE lower(email) = lower($1)`, req.Email).Scan(&userID)
+	if err == sql.ErrNoRows {
+		writeError(c, http.StatusBadRequest, "USER_NOT_FOUND", "no user with this email exists", nil)
+		return
+	} else if err != nil {
+		writeError(c, http.StatusInternalServerError, "USER_FETCH_FAILED", "failed to fetch user", err.Error())
+		return
+	}
+
+	memberKey := orgID + ":" + userID
+	before, err := auditSnapshot(tx, "org_member", memberKey)
+	if err != nil {
+		writeAuditError(c, err)
+		return
+	}
+	_, err = tx.Exec(`
+		INSERT INTO org_members (org_id, user_id, role, monthly_limit_paise)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (org_id, user_id) DO UPDATE SET role = EXCLUDED.role, monthly_limit_paise = EXCLUDED.monthly_limit_paise
+	`, orgID, userID, req.Role, req.MonthlyLimitPaise)
+	if err != nil {
+		writeError(c, http.StatusBadRequest, "ADD_MEMBER_FAILED", "could not add member", err.Error())
+		return
+	}
+	if err := auditRow(tx, c, "org_member.add", "org_member", memberKey, before); err != nil {
+		writeAuditError(c, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_COMMIT_FAILED", "failed to commit member", err.Error())
+		return
+	}
+	writeOK(c, gin.H{"data": gin.H{
+		"orgId":             orgID,
+		"userId":            userID,
+		"email":             req.Email,
+		"role":              req.Role,
+		"monthlyLimitPaise": req.MonthlyLimitPaise,
+	}})
+}
+
+// RemoveOrgMember takes a user out of the organisation.
+func (h *Handler) RemoveOrgMember(c *gin.Context) {
+	if !h.requireOrgAdmin(c) {
+		return
+	}
+	orgID, userID := c.Param("id"), c.Param("userId")
+	memberKey := orgID + ":" + userID
+	tx, err := h.DB.Begin()
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_BEGIN_FAILED", "could not start transaction", nil)
+		return
+	}
+	defer tx.Rollback()
+
+	before, err := auditSnapshot(tx, "org_member", memberKey)
+	if err != nil {
+		writeAuditError(c, err)
+		return
+	}
+	res, err := tx.Exec(`DELETE FROM org_members WHERE org_id = $1 AND user_id = $2`, orgID, userID)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "REMOVE_MEMBER_FAILED", "failed to remove member", err.Error())
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		writeError(c, http.StatusNotFound, "MEMBER_NOT_FOUND", "member not found", nil)
+		return
+	}
+	if err := audit(tx, c, "org_member.remove", "org_member", memberKey, before, nil); err != nil {
+		writeAuditError(c, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_COMMIT_FAILED", "failed to commit member removal", err.Error())
+		return
+	}
+	writeOK(c, gin.H{"data": gin.H{"orgId": orgID, "userId": userID, "removed": true}})
+}
+
+// AddFleetVehicle registers a vehicle any member of the organisation can
+// book with.
+func (h *Handler) AddFleetVehicle(c *gin.Context) {
+	if !h.requireOrgAdmin(c) {
+		return
+	}
+	orgID := c.Param("id")
+	var req addVehicleReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "invalid request body", err.Error())
+		return
+	}
+	claims := GetClaims(c)
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_BEGIN_FAILED", "could not start transaction", nil)
+		return
+	}
+	defer tx.Rollback()
+
+	var id string
+	err = tx.QueryRow(`
+		INSERT INTO vehicles (user_id, plate, type, org_id)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id
+	`, claims.UserID, req.Plate, req.Type, orgID).Scan(&id)
+	if err != nil {
+		writeError(c, http.StatusBadRequest, "ADD_VEHICLE_FAILED", "could not add vehicle (maybe duplicate plate)", err.Error())
+		return
+	}
+	if err := auditRow(tx, c, "vehicle.create", "vehicle", id, nil); err != nil {
+		writeAuditError(c, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_COMMIT_FAILED", "failed to commit vehicle", err.Error())
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"data": gin.H{"id": id, "orgId": orgID, "plate": req.Plate, "type": req.Type}})
+}
+
+// OrgBookings lists the organisation's fleet bookings, optionally for one
+// ?month=YYYY-MM (IST).
+func (h *Handler) OrgBookings(c *gin.Context) {
+	if !h.requireOrgAdmin(c) {
+		return
+	}
+	orgID := c.Param("id")
+	from, to := time.Time{}, time.Now().AddDate(100, 0, 0)
+	if m := c.Query("month"); m != "" {
+		var err error
+		if from, to, err = parseMonth(m); err != nil {
+			writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "month must be YYYY-MM", nil)
+			return
+		}
+	}
+
+	rows, err := h.DB.Query(`
+		SELECT b.id, b.user_id, u.email, b.vehicle_id, v.plate, b.spot_id, b.start_time, b.end_time, b.taxable_paise, b.org_invoice_id
+		FROM bookings b
+		JOIN users u ON u.id = b.user_id
+		JOIN vehicles v ON v.id = b.vehicle_id
+		WHERE b.org_id = $1 AND b.start_time >= $2 AND b.start_time < $3
+		ORDER BY b.start_time DESC
+		LIMIT 1000
+	`, orgID, from, to)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "ORG_BOOKINGS_FETCH_FAILED", "failed to fetch bookings", err.Error())
+		return
+	}
+	defer rows.Close()
+
+	items := make([]gin.H, 0, 20)
+	for rows.Next() {
+		var id, userID, email, vehicleID, plate, spotID string
+		var start time.Time
+		var end sql.NullTime
+		var taxable sql.NullInt64
+		var invoiceID sql.NullString
+		if err := rows.Scan(&id, &userID, &email, &vehicleID, &plate, &spotID, &start, &end, &taxable, &invoiceID); err != nil {
+			writeError(c, http.StatusInternalServerError, "SCAN_ERROR", "failed to read row", err.Error())
+			return
+		}
+		var endVal interface{}
+		if end.Valid {
+			endVal = toIST(end.Time)
+		}
+		items = append(items, gin.H{
+			"bookingId":    id,
+			"userId":       userID,
+			"userEmail":    email,
+			"vehicleId":    vehicleID,
+			"plate":        plate,
+			"spotId":       spotID,
+			"startTime":    toIST(start),
+			"endTime":      endVal,
+			"taxablePaise": nullInt(taxable),
+			"orgInvoiceId": nullString(invoiceID),
+		})
+	}
+	writeOK(c, gin.H{"items": items})
+}
+
+type orgInvoiceReq struct {
+	Month string `json:"month" binding:"required"`
+}
+
+// GenerateOrgInvoices issues the organisation's consolidated invoices for a
+// month: one per lot, covering every fleet session that ended in the month
+// and is not yet invoiced.
+func (h *Handler) GenerateOrgInvoices(c *gin.Context) {
+	orgID := c.Param("id")
+	var req orgInvoiceReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "invalid request body", err.Error())
+		return
+	}
+	from, to, err := parseMonth(req.Month)
+	if err != nil {
+		writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "month must be YYYY-MM", nil)
+		return
+	}
+	if to.After(time.Now()) {
+		writeError(c, http.StatusBadRequest, "MONTH_NOT_CLOSED", "a month can only be invoiced after it ends", nil)
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_BEGIN_FAILED", "could not start transaction", nil)
+		return
+	}
+	defer tx.Rollback()
+
+	var orgGSTIN sql.NullString
+	err = tx.QueryRow(`SELECT gstin FROM organizations WHERE id = $1 FOR UPDATE`, orgID).Scan(&orgGSTIN)
+	if err == sql.ErrNoRows {
+		writeError(c, http.StatusNotFound, "ORG_NOT_FOUND", "organisation not found", nil)
+		return
+	} else if err != nil {
+		writeError(c, http.StatusInternalServerError, "ORG_FETCH_FAILED", "failed to fetch organisation", err.Error())
+		return
+	}
+
+	rows, err := tx.Query(`
+		SELECT s.lot_id, COUNT(*), SUM(b.taxable_paise)
+		FROM bookings b
+		JOIN parking_spots s ON s.id = b.spot_id
+		WHERE b.org_id = $1 AND b.org_invoice_id IS NULL AND b.taxable_paise > 0
+		  AND b.end_time >= $2 AND b.end_time < $3
+		GROUP BY s.lot_id
+	`, orgID, from, to)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "ORG_INVOICE_FAILED", "failed to total sessions", err.Error())
+		return
+	}
+	type lotTotal struct {
+		lotID    string
+		sessions int
+		taxable  int64
+	}
+	var totals []lotTotal
+	for rows.Next() {
+		var t lotTotal
+		if err := rows.Scan(&t.lotID, &t.sessions, &t.taxable); err != nil {
+			rows.Close()
+			writeError(c, http.StatusInternalServerError, "SCAN_ERROR", "failed to read row", err.Error())
+			return
+		}
+		totals = append(totals, t)
+	}
+	rows.Close()
+
+	issued := make([]gin.H, 0, len(totals))
+	for _, t := range totals {
+		var legalName, gstin, address, stateCode, lotState, sac, prefix string
+		var rateBP int
+		err := tx.QueryRow(`
+			SELECT legal_name, gstin, address, state_code, lot_state_code, sac_code, gst_rate_bp, invoice_prefix
+			FROM lot_billing_profiles WHERE lot_id = $1
+		`, t.lotID).Scan(&legalName, &gstin, &address, &stateCode, &lotState, &sac, &rateBP, &prefix)
+		if err != nil {
+			writeError(c, http.StatusInternalServerError, "ORG_INVOICE_FAILED", "failed to fetch lot billing profile", err.Error())
+			return
+		}
+
+		// supplied where the lot is, whatever state the organisation is in
+		pos := lotState
+		tax := billing.ComputeGST(t.taxable, rateBP, billing.IntraStateSupply(gstin, lotState))
+		fy := billing.FinancialYear(to.Add(-time.Second))
+		invoiceNo, err := nextInvoiceNumber(tx, t.lotID, prefix, fy)
+		if err != nil {
+			writeError(c, http.StatusInternalServerError, "ORG_INVOICE_FAILED", "failed to number invoice", err.Error())
+			return
+		}
+
+		var id string
+		err = tx.QueryRow(`
+			INSERT INTO org_invoices (org_id, lot_id, period, invoice_no, financial_year,
+			                          supplier_name, supplier_gstin, supplier_address, supplier_state,
+			                          customer_gstin, place_of_supply, sac_code, sessions, gst_rate_bp,
+			                          taxable_paise, cgst_paise, sgst_paise, igst_paise, total_paise)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19)
+			RETURNING id
+		`, orgID, t.lotID, from.Format("2006-01-02"), invoiceNo, fy,
+			legalName, gstin, address, stateCode,
+			orgGSTIN, pos, sac, t.sessions, rateBP,
+			tax.Taxable, tax.CGST, tax.SGST, tax.IGST, tax.Total).Scan(&id)
+		if err != nil {
+			writeError(c, http.StatusConflict, "ORG_INVOICE_FAILED", "could not issue invoice (already issued for this month?)", err.Error())
+			return
+		}
+
+		_, err = tx.Exec(`
+			UPDATE bookings b SET org_invoice_id = $1
+			FROM parking_spots s
+			WHERE s.id = b.spot_id AND s.lot_id = $2
+			  AND b.org_id = $3 AND b.org_invoice_id IS NULL AND b.taxable_paise > 0
+			  AND b.end_time >= $4 AND b.end_time < $5
+		`, id, t.lotID, orgID, from, to)
+		if err != nil {
+			writeError(c, http.StatusInternalServerError, "ORG_INVOICE_FAILED", "failed to link sessions to invoice", err.Error())
+			return
+		}
+		if err := auditRow(tx, c, "org_invoice.issue", "org_invoice", id, nil); err != nil {
+			writeAuditError(c, err)
+			return
+		}
+
+		issued = append(issued, gin.H{
+			"id":         id,
+			"lotId":      t.lotID,
+			"invoiceNo":  invoiceNo,
+			"sessions":   t.sessions,
+			"totalPaise": tax.Total,
+		})
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_COMMIT_FAILED", "failed to commit invoices", err.Error())
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"items": issued})
+}
+
+// OrgInvoices lists the organisation's consolidated invoices.
+func (h *Handler) OrgInvoices(c *gin.Context) {
+	if !h.requireOrgAdmin(c) {
+		return
+	}
+	rows, err := h.DB.Query(`
+		SELECT id, lot_id, period, invoice_no, issued_at, sessions, taxable_paise, total_paise
+		FROM org_invoices
+		WHERE org_id = $1
+		ORDER BY period DESC, invoice_no
+	`, c.Param("id"))
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "ORG_INVOICES_FETCH_FAILED", "failed to fetch invoices", err.Error())
+		return
+	}
+	defer rows.Close()
+
+	items := make([]gin.H, 0, 12)
+	for rows.Next() {
+		var id, lotID, invoiceNo string
+		var period, issued time.Time
+		var sessions int
+		var taxable, total int64
+		if err := rows.Scan(&id, &lotID, &period, &invoiceNo, &issued, &sessions, &taxable, &total); err != nil {
+			writeError(c, http.StatusInternalServerError, "SCAN_ERROR", "failed to read row", err.Error())
+			return
+		}
+		items = append(items, gin.H{
+			"id":           id,
+			"lotId":        lotID,
+			"period":       period.Format("2006-01"),
+			"invoiceNo":    invoiceNo,
+			"issuedAt":     toIST(issued),
+			"sessions":     sessions,
+			"taxablePaise": taxable,
+			"totalPaise":   total,
+		})
+	}
+	writeOK(c, gin.H{"items": items})
+}
+
+// OrgInvoice serves one consolidated invoice as JSON or PDF, like Receipt.
+func (h *Handler) OrgInvoice(c *gin.Context) {
+	if !h.requireOrgAdmin(c) {
+		return
+	}
+	var r billing.Receipt
+	var period time.Time
+	var sessions int
+	var sac string
+	var customerGSTIN sql.NullString
+	err := h.DB.QueryRow(`
+		SELECT i.invoice_no, i.issued_at, i.period,
+		       i.supplier_name, i.supplier_gstin, i.supplier_address, i.supplier_state,
+		       o.name, o.billing_email, i.customer_gstin, i.place_of_supply,
+		       i.sac_code, i.sessions, i.gst_rate_bp,
+		       i.taxable_paise, i.cgst_paise, i.sgst_paise, i.igst_paise, i.total_paise
+		FROM org_invoices i
+		JOIN organizations o ON o.id = i.org_id
+		WHERE i.id = $1 AND i.org_id = $2
+	`, c.Param("invoiceId"), c.Param("id")).Scan(&r.InvoiceNo, &r.IssuedAt, &period,
+		&r.Supplier.Name, &r.Supplier.GSTIN, &r.Supplier.Address, &r.Supplier.StateCode,
+		&r.Customer.Name, &r.Customer.Email, &customerGSTIN, &r.PlaceOfSupply,
+		&sac, &sessions, &r.Tax.RateBP,
+		&r.Tax.Taxable, &r.Tax.CGST, &r.Tax.SGST, &r.Tax.IGST, &r.Tax.Total)
+	if err == sql.ErrNoRows {
+		writeError(c, http.StatusNotFound, "INVOICE_NOT_FOUND", "invoice not found", nil)
+		return
+	} else if err != nil {
+		writeError(c, http.StatusInternalServerError, "INVOICE_FETCH_FAILED", "failed to fetch invoice", err.Error())
+		return
+	}
+
+	r.Customer.GSTIN = customerGSTIN.String
+	r.IssuedAt = toIST(r.IssuedAt)
+	r.Period = period.Format("January 2006")
+	r.PlaceOfSupply = billing.PlaceOfSupply(r.PlaceOfSupply)
+	r.Lines = []billing.LineItem{{
+		Description: "Parking charges, fleet sessions",
+		SAC:         sac,
+		Quantity:    int64(sessions),
+		Unit:        "sess",
+		AmountPaise: r.Tax.Taxable,
+	}}
+	r.NetPaise = r.Tax.Total
+	r.AmountInWords = billing.AmountInWords(r.Tax.Total)
+
+	writeReceipt(c, r)
+}
@@ -0,0 +1,163 @@
+package handler
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// outboxStatuses maps ?status= to the events it selects.
+var outboxStatuses = map[string]string{
+	"":           `TRUE`,
+	"PENDING":    `dispatched_at IS NULL AND failed_at IS NULL`,
+	"FAILED":     `failed_at IS NOT NULL`,
+	"DISPATCHED": `dispatched_at IS NOT NULL`,
+}
+
+// ListOutboxEvents pages through domain events, newest first, filtered by
+// ?status= (PENDING, FAILED or DISPATCHED), ?type=, ?aggregateType= and
+// ?aggregateId=.
+func (h *Handler) ListOutboxEvents(c *gin.Context) {
+	page, size, ok := pageParams(c)
+	if !ok {
+		return
+	}
+	cond, ok := outboxStatuses[c.Query("status")]
+	if !ok {
+		writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "status must be PENDING, FAILED or DISPATCHED", nil)
+		return
+	}
+
+	where := `
+		WHERE ` + cond + `
+		  AND ($1 = '' OR type = $1)
+		  AND ($2 = '' OR aggregate_type = $2)
+		  AND ($3 = '' OR aggregate_id = $3)`
+	args := []interface{}{c.Query("type"), c.Query("aggregateType"), c.Query("aggregateId")}
+
+	var total int
+	if err := h.DB.QueryRow(`SELECT COUNT(*) FROM outbox_events`+where, args...).Scan(&total); err != nil {
+		writeError(c, http.StatusInternalServerError, "OUTBOX_FETCH_FAILED", "failed to count events", err.Error())
+		return
+	}
+	rows, err := h.DB.Query(`
+		SELECT id, type, aggregate_type, aggregate_id, data, occurred_at, array_to_string(delivered_to, ','),
+		       attempts, next_attempt_at, last_error, dispatched_at, failed_at
+		FROM outbox_events`+where+`
+		ORDER BY id DESC
+		LIMIT $4 OFFSET $5
+	`, append(args, size, (page-1)*size)...)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "OUTBOX_FETCH_FAILED", "failed to fetch events", err.Error())
+		return
+	}
+	defer rows.Close()
+
+	items := make([]gin.H, 0, size)
+	for rows.Next() {
+		var id int64
+		var typ, aggType, aggID, delivered string
+		var data []byte
+		var occurred, nextAttempt time.Time
+		var attempts int
+		var lastError sql.NullString
+		var dispatched, failed sql.NullTime
+		if err := rows.Scan(&id, &typ, &aggType, &aggID, &data, &occurred, &delivered, &attempts, &nextAttempt,
+			&lastError, &dispatched, &failed); err != nil {
+			writeError(c, http.StatusInternalServerError, "SCAN_ERROR", "failed to read row", err.Error())
+			return
+		}
+		status := "PENDING"
+		if dispatched.Valid {
+			status = "DISPATCHED"
+		} else if failed.Valid {
+			status = "FAILED"
+		}
+		deliveredTo := []string{}
+		if delivered != "" {
+			deliveredTo = strings.Split(delivered, ",")
+		}
+		item := gin.H{
+			"id":            id,
+			"type":          typ,
+			"aggregateType": aggType,
+			"aggregateId":   aggID,
+			"data":          rawJSON(data),
+			"occurredAt":    toIST(occurred),
+			"status":        status,
+			"deliveredTo":   deliveredTo,
+			"attempts":      attempts,
+			"nextAttemptAt": toIST(nextAttempt),
+			"lastError":     nullString(lastError),
+		}
+		if dispatched.Valid {
+			item["dispatchedAt"] = toIST(dispatched.Time)
+		}
+		if failed.Valid {
+			item["failedAt"] = toIST(failed.Time)
+		}
+		items = append(items, item)
+	}
+	writeOK(c, gin.H{
+		"items": items,
+		"pagination": gin.H{
+			"page":       page,
+			"pageSize":   size,
+			"total":      total,
+			"totalPages": (total + size - 1) / size,
+		},
+	})
+}
+
+// RetryOutboxEvent puts a failed event back in the queue with fresh
+// attempts. Sinks that already took it are still skipped.
+func (h *Handler) RetryOutboxEvent(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "id must be a number", nil)
+		return
+	}
+	tx, err := h.DB.Begin()
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_BEGIN_FAILED", "could not start transaction", nil)
+		return
+	}
+	defer tx.Rollback()
+
+	key := strconv.FormatInt(id, 10)
+	before, err := auditSnapshot(tx, "outbox_event", key)
+	if err != nil {
+		writeAuditError(c, err)
+		return
+	}
+	res, err := tx.Exec(`
+		UPDATE outbox_events
+		SET failed_at = NULL, attempts = 0, next_attempt_at = now(), locked_until = NULL
+		WHERE id = $1 AND failed_at IS NOT NULL
+	`, id)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "OUTBOX_UPDATE_FAILED", "failed to retry event", err.Error())
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		if before == nil {
+			writeError(c, http.StatusNotFound, "EVENT_NOT_FOUND", "event not found", nil)
+		} else {
+			writeError(c, http.StatusConflict, "EVENT_NOT_FAILED", "only failed events can be retried", nil)
+		}
+		return
+	}
+	if err := auditRow(tx, c, "outbox_event.retry", "outbox_event", key, before); err != nil {
+		writeAuditError(c, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_COMMIT_FAILED", "failed to commit retry", err.Error())
+		return
+	}
+	writeOK(c, gin.H{"data": gin.H{"id": id, "status": "PENDING"}})
+}
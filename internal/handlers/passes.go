@@ -0,0 +1,489 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// activePass returns the pass, if any, that covers a vehicle parking on
+// spotID in lotID right now, and when it expires. Reserved passes only cover
+// their own spot.
+func activePass(q queryRower, vehicleID, lotID, spotID string) (string, time.Time, error) {
+	var id string
+	var expires time.Time
+	err := q.QueryRow(`
+		SELECT p.id, p.expires_at
+		FROM passes p
+		JOIN pass_vehicles pv ON pv.pass_id = p.id
+		WHERE pv.vehicle_id = $1 AND p.lot_id = $2
+		  AND p.status = 'ACTIVE' AND now() >= p.starts_at AND now() < p.expires_at
+		  AND (p.spot_id IS NULL OR p.spot_id = $3)
+		ORDER BY p.spot_id NULLS LAST
+		LIMIT 1
+	`, vehicleID, lotID, spotID).Scan(&id, &expires)
+	if err == sql.ErrNoRows {
+		return "", time.Time{}, nil
+	}
+	return id, expires, err
+}
+
+// queryRower is satisfied by both *sql.DB and *sql.Tx.
+type queryRower interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// reservingPass returns the active reserved pass holding spotID, if any.
+// Reservations lapse with their pass, so an expired pass no longer holds it.
+func reservingPass(q queryRower, spotID string) (string, error) {
+	var id string
+	err := q.QueryRow(`
+		SELECT id FROM passes
+		WHERE spot_id = $1 AND status = 'ACTIVE' AND now() < expires_at
+		LIMIT 1
+	`, spotID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return id, err
+}
+
+// idleSpotStatus is the status a spot returns to when its session ends.
+func idleSpotStatus(tx *sql.Tx, spotID string) (string, error) {
+	passID, err := reservingPass(tx, spotID)
+	if err != nil {
+		return "", err
+	}
+	if passID != "" {
+		return "RESERVED", nil
+	}
+	return "AVAILABLE", nil
+}
+
+type createPassProductReq struct {
+	LotID        string `json:"lotId" binding:"required"`
+	Name         string `json:"name" binding:"required"`
+	ValidityDays int    `json:"validityDays" binding:"required,min=1,max=366"`
+	VehicleType  string `json:"vehicleType"`
+	SpotMode     string `json:"spotMode" binding:"required,oneof=RESERVED FLOATING"`
+	MaxVehicles  int    `json:"maxVehicles" binding:"omitempty,min=1"`
+	PricePaise   int64  `json:"pricePaise" binding:"min=0"`
+}
+
+func (h *Handler) CreatePassProduct(c *gin.Context) {
+	var req createPassProductReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "invalid request body", err.Error())
+		return
+	}
+	if req.MaxVehicles == 0 {
+		req.MaxVehicles = 1
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_BEGIN_FAILED", "could not start transaction", nil)
+		return
+	}
+	defer tx.Rollback()
+
+	var id string
+	err = tx.QueryRow(`
+		INSERT INTO pass_products (lot_id, name, validity_days, vehicle_type, spot_mode, max_vehicles, price_paise)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id
+	`, req.LotID, req.Name, req.ValidityDays, req.VehicleType, req.SpotMode, req.MaxVehicles, req.PricePaise).Scan(&id)
+	if err != nil {
+		writeError(c, http.StatusBadRequest, "CREATE_PASS_PRODUCT_FAILED", "could not create pass product (check lotId)", err.Error())
+		return
+	}
+	if err := auditRow(tx, c, "pass_product.create", "pass_product", id, nil); err != nil {
+		writeAuditError(c, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_COMMIT_FAILED", "failed to commit pass product", err.Error())
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"data": gin.H{
+		"id":           id,
+		"lotId":        req.LotID,
+		"name":         req.Name,
+		"validityDays": req.ValidityDays,
+		"vehicleType":  req.VehicleType,
+		"spotMode":     req.SpotMode,
+		"maxVehicles":  req.MaxVehicles,
+		"pricePaise":   req.PricePaise,
+		"active":       true,
+	}})
+}
+
+// ListPassProducts lists the passes on sale, optionally for one lot.
+func (h *Handler) ListPassProducts(c *gin.Context) {
+	rows, err := h.DB.Query(`
+		SELECT id, lot_id, name, validity_days, vehicle_type, spot_mode, max_vehicles, price_paise
+		FROM pass_products
+		WHERE active AND ($1 = '' OR lot_id::text = $1)
+		ORDER BY lot_id, price_paise
+	`, c.Query("lotId"))
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "PASS_PRODUCTS_FETCH_FAILED", "failed to fetch pass products", err.Error())
+		return
+	}
+	defer rows.Close()
+
+	items := make([]gin.H, 0, 10)
+	for rows.Next() {
+		var id, lotID, name, vehicleType, spotMode string
+		var validity, maxVehicles int
+		var price int64
+		if err := rows.Scan(&id, &lotID, &name, &validity, &vehicleType, &spotMode, &maxVehicles, &price); err != nil {
+			writeError(c, http.StatusInternalServerError, "SCAN_ERROR", "failed to read row", err.Error())
+			return
+		}
+		items = append(items, gin.H{
+			"id":           id,
+			"lotId":        lotID,
+			"name":         name,
+			"validityDays": validity,
+			"vehicleType":  vehicleType,
+			"spotMode":     spotMode,
+			"maxVehicles":  maxVehicles,
+			"pricePaise":   price,
+		})
+	}
+	writeOK(c, gin.H{"items": items})
+}
+
+type purchasePassReq struct {
+	ProductID  string   `json:"productId" binding:"required"`
+	VehicleIDs []string `json:"vehicleIds" binding:"required,min=1,dive,required"`
+	// SpotID picks the spot for a reserved pass; any available spot in the
+	// lot is assigned when omitted.
+	SpotID string `json:"spotId"`
+}
+
+// PurchasePass sells a pass to the caller for their own vehicles and charges
+// its price.
+func (h *Handler) PurchasePass(c *gin.Context) {
+	var req purchasePassReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "invalid request body", err.Error())
+		return
+	}
+	claims := GetClaims(c)
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_BEGIN_FAILED", "could not start transaction", nil)
+		return
+	}
+	defer tx.Rollback()
+
+	var lotID, vehicleType, spotMode string
+	var validity, maxVehicles int
+	var price int64
+	err = tx.QueryRow(`
+		SELECT lot_id, validity_days, vehicle_type, spot_mode, max_vehicles, price_paise
+		FROM pass_products
+		WHERE id = $1 AND active
+	`, req.ProductID).Scan(&lotID, &validity, &vehicleType, &spotMode, &maxVehicles, &price)
+	if err == sql.ErrNoRows {
+		writeError(c, http.StatusNotFound, "PASS_PRODUCT_NOT_FOUND", "pass product not found", nil)
+		return
+	} else if err != nil {
+		writeError(c, http.StatusInternalServerError, "PASS_PRODUCT_FETCH_FAILED", "failed to fetch pass product", err.Error())
+		return
+	}
+	if len(req.VehicleIDs) > maxVehicles {
+		writeError(c, http.StatusBadRequest, "TOO_MANY_VEHICLES", "pass allows at most "+strconv.Itoa(maxVehicles)+" vehicles", nil)
+		return
+	}
+
+	for _, vid := range req.VehicleIDs {
+		var vtype string
+		err := tx.QueryRow(`SELECT type FROM vehicles WHERE id = $1 AND user_id = $2`, vid, claims.UserID).Scan(&vtype)
+		if err == sql.ErrNoRows {
+			writeError(c, http.StatusForbidden, "VEHICLE_NOT_OWNED", "vehicle does not belong to user", vid)
+			return
+		} else if err != nil {
+			writeError(c, http.StatusInternalServerError, "VEHICLE_CHECK_FAILED", "failed to check vehicle", err.Error())
+			return
+		}
+		if vehicleType != "" && vtype != vehicleType {
+			writeError(c, http.StatusBadRequest, "VEHICLE_TYPE_MISMATCH", "pass is only valid for "+vehicleType+" vehicles", vid)
+			return
+		}
+	}
+
+	var spotID interface{}
+	if spotMode == "RESERVED" {
+		id, err := reserveSpot(tx, lotID, req.SpotID)
+		if err == sql.ErrNoRows {
+			writeError(c, http.StatusConflict, "SPOT_NOT_AVAILABLE", "no available spot to reserve in this lot", nil)
+			return
+		} else if err != nil {
+			writeError(c, http.StatusInternalServerError, "SPOT_RESERVE_FAILED", "failed to reserve spot", err.Error())
+			return
+		}
+		spotID = id
+	}
+
+	var passID string
+	var starts, expires time.Time
+	err = tx.QueryRow(`
+		INSERT INTO passes (product_id, user_id, lot_id, spot_id, expires_at)
+		VALUES ($1, $2, $3, $4, now() + make_interval(days => $5))
+		RETURNING id, starts_at, expires_at
+	`, req.ProductID, claims.UserID, lotID, spotID, validity).Scan(&passID, &starts, &expires)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "PASS_CREATE_FAILED", "failed to create pass", err.Error())
+		return
+	}
+	for _, vid := range req.VehicleIDs {
+		if _, err := tx.Exec(`INSERT INTO pass_vehicles (pass_id, vehicle_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`, passID, vid); err != nil {
+			writeError(c, http.StatusInternalServerError, "PASS_CREATE_FAILED", "failed to register pass vehicles", err.Error())
+			return
+		}
+	}
+
+	payment, err := h.chargePass(tx, passID, price)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "PAYMENT_RECORD_FAILED", "failed to record payment", err.Error())
+		return
+	}
+	if err := auditRow(tx, c, "pass.purchase", "pass", passID, nil); err != nil {
+		writeAuditError(c, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_COMMIT_FAILED", "failed to commit pass purchase", err.Error())
+		return
+	}
+	h.settlePass(c.Request.Context(), payment)
+	c.JSON(http.StatusCreated, gin.H{"data": gin.H{
+		"id":         passID,
+		"productId":  req.ProductID,
+		"lotId":      lotID,
+		"spotId":     spotID,
+		"vehicleIds": req.VehicleIDs,
+		"startsAt":   toIST(starts),
+		"expiresAt":  toIST(expires),
+		"status":     "ACTIVE",
+		"pricePaise": price,
+		"payment":    payment,
+	}})
+}
+
+// reserveSpot marks a spot RESERVED: the requested one if given, otherwise
+// the first available spot in the lot. Spots whose reservation lapsed are
+// treated as available.
+func reserveSpot(tx *sql.Tx, lotID, spotID string) (string, error) {
+	var id string
+	err := tx.QueryRow(`
+		SELECT s.id FROM parking_spots s
+		WHERE s.lot_id = $1 AND ($2 = '' OR s.id::text = $2)
+		  AND (s.status = 'AVAILABLE'
+		       OR (s.status = 'RESERVED' AND NOT EXISTS (
+		           SELECT 1 FROM passes p WHERE p.spot_id = s.id AND p.status = 'ACTIVE' AND now() < p.expires_at)))
+		ORDER BY s.level_id, s.number
+		LIMIT 1
+		FOR UPDATE
+	`, lotID, spotID).Scan(&id)
+	if err != nil {
+		return "", err
+	}
+	return id, setSpotStatus(tx, id, "RESERVED", "")
+}
+
+// chargePass records a PENDING payment of price for a pass inside the
+// caller's transaction, to be captured with settlePass once it commits.
+func (h *Handler) chargePass(tx *sql.Tx, passID string, price int64) (*paymentSummary, error) {
+	if price == 0 {
+		return nil, nil
+	}
+	id, err := h.pendingPayment(tx, "", passID, price)
+	if err != nil {
+		return nil, err
+	}
+	return &paymentSummary{ID: id, Status: paymentPending}, nil
+}
+
+// settlePass captures a pass payment chargePass recorded, after commit.
+func (h *Handler) settlePass(ctx context.Context, p *paymentSummary) {
+	if p != nil {
+		p.Status = h.settlePayment(ctx, p.ID)
+	}
+}
+
+// RenewPass extends one of the caller's passes by another validity period,
+// counted from its expiry or from now if it has already lapsed.
+func (h *Handler) RenewPass(c *gin.Context) {
+	passID := c.Param("id")
+	claims := GetClaims(c)
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_BEGIN_FAILED", "could not start transaction", nil)
+		return
+	}
+	defer tx.Rollback()
+
+	var lotID, spotMode string
+	var spotID sql.NullString
+	var expires time.Time
+	var validity int
+	var price int64
+	var productActive bool
+	err = tx.QueryRow(`
+		SELECT p.lot_id, p.spot_id, p.expires_at, pp.validity_days, pp.price_paise, pp.spot_mode, pp.active
+		FROM passes p
+		JOIN pass_products pp ON pp.id = p.product_id
+		WHERE p.id = $1 AND p.user_id = $2 AND p.status = 'ACTIVE'
+		FOR UPDATE OF p
+	`, passID, claims.UserID).Scan(&lotID, &spotID, &expires, &validity, &price, &spotMode, &productActive)
+	if err == sql.ErrNoRows {
+		writeError(c, http.StatusNotFound, "PASS_NOT_FOUND", "pass not found", nil)
+		return
+	} else if err != nil {
+		writeError(c, http.StatusInternalServerError, "PASS_FETCH_FAILED", "failed to fetch pass", err.Error())
+		return
+	}
+	if !productActive {
+		writeError(c, http.StatusConflict, "PASS_PRODUCT_RETIRED", "this pass can no longer be renewed", nil)
+		return
+	}
+
+	// a lapsed reserved pass gets its spot back only if nobody else took it
+	if spotMode == "RESERVED" && !expires.After(time.Now()) {
+		if _, err := reserveSpot(tx, lotID, spotID.String); err == sql.ErrNoRows {
+			writeError(c, http.StatusConflict, "SPOT_NOT_AVAILABLE", "the reserved spot has been taken; buy a new pass", nil)
+			return
+		} else if err != nil {
+			writeError(c, http.StatusInternalServerError, "SPOT_RESERVE_FAILED", "failed to reserve spot", err.Error())
+			return
+		}
+	}
+
+	before, err := auditSnapshot(tx, "pass", passID)
+	if err != nil {
+		writeAuditError(c, err)
+		return
+	}
+	err = tx.QueryRow(`
+		UPDATE passes
+		SET expires_at = GREATEST(expires_at, now()) + make_interval(days => $2)
+		WHERE id = $1
+		RETURNING expires_at
+	`, passID, validity).Scan(&expires)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "PASS_RENEW_FAILED", "failed to renew pass", err.Error())
+		return
+	}
+
+	payment, err := h.chargePass(tx, passID, price)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "PAYMENT_RECORD_FAILED", "failed to record payment", err.Error())
+		return
+	}
+	if err := auditRow(tx, c, "pass.renew", "pass", passID, before); err != nil {
+		writeAuditError(c, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_COMMIT_FAILED", "failed to commit renewal", err.Error())
+		return
+	}
+	h.settlePass(c.Request.Context(), payment)
+	writeOK(c, gin.H{"data": gin.H{
+		"id":         passID,
+		"expiresAt":  toIST(expires),
+		"pricePaise": price,
+		"payment":    payment,
+	}})
+}
+
+// MyPasses lists the caller's passes, newest first.
+func (h *Handler) MyPasses(c *gin.Context) {
+	claims := GetClaims(c)
+	h.listPasses(c, `
+		WHERE p.user_id = $1
+		ORDER BY p.expires_at DESC
+	`, claims.UserID)
+}
+
+// ExpiringPasses lists active passes that expire within ?withinDays (default
+// 7), soonest first, for renewal reminders.
+func (h *Handler) ExpiringPasses(c *gin.Context) {
+	days, err := strconv.Atoi(c.DefaultQuery("withinDays", "7"))
+	if err != nil || days < 0 {
+		writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "withinDays must be a non-negative integer", nil)
+		return
+	}
+	h.listPasses(c, `
+		WHERE p.status = 'ACTIVE' AND p.expires_at > now() AND p.expires_at <= now() + make_interval(days => $1)
+		ORDER BY p.expires_at
+	`, days)
+}
+
+func (h *Handler) listPasses(c *gin.Context, where string, arg interface{}) {
+	rows, err := h.DB.Query(`
+		SELECT p.id, p.product_id, pp.name, p.user_id, u.name, u.email, p.lot_id, p.spot_id,
+		       p.starts_at, p.expires_at, p.status,
+		       COALESCE(array_to_string(ARRAY(SELECT vehicle_id::text FROM pass_vehicles WHERE pass_id = p.id), ','), '')
+		FROM passes p
+		JOIN pass_products pp ON pp.id = p.product_id
+		JOIN users u ON u.id = p.user_id
+	`+where, arg)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "PASSES_FETCH_FAILED", "failed to fetch passes", err.Error())
+		return
+	}
+	defer rows.Close()
+
+	items := make([]gin.H, 0, 20)
+	for rows.Next() {
+		var id, productID, productName, userID, userName, email, lotID, status, vehicles string
+		var spotID sql.NullString
+		var starts, expires time.Time
+		if err := rows.Scan(&id, &productID, &productName, &userID, &userName, &email, &lotID, &spotID, &starts, &expires, &status, &vehicles); err != nil {
+			writeError(c, http.StatusInternalServerError, "SCAN_ERROR", "failed to read row", err.Error())
+			return
+		}
+		if status == "ACTIVE" && !expires.After(time.Now()) {
+			status = "EXPIRED"
+		}
+		items = append(items, gin.H{
+			"id":          id,
+			"productId":   productID,
+			"productName": productName,
+			"userId":      userID,
+			"userName":    userName,
+			"userEmail":   email,
+			"lotId":       lotID,
+			"spotId":      spotID.String,
+			"vehicleIds":  splitNonEmpty(vehicles),
+			"startsAt":    toIST(starts),
+			"expiresAt":   toIST(expires),
+			"status":      status,
+		})
+	}
+	writeOK(c, gin.H{"items": items})
+}
+
+func splitNonEmpty(s string) []string {
+	out := []string{}
+	for _, part := range strings.Split(s, ",") {
+		if part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
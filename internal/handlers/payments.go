@@ -0,0 +1,427 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"Backend-Go/internal/outbox"
+	"Backend-Go/internal/payments"
+)
+
+var (
+	errNothingToRefund = errors.New("booking has no refundable payment")
+	errRefundTooLarge  = errors.New("refund exceeds the refundable amount")
+)
+
+// refundReasons are the reason codes accepted for refunds, adjustments and
+// waivers.
+var refundReasons = map[string]bool{
+	"GATE_MALFUNCTION": true,
+	"OVERCHARGE":       true,
+	"DUPLICATE_CHARGE": true,
+	"SERVICE_ISSUE":    true,
+	"DISPUTE":          true,
+	"GOODWILL":         true,
+	"OTHER":            true,
+}
+
+const (
+	// paymentBatch is how many pending payments, and as many refunds, are
+	// claimed at a time; paymentLease is how long a claim holds.
+	paymentBatch = 50
+	paymentLease = 2 * time.Minute
+	// paymentMaxAttempts is how many times a capture or refund is tried
+	// before it is marked FAILED for staff to follow up.
+	paymentMaxAttempts = 10
+)
+
+// Payment statuses besides the refund ones.
+const (
+	paymentPending  = "PENDING"
+	paymentCaptured = "CAPTURED"
+	paymentFailed   = "FAILED"
+)
+
+// pendingPayment records a PENDING payment of amount for a booking or a pass
+// inside the caller's transaction. Nothing is charged until settlePayment
+// runs after the transaction commits; if that never happens,
+// SettlePayments does it later with the same idempotency key.
+func (h *Handler) pendingPayment(tx *sql.Tx, bookingID, passID string, amount int64) (string, error) {
+	var id string
+	err := tx.QueryRow(`
+		INSERT INTO payments (booking_id, pass_id, provider, amount_paise, status)
+		VALUES ($1, $2, $3, $4, 'PENDING')
+		RETURNING id
+	`, nullIfEmpty(bookingID), nullIfEmpty(passID), h.Payments.Name(), amount).Scan(&id)
+	return id, err
+}
+
+type claimedPayment struct {
+	ID        string
+	Key       string
+	BookingID sql.NullString
+	PassID    sql.NullString
+	Amount    int64
+	Attempts  int
+}
+
+// claimPayments leases PENDING payments matching cond, which may use $2.
+func (h *Handler) claimPayments(ctx context.Context, cond string, args ...interface{}) ([]claimedPayment, error) {
+	rows, err := h.DB.QueryContext(ctx, `
+		UPDATE payments
+		SET locked_until = now() + $1 * interval '1 second', attempts = attempts + 1
+		WHERE id IN (
+			SELECT id FROM payments
+			WHERE status = 'PENDING' AND (locked_until IS NULL OR locked_until < now()) AND `+cond+`
+			ORDER BY next_attempt_at
+			LIMIT `+fmt.Sprint(paymentBatch)+`
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, idempotency_key, booking_id, pass_id, amount_paise, attempts
+	`, append([]interface{}{paymentLease.Seconds()}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []claimedPayment
+	for rows.Next() {
+		var p claimedPayment
+		if err := rows.Scan(&p.ID, &p.Key, &p.BookingID, &p.PassID, &p.Amount, &p.Attempts); err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+// settlePayment tries to capture a payment the caller has just committed as
+// PENDING and reports its status. A failed capture is logged and left for
+// SettlePayments to retry.
+func (h *Handler) settlePayment(ctx context.Context, paymentID string) string {
+	claimed, err := h.claimPayments(ctx, "id = $2", paymentID)
+	if err != nil || len(claimed) == 0 {
+		if err != nil {
+			log.Printf("payment %s: %v", paymentID, err)
+		}
+		// claimed elsewhere; that attempt settles it
+		return paymentPending
+	}
+	status, err := h.capturePayment(ctx, claimed[0])
+	if err != nil {
+		log.Printf("payment %s: %v", paymentID, err)
+	}
+	return status
+}
+
+// capturePayment makes one attempt at capturing p through the provider and
+// records the outcome, retrying with the outbox backoff until it has used
+// its attempts.
+func (h *Handler) capturePayment(ctx context.Context, p claimedPayment) (string, error) {
+	req := payments.CaptureRequest{
+		IdempotencyKey: p.Key,
+		BookingID:      p.BookingID.String,
+		AmountPaise:    p.Amount,
+		Description:    "Parking charges",
+	}
+	if p.PassID.Valid {
+		req.Description = "Parking pass"
+	}
+	res, capErr := h.Payments.Capture(ctx, req)
+	if capErr != nil {
+		if ctx.Err() != nil {
+			// shutting down; the lease lapses and the attempt is made again
+			return paymentPending, nil
+		}
+		status := paymentPending
+		if p.Attempts >= paymentMaxAttempts {
+			status = paymentFailed
+		}
+		_, err := h.DB.Exec(`
+			UPDATE payments
+			SET status = $2, next_attempt_at = now() + $3 * interval '1 second', locked_until = NULL, last_error = $4
+			WHERE id = $1
+		`, p.ID, status, outbox.Backoff(p.Attempts).Seconds(), capErr.Error())
+		if err != nil {
+			return paymentPending, err
+		}
+		return status, capErr
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		return paymentPending, err
+	}
+	defer tx.Rollback()
+	r, err := tx.Exec(`
+		UPDATE payments SET status = 'CAPTURED', provider_ref = $2, locked_until = NULL, last_error = NULL
+		WHERE id = $1 AND status = 'PENDING'
+	`, p.ID, res.ProviderRef)
+	if err != nil {
+		return paymentPending, err
+	}
+	if n, _ := r.RowsAffected(); n == 1 {
+		aggregate, aggregateID := "booking", p.BookingID.String
+		if p.PassID.Valid {
+			aggregate, aggregateID = "pass", p.PassID.String
+		}
+		err = outbox.Write(tx, outbox.PaymentCaptured, aggregate, aggregateID, outbox.PaymentData{
+			PaymentID: p.ID, BookingID: p.BookingID.String, PassID: p.PassID.String, Provider: h.Payments.Name(),
+			ProviderRef: res.ProviderRef, AmountPaise: p.Amount,
+		})
+		if err != nil {
+			return paymentPending, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return paymentPending, err
+	}
+	return paymentCaptured, nil
+}
+
+type refundRecord struct {
+	ID          string `json:"id"`
+	PaymentID   string `json:"paymentId"`
+	AmountPaise int64  `json:"amountPaise"`
+	ReasonCode  string `json:"reasonCode"`
+	ProviderRef string `json:"providerRef,omitempty"`
+	Status      string `json:"status"`
+}
+
+// refundBooking records a PENDING refund of amount against the booking's
+// captured payment inside the caller's transaction; an amount of 0 refunds
+// everything still refundable. The amount counts as refunded straight away
+// so that refunds in flight cannot add up to more than was paid. Nothing is
+// paid back until settleRefund runs after the transaction commits.
+func (h *Handler) refundBooking(tx *sql.Tx, bookingID string, amount int64, reasonCode, note, actorID string) (*refundRecord, error) {
+	var paymentID string
+	var paid, refunded int64
+	err := tx.QueryRow(`
+		SELECT id, amount_paise, refunded_paise
+		FROM payments
+		WHERE booking_id = $1 AND status IN ('CAPTURED', 'PARTIALLY_REFUNDED') AND refunded_paise < amount_paise
+		ORDER BY created_at DESC
+		LIMIT 1
+		FOR UPDATE
+	`, bookingID).Scan(&paymentID, &paid, &refunded)
+	if err == sql.ErrNoRows {
+		return nil, errNothingToRefund
+	} else if err != nil {
+		return nil, err
+	}
+
+	remaining := paid - refunded
+	if amount == 0 {
+		amount = remaining
+	}
+	if amount > remaining {
+		return nil, fmt.Errorf("%w (%d paise left)", errRefundTooLarge, remaining)
+	}
+
+	r := refundRecord{PaymentID: paymentID, AmountPaise: amount, ReasonCode: reasonCode, Status: paymentPending}
+	err = tx.QueryRow(`
+		INSERT INTO refunds (payment_id, booking_id, amount_paise, reason_code, note, status, created_by)
+		VALUES ($1, $2, $3, $4, $5, 'PENDING', $6)
+		RETURNING id
+	`, paymentID, bookingID, amount, reasonCode, note, nullIfEmpty(actorID)).Scan(&r.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE payments
+		SET refunded_paise = refunded_paise + $2,
+		    status = CASE WHEN refunded_paise + $2 = amount_paise THEN 'REFUNDED' ELSE 'PARTIALLY_REFUNDED' END
+		WHERE id = $1
+	`, paymentID, amount)
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+type claimedRefund struct {
+	ID         string
+	Key        string
+	PaymentID  string
+	PaymentRef string
+	BookingID  string
+	Amount     int64
+	ReasonCode string
+	Attempts   int
+}
+
+// claimRefunds leases PENDING refunds matching cond, which may use $2.
+func (h *Handler) claimRefunds(ctx context.Context, cond string, args ...interface{}) ([]claimedRefund, error) {
+	rows, err := h.DB.QueryContext(ctx, `
+		UPDATE refunds r
+		SET locked_until = now() + $1 * interval '1 second', attempts = attempts + 1
+		FROM payments p
+		WHERE p.id = r.payment_id AND r.id IN (
+			SELECT id FROM refunds
+			WHERE status = 'PENDING' AND (locked_until IS NULL OR locked_until < now()) AND `+cond+`
+			ORDER BY next_attempt_at
+			LIMIT `+fmt.Sprint(paymentBatch)+`
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING r.id, r.idempotency_key, r.payment_id, p.provider_ref, r.booking_id, r.amount_paise,
+		          r.reason_code, r.attempts
+	`, append([]interface{}{paymentLease.Seconds()}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []claimedRefund
+	for rows.Next() {
+		var r claimedRefund
+		if err := rows.Scan(&r.ID, &r.Key, &r.PaymentID, &r.PaymentRef, &r.BookingID, &r.Amount,
+			&r.ReasonCode, &r.Attempts); err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+// settleRefund tries to pay back a refund the caller has just committed as
+// PENDING, updating r with the outcome. A failed refund is logged and left
+// for SettlePayments to retry.
+func (h *Handler) settleRefund(ctx context.Context, r *refundRecord) {
+	claimed, err := h.claimRefunds(ctx, "id = $2", r.ID)
+	if err != nil || len(claimed) == 0 {
+		if err != nil {
+			log.Printf("refund %s: %v", r.ID, err)
+		}
+		return
+	}
+	r.Status, r.ProviderRef, err = h.payRefund(ctx, claimed[0])
+	if err != nil {
+		log.Printf("refund %s: %v", r.ID, err)
+	}
+}
+
+// payRefund makes one attempt at r through the provider and records the
+// outcome like capturePayment. A refund that runs out of attempts is marked
+// FAILED and its amount is no longer counted as refunded.
+func (h *Handler) payRefund(ctx context.Context, r claimedRefund) (status, providerRef string, err error) {
+	res, refErr := h.Payments.Refund(ctx, payments.RefundRequest{
+		IdempotencyKey: r.Key,
+		PaymentRef:     r.PaymentRef,
+		AmountPaise:    r.Amount,
+		Reason:         r.ReasonCode,
+	})
+	if refErr != nil && ctx.Err() != nil {
+		return paymentPending, "", nil
+	}
+	if refErr != nil && r.Attempts < paymentMaxAttempts {
+		_, err := h.DB.Exec(`
+			UPDATE refunds
+			SET next_attempt_at = now() + $2 * interval '1 second', locked_until = NULL, last_error = $3
+			WHERE id = $1
+		`, r.ID, outbox.Backoff(r.Attempts).Seconds(), refErr.Error())
+		if err != nil {
+			return paymentPending, "", err
+		}
+		return paymentPending, "", refErr
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		return paymentPending, "", err
+	}
+	defer tx.Rollback()
+	if refErr != nil {
+		err := tx.QueryRow(`
+			UPDATE refunds SET status = 'FAILED', locked_until = NULL, last_error = $2
+			WHERE id = $1 AND status = 'PENDING'
+			RETURNING id
+		`, r.ID, refErr.Error()).Scan(new(string))
+		if err == sql.ErrNoRows {
+			return paymentFailed, "", refErr
+		} else if err != nil {
+			return paymentPending, "", err
+		}
+		_, err = tx.Exec(`
+			UPDATE payments
+			SET refunded_paise = refunded_paise - $2,
+			    status = CASE WHEN refunded_paise - $2 = 0 THEN 'CAPTURED' ELSE 'PARTIALLY_REFUNDED' END
+			WHERE id = $1
+		`, r.PaymentID, r.Amount)
+		if err != nil {
+			return paymentPending, "", err
+		}
+		if err := tx.Commit(); err != nil {
+			return paymentPending, "", err
+		}
+		return paymentFailed, "", refErr
+	}
+
+	u, err := tx.Exec(`
+		UPDATE refunds SET status = $2, provider_ref = $3, locked_until = NULL, last_error = NULL
+		WHERE id = $1 AND status = 'PENDING'
+	`, r.ID, res.Status, res.ProviderRef)
+	if err != nil {
+		return paymentPending, "", err
+	}
+	if n, _ := u.RowsAffected(); n == 1 {
+		err = outbox.Write(tx, outbox.PaymentRefunded, "booking", r.BookingID, outbox.PaymentData{
+			PaymentID: r.PaymentID, RefundID: r.ID, BookingID: r.BookingID, Provider: h.Payments.Name(),
+			ProviderRef: res.ProviderRef, AmountPaise: r.Amount, ReasonCode: r.ReasonCode,
+		})
+		if err != nil {
+			return paymentPending, "", err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return paymentPending, "", err
+	}
+	return res.Status, res.ProviderRef, nil
+}
+
+// SettlePayments retries the captures and refunds still PENDING once they
+// are due: those whose first attempt failed, and those recorded by a
+// process that stopped before making it. Each is sent with the idempotency
+// key it was recorded with, so the provider acts on it once. Runs as a
+// background job on every replica.
+func (h *Handler) SettlePayments(ctx context.Context) error {
+	for {
+		claimed, err := h.claimPayments(ctx, "next_attempt_at <= now()")
+		if err != nil {
+			return err
+		}
+		for _, p := range claimed {
+			if _, err := h.capturePayment(ctx, p); err != nil {
+				log.Printf("payment %s: %v", p.ID, err)
+			}
+		}
+		if len(claimed) < paymentBatch {
+			break
+		}
+	}
+	for {
+		claimed, err := h.claimRefunds(ctx, "next_attempt_at <= now()")
+		if err != nil {
+			return err
+		}
+		for _, r := range claimed {
+			if _, _, err := h.payRefund(ctx, r); err != nil {
+				log.Printf("refund %s: %v", r.ID, err)
+			}
+		}
+		if len(claimed) < paymentBatch {
+			return nil
+		}
+	}
+}
+
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
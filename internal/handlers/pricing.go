@@ -0,0 +1,452 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"Backend-Go/internal/billing"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// pricingForecastHours is how far ahead a FORECAST-priced lot looks: the
+	// hour now and the next.
+	pricingForecastHours = 2
+	// pricingForecastWeeks is the history a FORECAST-priced lot learns from.
+	pricingForecastWeeks = 8
+	// pricingForecastMaxAge is how old a stored forecast can be and still
+	// price; RefreshPricingForecasts runs well within it.
+	pricingForecastMaxAge = 15 * time.Minute
+)
+
+// querier is satisfied by both *sql.DB and *sql.Tx.
+type querier interface {
+	queryRower
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+type pricingPolicy struct {
+	Enabled  bool
+	Basis    string
+	Surge    billing.Surge
+	QuoteTTL time.Duration
+	// Forecast is the busiest expected occupancy over the coming hours, as
+	// of ForecastAt; Valid is false until the first refresh.
+	Forecast   sql.NullFloat64
+	ForecastAt sql.NullTime
+}
+
+// loadPricingPolicy returns lotID's dynamic pricing, or nil if it has none.
+func loadPricingPolicy(q querier, lotID string) (*pricingPolicy, error) {
+	p := &pricingPolicy{}
+	var ttl int
+	err := q.QueryRow(`
+		SELECT enabled, basis, min_multiplier_bp, max_multiplier_bp, quote_ttl_seconds, forecast_occupied, forecast_at
+		FROM lot_pricing_policies WHERE lot_id = $1
+	`, lotID).Scan(&p.Enabled, &p.Basis, &p.Surge.MinBP, &p.Surge.MaxBP, &ttl, &p.Forecast, &p.ForecastAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	p.QuoteTTL = time.Duration(ttl) * time.Second
+
+	rows, err := q.Query(`
+		SELECT threshold_pct, multiplier_bp FROM lot_pricing_steps WHERE lot_id = $1 ORDER BY threshold_pct
+	`, lotID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var s billing.SurgeStep
+		if err := rows.Scan(&s.ThresholdPct, &s.MultiplierBP); err != nil {
+			return nil, err
+		}
+		p.Surge.Steps = append(p.Surge.Steps, s)
+	}
+	return p, rows.Err()
+}
+
+// priceDecision is the hourly rate a session is charged and why.
+type priceDecision struct {
+	QuoteID      string
+	Basis        string
+	OccupancyPct float64
+	MultiplierBP int
+	BaseRate     int64
+	Rate         int64
+	quoteTTL     time.Duration
+}
+
+func (d *priceDecision) json() gin.H {
+	return gin.H{
+		"quoteId":              nullIfEmpty(d.QuoteID),
+		"basis":                d.Basis,
+		"occupancyPct":         math.Round(d.OccupancyPct*10) / 10,
+		"multiplierBp":         d.MultiplierBP,
+		"baseRatePerHourPaise": d.BaseRate,
+		"ratePerHourPaise":     d.Rate,
+	}
+}
+
+// decidePrice prices an hour at lotID now. It returns nil for lots without
+// a billing profile or with dynamic pricing off, which charge the profile
+// rate.
+func (h *Handler) decidePrice(q querier, lotID string) (*priceDecision, error) {
+	d := &priceDecision{}
+	err := q.QueryRow(`SELECT rate_per_hour_paise FROM lot_billing_profiles WHERE lot_id = $1`, lotID).Scan(&d.BaseRate)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	policy, err := loadPricingPolicy(q, lotID)
+	if err != nil || policy == nil || !policy.Enabled {
+		return nil, err
+	}
+	d.Basis = policy.Basis
+	d.quoteTTL = policy.QuoteTTL
+
+	var capacity, active int
+	err = q.QueryRow(`
+		SELECT (SELECT COUNT(*) FROM parking_spots s WHERE s.lot_id = $1 AND s.status <> 'DISABLED'),
+		       (SELECT COUNT(*) FROM bookings b JOIN parking_spots s ON s.id = b.spot_id
+		        WHERE s.lot_id = $1 AND b.end_time IS NULL)
+	`, lotID).Scan(&capacity, &active)
+	if err != nil {
+		return nil, err
+	}
+	occupied := float64(active)
+
+	// a forecast lot prices on the busier of now and the coming hour, so the
+	// price rises ahead of the rush rather than once it has arrived; the
+	// forecast is the stored one, as this may run inside a booking's
+	// transaction, and until there is a recent one the lot prices on now
+	if policy.Basis == "FORECAST" && policy.Forecast.Valid && time.Since(policy.ForecastAt.Time) < pricingForecastMaxAge {
+		occupied = math.Max(occupied, policy.Forecast.Float64)
+	}
+	if capacity > 0 {
+		d.OccupancyPct = math.Min(100, 100*occupied/float64(capacity))
+	}
+
+	d.MultiplierBP = policy.Surge.Multiplier(d.OccupancyPct)
+	d.Rate = billing.ApplyMultiplier(d.BaseRate, d.MultiplierBP)
+	return d, nil
+}
+
+// RefreshPricingForecasts stores a fresh forecast for each lot priced on
+// FORECAST, for decidePrice. Run it as a background job.
+func (h *Handler) RefreshPricingForecasts(ctx context.Context) error {
+	rows, err := h.DB.QueryContext(ctx, `
+		SELECT lot_id FROM lot_pricing_policies WHERE enabled AND basis = 'FORECAST'
+	`)
+	if err != nil {
+		return err
+	}
+	var lots []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		lots = append(lots, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, lotID := range lots {
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err := h.refreshPricingForecast(lotID); err != nil {
+			log.Printf("pricing: forecast for lot %s: %v", lotID, err)
+		}
+	}
+	return nil
+}
+
+// refreshPricingForecast stores the busiest expected occupancy at lotID over
+// the next pricingForecastHours.
+func (h *Handler) refreshPricingForecast(lotID string) error {
+	fc, err := h.forecastLot(lotID, pricingForecastHours, pricingForecastWeeks)
+	if err != nil {
+		return err
+	}
+	var peak float64
+	for _, p := range fc.Points {
+		peak = math.Max(peak, p.Expected)
+	}
+	_, err = h.DB.Exec(`
+		UPDATE lot_pricing_policies SET forecast_occupied = $2, forecast_at = now() WHERE lot_id = $1
+	`, lotID, peak)
+	return err
+}
+
+// issueQuote prices an hour at lotID for a user about to book spotID and
+// records it, so a booking made before it expires pays the quoted rate. It
+// returns nil where dynamic pricing is off.
+func (h *Handler) issueQuote(lotID, spotID, userID string) (*priceDecision, time.Time, error) {
+	d, err := h.decidePrice(h.DB, lotID)
+	if err != nil || d == nil {
+		return nil, time.Time{}, err
+	}
+	var expires time.Time
+	err = h.DB.QueryRow(`
+		INSERT INTO price_quotes (lot_id, spot_id, user_id, basis, occupancy_pct, multiplier_bp,
+		                          base_rate_paise, rate_paise, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, now() + $9 * interval '1 second')
+		RETURNING id, expires_at
+	`, lotID, spotID, userID, d.Basis, d.OccupancyPct, d.MultiplierBP, d.BaseRate, d.Rate,
+		int(d.quoteTTL/time.Second)).Scan(&d.QuoteID, &expires)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return d, expires, nil
+}
+
+// lockPrice fixes the rate of a booking about to start at lotID: the quote
+// it was made from, if any and still good, or else the price right now.
+func (h *Handler) lockPrice(tx *sql.Tx, p bookingParams, lotID string) (*priceDecision, *bookingError) {
+	if p.QuoteID == "" {
+		d, err := h.decidePrice(tx, lotID)
+		if err != nil {
+			return nil, internalBookingError("PRICING_FAILED", "failed to work out price", err)
+		}
+		return d, nil
+	}
+
+	d := &priceDecision{QuoteID: p.QuoteID}
+	var userID, spotID string
+	var bookingID sql.NullString
+	var expires time.Time
+	err := tx.QueryRow(`
+		SELECT user_id, spot_id, booking_id, expires_at, basis, occupancy_pct, multiplier_bp, base_rate_paise, rate_paise
+		FROM price_quotes
+		WHERE id::text = $1
+		FOR UPDATE
+	`, p.QuoteID).Scan(&userID, &spotID, &bookingID, &expires, &d.Basis, &d.OccupancyPct, &d.MultiplierBP,
+		&d.BaseRate, &d.Rate)
+	if err == sql.ErrNoRows {
+		return nil, &bookingError{http.StatusNotFound, "QUOTE_NOT_FOUND", "quote not found", nil}
+	} else if err != nil {
+		return nil, internalBookingError("QUOTE_CHECK_FAILED", "failed to check quote", err)
+	}
+	if userID != p.UserID || spotID != p.SpotID {
+		return nil, &bookingError{http.StatusConflict, "QUOTE_MISMATCH", "quote is for another user or spot", nil}
+	}
+	if bookingID.Valid {
+		return nil, &bookingError{http.StatusConflict, "QUOTE_USED", "quote has already been used", nil}
+	}
+	if !time.Now().Before(expires) {
+		return nil, &bookingError{http.StatusGone, "QUOTE_EXPIRED", "quote has expired; ask for a new one", nil}
+	}
+	return d, nil
+}
+
+// recordPrice keeps the audit record of the rate a booking was started at
+// and marks the quote it used, if any, as taken.
+func recordPrice(tx *sql.Tx, bookingID, lotID string, d *priceDecision) error {
+	if _, err := tx.Exec(`
+		INSERT INTO booking_pricing (booking_id, lot_id, quote_id, basis, occupancy_pct, multiplier_bp, base_rate_paise, rate_paise)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`, bookingID, lotID, nullIfEmpty(d.QuoteID), d.Basis, d.OccupancyPct, d.MultiplierBP, d.BaseRate, d.Rate); err != nil {
+		return err
+	}
+	if d.QuoteID == "" {
+		return nil
+	}
+	_, err := tx.Exec(`UPDATE price_quotes SET booking_id = $2 WHERE id = $1`, d.QuoteID, bookingID)
+	return err
+}
+
+type pricingStepReq struct {
+	ThresholdPct int `json:"thresholdPct" binding:"min=0,max=100"`
+	MultiplierBP int `json:"multiplierBp" binding:"required,min=1"`
+}
+
+type pricingPolicyReq struct {
+	Enabled         bool             `json:"enabled"`
+	Basis           string           `json:"basis" binding:"omitempty,oneof=OCCUPANCY FORECAST"`
+	MinMultiplierBP int              `json:"minMultiplierBp" binding:"omitempty,min=1"`
+	MaxMultiplierBP int              `json:"maxMultiplierBp" binding:"omitempty,min=1"`
+	QuoteTTLSeconds int              `json:"quoteTtlSeconds" binding:"omitempty,min=30,max=86400"`
+	Steps           []pricingStepReq `json:"steps" binding:"dive"`
+}
+
+// SetLotPricing creates or replaces a lot's dynamic pricing: multipliers
+// stepped by occupancy (or forecast occupancy), kept within the bounds.
+func (h *Handler) SetLotPricing(c *gin.Context) {
+	lotID := c.Param("id")
+	var req pricingPolicyReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "invalid request body", err.Error())
+		return
+	}
+	if req.Basis == "" {
+		req.Basis = "OCCUPANCY"
+	}
+	if req.MinMultiplierBP == 0 {
+		req.MinMultiplierBP = 10000
+	}
+	if req.MaxMultiplierBP == 0 {
+		req.MaxMultiplierBP = 20000
+	}
+	if req.QuoteTTLSeconds == 0 {
+		req.QuoteTTLSeconds = 600
+	}
+	if req.MaxMultiplierBP < req.MinMultiplierBP {
+		writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "maxMultiplierBp must not be below minMultiplierBp", nil)
+		return
+	}
+	seen := map[int]bool{}
+	for _, s := range req.Steps {
+		if seen[s.ThresholdPct] {
+			writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "step thresholds must be distinct", nil)
+			return
+		}
+		seen[s.ThresholdPct] = true
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_BEGIN_FAILED", "could not start transaction", nil)
+		return
+	}
+	defer tx.Rollback()
+
+	before, err := auditSnapshot(tx, "pricing_policy", lotID)
+	if err != nil {
+		writeAuditError(c, err)
+		return
+	}
+	res, err := tx.Exec(`
+		INSERT INTO lot_pricing_policies (lot_id, enabled, basis, min_multiplier_bp, max_multiplier_bp, quote_ttl_seconds)
+		SELECT id, $2, $3, $4, $5, $6 FROM parking_lots WHERE id = $1
+		ON CONFLICT (lot_id) DO UPDATE SET
+			enabled = EXCLUDED.enabled,
+			basis = EXCLUDED.basis,
+			min_multiplier_bp = EXCLUDED.min_multiplier_bp,
+			max_multiplier_bp = EXCLUDED.max_multiplier_bp,
+			quote_ttl_seconds = EXCLUDED.quote_ttl_seconds,
+			updated_at = now()
+	`, lotID, req.Enabled, req.Basis, req.MinMultiplierBP, req.MaxMultiplierBP, req.QuoteTTLSeconds)
+	if err != nil {
+		writeError(c, http.StatusBadRequest, "SET_PRICING_FAILED", "could not save pricing policy", err.Error())
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		writeError(c, http.StatusNotFound, "LOT_NOT_FOUND", "lot not found", nil)
+		return
+	}
+	if _, err := tx.Exec(`DELETE FROM lot_pricing_steps WHERE lot_id = $1`, lotID); err != nil {
+		writeError(c, http.StatusInternalServerError, "SET_PRICING_FAILED", "could not save pricing steps", err.Error())
+		return
+	}
+	for _, s := range req.Steps {
+		if _, err := tx.Exec(`
+			INSERT INTO lot_pricing_steps (lot_id, threshold_pct, multiplier_bp) VALUES ($1, $2, $3)
+		`, lotID, s.ThresholdPct, s.MultiplierBP); err != nil {
+			writeError(c, http.StatusInternalServerError, "SET_PRICING_FAILED", "could not save pricing steps", err.Error())
+			return
+		}
+	}
+	if err := auditRow(tx, c, "pricing_policy.set", "pricing_policy", lotID, before); err != nil {
+		writeAuditError(c, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_COMMIT_FAILED", "failed to commit pricing policy", err.Error())
+		return
+	}
+	// price on the forecast straight away rather than from the next refresh
+	if req.Enabled && req.Basis == "FORECAST" {
+		if err := h.refreshPricingForecast(lotID); err != nil {
+			log.Printf("pricing: forecast for lot %s: %v", lotID, err)
+		}
+	}
+	writeOK(c, gin.H{"data": gin.H{
+		"lotId":           lotID,
+		"enabled":         req.Enabled,
+		"basis":           req.Basis,
+		"minMultiplierBp": req.MinMultiplierBP,
+		"maxMultiplierBp": req.MaxMultiplierBP,
+		"quoteTtlSeconds": req.QuoteTTLSeconds,
+		"steps":           req.Steps,
+	}})
+}
+
+// GetLotPricing shows a lot's dynamic pricing and the price it would charge
+// right now.
+func (h *Handler) GetLotPricing(c *gin.Context) {
+	lotID := c.Param("id")
+	policy, err := loadPricingPolicy(h.DB, lotID)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "PRICING_FETCH_FAILED", "failed to fetch pricing policy", err.Error())
+		return
+	}
+	if policy == nil {
+		writeError(c, http.StatusNotFound, "PRICING_NOT_FOUND", "lot has no pricing policy", nil)
+		return
+	}
+	current, err := h.decidePrice(h.DB, lotID)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "PRICING_FAILED", "failed to work out current price", err.Error())
+		return
+	}
+
+	steps := make([]gin.H, 0, len(policy.Surge.Steps))
+	for _, s := range policy.Surge.Steps {
+		steps = append(steps, gin.H{"thresholdPct": s.ThresholdPct, "multiplierBp": s.MultiplierBP})
+	}
+	out := gin.H{
+		"lotId":           lotID,
+		"enabled":         policy.Enabled,
+		"basis":           policy.Basis,
+		"minMultiplierBp": policy.Surge.MinBP,
+		"maxMultiplierBp": policy.Surge.MaxBP,
+		"quoteTtlSeconds": int(policy.QuoteTTL / time.Second),
+		"steps":           steps,
+		"current":         nil,
+	}
+	if current != nil {
+		out["current"] = current.json()
+	}
+	writeOK(c, gin.H{"data": out})
+}
+
+// GetBookingPricing shows which multiplier a booking was charged and the
+// occupancy it was based on.
+func (h *Handler) GetBookingPricing(c *gin.Context) {
+	bookingID := c.Param("id")
+	d := &priceDecision{}
+	var lotID string
+	var quoteID sql.NullString
+	var decided time.Time
+	err := h.DB.QueryRow(`
+		SELECT lot_id, quote_id, basis, occupancy_pct, multiplier_bp, base_rate_paise, rate_paise, decided_at
+		FROM booking_pricing
+		WHERE booking_id = $1
+	`, bookingID).Scan(&lotID, &quoteID, &d.Basis, &d.OccupancyPct, &d.MultiplierBP, &d.BaseRate, &d.Rate, &decided)
+	if err == sql.ErrNoRows {
+		writeError(c, http.StatusNotFound, "PRICING_NOT_FOUND", "booking was not dynamically priced", nil)
+		return
+	} else if err != nil {
+		writeError(c, http.StatusInternalServerError, "PRICING_FETCH_FAILED", "failed to fetch booking pricing", err.Error())
+		return
+	}
+	d.QuoteID = quoteID.String
+	out := d.json()
+	out["bookingId"] = bookingID
+	out["lotId"] = lotID
+	out["decidedAt"] = toIST(decided)
+	writeOK(c, gin.H{"data": out})
+}
@@ -0,0 +1,268 @@
+package handler
+
+import (
+	"database/sql"
+	"net/http"
+	"strings"
+	"time"
+
+	"Backend-Go/internal/billing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// promoError explains why a code cannot be used; its code doubles as the API
+// error code.
+type promoError struct{ code, message string }
+
+func (e *promoError) Error() string { return e.message }
+
+type promoCode struct {
+	ID string
+	billing.Promo
+}
+
+// checkPromo finds a code by its text and verifies it can be used by userID
+// in lotID at time at. Inside a transaction, pass forUpdate to lock the code
+// so concurrent redemptions cannot overrun its limits.
+func checkPromo(q queryRower, code, userID, lotID string, at time.Time, forUpdate bool) (*promoCode, error) {
+	return findPromo(q, "upper(code) = upper($1)", code, userID, lotID, at, forUpdate)
+}
+
+func checkPromoByID(q queryRower, id, userID, lotID string, at time.Time, forUpdate bool) (*promoCode, error) {
+	return findPromo(q, "id = $1", id, userID, lotID, at, forUpdate)
+}
+
+func findPromo(q queryRower, where string, arg interface{}, userID, lotID string, at time.Time, forUpdate bool) (*promoCode, error) {
+	query := `
+		SELECT id, code, kind, value, max_discount_paise, min_spend_paise,
+		       valid_from, valid_to, max_uses, max_uses_per_user, lot_id, active
+		FROM promo_codes
+		WHERE ` + where
+	if forUpdate {
+		query += " FOR UPDATE"
+	}
+
+	var p promoCode
+	var from time.Time
+	var to sql.NullTime
+	var maxUses, maxPerUser sql.NullInt64
+	var promoLot sql.NullString
+	var active bool
+	err := q.QueryRow(query, arg).Scan(&p.ID, &p.Code, &p.Kind, &p.Value, &p.MaxDiscountPaise, &p.MinSpendPaise,
+		&from, &to, &maxUses, &maxPerUser, &promoLot, &active)
+	if err == sql.ErrNoRows {
+		return nil, &promoError{"PROMO_NOT_FOUND", "promo code not found"}
+	} else if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case !active:
+		return nil, &promoError{"PROMO_INACTIVE", "promo code is no longer active"}
+	case at.Before(from):
+		return nil, &promoError{"PROMO_NOT_STARTED", "promo code is not valid yet"}
+	case to.Valid && !at.Before(to.Time):
+		return nil, &promoError{"PROMO_EXPIRED", "promo code has expired"}
+	case promoLot.Valid && promoLot.String != lotID:
+		return nil, &promoError{"PROMO_WRONG_LOT", "promo code is not valid at this lot"}
+	}
+
+	if maxUses.Valid || maxPerUser.Valid {
+		var used, usedByUser int64
+		err := q.QueryRow(`
+			SELECT COUNT(*), COUNT(*) FILTER (WHERE user_id::text = $2)
+			FROM promo_redemptions
+			WHERE promo_id = $1
+		`, p.ID, userID).Scan(&used, &usedByUser)
+		if err != nil {
+			return nil, err
+		}
+		if maxUses.Valid && used >= maxUses.Int64 {
+			return nil, &promoError{"PROMO_EXHAUSTED", "promo code has been fully redeemed"}
+		}
+		if maxPerUser.Valid && usedByUser >= maxPerUser.Int64 {
+			return nil, &promoError{"PROMO_LIMIT_REACHED", "you have already used this promo code the maximum number of times"}
+		}
+	}
+	return &p, nil
+}
+
+type createPromoReq struct {
+	Code             string     `json:"code" binding:"required,min=3,max=32,alphanum"`
+	Kind             string     `json:"kind" binding:"required,oneof=PERCENT FIXED"`
+	Value            int64      `json:"value" binding:"required,min=1"`
+	MaxDiscountPaise int64      `json:"maxDiscountPaise" binding:"min=0"`
+	MinSpendPaise    int64      `json:"minSpendPaise" binding:"min=0"`
+	ValidFrom        *time.Time `json:"validFrom"`
+	ValidTo          *time.Time `json:"validTo"`
+	MaxUses          *int       `json:"maxUses" binding:"omitempty,min=1"`
+	MaxUsesPerUser   *int       `json:"maxUsesPerUser" binding:"omitempty,min=1"`
+	LotID            *string    `json:"lotId"`
+}
+
+// CreatePromo adds a promotion code. PERCENT values are basis points (1000 =
+// 10%), FIXED values paise.
+func (h *Handler) CreatePromo(c *gin.Context) {
+	var req createPromoReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "invalid request body", err.Error())
+		return
+	}
+	req.Code = strings.ToUpper(req.Code)
+	if req.Kind == "PERCENT" && req.Value > 10000 {
+		writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "a percentage discount cannot exceed 10000 basis points", nil)
+		return
+	}
+	from := time.Now()
+	if req.ValidFrom != nil {
+		from = *req.ValidFrom
+	}
+	if req.ValidTo != nil && !req.ValidTo.After(from) {
+		writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "validTo must be after validFrom", nil)
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_BEGIN_FAILED", "could not start transaction", nil)
+		return
+	}
+	defer tx.Rollback()
+
+	var id string
+	err = tx.QueryRow(`
+		INSERT INTO promo_codes (code, kind, value, max_discount_paise, min_spend_paise, valid_from, valid_to, max_uses, max_uses_per_user, lot_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		RETURNING id
+	`, req.Code, req.Kind, req.Value, req.MaxDiscountPaise, req.MinSpendPaise, from, req.ValidTo, req.MaxUses, req.MaxUsesPerUser, req.LotID).Scan(&id)
+	if err != nil {
+		writeError(c, http.StatusBadRequest, "CREATE_PROMO_FAILED", "could not create promo code (maybe duplicate code)", err.Error())
+		return
+	}
+	if err := auditRow(tx, c, "promo_code.create", "promo_code", id, nil); err != nil {
+		writeAuditError(c, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_COMMIT_FAILED", "failed to commit promo code", err.Error())
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"data": gin.H{
+		"id":               id,
+		"code":             req.Code,
+		"kind":             req.Kind,
+		"value":            req.Value,
+		"maxDiscountPaise": req.MaxDiscountPaise,
+		"minSpendPaise":    req.MinSpendPaise,
+		"validFrom":        toIST(from),
+		"validTo":          req.ValidTo,
+		"maxUses":          req.MaxUses,
+		"maxUsesPerUser":   req.MaxUsesPerUser,
+		"lotId":            req.LotID,
+		"active":           true,
+	}})
+}
+
+// ListPromos lists promotion codes with their usage so far.
+func (h *Handler) ListPromos(c *gin.Context) {
+	rows, err := h.DB.Query(`
+		SELECT p.id, p.code, p.kind, p.value, p.max_discount_paise, p.min_spend_paise,
+		       p.valid_from, p.valid_to, p.max_uses, p.max_uses_per_user, p.lot_id, p.active,
+		       COUNT(r.id), COALESCE(SUM(r.discount_paise), 0)
+		FROM promo_codes p
+		LEFT JOIN promo_redemptions r ON r.promo_id = p.id
+		GROUP BY p.id
+		ORDER BY p.created_at DESC
+	`)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "PROMOS_FETCH_FAILED", "failed to fetch promo codes", err.Error())
+		return
+	}
+	defer rows.Close()
+
+	items := make([]gin.H, 0, 20)
+	for rows.Next() {
+		var id, code, kind string
+		var value, maxDiscount, minSpend, redemptions, discounted int64
+		var from time.Time
+		var to sql.NullTime
+		var maxUses, maxPerUser sql.NullInt64
+		var lotID sql.NullString
+		var active bool
+		if err := rows.Scan(&id, &code, &kind, &value, &maxDiscount, &minSpend, &from, &to, &maxUses, &maxPerUser, &lotID, &active, &redemptions, &discounted); err != nil {
+			writeError(c, http.StatusInternalServerError, "SCAN_ERROR", "failed to read row", err.Error())
+			return
+		}
+		var validTo interface{}
+		if to.Valid {
+			validTo = toIST(to.Time)
+		}
+		items = append(items, gin.H{
+			"id":               id,
+			"code":             code,
+			"kind":             kind,
+			"value":            value,
+			"maxDiscountPaise": maxDiscount,
+			"minSpendPaise":    minSpend,
+			"validFrom":        toIST(from),
+			"validTo":          validTo,
+			"maxUses":          nullInt(maxUses),
+			"maxUsesPerUser":   nullInt(maxPerUser),
+			"lotId":            nullString(lotID),
+			"active":           active,
+			"redemptions":      redemptions,
+			"discountPaise":    discounted,
+		})
+	}
+	writeOK(c, gin.H{"items": items})
+}
+
+// DeactivatePromo stops a code from being used on new bookings.
+func (h *Handler) DeactivatePromo(c *gin.Context) {
+	id := c.Param("id")
+	tx, err := h.DB.Begin()
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_BEGIN_FAILED", "could not start transaction", nil)
+		return
+	}
+	defer tx.Rollback()
+
+	before, err := auditSnapshot(tx, "promo_code", id)
+	if err != nil {
+		writeAuditError(c, err)
+		return
+	}
+	res, err := tx.Exec(`UPDATE promo_codes SET active = false WHERE id = $1`, id)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "DEACTIVATE_PROMO_FAILED", "failed to deactivate promo code", err.Error())
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		writeError(c, http.StatusNotFound, "PROMO_NOT_FOUND", "promo code not found", nil)
+		return
+	}
+	if err := auditRow(tx, c, "promo_code.deactivate", "promo_code", id, before); err != nil {
+		writeAuditError(c, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_COMMIT_FAILED", "failed to commit promo code", err.Error())
+		return
+	}
+	writeOK(c, gin.H{"data": gin.H{"id": id, "active": false}})
+}
+
+func nullInt(v sql.NullInt64) interface{} {
+	if v.Valid {
+		return v.Int64
+	}
+	return nil
+}
+
+func nullString(v sql.NullString) interface{} {
+	if v.Valid {
+		return v.String
+	}
+	return nil
+}
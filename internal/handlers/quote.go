@@ -0,0 +1,178 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"Backend-Go/internal/billing"
+
+	"github.com/gin-gonic/gin"
+)
+
+type quoteReq struct {
+	SpotID    string `json:"spotId" binding:"required"`
+	Hours     int    `json:"hours" binding:"omitempty,min=1,max=720"`
+	VehicleID string `json:"vehicleId"`
+	PromoCode string `json:"promoCode"`
+}
+
+// Quote estimates what parking on a spot for ?hours (default 1) would cost,
+// taking the caller's pass (?vehicleId) and a promo code (?promoCode) into
+// account. The invoice is computed the same way on release. It records
+// nothing; LockQuote holds a dynamic price.
+func (h *Handler) Quote(c *gin.Context) {
+	req := quoteReq{SpotID: c.Query("spotId"), VehicleID: c.Query("vehicleId"), PromoCode: c.Query("promoCode")}
+	if req.SpotID == "" {
+		writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "spotId is required", nil)
+		return
+	}
+	hours, err := strconv.Atoi(c.DefaultQuery("hours", "1"))
+	if err != nil || hours < 1 || hours > 720 {
+		writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "hours must be between 1 and 720", nil)
+		return
+	}
+	req.Hours = hours
+	h.quote(c, req, false)
+}
+
+// LockQuote is Quote with the same fields in a JSON body, which at a lot
+// with dynamic pricing also locks the current rate for a while: a booking
+// made with the returned quoteId before it expires pays that rate.
+func (h *Handler) LockQuote(c *gin.Context) {
+	var req quoteReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "invalid request body", err.Error())
+		return
+	}
+	if req.Hours == 0 {
+		req.Hours = 1
+	}
+	h.quote(c, req, true)
+}
+
+func (h *Handler) quote(c *gin.Context, req quoteReq, lock bool) {
+	spotID, hours := req.SpotID, req.Hours
+	claims := GetClaims(c)
+
+	var lotID string
+	err := h.DB.QueryRow(`SELECT lot_id FROM parking_spots WHERE id = $1`, spotID).Scan(&lotID)
+	if err == sql.ErrNoRows {
+		writeError(c, http.StatusNotFound, "SPOT_NOT_FOUND", "spot does not exist", nil)
+		return
+	} else if err != nil {
+		writeError(c, http.StatusInternalServerError, "SPOT_CHECK_FAILED", "failed to check spot", err.Error())
+		return
+	}
+
+	// a pass is only looked up for a vehicle the caller can book with
+	if req.VehicleID != "" {
+		ok, err := usableVehicle(h.DB, req.VehicleID, claims.UserID)
+		if err != nil {
+			writeError(c, http.StatusInternalServerError, "VEHICLE_CHECK_FAILED", "failed to check vehicle", err.Error())
+			return
+		}
+		if !ok {
+			writeError(c, http.StatusNotFound, "VEHICLE_NOT_FOUND", "vehicle not found", nil)
+			return
+		}
+	}
+
+	quote := gin.H{"spotId": spotID, "lotId": lotID, "hours": hours}
+
+	var gstin, lotState string
+	var rateBP int
+	var ratePerHour int64
+	err = h.DB.QueryRow(`
+		SELECT gstin, lot_state_code, gst_rate_bp, rate_per_hour_paise FROM lot_billing_profiles WHERE lot_id = $1
+	`, lotID).Scan(&gstin, &lotState, &rateBP, &ratePerHour)
+	if err == sql.ErrNoRows {
+		// lots without a billing profile are free
+		quote["billed"] = false
+		quote["tax"] = billing.ComputeGST(0, 0, true)
+		writeOK(c, gin.H{"data": quote})
+		return
+	} else if err != nil {
+		writeError(c, http.StatusInternalServerError, "BILLING_FETCH_FAILED", "failed to fetch lot billing", err.Error())
+		return
+	}
+
+	// with dynamic pricing on, a locked quote holds its rate for a booking
+	// made with its quoteId before it expires
+	var price *priceDecision
+	var expires time.Time
+	if lock {
+		price, expires, err = h.issueQuote(lotID, spotID, claims.UserID)
+	} else {
+		price, err = h.decidePrice(h.DB, lotID)
+	}
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "PRICING_FAILED", "failed to work out price", err.Error())
+		return
+	}
+	if price != nil {
+		ratePerHour = price.Rate
+		quote["pricing"] = price.json()
+		if price.QuoteID != "" {
+			quote["quoteId"] = price.QuoteID
+			quote["expiresAt"] = toIST(expires)
+		}
+	}
+
+	start := time.Now()
+	end := start.Add(time.Duration(hours) * time.Hour)
+	tariff := billing.Tariff{RatePerHour: ratePerHour}
+	if vid := req.VehicleID; vid != "" {
+		passID, until, err := activePass(h.DB, vid, lotID, spotID)
+		if err != nil {
+			writeError(c, http.StatusInternalServerError, "PASS_CHECK_FAILED", "failed to check passes", err.Error())
+			return
+		}
+		if passID != "" {
+			tariff.PassValidUntil = until
+			quote["passId"] = passID
+		}
+	}
+	billedHours, charge := tariff.Evaluate(start, end)
+	taxable := charge
+
+	var discount int64
+	if code := req.PromoCode; code != "" {
+		promo, err := checkPromo(h.DB, code, claims.UserID, lotID, start, false)
+		if pe, ok := err.(*promoError); ok {
+			writeError(c, http.StatusBadRequest, pe.code, pe.message, nil)
+			return
+		} else if err != nil {
+			writeError(c, http.StatusInternalServerError, "PROMO_CHECK_FAILED", "failed to check promo code", err.Error())
+			return
+		}
+		discount = promo.Discount(taxable)
+		taxable -= discount
+		quote["promoCode"] = strings.ToUpper(code)
+	}
+
+	quote["billed"] = true
+	quote["billedHours"] = billedHours
+	quote["ratePerHourPaise"] = ratePerHour
+	quote["chargePaise"] = charge
+	quote["discountPaise"] = discount
+	quote["tax"] = billing.ComputeGST(taxable, rateBP, billing.IntraStateSupply(gstin, lotState))
+	writeOK(c, gin.H{"data": quote})
+}
+
+// quoteRetention is how long unused quotes are kept after they expire.
+const quoteRetention = 24 * time.Hour
+
+// PruneQuotes deletes quotes that expired unused more than quoteRetention
+// ago. Quotes a booking used are kept with its pricing record. Run it as a
+// background job.
+func (h *Handler) PruneQuotes(ctx context.Context) error {
+	_, err := h.DB.ExecContext(ctx, `
+		DELETE FROM price_quotes
+		WHERE booking_id IS NULL AND expires_at < now() - $1 * interval '1 second'
+	`, quoteRetention.Seconds())
+	return err
+}
@@ -0,0 +1,329 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+
+	"Backend-Go/internal/billing"
+
+	"github.com/gin-gonic/gin"
+)
+
+type refundReq struct {
+	AmountPaise int64  `json:"amountPaise" binding:"min=0"`
+	ReasonCode  string `json:"reasonCode" binding:"required"`
+	Note        string `json:"note"`
+}
+
+// RefundBooking refunds part or (with no amount) all of a booking's payment.
+// A refund the provider does not take straight away is returned PENDING and
+// retried in the background.
+func (h *Handler) RefundBooking(c *gin.Context) {
+	bookingID := c.Param("id")
+	var req refundReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "invalid request body", err.Error())
+		return
+	}
+	if !refundReasons[req.ReasonCode] {
+		writeError(c, http.StatusBadRequest, "INVALID_REASON_CODE", "unknown reasonCode", nil)
+		return
+	}
+	claims := GetClaims(c)
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_BEGIN_FAILED", "could not start transaction", nil)
+		return
+	}
+	defer tx.Rollback()
+
+	refund, err := h.refundBooking(tx, bookingID, req.AmountPaise, req.ReasonCode, req.Note, claims.UserID)
+	if !writeRefundError(c, err) {
+		return
+	}
+	if err := audit(tx, c, "booking.refund", "booking", bookingID, nil, refund); err != nil {
+		writeAuditError(c, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_COMMIT_FAILED", "failed to commit refund", err.Error())
+		return
+	}
+	h.settleRefund(c.Request.Context(), refund)
+	c.JSON(http.StatusCreated, gin.H{"data": refund})
+}
+
+// writeRefundError maps refundBooking errors to responses and reports
+// whether err was nil.
+func writeRefundError(c *gin.Context, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, errNothingToRefund):
+		writeError(c, http.StatusConflict, "NOTHING_TO_REFUND", err.Error(), nil)
+	case errors.Is(err, errRefundTooLarge):
+		writeError(c, http.StatusBadRequest, "REFUND_TOO_LARGE", err.Error(), nil)
+	default:
+		writeError(c, http.StatusInternalServerError, "REFUND_FAILED", "failed to record refund", err.Error())
+	}
+	return false
+}
+
+type adjustReq struct {
+	Kind        string `json:"kind" binding:"required,oneof=ADJUSTMENT WAIVER"`
+	AmountPaise int64  `json:"amountPaise"`
+	ReasonCode  string `json:"reasonCode" binding:"required"`
+	Note        string `json:"note"`
+}
+
+// AdjustBooking changes what a booking is charged. On an active booking the
+// adjustment (a signed change to the taxable value) or waiver is applied when
+// the invoice is issued; on an invoiced booking the reduction, with its GST,
+// is refunded.
+func (h *Handler) AdjustBooking(c *gin.Context) {
+	bookingID := c.Param("id")
+	var req adjustReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "invalid request body", err.Error())
+		return
+	}
+	if !refundReasons[req.ReasonCode] {
+		writeError(c, http.StatusBadRequest, "INVALID_REASON_CODE", "unknown reasonCode", nil)
+		return
+	}
+	if req.Kind == "ADJUSTMENT" && req.AmountPaise == 0 {
+		writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "amountPaise is required for an adjustment", nil)
+		return
+	}
+	if req.Kind == "WAIVER" {
+		req.AmountPaise = 0
+	}
+	claims := GetClaims(c)
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_BEGIN_FAILED", "could not start transaction", nil)
+		return
+	}
+	defer tx.Rollback()
+
+	var end sql.NullTime
+	err = tx.QueryRow(`SELECT end_time FROM bookings WHERE id = $1 FOR UPDATE`, bookingID).Scan(&end)
+	if err == sql.ErrNoRows {
+		writeError(c, http.StatusNotFound, "BOOKING_NOT_FOUND", "booking not found", nil)
+		return
+	} else if err != nil {
+		writeError(c, http.StatusInternalServerError, "BOOKING_FETCH_FAILED", "failed to fetch booking", err.Error())
+		return
+	}
+
+	var rateBP int
+	var intraState bool
+	err = tx.QueryRow(`SELECT gst_rate_bp, igst_paise = 0 FROM invoices WHERE booking_id = $1`, bookingID).Scan(&rateBP, &intraState)
+	invoiced := err == nil
+	if err != nil && err != sql.ErrNoRows {
+		writeError(c, http.StatusInternalServerError, "INVOICE_FETCH_FAILED", "failed to fetch invoice", err.Error())
+		return
+	}
+	if end.Valid && !invoiced {
+		writeError(c, http.StatusConflict, "BOOKING_NOT_BILLED", "booking was closed without a charge", nil)
+		return
+	}
+	if invoiced && req.AmountPaise > 0 {
+		writeError(c, http.StatusConflict, "BOOKING_INVOICED", "invoiced bookings can only be adjusted downwards", nil)
+		return
+	}
+
+	var refund *refundRecord
+	if invoiced {
+		amount := int64(0) // waiver: everything still refundable
+		if req.Kind == "ADJUSTMENT" {
+			amount = billing.ComputeGST(-req.AmountPaise, rateBP, intraState).Total
+		}
+		refund, err = h.refundBooking(tx, bookingID, amount, req.ReasonCode, req.Note, claims.UserID)
+		if !writeRefundError(c, err) {
+			return
+		}
+	}
+
+	var refundID interface{}
+	if refund != nil {
+		refundID = refund.ID
+	}
+	var id string
+	var created time.Time
+	err = tx.QueryRow(`
+		INSERT INTO booking_adjustments (booking_id, kind, amount_paise, reason_code, note, refund_id, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, created_at
+	`, bookingID, req.Kind, req.AmountPaise, req.ReasonCode, req.Note, refundID, nullIfEmpty(claims.UserID)).Scan(&id, &created)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "ADJUSTMENT_FAILED", "failed to record adjustment", err.Error())
+		return
+	}
+	adjustment := gin.H{"id": id, "kind": req.Kind, "amountPaise": req.AmountPaise, "reasonCode": req.ReasonCode,
+		"note": req.Note, "refund": refund}
+	if err := audit(tx, c, "booking.adjust", "booking", bookingID, nil, adjustment); err != nil {
+		writeAuditError(c, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_COMMIT_FAILED", "failed to commit adjustment", err.Error())
+		return
+	}
+	if refund != nil {
+		h.settleRefund(c.Request.Context(), refund)
+	}
+	c.JSON(http.StatusCreated, gin.H{"data": gin.H{
+		"id":          id,
+		"bookingId":   bookingID,
+		"kind":        req.Kind,
+		"amountPaise": req.AmountPaise,
+		"reasonCode":  req.ReasonCode,
+		"note":        req.Note,
+		"createdAt":   toIST(created),
+		"refund":      refund,
+	}})
+}
+
+type disputeReq struct {
+	Reason string `json:"reason" binding:"required,min=5"`
+}
+
+// OpenDispute lets a user contest the charge on one of their bookings.
+func (h *Handler) OpenDispute(c *gin.Context) {
+	bookingID := c.Param("id")
+	var req disputeReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "invalid request body", err.Error())
+		return
+	}
+	claims := GetClaims(c)
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_BEGIN_FAILED", "could not start transaction", nil)
+		return
+	}
+	defer tx.Rollback()
+
+	before, err := auditSnapshot(tx, "booking", bookingID)
+	if err != nil {
+		writeAuditError(c, err)
+		return
+	}
+	var opened time.Time
+	err = tx.QueryRow(`
+		UPDATE bookings
+		SET dispute_status = 'OPEN', dispute_reason = $3, dispute_opened_at = now()
+		WHERE id = $1 AND user_id = $2 AND dispute_status = 'NONE'
+		RETURNING dispute_opened_at
+	`, bookingID, claims.UserID, req.Reason).Scan(&opened)
+	if err == sql.ErrNoRows {
+		writeError(c, http.StatusConflict, "DISPUTE_NOT_ALLOWED", "booking not found or already disputed", nil)
+		return
+	} else if err != nil {
+		writeError(c, http.StatusInternalServerError, "DISPUTE_FAILED", "failed to open dispute", err.Error())
+		return
+	}
+	if err := auditRow(tx, c, "booking.dispute", "booking", bookingID, before); err != nil {
+		writeAuditError(c, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_COMMIT_FAILED", "failed to commit dispute", err.Error())
+		return
+	}
+
+	writeOK(c, gin.H{"data": gin.H{
+		"bookingId":     bookingID,
+		"disputeStatus": "OPEN",
+		"reason":        req.Reason,
+		"openedAt":      toIST(opened),
+	}})
+}
+
+type resolveDisputeReq struct {
+	Outcome     string `json:"outcome" binding:"required,oneof=ACCEPTED REJECTED"`
+	Note        string `json:"note"`
+	RefundPaise *int64 `json:"refundPaise" binding:"omitempty,min=0"`
+}
+
+// ResolveDispute closes an open dispute. Accepting it may refund the booking:
+// refundPaise 0 refunds in full, a positive value refunds that much.
+func (h *Handler) ResolveDispute(c *gin.Context) {
+	bookingID := c.Param("id")
+	var req resolveDisputeReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "invalid request body", err.Error())
+		return
+	}
+	if req.Outcome == "REJECTED" && req.RefundPaise != nil {
+		writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "a rejected dispute cannot carry a refund", nil)
+		return
+	}
+	claims := GetClaims(c)
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_BEGIN_FAILED", "could not start transaction", nil)
+		return
+	}
+	defer tx.Rollback()
+
+	before, err := auditSnapshot(tx, "booking", bookingID)
+	if err != nil {
+		writeAuditError(c, err)
+		return
+	}
+	var resolved time.Time
+	err = tx.QueryRow(`
+		UPDATE bookings
+		SET dispute_status = $2, dispute_resolution = $3, dispute_resolved_at = now()
+		WHERE id = $1 AND dispute_status = 'OPEN'
+		RETURNING dispute_resolved_at
+	`, bookingID, req.Outcome, req.Note).Scan(&resolved)
+	if err == sql.ErrNoRows {
+		writeError(c, http.StatusConflict, "NO_OPEN_DISPUTE", "booking has no open dispute", nil)
+		return
+	} else if err != nil {
+		writeError(c, http.StatusInternalServerError, "DISPUTE_RESOLVE_FAILED", "failed to resolve dispute", err.Error())
+		return
+	}
+
+	var refund *refundRecord
+	if req.RefundPaise != nil {
+		refund, err = h.refundBooking(tx, bookingID, *req.RefundPaise, "DISPUTE", req.Note, claims.UserID)
+		if !writeRefundError(c, err) {
+			return
+		}
+		if err := audit(tx, c, "booking.refund", "booking", bookingID, nil, refund); err != nil {
+			writeAuditError(c, err)
+			return
+		}
+	}
+	if err := auditRow(tx, c, "booking.resolve_dispute", "booking", bookingID, before); err != nil {
+		writeAuditError(c, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_COMMIT_FAILED", "failed to commit dispute resolution", err.Error())
+		return
+	}
+	if refund != nil {
+		h.settleRefund(c.Request.Context(), refund)
+	}
+	writeOK(c, gin.H{"data": gin.H{
+		"bookingId":     bookingID,
+		"disputeStatus": req.Outcome,
+		"note":          req.Note,
+		"resolvedAt":    toIST(resolved),
+		"refund":        refund,
+	}})
+}
@@ -0,0 +1,385 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"Backend-Go/internal/cron"
+	"Backend-Go/internal/mailer"
+	"Backend-Go/internal/safehttp"
+
+	"github.com/gin-gonic/gin"
+)
+
+// reportRunTimeout bounds generating and delivering one report.
+const reportRunTimeout = 5 * time.Minute
+
+// scheduledReport is a report a schedule can deliver.
+type scheduledReport struct {
+	title string
+	sheet string
+	// period is the span a run on day (midnight, local) covers.
+	period func(day time.Time) (from, to time.Time)
+	write  func(h *Handler, ctx context.Context, t exportTable, f *reportFilter) (int, error)
+}
+
+var scheduledReports = map[string]scheduledReport{
+	"DAILY_OCCUPANCY": {
+		title: "Daily occupancy summary",
+		sheet: "occupancy",
+		period: func(day time.Time) (time.Time, time.Time) {
+			return day.AddDate(0, 0, -1), day.AddDate(0, 0, -1)
+		},
+		write: (*Handler).writeDailyOccupancy,
+	},
+	"WEEKLY_REVENUE": {
+		title: "Weekly revenue",
+		sheet: "revenue",
+		period: func(day time.Time) (time.Time, time.Time) {
+			monday := day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+			return monday.AddDate(0, 0, -7), monday.AddDate(0, 0, -1)
+		},
+		write: (*Handler).writeWeeklyRevenue,
+	},
+	"MONTHLY_INVOICE_REGISTER": {
+		title: "Monthly invoice register",
+		sheet: "invoices",
+		period: func(day time.Time) (time.Time, time.Time) {
+			m := monthStart(day)
+			return m.AddDate(0, -1, 0), m.AddDate(0, 0, -1)
+		},
+		write: (*Handler).writeInvoiceRegister,
+	},
+}
+
+type reportRun struct {
+	ID           string
+	ScheduledFor time.Time
+	Manual       bool
+	From, To     time.Time
+	Status       string
+	Rows         sql.NullInt64
+	Bytes        sql.NullInt64
+	Error        sql.NullString
+	StartedAt    time.Time
+	FinishedAt   sql.NullTime
+}
+
+func (r *reportRun) json() gin.H {
+	var finished interface{}
+	if r.FinishedAt.Valid {
+		finished = toIST(r.FinishedAt.Time)
+	}
+	return gin.H{
+		"id":           r.ID,
+		"scheduledFor": toIST(r.ScheduledFor),
+		"manual":       r.Manual,
+		"periodFrom":   r.From.Format("2006-01-02"),
+		"periodTo":     r.To.Format("2006-01-02"),
+		"status":       r.Status,
+		"rows":         nullInt(r.Rows),
+		"bytes":        nullInt(r.Bytes),
+		"error":        nullString(r.Error),
+		"startedAt":    toIST(r.StartedAt),
+		"finishedAt":   finished,
+	}
+}
+
+// reportFilter is the filter a run of s scheduled for at reports on.
+func (s *reportSchedule) reportFilter(at time.Time) (*reportFilter, error) {
+	kind, ok := scheduledReports[s.Report]
+	if !ok {
+		return nil, fmt.Errorf("unknown report %q", s.Report)
+	}
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return nil, err
+	}
+	at = at.In(loc)
+	day := time.Date(at.Year(), at.Month(), at.Day(), 0, 0, 0, 0, loc)
+	f := &reportFilter{Loc: loc, LotID: s.LotID.String, GroupBy: "day"}
+	f.From, f.To = kind.period(day)
+	return f, nil
+}
+
+// startReportRun records a run of s for at. A scheduled run that another
+// replica already recorded returns nil.
+func (h *Handler) startReportRun(q queryRower, s *reportSchedule, at time.Time, manual bool) (*reportRun, error) {
+	f, err := s.reportFilter(at)
+	if err != nil {
+		return nil, err
+	}
+	r := reportRun{ScheduledFor: at, Manual: manual, From: f.From, To: f.To, Status: "RUNNING"}
+	err = q.QueryRow(`
+		INSERT INTO report_runs (schedule_id, scheduled_for, manual, period_from, period_to)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (schedule_id, scheduled_for) DO NOTHING
+		RETURNING id, started_at
+	`, s.ID, at, manual, f.From.Format("2006-01-02"), f.To.Format("2006-01-02")).Scan(&r.ID, &r.StartedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// RunDueReports generates and delivers every report whose schedule is due,
+// one schedule at a time. Each schedule is claimed and moved on to its next
+// run in a transaction, so replicas do not run it twice; runs missed while
+// no replica was up collapse into one. It runs as a background job.
+func (h *Handler) RunDueReports(ctx context.Context) error {
+	// runs whose replica stopped part-way
+	if _, err := h.DB.ExecContext(ctx, `
+		UPDATE report_runs SET status = 'FAILED', error = 'interrupted', finished_at = now()
+		WHERE status = 'RUNNING' AND started_at < now() - interval '1 hour'
+	`); err != nil {
+		return err
+	}
+	for ctx.Err() == nil {
+		s, run, err := h.claimDueReport(ctx)
+		if err != nil {
+			return err
+		}
+		if s == nil {
+			return nil
+		}
+		if run != nil {
+			h.executeReportRun(ctx, s, run)
+		}
+	}
+	return nil
+}
+
+// claimDueReport takes the most overdue schedule, if any, sets its next run
+// and records the run now due.
+func (h *Handler) claimDueReport(ctx context.Context) (*reportSchedule, *reportRun, error) {
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	s, err := scanReportSchedule(tx.QueryRowContext(ctx, `
+		SELECT `+reportScheduleColumns+`
+		FROM report_schedules
+		WHERE active AND next_run_at <= now()
+		ORDER BY next_run_at
+		LIMIT 1
+		FOR UPDATE SKIP LOCKED
+	`))
+	if err == sql.ErrNoRows {
+		return nil, nil, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	var next sql.NullTime
+	sched, err := cron.Parse(s.Cron)
+	loc, locErr := time.LoadLocation(s.Timezone)
+	if err == nil && locErr == nil {
+		if at := sched.Next(time.Now().In(loc)); !at.IsZero() {
+			next = sql.NullTime{Time: at, Valid: true}
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE report_schedules SET next_run_at = $2 WHERE id = $1
+	`, s.ID, next); err != nil {
+		return nil, nil, err
+	}
+	if !next.Valid {
+		log.Printf("report schedule %s: cannot work out next run; pausing it", s.ID)
+	}
+
+	run, err := h.startReportRun(tx, s, s.NextRunAt.Time, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+	return s, run, nil
+}
+
+// executeReportRun generates run's report, delivers it and records the
+// outcome on run.
+func (h *Handler) executeReportRun(ctx context.Context, s *reportSchedule, run *reportRun) {
+	ctx, cancel := context.WithTimeout(ctx, reportRunTimeout)
+	defer cancel()
+
+	n, size, err := h.generateAndDeliver(ctx, s, run)
+	run.Status = "SUCCEEDED"
+	run.Rows = sql.NullInt64{Int64: int64(n), Valid: true}
+	run.Bytes = sql.NullInt64{Int64: int64(size), Valid: size > 0}
+	if err != nil {
+		run.Status = "FAILED"
+		run.Error = sql.NullString{String: err.Error(), Valid: true}
+		log.Printf("report schedule %s: run %s: %v", s.ID, run.ID, err)
+	}
+	// record the outcome even if ctx has run out
+	if err := h.DB.QueryRow(`
+		UPDATE report_runs SET status = $2, rows = $3, bytes = $4, error = $5, finished_at = now()
+		WHERE id = $1
+		RETURNING finished_at
+	`, run.ID, run.Status, run.Rows, run.Bytes, run.Error).Scan(&run.FinishedAt); err != nil {
+		log.Printf("report schedule %s: record run %s: %v", s.ID, run.ID, err)
+	}
+}
+
+func (h *Handler) generateAndDeliver(ctx context.Context, s *reportSchedule, run *reportRun) (rows, size int, err error) {
+	kind := scheduledReports[s.Report]
+	f, err := s.reportFilter(run.ScheduledFor)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var buf bytes.Buffer
+	t, err := newExportTable(&buf, s.Format, kind.sheet)
+	if err != nil {
+		return 0, 0, err
+	}
+	if rows, err = kind.write(h, ctx, t, f); err != nil {
+		return rows, 0, err
+	}
+
+	period := f.From.Format("2006-01-02")
+	if !f.To.Equal(f.From) {
+		period += " to " + f.To.Format("2006-01-02")
+	}
+	filename := exportFilename(kind.sheet, f, s.Format)
+	switch s.Channel {
+	case "EMAIL":
+		err = h.Mailer.Send(ctx, mailer.Message{
+			To:      s.Recipients,
+			Subject: fmt.Sprintf("%s: %s", s.Name, period),
+			Body: fmt.Sprintf("%s for %s (%s), %d rows.\n\nThis report is sent by the schedule %q.\n",
+				kind.title, period, f.Loc, rows, s.Name),
+			Attachments: []mailer.Attachment{{Filename: filename, ContentType: exportContentType(s.Format), Data: buf.Bytes()}},
+		})
+	case "WEBHOOK":
+		err = postReport(ctx, s, run, filename, buf.Bytes())
+	default:
+		err = fmt.Errorf("unknown channel %q", s.Channel)
+	}
+	return rows, buf.Len(), err
+}
+
+// reportWebhookClient only reaches public addresses and does not follow
+// redirects, since webhook URLs are set through the API.
+var reportWebhookClient = safehttp.NewClient(30 * time.Second)
+
+// postReport sends the file as the body of a POST to the schedule's webhook;
+// any 2xx response counts as delivered.
+func postReport(ctx context.Context, s *reportSchedule, run *reportRun, filename string, data []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.WebhookURL.String, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", exportContentType(s.Format))
+	req.Header.Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	req.Header.Set("X-Report-Schedule-Id", s.ID)
+	req.Header.Set("X-Report-Run-Id", run.ID)
+	req.Header.Set("X-Report-Type", s.Report)
+	req.Header.Set("X-Report-Period-From", run.From.Format("2006-01-02"))
+	req.Header.Set("X-Report-Period-To", run.To.Format("2006-01-02"))
+	resp, err := reportWebhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.New("webhook responded " + resp.Status)
+	}
+	return nil
+}
+
+// writeDailyOccupancy summarises each level's and each lot's occupancy
+// snapshots over f's days.
+func (h *Handler) writeDailyOccupancy(ctx context.Context, t exportTable, f *reportFilter) (int, error) {
+	rows, err := h.DB.QueryContext(ctx, `
+		WITH samples AS (
+			SELECT taken_at, lot_id, level_id::text AS level_id, total, occupied, disabled
+			FROM occupancy_snapshots
+			WHERE taken_at >= $1 AND taken_at < $2 AND ($3 = '' OR lot_id::text = $3)
+			UNION ALL
+			SELECT taken_at, lot_id, NULL, SUM(total), SUM(occupied), SUM(disabled)
+			FROM occupancy_snapshots
+			WHERE taken_at >= $1 AND taken_at < $2 AND ($3 = '' OR lot_id::text = $3)
+			GROUP BY taken_at, lot_id
+		)
+		SELECT l.name, x.level_id, COUNT(*), AVG(x.total)::float8, AVG(x.occupied)::float8,
+		       AVG(x.occupied::float8 / NULLIF(x.total - x.disabled, 0)),
+		       MAX(x.occupied), MAX(x.occupied::float8 / NULLIF(x.total - x.disabled, 0))
+		FROM samples x
+		JOIN parking_lots l ON l.id = x.lot_id
+		GROUP BY l.name, x.lot_id, x.level_id
+		ORDER BY l.name, x.lot_id, x.level_id NULLS FIRST
+	`, f.From, f.To.AddDate(0, 0, 1), f.LotID)
+	if err != nil {
+		return 0, err
+	}
+	header := []string{"Day", "Lot", "Level", "Snapshots", "Avg spots", "Avg occupied", "Avg occupancy rate",
+		"Peak occupied", "Peak occupancy rate"}
+	return copyRows(t, header, rows, func() ([]interface{}, error) {
+		var lot string
+		var level sql.NullString
+		var samples, peak int
+		var total, occupied float64
+		var rate, peakRate sql.NullFloat64
+		if err := rows.Scan(&lot, &level, &samples, &total, &occupied, &rate, &peak, &peakRate); err != nil {
+			return nil, err
+		}
+		levelName := "All levels"
+		if level.Valid {
+			levelName = level.String
+		}
+		return []interface{}{f.From.Format("2006-01-02"), lot, levelName, samples, total, occupied,
+			nullFloat(rate), peak, nullFloat(peakRate)}, nil
+	})
+}
+
+// writeWeeklyRevenue writes the report metrics for each day of f.
+func (h *Handler) writeWeeklyRevenue(_ context.Context, t exportTable, f *reportFilter) (int, error) {
+	periods, err := h.reportMetrics(f)
+	if err != nil {
+		return 0, err
+	}
+	return len(periods), writeReportMetrics(t, f, periods)
+}
+
+// writeInvoiceRegister lists the GST invoices issued on f's days.
+func (h *Handler) writeInvoiceRegister(ctx context.Context, t exportTable, f *reportFilter) (int, error) {
+	rows, err := h.DB.QueryContext(ctx, `
+		SELECT i.invoice_no, i.issued_at, l.name, i.booking_id, i.customer_name, i.customer_gstin,
+		       i.place_of_supply, i.sac_code, i.taxable_paise, i.cgst_paise, i.sgst_paise, i.igst_paise, i.total_paise
+		FROM invoices i
+		JOIN parking_lots l ON l.id = i.lot_id
+		WHERE i.issued_at >= $1 AND i.issued_at < $2 AND ($3 = '' OR i.lot_id::text = $3)
+		ORDER BY l.name, i.issued_at, i.invoice_no
+	`, f.From, f.To.AddDate(0, 0, 1), f.LotID)
+	if err != nil {
+		return 0, err
+	}
+	header := []string{"Invoice no", "Issued (" + f.Loc.String() + ")", "Lot", "Booking ID", "Customer",
+		"Customer GSTIN", "Place of supply", "SAC", "Taxable (INR)", "CGST (INR)", "SGST (INR)", "IGST (INR)",
+		"Total (INR)"}
+	return copyRows(t, header, rows, func() ([]interface{}, error) {
+		var no, lot, bookingID, customer, pos, sac string
+		var issued time.Time
+		var gstin sql.NullString
+		var taxable, cgst, sgst, igst, total int64
+		if err := rows.Scan(&no, &issued, &lot, &bookingID, &customer, &gstin, &pos, &sac,
+			&taxable, &cgst, &sgst, &igst, &total); err != nil {
+			return nil, err
+		}
+		return []interface{}{no, exportTime(sql.NullTime{Time: issued, Valid: true}, f.Loc), lot, bookingID,
+			customer, nullString(gstin), pos, sac, rupees(taxable), rupees(cgst), rupees(sgst), rupees(igst),
+			rupees(total)}, nil
+	})
+}
@@ -0,0 +1,398 @@
+package handler
+
+import (
+	"database/sql"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"Backend-Go/internal/cron"
+
+	"github.com/gin-gonic/gin"
+)
+
+type reportSchedule struct {
+	ID         string
+	Name       string
+	Report     string
+	Cron       string
+	Timezone   string
+	LotID      sql.NullString
+	Format     string
+	Channel    string
+	Recipients []string
+	WebhookURL sql.NullString
+	Active     bool
+	NextRunAt  sql.NullTime
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+const reportScheduleColumns = `id, name, report, cron, timezone, lot_id, format, channel,
+	array_to_string(recipients, ','), webhook_url, active, next_run_at, created_at, updated_at`
+
+func scanReportSchedule(row interface{ Scan(...interface{}) error }) (*reportSchedule, error) {
+	var s reportSchedule
+	var recipients string
+	if err := row.Scan(&s.ID, &s.Name, &s.Report, &s.Cron, &s.Timezone, &s.LotID, &s.Format, &s.Channel,
+		&recipients, &s.WebhookURL, &s.Active, &s.NextRunAt, &s.CreatedAt, &s.UpdatedAt); err != nil {
+		return nil, err
+	}
+	s.Recipients = splitNonEmpty(recipients)
+	return &s, nil
+}
+
+func (s *reportSchedule) json() gin.H {
+	var next interface{}
+	if s.NextRunAt.Valid {
+		next = toIST(s.NextRunAt.Time)
+	}
+	return gin.H{
+		"id":         s.ID,
+		"name":       s.Name,
+		"report":     s.Report,
+		"cron":       s.Cron,
+		"timezone":   s.Timezone,
+		"lotId":      nullString(s.LotID),
+		"format":     s.Format,
+		"channel":    s.Channel,
+		"recipients": s.Recipients,
+		"webhookUrl": nullString(s.WebhookURL),
+		"active":     s.Active,
+		"nextRunAt":  next,
+		"createdAt":  toIST(s.CreatedAt),
+		"updatedAt":  toIST(s.UpdatedAt),
+	}
+}
+
+type reportScheduleReq struct {
+	Name       string   `json:"name" binding:"required,max=100"`
+	Report     string   `json:"report" binding:"required,oneof=DAILY_OCCUPANCY WEEKLY_REVENUE MONTHLY_INVOICE_REGISTER"`
+	Cron       string   `json:"cron" binding:"required"`
+	Timezone   string   `json:"timezone"`
+	LotID      *string  `json:"lotId"`
+	Format     string   `json:"format" binding:"omitempty,oneof=csv xlsx"`
+	Channel    string   `json:"channel" binding:"required,oneof=EMAIL WEBHOOK"`
+	Recipients []string `json:"recipients" binding:"omitempty,max=20,dive,email"`
+	WebhookURL string   `json:"webhookUrl"`
+	Active     *bool    `json:"active"`
+}
+
+// bindReportSchedule reads and checks a schedule body, returning when it
+// next runs (NULL when inactive).
+func bindReportSchedule(c *gin.Context) (*reportScheduleReq, sql.NullTime, bool) {
+	var req reportScheduleReq
+	var next sql.NullTime
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "invalid request body", err.Error())
+		return nil, next, false
+	}
+	if req.Timezone == "" {
+		req.Timezone = "Asia/Kolkata"
+	}
+	if req.Format == "" {
+		req.Format = "xlsx"
+	}
+	loc, err := time.LoadLocation(req.Timezone)
+	if err != nil {
+		writeError(c, http.StatusBadRequest, "INVALID_TIMEZONE", "timezone is not a known IANA zone", nil)
+		return nil, next, false
+	}
+	sched, err := cron.Parse(req.Cron)
+	if err != nil {
+		writeError(c, http.StatusBadRequest, "INVALID_CRON", "cron is not a valid five-field expression", err.Error())
+		return nil, next, false
+	}
+	at := sched.Next(time.Now().In(loc))
+	if at.IsZero() {
+		writeError(c, http.StatusBadRequest, "INVALID_CRON", "cron never runs", nil)
+		return nil, next, false
+	}
+
+	switch req.Channel {
+	case "EMAIL":
+		if len(req.Recipients) == 0 {
+			writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "email delivery needs at least one recipient", nil)
+			return nil, next, false
+		}
+		req.WebhookURL = ""
+	case "WEBHOOK":
+		u, err := url.Parse(req.WebhookURL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "webhook delivery needs an http(s) webhookUrl", nil)
+			return nil, next, false
+		}
+		req.Recipients = nil
+	}
+
+	if req.Active == nil || *req.Active {
+		next = sql.NullTime{Time: at, Valid: true}
+	}
+	return &req, next, true
+}
+
+// CreateReportSchedule adds a recurring report. cron is evaluated in
+// timezone (default IST); each run covers the last full day, week (Monday
+// to Sunday) or month before it, and is emailed to recipients or POSTed to
+// webhookUrl as a CSV or XLSX (default) file.
+func (h *Handler) CreateReportSchedule(c *gin.Context) {
+	req, next, ok := bindReportSchedule(c)
+	if !ok {
+		return
+	}
+	claims := GetClaims(c)
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_BEGIN_FAILED", "could not start transaction", nil)
+		return
+	}
+	defer tx.Rollback()
+
+	s, err := scanReportSchedule(tx.QueryRow(`
+		INSERT INTO report_schedules (name, report, cron, timezone, lot_id, format, channel, recipients,
+		                              webhook_url, active, next_run_at, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, string_to_array($8, ','), $9, $10, $11, $12)
+		RETURNING `+reportScheduleColumns,
+		req.Name, req.Report, req.Cron, req.Timezone, req.LotID, req.Format, req.Channel,
+		strings.Join(req.Recipients, ","), nullIfEmpty(req.WebhookURL), next.Valid, next, nullIfEmpty(claims.UserID)))
+	if err != nil {
+		writeError(c, http.StatusBadRequest, "CREATE_SCHEDULE_FAILED", "could not create report schedule (maybe unknown lot)", err.Error())
+		return
+	}
+	if err := auditRow(tx, c, "report_schedule.create", "report_schedule", s.ID, nil); err != nil {
+		writeAuditError(c, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_COMMIT_FAILED", "failed to commit report schedule", err.Error())
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"data": s.json()})
+}
+
+// ListReportSchedules lists report schedules with their latest run.
+func (h *Handler) ListReportSchedules(c *gin.Context) {
+	rows, err := h.DB.Query(`
+		SELECT ` + reportScheduleColumns + `, r.status, r.finished_at
+		FROM report_schedules s
+		LEFT JOIN LATERAL (
+			SELECT status, finished_at FROM report_runs
+			WHERE schedule_id = s.id ORDER BY started_at DESC LIMIT 1
+		) r ON true
+		ORDER BY s.created_at DESC
+	`)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "SCHEDULES_FETCH_FAILED", "failed to fetch report schedules", err.Error())
+		return
+	}
+	defer rows.Close()
+
+	items := make([]gin.H, 0, 10)
+	for rows.Next() {
+		var lastStatus sql.NullString
+		var lastFinished sql.NullTime
+		var s reportSchedule
+		var recipients string
+		if err := rows.Scan(&s.ID, &s.Name, &s.Report, &s.Cron, &s.Timezone, &s.LotID, &s.Format, &s.Channel,
+			&recipients, &s.WebhookURL, &s.Active, &s.NextRunAt, &s.CreatedAt, &s.UpdatedAt,
+			&lastStatus, &lastFinished); err != nil {
+			writeError(c, http.StatusInternalServerError, "SCAN_ERROR", "failed to read row", err.Error())
+			return
+		}
+		s.Recipients = splitNonEmpty(recipients)
+		item := s.json()
+		item["lastRunStatus"] = nullString(lastStatus)
+		item["lastRunFinishedAt"] = nil
+		if lastFinished.Valid {
+			item["lastRunFinishedAt"] = toIST(lastFinished.Time)
+		}
+		items = append(items, item)
+	}
+	writeOK(c, gin.H{"items": items})
+}
+
+func (h *Handler) findReportSchedule(c *gin.Context) (*reportSchedule, bool) {
+	s, err := scanReportSchedule(h.DB.QueryRow(`
+		SELECT `+reportScheduleColumns+` FROM report_schedules WHERE id = $1
+	`, c.Param("id")))
+	if err == sql.ErrNoRows {
+		writeError(c, http.StatusNotFound, "SCHEDULE_NOT_FOUND", "report schedule not found", nil)
+		return nil, false
+	} else if err != nil {
+		writeError(c, http.StatusInternalServerError, "SCHEDULE_FETCH_FAILED", "failed to fetch report schedule", err.Error())
+		return nil, false
+	}
+	return s, true
+}
+
+// GetReportSchedule returns one report schedule.
+func (h *Handler) GetReportSchedule(c *gin.Context) {
+	s, ok := h.findReportSchedule(c)
+	if !ok {
+		return
+	}
+	writeOK(c, gin.H{"data": s.json()})
+}
+
+// UpdateReportSchedule replaces a schedule's settings and works out its
+// next run afresh.
+func (h *Handler) UpdateReportSchedule(c *gin.Context) {
+	req, next, ok := bindReportSchedule(c)
+	if !ok {
+		return
+	}
+	tx, err := h.DB.Begin()
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_BEGIN_FAILED", "could not start transaction", nil)
+		return
+	}
+	defer tx.Rollback()
+
+	before, err := auditSnapshot(tx, "report_schedule", c.Param("id"))
+	if err != nil {
+		writeAuditError(c, err)
+		return
+	}
+	s, err := scanReportSchedule(tx.QueryRow(`
+		UPDATE report_schedules
+		SET name = $2, report = $3, cron = $4, timezone = $5, lot_id = $6, format = $7, channel = $8,
+		    recipients = string_to_array($9, ','), webhook_url = $10, active = $11, next_run_at = $12,
+		    updated_at = now()
+		WHERE id = $1
+		RETURNING `+reportScheduleColumns,
+		c.Param("id"), req.Name, req.Report, req.Cron, req.Timezone, req.LotID, req.Format, req.Channel,
+		strings.Join(req.Recipients, ","), nullIfEmpty(req.WebhookURL), next.Valid, next))
+	if err == sql.ErrNoRows {
+		writeError(c, http.StatusNotFound, "SCHEDULE_NOT_FOUND", "report schedule not found", nil)
+		return
+	} else if err != nil {
+		writeError(c, http.StatusBadRequest, "UPDATE_SCHEDULE_FAILED", "could not update report schedule (maybe unknown lot)", err.Error())
+		return
+	}
+	if err := auditRow(tx, c, "report_schedule.update", "report_schedule", s.ID, before); err != nil {
+		writeAuditError(c, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_COMMIT_FAILED", "failed to commit report schedule", err.Error())
+		return
+	}
+	writeOK(c, gin.H{"data": s.json()})
+}
+
+// DeleteReportSchedule removes a schedule and its run history.
+func (h *Handler) DeleteReportSchedule(c *gin.Context) {
+	id := c.Param("id")
+	tx, err := h.DB.Begin()
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_BEGIN_FAILED", "could not start transaction", nil)
+		return
+	}
+	defer tx.Rollback()
+
+	before, err := auditSnapshot(tx, "report_schedule", id)
+	if err != nil {
+		writeAuditError(c, err)
+		return
+	}
+	res, err := tx.Exec(`DELETE FROM report_schedules WHERE id = $1`, id)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "DELETE_SCHEDULE_FAILED", "failed to delete report schedule", err.Error())
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		writeError(c, http.StatusNotFound, "SCHEDULE_NOT_FOUND", "report schedule not found", nil)
+		return
+	}
+	if err := audit(tx, c, "report_schedule.delete", "report_schedule", id, before, nil); err != nil {
+		writeAuditError(c, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_COMMIT_FAILED", "failed to commit report schedule deletion", err.Error())
+		return
+	}
+	writeOK(c, gin.H{"message": "report schedule deleted"})
+}
+
+// ListReportRuns pages through a schedule's runs, newest first.
+func (h *Handler) ListReportRuns(c *gin.Context) {
+	page, size, ok := pageParams(c)
+	if !ok {
+		return
+	}
+	if _, ok := h.findReportSchedule(c); !ok {
+		return
+	}
+
+	var total int
+	if err := h.DB.QueryRow(`SELECT COUNT(*) FROM report_runs WHERE schedule_id = $1`, c.Param("id")).Scan(&total); err != nil {
+		writeError(c, http.StatusInternalServerError, "RUNS_FETCH_FAILED", "failed to count report runs", err.Error())
+		return
+	}
+	rows, err := h.DB.Query(`
+		SELECT id, scheduled_for, manual, period_from, period_to, status, rows, bytes, error, started_at, finished_at
+		FROM report_runs
+		WHERE schedule_id = $1
+		ORDER BY started_at DESC, id
+		LIMIT $2 OFFSET $3
+	`, c.Param("id"), size, (page-1)*size)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "RUNS_FETCH_FAILED", "failed to fetch report runs", err.Error())
+		return
+	}
+	defer rows.Close()
+
+	items := make([]gin.H, 0, size)
+	for rows.Next() {
+		var r reportRun
+		if err := rows.Scan(&r.ID, &r.ScheduledFor, &r.Manual, &r.From, &r.To, &r.Status, &r.Rows, &r.Bytes,
+			&r.Error, &r.StartedAt, &r.FinishedAt); err != nil {
+			writeError(c, http.StatusInternalServerError, "SCAN_ERROR", "failed to read row", err.Error())
+			return
+		}
+		items = append(items, r.json())
+	}
+	writeOK(c, gin.H{
+		"items": items,
+		"pagination": gin.H{
+			"page":       page,
+			"pageSize":   size,
+			"total":      total,
+			"totalPages": (total + size - 1) / size,
+		},
+	})
+}
+
+// RunReportNow generates and delivers a schedule's report for the period
+// before now, whether or not the schedule is active, and returns the run.
+func (h *Handler) RunReportNow(c *gin.Context) {
+	s, ok := h.findReportSchedule(c)
+	if !ok {
+		return
+	}
+	tx, err := h.DB.Begin()
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_BEGIN_FAILED", "could not start transaction", nil)
+		return
+	}
+	defer tx.Rollback()
+
+	run, err := h.startReportRun(tx, s, time.Now(), true)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "RUN_FAILED", "failed to start report run", err.Error())
+		return
+	}
+	if err := audit(tx, c, "report_schedule.run", "report_schedule", s.ID, nil, run.json()); err != nil {
+		writeAuditError(c, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_COMMIT_FAILED", "failed to commit report run", err.Error())
+		return
+	}
+	h.executeReportRun(c.Request.Context(), s, run)
+	writeOK(c, gin.H{"data": run.json()})
+}
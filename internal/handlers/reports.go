@@ -1,28 +1,414 @@
 package handler
 
 import (
-    "database/sql"
+	"database/sql"
+	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
-func (h *Handler) Reports(c *gin.Context) {
-    var totalSessions int
-    var avgMinutes sql.NullFloat64
+// maxReportDays bounds the date range of one report.
+const maxReportDays = 731
+
+// reportFilter is the slice of sessions a report covers. Dates are whole
+// days in Loc, from From to To inclusive.
+type reportFilter struct {
+	Loc         *time.Location
+	From, To    time.Time // midnight in Loc
+	LotID       string
+	LevelID     string
+	VehicleType string
+	UserID      string
+	GroupBy     string // day, week, month, or "" for no series
+}
+
+// parseReportFilter reads ?from=&to= (YYYY-MM-DD, default the last 30 days),
+// ?lotId=, ?levelId=, ?vehicleType=, ?userId= and ?groupBy=. Days are cut
+// in ?tz=, else the lot's timezone, else IST.
+func (h *Handler) parseReportFilter(c *gin.Context) (*reportFilter, bool) {
+	f := &reportFilter{
+		LotID:       c.Query("lotId"),
+		LevelID:     c.Query("levelId"),
+		VehicleType: c.Query("vehicleType"),
+		UserID:      c.Query("userId"),
+		GroupBy:     c.DefaultQuery("groupBy", "day"),
+	}
+	if f.GroupBy == "none" {
+		f.GroupBy = ""
+	}
+	if f.GroupBy != "" && f.GroupBy != "day" && f.GroupBy != "week" && f.GroupBy != "month" {
+		writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "groupBy must be day, week, month or none", nil)
+		return nil, false
+	}
+
+	tz := c.Query("tz")
+	if tz == "" && f.LotID != "" {
+		err := h.DB.QueryRow(`SELECT timezone FROM parking_lots WHERE id::text = $1`, f.LotID).Scan(&tz)
+		if err == sql.ErrNoRows {
+			writeError(c, http.StatusNotFound, "LOT_NOT_FOUND", "lot not found", nil)
+			return nil, false
+		} else if err != nil {
+			writeError(c, http.StatusInternalServerError, "LOT_FETCH_FAILED", "failed to fetch lot", err.Error())
+			return nil, false
+		}
+	}
+	if tz == "" {
+		f.Loc = istLoc
+	} else {
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			writeError(c, http.StatusBadRequest, "INVALID_TIMEZONE", "tz is not a known IANA zone", nil)
+			return nil, false
+		}
+		f.Loc = loc
+	}
+
+	now := time.Now().In(f.Loc)
+	f.To = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, f.Loc)
+	f.From = f.To.AddDate(0, 0, -29)
+	for _, p := range []struct {
+		name string
+		dst  *time.Time
+	}{{"from", &f.From}, {"to", &f.To}} {
+		if s := c.Query(p.name); s != "" {
+			d, err := time.ParseInLocation("2006-01-02", s, f.Loc)
+			if err != nil {
+				writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", p.name+" must be a date (YYYY-MM-DD)", nil)
+				return nil, false
+			}
+			*p.dst = d
+		}
+	}
+	if f.To.Before(f.From) {
+		writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "to must not be before from", nil)
+		return nil, false
+	}
+	if f.To.Sub(f.From) > maxReportDays*24*time.Hour {
+		writeError(c, http.StatusBadRequest, "RANGE_TOO_LARGE", "reports cover at most two years", nil)
+		return nil, false
+	}
+	return f, true
+}
+
+// args are the query parameters reportSessionsSQL expects, in order.
+func (f *reportFilter) args() []interface{} {
+	return []interface{}{
+		f.Loc.String(),
+		f.From.Format("2006-01-02"),
+		f.To.AddDate(0, 0, 1).Format("2006-01-02"),
+		f.LotID, f.LevelID, f.VehicleType, f.UserID,
+	}
+}
+
+// echo describes the filter in a response.
+func (f *reportFilter) echo() gin.H {
+	return gin.H{
+		"from":        f.From.Format("2006-01-02"),
+		"to":          f.To.Format("2006-01-02"),
+		"tz":          f.Loc.String(),
+		"lotId":       nullIfEmpty(f.LotID),
+		"levelId":     nullIfEmpty(f.LevelID),
+		"vehicleType": nullIfEmpty(f.VehicleType),
+		"userId":      nullIfEmpty(f.UserID),
+		"groupBy":     nullIfEmpty(f.GroupBy),
+	}
+}
+
+// reportSessionsSQL opens a WITH clause defining, for reportFilter.args:
+//
+//	span   the report's start_at/end_at instants
+//	spots  spots matching lot, level and vehicle type (with their status)
+//	sess   matching sessions overlapping the span; in_span marks those that
+//	       started in it, which are the ones counted and billed
+//	caps   spots in service in each hour of the span (bucket h, count cap)
+//	       of the matching levels, from the hourly occupancy rollups
+//
+// A vehicle type filter matches the vehicle's type, and spots that accept it.
+const reportSessionsSQL = `
+	WITH span AS (
+		SELECT ($2::text::timestamp AT TIME ZONE $1::text) AS start_at,
+		       ($3::text::timestamp AT TIME ZONE $1::text) AS end_at
+	), spots AS (
+		SELECT s.id, s.lot_id, s.status
+		FROM parking_spots s
+		WHERE ($4 = '' OR s.lot_id::text = $4)
+		  AND ($5 = '' OR s.level_id::text = $5)
+		  AND ($6 = '' OR s.vehicle_type IS NULL OR s.vehicle_type = $6)
+	), sess AS (
+		SELECT b.id, b.start_time, b.end_time, b.ticket_code, b.dispute_status,
+		       b.start_time >= span.start_at AS in_span,
+		       (EXTRACT(EPOCH FROM (b.end_time - b.start_time)) / 60.0)::float8 AS mins,
+		       COALESCE(i.total_paise, 0) AS invoiced,
+		       (SELECT COALESCE(SUM(r.amount_paise), 0) FROM refunds r WHERE r.booking_id = b.id AND r.status <> 'FAILED') AS refunded,
+		       b.checked_in_at IS NOT NULL
+		           OR EXISTS (SELECT 1 FROM gate_events e WHERE e.booking_id = b.id AND e.direction = 'ENTRY') AS arrived,
+		       EXISTS (SELECT 1 FROM gates g WHERE g.lot_id = s.lot_id AND g.active) AS gated
+		FROM bookings b
+		JOIN spots s ON s.id = b.spot_id
+		CROSS JOIN span
+		LEFT JOIN vehicles v ON v.id = b.vehicle_id
+		LEFT JOIN invoices i ON i.booking_id = b.id
+		WHERE b.start_time < span.end_at AND COALESCE(b.end_time, now()) > span.start_at
+		  AND ($6 = '' OR v.type = $6)
+		  AND ($7 = '' OR b.user_id::text = $7)
+	), caps AS (
+		SELECT r.bucket_start AS h, SUM(r.total - r.disabled)::float8 AS cap
+		FROM occupancy_rollups r
+		CROSS JOIN span
+		WHERE r.granularity = 'HOUR' AND r.level_id IS NOT NULL
+		  AND r.bucket_start > span.start_at - interval '1 hour' AND r.bucket_start < span.end_at
+		  AND ($4 = '' OR r.lot_id::text = $4)
+		  AND ($5 = '' OR r.level_id::text = $5)
+		GROUP BY r.bucket_start
+	)`
+
+// reportMetricsSQL computes the metrics of each row of a periods relation
+// (period, start_at, end_at) over sess. A session is counted in the period
+// it started in; occupied time is split across the periods it overlaps.
+// Capacity is spot-time in service up to now: each hour at its rolled-up
+// count from caps, or today's count for hours without a rollup (before
+// snapshots, past retention, the current hour) and for vehicle type
+// filters, which rollups cannot apply.
+// Only sessions at gated lots, other than walk-ins, that started over 15
+// minutes ago can be no-shows: those with no check-in or entry event.
+const reportMetricsSQL = `
+	SELECT p.period,
+	       COUNT(x.id) FILTER (WHERE x.start_time >= p.start_at),
+	       COALESCE(SUM(x.mins) FILTER (WHERE x.start_time >= p.start_at), 0),
+	       AVG(x.mins) FILTER (WHERE x.start_time >= p.start_at),
+	       percentile_cont(0.5) WITHIN GROUP (ORDER BY x.mins) FILTER (WHERE x.start_time >= p.start_at AND x.end_time IS NOT NULL),
+	       percentile_cont(0.9) WITHIN GROUP (ORDER BY x.mins) FILTER (WHERE x.start_time >= p.start_at AND x.end_time IS NOT NULL),
+	       COALESCE(SUM(x.invoiced) FILTER (WHERE x.start_time >= p.start_at), 0)::bigint,
+	       COALESCE(SUM(x.refunded) FILTER (WHERE x.start_time >= p.start_at), 0)::bigint,
+	       COALESCE(SUM(EXTRACT(EPOCH FROM
+	           LEAST(COALESCE(x.end_time, now()), p.end_at) - GREATEST(x.start_time, p.start_at))), 0)::float8,
+	       (SELECT COALESCE(SUM(EXTRACT(EPOCH FROM LEAST(h + interval '1 hour', p.end_at, now()) - GREATEST(h, p.start_at))
+	                            * COALESCE(CASE WHEN $6 = '' THEN c.cap END,
+	                                       (SELECT COUNT(*) FROM spots WHERE status <> 'DISABLED'))), 0)::float8
+	        FROM generate_series(date_trunc('hour', p.start_at), LEAST(p.end_at, now()) - interval '1 microsecond', interval '1 hour') h
+	        LEFT JOIN caps c ON c.h = h),
+	       (SELECT COUNT(*) FROM spots WHERE status <> 'DISABLED'),
+	       COUNT(x.id) FILTER (WHERE x.start_time >= p.start_at AND x.gated AND x.ticket_code IS NULL
+	                             AND x.start_time < now() - interval '15 minutes'),
+	       COUNT(x.id) FILTER (WHERE x.start_time >= p.start_at AND x.gated AND x.ticket_code IS NULL
+	                             AND x.start_time < now() - interval '15 minutes' AND NOT x.arrived)
+	FROM periods p
+	LEFT JOIN sess x ON x.start_time < p.end_at AND COALESCE(x.end_time, now()) > p.start_at
+	GROUP BY p.period, p.start_at, p.end_at
+	ORDER BY p.period`
+
+// periodsSQL splits the span into calendar periods of $8 in the report's
+// timezone, clipped to the span.
+const periodsSQL = `, periods AS (
+		SELECT p::date AS period,
+		       GREATEST(p, $2::text::timestamp) AT TIME ZONE $1::text AS start_at,
+		       LEAST(p + ('1 ' || $8::text)::interval, $3::text::timestamp) AT TIME ZONE $1::text AS end_at
+		FROM generate_series(date_trunc($8::text, $2::text::timestamp),
+		                     $3::text::timestamp - interval '1 second',
+		                     ('1 ' || $8::text)::interval) p
+	)`
+
+// wholeSpanSQL is the span as a single period.
+const wholeSpanSQL = `, periods AS (
+		SELECT $2::text::date AS period, start_at, end_at FROM span
+	)`
+
+type reportMetrics struct {
+	Period        time.Time
+	Sessions      int
+	TotalMins     float64
+	AvgMins       sql.NullFloat64
+	MedianMins    sql.NullFloat64
+	P90Mins       sql.NullFloat64
+	InvoicedPaise int64
+	RefundedPaise int64
+	OccupiedSecs  float64
+	CapacitySecs  float64 // spot-seconds in service
+	Spots         int
+	ArrivalKnown  int
+	NoShows       int
+}
 
-    _ = h.DB.QueryRow(`
-        SELECT COUNT(*),
-               AVG(EXTRACT(EPOCH FROM (end_time - start_time))/60.0)
-        FROM bookings
-        WHERE end_time IS NOT NULL
-    `).Scan(&totalSessions, &avgMinutes)
+func (m *reportMetrics) json() gin.H {
+	return gin.H{
+		"sessions":           m.Sessions,
+		"totalDurationMins":  m.TotalMins,
+		"avgDurationMins":    nullFloat(m.AvgMins),
+		"medianDurationMins": nullFloat(m.MedianMins),
+		"p90DurationMins":    nullFloat(m.P90Mins),
+		"revenue": gin.H{
+			"invoicedPaise": m.InvoicedPaise,
+			"refundedPaise": m.RefundedPaise,
+			"netPaise":      m.InvoicedPaise - m.RefundedPaise,
+		},
+		"spots":           m.Spots,
+		"utilisationPct":  m.utilisationPct(),
+		"turnoverPerSpot": m.turnoverPerSpot(),
+		"noShows":         m.NoShows,
+		"noShowRate":      m.noShowRate(),
+	}
+}
+
+// The rates are nil when undefined, e.g. for a lot with no spots.
 
-    var avg float64
-    if avgMinutes.Valid { avg = avgMinutes.Float64 } else { avg = 0 }
+func (m *reportMetrics) utilisationPct() interface{} {
+	if m.CapacitySecs > 0 {
+		return 100 * m.OccupiedSecs / m.CapacitySecs
+	}
+	return nil
+}
+
+func (m *reportMetrics) turnoverPerSpot() interface{} {
+	if m.Spots > 0 {
+		return float64(m.Sessions) / float64(m.Spots)
+	}
+	return nil
+}
 
-    writeOK(c, gin.H{"data": gin.H{
-        "totalSessions":   totalSessions,
-        "avgDurationMins": avg,
-    }})
+func (m *reportMetrics) noShowRate() interface{} {
+	if m.ArrivalKnown > 0 {
+		return float64(m.NoShows) / float64(m.ArrivalKnown)
+	}
+	return nil
 }
 
+func nullFloat(v sql.NullFloat64) interface{} {
+	if v.Valid {
+		return v.Float64
+	}
+	return nil
+}
+
+// reportMetrics runs reportMetricsSQL for f, one row per period, or one row
+// for the whole span when f has no grouping.
+func (h *Handler) reportMetrics(f *reportFilter) ([]reportMetrics, error) {
+	args := f.args()
+	query := reportSessionsSQL + wholeSpanSQL + reportMetricsSQL
+	if f.GroupBy != "" {
+		query = reportSessionsSQL + periodsSQL + reportMetricsSQL
+		args = append(args, f.GroupBy)
+	}
+	rows, err := h.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]reportMetrics, 0, 31)
+	for rows.Next() {
+		var m reportMetrics
+		if err := rows.Scan(&m.Period, &m.Sessions, &m.TotalMins, &m.AvgMins, &m.MedianMins, &m.P90Mins,
+			&m.InvoicedPaise, &m.RefundedPaise, &m.OccupiedSecs, &m.CapacitySecs, &m.Spots,
+			&m.ArrivalKnown, &m.NoShows); err != nil {
+			return nil, err
+		}
+		out = append(out, m)
+	}
+	return out, rows.Err()
+}
+
+// Reports summarises sessions, durations, revenue, utilisation, turnover
+// and no-shows for the filtered sessions (see parseReportFilter), with a
+// series per ?groupBy= period, plus adjustments, promotions and disputes on
+// the sessions that started in the range.
+func (h *Handler) Reports(c *gin.Context) {
+	f, ok := h.parseReportFilter(c)
+	if !ok {
+		return
+	}
+
+	whole := *f
+	whole.GroupBy = ""
+	summary, err := h.reportMetrics(&whole)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "REPORT_FAILED", "failed to compute session report", err.Error())
+		return
+	}
+	series := make([]gin.H, 0, 31)
+	if f.GroupBy != "" {
+		periods, err := h.reportMetrics(f)
+		if err != nil {
+			writeError(c, http.StatusInternalServerError, "REPORT_FAILED", "failed to compute report series", err.Error())
+			return
+		}
+		for _, m := range periods {
+			point := m.json()
+			point["period"] = m.Period.Format("2006-01-02")
+			series = append(series, point)
+		}
+	}
+
+	// money beyond invoices and refunds, and disputes
+	var adjusted int64
+	var waivers, openDisputes, acceptedDisputes, rejectedDisputes int
+	err = h.DB.QueryRow(reportSessionsSQL+`
+		SELECT (SELECT COALESCE(SUM(a.amount_paise), 0) FROM booking_adjustments a
+		        JOIN sess x ON x.id = a.booking_id WHERE x.in_span AND a.kind = 'ADJUSTMENT'),
+		       (SELECT COUNT(*) FROM booking_adjustments a
+		        JOIN sess x ON x.id = a.booking_id WHERE x.in_span AND a.kind = 'WAIVER'),
+		       COUNT(*) FILTER (WHERE dispute_status = 'OPEN'),
+		       COUNT(*) FILTER (WHERE dispute_status = 'ACCEPTED'),
+		       COUNT(*) FILTER (WHERE dispute_status = 'REJECTED')
+		FROM sess
+		WHERE in_span
+	`, f.args()...).Scan(&adjusted, &waivers, &openDisputes, &acceptedDisputes, &rejectedDisputes)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "REPORT_FAILED", "failed to compute revenue report", err.Error())
+		return
+	}
+
+	// promotions: redemptions and discount given per code
+	rows, err := h.DB.Query(reportSessionsSQL+`
+		SELECT p.code, COUNT(r.id), COALESCE(SUM(r.discount_paise), 0)
+		FROM promo_codes p
+		JOIN promo_redemptions r ON r.promo_id = p.id
+		JOIN sess x ON x.id = r.booking_id
+		WHERE x.in_span
+		GROUP BY p.code
+		ORDER BY COUNT(r.id) DESC
+	`, f.args()...)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "REPORT_FAILED", "failed to compute promotions report", err.Error())
+		return
+	}
+	defer rows.Close()
+	promotions := make([]gin.H, 0, 10)
+	var discountTotal int64
+	for rows.Next() {
+		var code string
+		var redemptions, discount int64
+		if err := rows.Scan(&code, &redemptions, &discount); err != nil {
+			writeError(c, http.StatusInternalServerError, "SCAN_ERROR", "failed to read row", err.Error())
+			return
+		}
+		discountTotal += discount
+		promotions = append(promotions, gin.H{"code": code, "redemptions": redemptions, "discountPaise": discount})
+	}
+	if err := rows.Err(); err != nil {
+		writeError(c, http.StatusInternalServerError, "REPORT_FAILED", "failed to compute promotions report", err.Error())
+		return
+	}
+
+	s := summary[0]
+	out := s.json()
+	revenue := out["revenue"].(gin.H)
+	revenue["adjustmentsPaise"] = adjusted
+	revenue["waivers"] = waivers
+	revenue["discountsPaise"] = discountTotal
+
+	writeOK(c, gin.H{"data": gin.H{
+		"filters":         f.echo(),
+		"totalSessions":   s.Sessions,
+		"avgDurationMins": s.AvgMins.Float64,
+		"summary":         out,
+		"revenue":         revenue,
+		"series":          series,
+		"promotions":      promotions,
+		"disputes": gin.H{
+			"open":     openDisputes,
+			"accepted": acceptedDisputes,
+			"rejected": rejectedDisputes,
+		},
+	}})
+}
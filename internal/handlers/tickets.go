@@ -0,0 +1,312 @@
+package handler
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"Backend-Go/internal/anpr"
+	"Backend-Go/internal/billing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ticketAlphabet leaves out characters that are easily misread on a printed
+// ticket (0/O, 1/I/L).
+const ticketAlphabet = "ABCDEFGHJKMNPQRSTUVWXYZ23456789"
+
+const ticketCodeLen = 8
+
+func newTicketCode() (string, error) {
+	max := big.NewInt(int64(len(ticketAlphabet)))
+	b := make([]byte, ticketCodeLen)
+	for i := range b {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b[i] = ticketAlphabet[n.Int64()]
+	}
+	return string(b), nil
+}
+
+// ticketQR is the payload printed as a QR code on the ticket for the exit
+// scanner.
+func ticketQR(code string) string { return "PARKING-TICKET:" + code }
+
+// pickFreeSpot locks and returns an available spot in lotID that fits
+// vehicleType, or "" when the lot is full. Untyped spots take any vehicle;
+// typed ones only their own type.
+func pickFreeSpot(tx *sql.Tx, lotID, vehicleType string) (string, error) {
+	var id string
+	err := tx.QueryRow(`
+		SELECT id FROM parking_spots
+		WHERE lot_id = $1 AND status = 'AVAILABLE'
+		  AND (vehicle_type IS NULL OR vehicle_type = $2)
+		ORDER BY number
+		LIMIT 1
+		FOR UPDATE SKIP LOCKED
+	`, lotID, vehicleType).Scan(&id)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return id, err
+}
+
+type issueTicketReq struct {
+	Plate string `json:"plate" binding:"required"`
+	// Either a spot chosen by the operator, or a lot to pick a free spot in.
+	SpotID      string `json:"spotId"`
+	LotID       string `json:"lotId"`
+	VehicleType string `json:"vehicleType"`
+}
+
+// IssueTicket starts a walk-in session for a plate at entry and returns the
+// ticket code the driver exits with.
+func (h *Handler) IssueTicket(c *gin.Context) {
+	var req issueTicketReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "invalid request body", err.Error())
+		return
+	}
+	plate := anpr.Normalize(req.Plate)
+	if plate == "" {
+		writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "plate is required", nil)
+		return
+	}
+	if (req.SpotID == "") == (req.LotID == "") {
+		writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "exactly one of spotId or lotId is required", nil)
+		return
+	}
+
+	code, err := newTicketCode()
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "TICKET_CODE_FAILED", "failed to generate ticket code", err.Error())
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_BEGIN_FAILED", "could not start transaction", nil)
+		return
+	}
+	defer tx.Rollback()
+
+	spotID := req.SpotID
+	if spotID == "" {
+		if spotID, err = pickFreeSpot(tx, req.LotID, req.VehicleType); err != nil {
+			writeError(c, http.StatusInternalServerError, "SPOT_CHECK_FAILED", "failed to find a free spot", err.Error())
+			return
+		}
+		if spotID == "" {
+			writeError(c, http.StatusConflict, "LOT_FULL", "no free spot in this lot", nil)
+			return
+		}
+	}
+
+	b, berr := h.startBooking(tx, bookingParams{
+		SpotID:     spotID,
+		Plate:      plate,
+		TicketCode: code,
+	})
+	if berr != nil {
+		berr.write(c)
+		return
+	}
+	if err := auditRow(tx, c, "booking.start", "booking", b.ID, nil); err != nil {
+		writeAuditError(c, err)
+		return
+	}
+
+	var spotNumber string
+	if err := tx.QueryRow(`SELECT number FROM parking_spots WHERE id = $1`, spotID).Scan(&spotNumber); err != nil {
+		writeError(c, http.StatusInternalServerError, "SPOT_CHECK_FAILED", "failed to fetch spot", err.Error())
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_COMMIT_FAILED", "failed to commit ticket", err.Error())
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"data": gin.H{
+		"ticketCode": code,
+		"qrPayload":  ticketQR(code),
+		"bookingId":  b.ID,
+		"plate":      plate,
+		"lotId":      b.LotID,
+		"spotId":     spotID,
+		"spotNumber": spotNumber,
+		"status":     "ACTIVE",
+		"startTime":  toIST(b.Start),
+	}})
+}
+
+// GetTicket shows a ticket's session, with the amount due so far for a
+// session still in progress.
+func (h *Handler) GetTicket(c *gin.Context) {
+	code := strings.ToUpper(c.Param("code"))
+
+	var bookingID, plate, spotID, spotNumber, lotID string
+	var start time.Time
+	var end sql.NullTime
+	var amount sql.NullInt64
+	err := h.DB.QueryRow(`
+		SELECT b.id, b.plate, b.spot_id, s.number, s.lot_id, b.start_time, b.end_time, b.amount_paise
+		FROM bookings b
+		JOIN parking_spots s ON s.id = b.spot_id
+		WHERE b.ticket_code = $1
+	`, code).Scan(&bookingID, &plate, &spotID, &spotNumber, &lotID, &start, &end, &amount)
+	if err == sql.ErrNoRows {
+		writeError(c, http.StatusNotFound, "TICKET_NOT_FOUND", "ticket not found", nil)
+		return
+	} else if err != nil {
+		writeError(c, http.StatusInternalServerError, "TICKET_FETCH_FAILED", "failed to fetch ticket", err.Error())
+		return
+	}
+
+	ticket := gin.H{
+		"ticketCode": code,
+		"qrPayload":  ticketQR(code),
+		"bookingId":  bookingID,
+		"plate":      plate,
+		"lotId":      lotID,
+		"spotId":     spotID,
+		"spotNumber": spotNumber,
+		"startTime":  toIST(start),
+	}
+	if end.Valid {
+		ticket["status"] = "COMPLETED"
+		ticket["endTime"] = toIST(end.Time)
+		ticket["amountPaise"] = nullInt(amount)
+		writeOK(c, gin.H{"data": ticket})
+		return
+	}
+
+	ticket["status"] = "ACTIVE"
+	var gstin, lotState string
+	var rateBP int
+	var ratePerHour int64
+	err = h.DB.QueryRow(`
+		SELECT lbp.gstin, lbp.lot_state_code, lbp.gst_rate_bp, COALESCE(bp.rate_paise, lbp.rate_per_hour_paise)
+		FROM lot_billing_profiles lbp
+		LEFT JOIN booking_pricing bp ON bp.booking_id = $2
+		WHERE lbp.lot_id = $1
+	`, lotID, bookingID).Scan(&gstin, &lotState, &rateBP, &ratePerHour)
+	if err == sql.ErrNoRows {
+		ticket["billedHours"] = 0
+		ticket["amountDuePaise"] = 0
+		writeOK(c, gin.H{"data": ticket})
+		return
+	} else if err != nil {
+		writeError(c, http.StatusInternalServerError, "BILLING_FETCH_FAILED", "failed to fetch lot billing", err.Error())
+		return
+	}
+	hours, charge := billing.Tariff{RatePerHour: ratePerHour}.Evaluate(start, time.Now())
+	ticket["billedHours"] = hours
+	ticket["amountDuePaise"] = billing.ComputeGST(charge, rateBP, billing.IntraStateSupply(gstin, lotState)).Total
+	writeOK(c, gin.H{"data": ticket})
+}
+
+type closeTicketReq struct {
+	TicketCode string `json:"ticketCode"`
+	Plate      string `json:"plate"`
+}
+
+// CloseTicket ends a walk-in session at exit, found by ticket code or, for a
+// lost ticket, by plate. It invoices and collects payment like Release.
+func (h *Handler) CloseTicket(c *gin.Context) {
+	var req closeTicketReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "invalid request body", err.Error())
+		return
+	}
+	code := strings.ToUpper(strings.TrimSpace(req.TicketCode))
+	plate := anpr.Normalize(req.Plate)
+	if code == "" && plate == "" {
+		writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "ticketCode or plate is required", nil)
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_BEGIN_FAILED", "could not start transaction", nil)
+		return
+	}
+	defer tx.Rollback()
+
+	var bookingID, spotID string
+	var start, end time.Time
+	var before []byte
+	err = tx.QueryRow(`
+		UPDATE bookings b
+		SET end_time = now()
+		FROM bookings old
+		WHERE old.id = b.id AND b.end_time IS NULL AND b.ticket_code IS NOT NULL
+		  AND (b.ticket_code = $1 OR ($1 = '' AND upper(b.plate) = $2))
+		RETURNING b.id, b.spot_id, b.ticket_code, b.plate, b.start_time, b.end_time, to_jsonb(old)
+	`, code, plate).Scan(&bookingID, &spotID, &code, &plate, &start, &end, &before)
+	if err == sql.ErrNoRows {
+		writeError(c, http.StatusConflict, "NO_ACTIVE_TICKET", "no active ticket found", nil)
+		return
+	} else if err != nil {
+		writeError(c, http.StatusInternalServerError, "BOOKING_CLOSE_FAILED", "failed to close ticket", err.Error())
+		return
+	}
+
+	f, berr := h.finishBooking(tx, bookingID, spotID)
+	if berr != nil {
+		berr.write(c)
+		return
+	}
+	if err := auditRow(tx, c, "booking.ticket_exit", "booking", bookingID, before); err != nil {
+		writeAuditError(c, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_COMMIT_FAILED", "failed to commit exit", err.Error())
+		return
+	}
+	h.settleFinished(c.Request.Context(), f)
+
+	writeOK(c, gin.H{"data": gin.H{
+		"ticketCode":      code,
+		"bookingId":       bookingID,
+		"plate":           plate,
+		"spotId":          spotID,
+		"startTime":       toIST(start),
+		"endTime":         toIST(end),
+		"status":          "COMPLETED",
+		"invoice":         f.Invoice,
+		"payment":         f.Payment,
+		"heldForWaitlist": f.HeldFor != "",
+	}})
+}
+
+// TicketReceipt returns the tax invoice for a closed walk-in ticket, for the
+// operator to print or email.
+func (h *Handler) TicketReceipt(c *gin.Context) {
+	var bookingID string
+	err := h.DB.QueryRow(`SELECT id FROM bookings WHERE ticket_code = $1`, strings.ToUpper(c.Param("code"))).Scan(&bookingID)
+	if err == sql.ErrNoRows {
+		writeError(c, http.StatusNotFound, "TICKET_NOT_FOUND", "ticket not found", nil)
+		return
+	} else if err != nil {
+		writeError(c, http.StatusInternalServerError, "TICKET_FETCH_FAILED", "failed to fetch ticket", err.Error())
+		return
+	}
+
+	r, _, err := h.loadReceipt(bookingID)
+	if err == sql.ErrNoRows {
+		writeError(c, http.StatusNotFound, "RECEIPT_NOT_FOUND", "no invoice exists for this ticket", nil)
+		return
+	} else if err != nil {
+		writeError(c, http.StatusInternalServerError, "RECEIPT_FETCH_FAILED", "failed to fetch receipt", err.Error())
+		return
+	}
+	writeReceipt(c, r)
+}
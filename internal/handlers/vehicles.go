@@ -19,8 +19,15 @@ func (h *Handler) AddVehicle(c *gin.Context) {
     }
     claims := GetClaims(c)
 
+    tx, err := h.DB.Begin()
+    if err != nil {
+        writeError(c, http.StatusInternalServerError, "TX_BEGIN_FAILED", "could not start transaction", nil)
+        return
+    }
+    defer tx.Rollback()
+
     var id string
-    err := h.DB.QueryRow(`
+    err = tx.QueryRow(`
         INSERT INTO vehicles (user_id, plate, type)
         VALUES ($1, $2, $3)
         RETURNING id
@@ -29,6 +36,14 @@ func (h *Handler) AddVehicle(c *gin.Context) {
         writeError(c, http.StatusBadRequest, "ADD_VEHICLE_FAILED", "could not add vehicle (maybe duplicate plate)", err.Error())
         return
     }
+    if err := auditRow(tx, c, "vehicle.create", "vehicle", id, nil); err != nil {
+        writeAuditError(c, err)
+        return
+    }
+    if err := tx.Commit(); err != nil {
+        writeError(c, http.StatusInternalServerError, "TX_COMMIT_FAILED", "failed to commit vehicle", err.Error())
+        return
+    }
 
     writeOK(c, gin.H{"data": gin.H{
         "id":     id,
@@ -0,0 +1,433 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"time"
+
+	"Backend-Go/internal/outbox"
+
+	"github.com/gin-gonic/gin"
+)
+
+// offerSpot hands a just-freed spot to the longest-waiting entry on its lot
+// whose vehicle fits the spot, returning that entry's id ("" when nobody is
+// waiting). The caller marks the spot HELD.
+func (h *Handler) offerSpot(tx *sql.Tx, spotID string) (string, error) {
+	var entryID string
+	err := tx.QueryRow(`
+		SELECT w.id
+		FROM waitlist_entries w
+		JOIN vehicles v ON v.id = w.vehicle_id
+		JOIN parking_spots s ON s.id = $1
+		WHERE w.lot_id = s.lot_id AND w.status = 'WAITING'
+		  AND (s.vehicle_type IS NULL OR s.vehicle_type = v.type)
+		ORDER BY w.created_at
+		LIMIT 1
+		FOR UPDATE OF w SKIP LOCKED
+	`, spotID).Scan(&entryID)
+	if err == sql.ErrNoRows {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	d := outbox.WaitlistData{EntryID: entryID, SpotID: spotID}
+	err = tx.QueryRow(`
+		UPDATE waitlist_entries
+		SET status = 'OFFERED', spot_id = $2, offered_at = now(),
+		    offer_expires_at = now() + make_interval(mins => $3)
+		WHERE id = $1
+		RETURNING lot_id, user_id, vehicle_id, offer_expires_at
+	`, entryID, spotID, h.Cfg.WaitlistHoldMinutes).Scan(&d.LotID, &d.UserID, &d.VehicleID, &d.ExpiresAt)
+	if err != nil {
+		return "", err
+	}
+	if err := outbox.Write(tx, outbox.WaitlistOffered, "waitlist_entry", entryID, d); err != nil {
+		return "", err
+	}
+	return entryID, nil
+}
+
+// freeSpot works out what a spot nobody is using becomes: RESERVED for its
+// pass, HELD for the next waitlisted vehicle, or AVAILABLE.
+func (h *Handler) freeSpot(tx *sql.Tx, spotID string) (status, heldFor string, err error) {
+	status, err = idleSpotStatus(tx, spotID)
+	if err != nil || status != "AVAILABLE" {
+		return status, "", err
+	}
+	heldFor, err = h.offerSpot(tx, spotID)
+	if err != nil {
+		return "", "", err
+	}
+	if heldFor != "" {
+		status = "HELD"
+	}
+	return status, heldFor, nil
+}
+
+// releaseHold passes a HELD spot whose offer lapsed or was declined on to
+// the next person in the queue.
+func (h *Handler) releaseHold(tx *sql.Tx, spotID string) error {
+	var status string
+	err := tx.QueryRow(`SELECT status FROM parking_spots WHERE id = $1 FOR UPDATE`, spotID).Scan(&status)
+	if err == sql.ErrNoRows || (err == nil && status != "HELD") {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	status, _, err = h.freeSpot(tx, spotID)
+	if err != nil {
+		return err
+	}
+	return setSpotStatus(tx, spotID, status, "")
+}
+
+// queuePosition is an entry's 1-based place among those still waiting.
+func queuePosition(q queryRower, lotID string, joined time.Time) (int, error) {
+	var n int
+	err := q.QueryRow(`
+		SELECT COUNT(*) FROM waitlist_entries
+		WHERE lot_id = $1 AND status = 'WAITING' AND created_at <= $2
+	`, lotID, joined).Scan(&n)
+	return n, err
+}
+
+type joinWaitlistReq struct {
+	LotID     string `json:"lotId" binding:"required"`
+	VehicleID string `json:"vehicleId" binding:"required"`
+}
+
+// JoinWaitlist queues a vehicle for the next compatible spot to free up in a
+// lot. Only full lots can be joined.
+func (h *Handler) JoinWaitlist(c *gin.Context) {
+	var req joinWaitlistReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "invalid request body", err.Error())
+		return
+	}
+	claims := GetClaims(c)
+
+	var vehicleType string
+	err := h.DB.QueryRow(`
+		SELECT v.type
+		FROM vehicles v
+		LEFT JOIN org_members m ON m.org_id = v.org_id AND m.user_id = $2
+		WHERE v.id = $1 AND ((v.org_id IS NULL AND v.user_id = $2) OR m.user_id IS NOT NULL)
+	`, req.VehicleID, claims.UserID).Scan(&vehicleType)
+	if err == sql.ErrNoRows {
+		writeError(c, http.StatusForbidden, "VEHICLE_NOT_OWNED", "vehicle does not belong to user", nil)
+		return
+	} else if err != nil {
+		writeError(c, http.StatusInternalServerError, "VEHICLE_CHECK_FAILED", "failed to check vehicle", err.Error())
+		return
+	}
+
+	var lotExists, spotFree bool
+	err = h.DB.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM parking_lots WHERE id = $1),
+		       EXISTS (SELECT 1 FROM parking_spots
+		               WHERE lot_id = $1 AND status = 'AVAILABLE'
+		                 AND (vehicle_type IS NULL OR vehicle_type = $2))
+	`, req.LotID, vehicleType).Scan(&lotExists, &spotFree)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "LOT_CHECK_FAILED", "failed to check lot", err.Error())
+		return
+	}
+	if !lotExists {
+		writeError(c, http.StatusNotFound, "LOT_NOT_FOUND", "parking lot not found", nil)
+		return
+	}
+	if spotFree {
+		writeError(c, http.StatusConflict, "SPOTS_AVAILABLE", "this lot has a free spot for your vehicle; book it directly", nil)
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_BEGIN_FAILED", "could not start transaction", nil)
+		return
+	}
+	defer tx.Rollback()
+
+	var id string
+	var created time.Time
+	err = tx.QueryRow(`
+		INSERT INTO waitlist_entries (lot_id, user_id, vehicle_id)
+		VALUES ($1, $2, $3)
+		RETURNING id, created_at
+	`, req.LotID, claims.UserID, req.VehicleID).Scan(&id, &created)
+	if err != nil {
+		writeError(c, http.StatusConflict, "ALREADY_WAITING", "vehicle is already on a waitlist", err.Error())
+		return
+	}
+	position, err := queuePosition(tx, req.LotID, created)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "WAITLIST_FETCH_FAILED", "failed to fetch queue position", err.Error())
+		return
+	}
+	if err := auditRow(tx, c, "waitlist_entry.join", "waitlist_entry", id, nil); err != nil {
+		writeAuditError(c, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_COMMIT_FAILED", "failed to commit waitlist entry", err.Error())
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"data": gin.H{
+		"id":        id,
+		"lotId":     req.LotID,
+		"vehicleId": req.VehicleID,
+		"status":    "WAITING",
+		"position":  position,
+		"createdAt": toIST(created),
+	}})
+}
+
+// MyWaitlist lists the caller's open waitlist entries with their place in
+// the queue or the spot they are being offered.
+func (h *Handler) MyWaitlist(c *gin.Context) {
+	claims := GetClaims(c)
+	rows, err := h.DB.Query(`
+		SELECT w.id, w.lot_id, w.vehicle_id, w.status, w.spot_id, w.offer_expires_at, w.created_at,
+		       (SELECT COUNT(*) FROM waitlist_entries q
+		        WHERE q.lot_id = w.lot_id AND q.status = 'WAITING' AND q.created_at <= w.created_at)
+		FROM waitlist_entries w
+		WHERE w.user_id = $1 AND w.status IN ('WAITING', 'OFFERED')
+		ORDER BY w.created_at
+	`, claims.UserID)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "WAITLIST_FETCH_FAILED", "failed to fetch waitlist", err.Error())
+		return
+	}
+	defer rows.Close()
+
+	items := make([]gin.H, 0, 4)
+	for rows.Next() {
+		var id, lotID, vehicleID, status string
+		var spotID sql.NullString
+		var expires sql.NullTime
+		var created time.Time
+		var position int
+		if err := rows.Scan(&id, &lotID, &vehicleID, &status, &spotID, &expires, &created, &position); err != nil {
+			writeError(c, http.StatusInternalServerError, "SCAN_ERROR", "failed to read row", err.Error())
+			return
+		}
+		item := gin.H{
+			"id":        id,
+			"lotId":     lotID,
+			"vehicleId": vehicleID,
+			"status":    status,
+			"createdAt": toIST(created),
+		}
+		if status == "WAITING" {
+			item["position"] = position
+		} else {
+			item["spotId"] = nullString(spotID)
+			item["offerExpiresAt"] = toIST(expires.Time)
+		}
+		items = append(items, item)
+	}
+	writeOK(c, gin.H{"items": items})
+}
+
+// CancelWaitlist leaves the queue, or declines a pending offer, in which
+// case the held spot moves on to the next person.
+func (h *Handler) CancelWaitlist(c *gin.Context) {
+	id := c.Param("id")
+	claims := GetClaims(c)
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_BEGIN_FAILED", "could not start transaction", nil)
+		return
+	}
+	defer tx.Rollback()
+
+	var status string
+	var spotID sql.NullString
+	err = tx.QueryRow(`
+		SELECT status, spot_id FROM waitlist_entries WHERE id = $1 AND user_id = $2 FOR UPDATE
+	`, id, claims.UserID).Scan(&status, &spotID)
+	if err == sql.ErrNoRows {
+		writeError(c, http.StatusNotFound, "WAITLIST_ENTRY_NOT_FOUND", "waitlist entry not found", nil)
+		return
+	} else if err != nil {
+		writeError(c, http.StatusInternalServerError, "WAITLIST_FETCH_FAILED", "failed to fetch waitlist entry", err.Error())
+		return
+	}
+	if status != "WAITING" && status != "OFFERED" {
+		writeError(c, http.StatusConflict, "WAITLIST_ENTRY_CLOSED", "waitlist entry is already "+status, nil)
+		return
+	}
+
+	before, err := auditSnapshot(tx, "waitlist_entry", id)
+	if err != nil {
+		writeAuditError(c, err)
+		return
+	}
+	if _, err := tx.Exec(`UPDATE waitlist_entries SET status = 'CANCELLED' WHERE id = $1`, id); err != nil {
+		writeError(c, http.StatusInternalServerError, "WAITLIST_UPDATE_FAILED", "failed to cancel waitlist entry", err.Error())
+		return
+	}
+	if status == "OFFERED" && spotID.Valid {
+		if err := h.releaseHold(tx, spotID.String); err != nil {
+			writeError(c, http.StatusInternalServerError, "WAITLIST_OFFER_FAILED", "failed to pass on held spot", err.Error())
+			return
+		}
+	}
+	if err := auditRow(tx, c, "waitlist_entry.cancel", "waitlist_entry", id, before); err != nil {
+		writeAuditError(c, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_COMMIT_FAILED", "failed to commit cancellation", err.Error())
+		return
+	}
+	writeOK(c, gin.H{"data": gin.H{"id": id, "status": "CANCELLED"}})
+}
+
+type confirmOfferReq struct {
+	PromoCode string `json:"promoCode"`
+}
+
+// ConfirmWaitlistOffer takes up a held spot before the offer runs out,
+// starting a booking on it exactly as BookSpot would.
+func (h *Handler) ConfirmWaitlistOffer(c *gin.Context) {
+	id := c.Param("id")
+	var req confirmOfferReq
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "invalid request body", err.Error())
+			return
+		}
+	}
+	claims := GetClaims(c)
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_BEGIN_FAILED", "could not start transaction", nil)
+		return
+	}
+	defer tx.Rollback()
+
+	var status, vehicleID string
+	var spotID sql.NullString
+	var expires sql.NullTime
+	err = tx.QueryRow(`
+		SELECT status, vehicle_id, spot_id, offer_expires_at
+		FROM waitlist_entries
+		WHERE id = $1 AND user_id = $2
+		FOR UPDATE
+	`, id, claims.UserID).Scan(&status, &vehicleID, &spotID, &expires)
+	if err == sql.ErrNoRows {
+		writeError(c, http.StatusNotFound, "WAITLIST_ENTRY_NOT_FOUND", "waitlist entry not found", nil)
+		return
+	} else if err != nil {
+		writeError(c, http.StatusInternalServerError, "WAITLIST_FETCH_FAILED", "failed to fetch waitlist entry", err.Error())
+		return
+	}
+	if status != "OFFERED" || !spotID.Valid {
+		writeError(c, http.StatusConflict, "NO_OFFER", "there is no spot on offer for this entry", nil)
+		return
+	}
+	if !time.Now().Before(expires.Time) {
+		writeError(c, http.StatusGone, "OFFER_EXPIRED", "the offer for this spot has expired", nil)
+		return
+	}
+
+	before, err := auditSnapshot(tx, "waitlist_entry", id)
+	if err != nil {
+		writeAuditError(c, err)
+		return
+	}
+	b, berr := h.startBooking(tx, bookingParams{
+		UserID:    claims.UserID,
+		VehicleID: vehicleID,
+		SpotID:    spotID.String,
+		PromoCode: req.PromoCode,
+		ClaimHold: true,
+	})
+	if berr != nil {
+		berr.write(c)
+		return
+	}
+	if _, err := tx.Exec(`
+		UPDATE waitlist_entries SET status = 'CONFIRMED', booking_id = $2 WHERE id = $1
+	`, id, b.ID); err != nil {
+		writeError(c, http.StatusInternalServerError, "WAITLIST_UPDATE_FAILED", "failed to confirm waitlist entry", err.Error())
+		return
+	}
+	if err := auditRow(tx, c, "booking.start", "booking", b.ID, nil); err != nil {
+		writeAuditError(c, err)
+		return
+	}
+	if err := auditRow(tx, c, "waitlist_entry.confirm", "waitlist_entry", id, before); err != nil {
+		writeAuditError(c, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_COMMIT_FAILED", "failed to commit booking", err.Error())
+		return
+	}
+
+	writeOK(c, gin.H{"data": gin.H{
+		"waitlistEntryId": id,
+		"bookingId":       b.ID,
+		"userId":          claims.UserID,
+		"vehicleId":       vehicleID,
+		"spotId":          spotID.String,
+		"status":          "ACTIVE",
+		"startTime":       toIST(b.Start),
+		"passId":          nullIfEmpty(b.PassID),
+		"orgId":           nullString(b.OrgID),
+	}})
+}
+
+// ExpireWaitlistOffers lapses offers that were not confirmed in time and
+// passes their spots on. It runs as a background job.
+func (h *Handler) ExpireWaitlistOffers(ctx context.Context) error {
+	for ctx.Err() == nil {
+		done, err := h.expireOneOffer(ctx)
+		if err != nil || done {
+			return err
+		}
+	}
+	return ctx.Err()
+}
+
+func (h *Handler) expireOneOffer(ctx context.Context) (bool, error) {
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var id string
+	var spotID sql.NullString
+	err = tx.QueryRow(`
+		SELECT id, spot_id FROM waitlist_entries
+		WHERE status = 'OFFERED' AND offer_expires_at <= now()
+		ORDER BY offer_expires_at
+		LIMIT 1
+		FOR UPDATE SKIP LOCKED
+	`).Scan(&id, &spotID)
+	if err == sql.ErrNoRows {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	if _, err := tx.Exec(`UPDATE waitlist_entries SET status = 'EXPIRED' WHERE id = $1`, id); err != nil {
+		return false, err
+	}
+	if spotID.Valid {
+		if err := h.releaseHold(tx, spotID.String); err != nil {
+			return false, err
+		}
+	}
+	return false, tx.Commit()
+}
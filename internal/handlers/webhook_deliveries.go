@@ -0,0 +1,238 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"Backend-Go/internal/outbox"
+	"Backend-Go/internal/safehttp"
+)
+
+const (
+	// webhookBatch is how many deliveries are claimed, and sent in
+	// parallel, at a time; webhookLease is how long a claim holds.
+	webhookBatch = 20
+	webhookLease = 2 * time.Minute
+	// webhookMaxAttempts is how many times a delivery is tried before it is
+	// dead-lettered, about a day with the outbox backoff.
+	webhookMaxAttempts = 30
+)
+
+// webhookClient only reaches public addresses and does not follow
+// redirects, since subscribers choose the URLs.
+var webhookClient = safehttp.NewClient(10 * time.Second)
+
+// webhookPayload is the body of every webhook POST.
+type webhookPayload struct {
+	ID         int64           `json:"id"`
+	Type       string          `json:"type"`
+	OccurredAt time.Time       `json:"occurredAt"`
+	Data       json.RawMessage `json:"data"`
+}
+
+// webhookSink queues outbox events for the active subscriptions that want
+// them. Queueing is idempotent, so an event the outbox hands over twice is
+// still sent once.
+type webhookSink struct{ db *sql.DB }
+
+// WebhookSink returns the outbox sink feeding webhook subscriptions.
+func (h *Handler) WebhookSink() outbox.Sink { return webhookSink{h.DB} }
+
+func (webhookSink) Name() string { return "webhooks" }
+
+func (s webhookSink) Deliver(ctx context.Context, e outbox.Event) error {
+	payload, err := json.Marshal(webhookPayload{ID: e.ID, Type: e.Type, OccurredAt: toIST(e.OccurredAt), Data: e.Data})
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO webhook_deliveries (subscription_id, event_id, event_type, payload)
+		SELECT id, $1, $2, $3 FROM webhook_subscriptions
+		WHERE active AND (cardinality(event_types) = 0 OR $2 = ANY (event_types))
+		ON CONFLICT (subscription_id, event_id) WHERE replay_of IS NULL DO NOTHING
+	`, e.ID, e.Type, string(payload))
+	return err
+}
+
+// signWebhook is the X-Webhook-Signature of body sent at t: "v1=" and the
+// hex HMAC-SHA256, keyed with the subscription's secret, of the Unix time in
+// seconds, a dot and the body.
+func signWebhook(secret string, t time.Time, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(t.Unix(), 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// claimedWebhook is a delivery leased for sending.
+type claimedWebhook struct {
+	ID        string
+	EventID   int64
+	EventType string
+	Payload   []byte
+	Attempts  int
+	URL       string
+	Secret    string
+}
+
+// DeliverWebhooks sends due webhook deliveries until none are left. It runs
+// as a background job on every replica; deliveries are leased, so each
+// attempt is made by one of them.
+func (h *Handler) DeliverWebhooks(ctx context.Context) error {
+	for ctx.Err() == nil {
+		batch, err := h.claimWebhooks(ctx)
+		if err != nil {
+			return err
+		}
+		var wg sync.WaitGroup
+		for _, d := range batch {
+			wg.Add(1)
+			go func(d claimedWebhook) {
+				defer wg.Done()
+				if err := h.sendWebhook(ctx, d); err != nil {
+					log.Printf("webhook delivery %s: %v", d.ID, err)
+				}
+			}(d)
+		}
+		wg.Wait()
+		if len(batch) < webhookBatch {
+			return nil
+		}
+	}
+	return nil
+}
+
+func (h *Handler) claimWebhooks(ctx context.Context) ([]claimedWebhook, error) {
+	rows, err := h.DB.QueryContext(ctx, `
+		UPDATE webhook_deliveries d
+		SET locked_until = now() + $2 * interval '1 second', attempts = d.attempts + 1
+		FROM webhook_subscriptions s
+		WHERE s.id = d.subscription_id AND d.id IN (
+			SELECT w.id FROM webhook_deliveries w
+			JOIN webhook_subscriptions ws ON ws.id = w.subscription_id
+			WHERE w.status = 'PENDING' AND ws.active AND w.next_attempt_at <= now()
+			  AND (w.locked_until IS NULL OR w.locked_until < now())
+			ORDER BY w.next_attempt_at
+			LIMIT $1
+			FOR UPDATE OF w SKIP LOCKED
+		)
+		RETURNING d.id, d.event_id, d.event_type, d.payload, d.attempts, s.url, s.secret
+	`, webhookBatch, webhookLease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []claimedWebhook
+	for rows.Next() {
+		var d claimedWebhook
+		if err := rows.Scan(&d.ID, &d.EventID, &d.EventType, &d.Payload, &d.Attempts, &d.URL, &d.Secret); err != nil {
+			return nil, err
+		}
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
+
+// sendWebhook makes one attempt at d and records it. Any 2xx response is
+// success; anything else is retried with backoff until the delivery is
+// dead-lettered.
+func (h *Handler) sendWebhook(ctx context.Context, d claimedWebhook) error {
+	started := time.Now()
+	status, sendErr := postWebhook(ctx, d, started)
+	if ctx.Err() != nil {
+		// shutting down; the lease lapses and the attempt is made again
+		return nil
+	}
+	elapsed := time.Since(started)
+
+	var errText interface{}
+	if sendErr != nil {
+		errText = sendErr.Error()
+	}
+	var code interface{}
+	if status != 0 {
+		code = status
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`
+		INSERT INTO webhook_attempts (delivery_id, attempted_at, status_code, error, duration_ms)
+		VALUES ($1, $2, $3, $4, $5)
+	`, d.ID, started, code, errText, elapsed.Milliseconds()); err != nil {
+		return err
+	}
+
+	switch {
+	case sendErr == nil:
+		_, err = tx.Exec(`
+			UPDATE webhook_deliveries
+			SET status = 'SUCCEEDED', delivered_at = now(), locked_until = NULL,
+			    last_status_code = $2, last_error = NULL
+			WHERE id = $1
+		`, d.ID, code)
+	case d.Attempts >= webhookMaxAttempts:
+		_, err = tx.Exec(`
+			UPDATE webhook_deliveries
+			SET status = 'DEAD', locked_until = NULL, last_status_code = $2, last_error = $3
+			WHERE id = $1
+		`, d.ID, code, errText)
+	default:
+		_, err = tx.Exec(`
+			UPDATE webhook_deliveries
+			SET next_attempt_at = now() + $2 * interval '1 second', locked_until = NULL,
+			    last_status_code = $3, last_error = $4
+			WHERE id = $1
+		`, d.ID, outbox.Backoff(d.Attempts).Seconds(), code, errText)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// postWebhook POSTs d's payload, returning the response status (0 if none
+// came back). The response body is not kept, so a URL cannot be used to
+// read back what some other server returns.
+func postWebhook(ctx context.Context, d claimedWebhook, at time.Time) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "parking-backend-webhooks")
+	req.Header.Set("X-Webhook-Delivery", d.ID)
+	req.Header.Set("X-Webhook-Event", d.EventType)
+	req.Header.Set("X-Webhook-Event-Id", strconv.FormatInt(d.EventID, 10))
+	req.Header.Set("X-Webhook-Timestamp", strconv.FormatInt(at.Unix(), 10))
+	req.Header.Set("X-Webhook-Signature", signWebhook(d.Secret, at, d.Payload))
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// drained so the connection can be reused
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, errors.New("webhook responded " + resp.Status)
+	}
+	return resp.StatusCode, nil
+}
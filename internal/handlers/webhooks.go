@@ -0,0 +1,539 @@
+package handler
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"Backend-Go/internal/outbox"
+
+	"github.com/gin-gonic/gin"
+)
+
+type webhookSubscription struct {
+	ID         string
+	Name       string
+	URL        string
+	EventTypes []string
+	Active     bool
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+const webhookColumns = `id, name, url, array_to_string(event_types, ','), active, created_at, updated_at`
+
+func scanWebhook(row interface{ Scan(...interface{}) error }) (*webhookSubscription, error) {
+	var w webhookSubscription
+	var types string
+	if err := row.Scan(&w.ID, &w.Name, &w.URL, &types, &w.Active, &w.CreatedAt, &w.UpdatedAt); err != nil {
+		return nil, err
+	}
+	w.EventTypes = splitNonEmpty(types)
+	return &w, nil
+}
+
+func (w *webhookSubscription) json() gin.H {
+	return gin.H{
+		"id":         w.ID,
+		"name":       w.Name,
+		"url":        w.URL,
+		"eventTypes": w.EventTypes,
+		"active":     w.Active,
+		"createdAt":  toIST(w.CreatedAt),
+		"updatedAt":  toIST(w.UpdatedAt),
+	}
+}
+
+// newWebhookSecret generates a signing secret for a subscription.
+func newWebhookSecret() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(raw), nil
+}
+
+type webhookReq struct {
+	Name string `json:"name" binding:"required,max=100"`
+	URL  string `json:"url" binding:"required"`
+	// EventTypes limits deliveries to these event types; empty sends all.
+	EventTypes []string `json:"eventTypes"`
+	Active     *bool    `json:"active"`
+}
+
+func bindWebhook(c *gin.Context) (*webhookReq, bool) {
+	var req webhookReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "invalid request body", err.Error())
+		return nil, false
+	}
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "url must be an http(s) URL", nil)
+		return nil, false
+	}
+	known := make(map[string]bool, len(outbox.Types))
+	for _, t := range outbox.Types {
+		known[t] = true
+	}
+	for _, t := range req.EventTypes {
+		if !known[t] {
+			writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "unknown event type "+t, gin.H{"eventTypes": outbox.Types})
+			return nil, false
+		}
+	}
+	if req.Active == nil {
+		active := true
+		req.Active = &active
+	}
+	return &req, true
+}
+
+// CreateWebhook subscribes a partner URL to domain events. The signing
+// secret is returned this once (and again on rotation).
+func (h *Handler) CreateWebhook(c *gin.Context) {
+	req, ok := bindWebhook(c)
+	if !ok {
+		return
+	}
+	secret, err := newWebhookSecret()
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "KEY_GENERATION_FAILED", "failed to generate webhook secret", err.Error())
+		return
+	}
+	claims := GetClaims(c)
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_BEGIN_FAILED", "could not start transaction", nil)
+		return
+	}
+	defer tx.Rollback()
+
+	w, err := scanWebhook(tx.QueryRow(`
+		INSERT INTO webhook_subscriptions (name, url, secret, event_types, active, created_by)
+		VALUES ($1, $2, $3, string_to_array($4, ','), $5, $6)
+		RETURNING `+webhookColumns,
+		req.Name, req.URL, secret, strings.Join(req.EventTypes, ","), *req.Active, nullIfEmpty(claims.UserID)))
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "CREATE_WEBHOOK_FAILED", "could not create webhook", err.Error())
+		return
+	}
+	if err := auditRow(tx, c, "webhook.create", "webhook", w.ID, nil); err != nil {
+		writeAuditError(c, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_COMMIT_FAILED", "failed to commit webhook", err.Error())
+		return
+	}
+	data := w.json()
+	data["secret"] = secret
+	c.JSON(http.StatusCreated, gin.H{"data": data})
+}
+
+// ListWebhooks lists webhook subscriptions with how many deliveries are
+// pending and dead-lettered.
+func (h *Handler) ListWebhooks(c *gin.Context) {
+	rows, err := h.DB.Query(`
+		SELECT ` + webhookColumns + `,
+		       (SELECT COUNT(*) FROM webhook_deliveries d WHERE d.subscription_id = w.id AND d.status = 'PENDING'),
+		       (SELECT COUNT(*) FROM webhook_deliveries d WHERE d.subscription_id = w.id AND d.status = 'DEAD')
+		FROM webhook_subscriptions w
+		ORDER BY created_at DESC
+	`)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "WEBHOOKS_FETCH_FAILED", "failed to fetch webhooks", err.Error())
+		return
+	}
+	defer rows.Close()
+
+	items := make([]gin.H, 0, 4)
+	for rows.Next() {
+		var w webhookSubscription
+		var types string
+		var pending, dead int
+		if err := rows.Scan(&w.ID, &w.Name, &w.URL, &types, &w.Active, &w.CreatedAt, &w.UpdatedAt,
+			&pending, &dead); err != nil {
+			writeError(c, http.StatusInternalServerError, "SCAN_ERROR", "failed to read row", err.Error())
+			return
+		}
+		w.EventTypes = splitNonEmpty(types)
+		item := w.json()
+		item["pendingDeliveries"] = pending
+		item["deadDeliveries"] = dead
+		items = append(items, item)
+	}
+	writeOK(c, gin.H{"items": items})
+}
+
+func (h *Handler) findWebhook(c *gin.Context) (*webhookSubscription, bool) {
+	w, err := scanWebhook(h.DB.QueryRow(`
+		SELECT `+webhookColumns+` FROM webhook_subscriptions WHERE id = $1
+	`, c.Param("id")))
+	if err == sql.ErrNoRows {
+		writeError(c, http.StatusNotFound, "WEBHOOK_NOT_FOUND", "webhook not found", nil)
+		return nil, false
+	} else if err != nil {
+		writeError(c, http.StatusInternalServerError, "WEBHOOK_FETCH_FAILED", "failed to fetch webhook", err.Error())
+		return nil, false
+	}
+	return w, true
+}
+
+// GetWebhook returns one webhook subscription.
+func (h *Handler) GetWebhook(c *gin.Context) {
+	w, ok := h.findWebhook(c)
+	if !ok {
+		return
+	}
+	writeOK(c, gin.H{"data": w.json()})
+}
+
+// UpdateWebhook replaces a subscription's name, URL, event types and
+// active flag. Deliveries already queued go to the new URL.
+func (h *Handler) UpdateWebhook(c *gin.Context) {
+	req, ok := bindWebhook(c)
+	if !ok {
+		return
+	}
+	tx, err := h.DB.Begin()
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_BEGIN_FAILED", "could not start transaction", nil)
+		return
+	}
+	defer tx.Rollback()
+
+	before, err := auditSnapshot(tx, "webhook", c.Param("id"))
+	if err != nil {
+		writeAuditError(c, err)
+		return
+	}
+	w, err := scanWebhook(tx.QueryRow(`
+		UPDATE webhook_subscriptions
+		SET name = $2, url = $3, event_types = string_to_array($4, ','), active = $5, updated_at = now()
+		WHERE id = $1
+		RETURNING `+webhookColumns,
+		c.Param("id"), req.Name, req.URL, strings.Join(req.EventTypes, ","), *req.Active))
+	if err == sql.ErrNoRows {
+		writeError(c, http.StatusNotFound, "WEBHOOK_NOT_FOUND", "webhook not found", nil)
+		return
+	} else if err != nil {
+		writeError(c, http.StatusInternalServerError, "UPDATE_WEBHOOK_FAILED", "could not update webhook", err.Error())
+		return
+	}
+	if err := auditRow(tx, c, "webhook.update", "webhook", w.ID, before); err != nil {
+		writeAuditError(c, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_COMMIT_FAILED", "failed to commit webhook", err.Error())
+		return
+	}
+	writeOK(c, gin.H{"data": w.json()})
+}
+
+// RotateWebhookSecret replaces a subscription's signing secret and returns
+// the new one. Attempts from then on are signed with it.
+func (h *Handler) RotateWebhookSecret(c *gin.Context) {
+	secret, err := newWebhookSecret()
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "KEY_GENERATION_FAILED", "failed to generate webhook secret", err.Error())
+		return
+	}
+	tx, err := h.DB.Begin()
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_BEGIN_FAILED", "could not start transaction", nil)
+		return
+	}
+	defer tx.Rollback()
+
+	before, err := auditSnapshot(tx, "webhook", c.Param("id"))
+	if err != nil {
+		writeAuditError(c, err)
+		return
+	}
+	w, err := scanWebhook(tx.QueryRow(`
+		UPDATE webhook_subscriptions SET secret = $2, updated_at = now()
+		WHERE id = $1
+		RETURNING `+webhookColumns,
+		c.Param("id"), secret))
+	if err == sql.ErrNoRows {
+		writeError(c, http.StatusNotFound, "WEBHOOK_NOT_FOUND", "webhook not found", nil)
+		return
+	} else if err != nil {
+		writeError(c, http.StatusInternalServerError, "UPDATE_WEBHOOK_FAILED", "could not rotate webhook secret", err.Error())
+		return
+	}
+	if err := auditRow(tx, c, "webhook.rotate_secret", "webhook", w.ID, before); err != nil {
+		writeAuditError(c, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_COMMIT_FAILED", "failed to commit webhook", err.Error())
+		return
+	}
+	data := w.json()
+	data["secret"] = secret
+	writeOK(c, gin.H{"data": data})
+}
+
+// DeleteWebhook removes a subscription with its deliveries and their log.
+func (h *Handler) DeleteWebhook(c *gin.Context) {
+	id := c.Param("id")
+	tx, err := h.DB.Begin()
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_BEGIN_FAILED", "could not start transaction", nil)
+		return
+	}
+	defer tx.Rollback()
+
+	before, err := auditSnapshot(tx, "webhook", id)
+	if err != nil {
+		writeAuditError(c, err)
+		return
+	}
+	res, err := tx.Exec(`DELETE FROM webhook_subscriptions WHERE id = $1`, id)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "DELETE_WEBHOOK_FAILED", "failed to delete webhook", err.Error())
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		writeError(c, http.StatusNotFound, "WEBHOOK_NOT_FOUND", "webhook not found", nil)
+		return
+	}
+	if err := audit(tx, c, "webhook.delete", "webhook", id, before, nil); err != nil {
+		writeAuditError(c, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_COMMIT_FAILED", "failed to commit webhook deletion", err.Error())
+		return
+	}
+	writeOK(c, gin.H{"message": "webhook deleted"})
+}
+
+const webhookDeliveryColumns = `id, subscription_id, event_id, event_type, replay_of, status, attempts,
+	next_attempt_at, last_status_code, last_error, created_at, delivered_at`
+
+func scanWebhookDelivery(row interface{ Scan(...interface{}) error }) (gin.H, error) {
+	var id, subID, eventType, status string
+	var eventID int64
+	var replayOf, lastError sql.NullString
+	var attempts int
+	var next, created time.Time
+	var lastCode sql.NullInt64
+	var delivered sql.NullTime
+	if err := row.Scan(&id, &subID, &eventID, &eventType, &replayOf, &status, &attempts, &next, &lastCode,
+		&lastError, &created, &delivered); err != nil {
+		return nil, err
+	}
+	d := gin.H{
+		"id":             id,
+		"subscriptionId": subID,
+		"eventId":        eventID,
+		"eventType":      eventType,
+		"replayOf":       nullString(replayOf),
+		"status":         status,
+		"attempts":       attempts,
+		"lastStatusCode": nullInt(lastCode),
+		"lastError":      nullString(lastError),
+		"createdAt":      toIST(created),
+	}
+	if status == "PENDING" {
+		d["nextAttemptAt"] = toIST(next)
+	}
+	if delivered.Valid {
+		d["deliveredAt"] = toIST(delivered.Time)
+	}
+	return d, nil
+}
+
+// ListWebhookDeliveries pages through a subscription's deliveries, newest
+// first (?status= PENDING, SUCCEEDED or DEAD; ?eventType=).
+func (h *Handler) ListWebhookDeliveries(c *gin.Context) {
+	page, size, ok := pageParams(c)
+	if !ok {
+		return
+	}
+	status := c.Query("status")
+	if status != "" && status != "PENDING" && status != "SUCCEEDED" && status != "DEAD" {
+		writeError(c, http.StatusBadRequest, "VALIDATION_ERROR", "status must be PENDING, SUCCEEDED or DEAD", nil)
+		return
+	}
+	w, ok := h.findWebhook(c)
+	if !ok {
+		return
+	}
+
+	const where = `
+		WHERE subscription_id = $1 AND ($2 = '' OR status = $2) AND ($3 = '' OR event_type = $3)`
+	args := []interface{}{w.ID, status, c.Query("eventType")}
+
+	var total int
+	if err := h.DB.QueryRow(`SELECT COUNT(*) FROM webhook_deliveries`+where, args...).Scan(&total); err != nil {
+		writeError(c, http.StatusInternalServerError, "DELIVERIES_FETCH_FAILED", "failed to count deliveries", err.Error())
+		return
+	}
+	rows, err := h.DB.Query(`
+		SELECT `+webhookDeliveryColumns+`
+		FROM webhook_deliveries`+where+`
+		ORDER BY created_at DESC
+		LIMIT $4 OFFSET $5
+	`, append(args, size, (page-1)*size)...)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "DELIVERIES_FETCH_FAILED", "failed to fetch deliveries", err.Error())
+		return
+	}
+	defer rows.Close()
+
+	items := make([]gin.H, 0, size)
+	for rows.Next() {
+		d, err := scanWebhookDelivery(rows)
+		if err != nil {
+			writeError(c, http.StatusInternalServerError, "SCAN_ERROR", "failed to read row", err.Error())
+			return
+		}
+		items = append(items, d)
+	}
+	writeOK(c, gin.H{
+		"items": items,
+		"pagination": gin.H{
+			"page":       page,
+			"pageSize":   size,
+			"total":      total,
+			"totalPages": (total + size - 1) / size,
+		},
+	})
+}
+
+// GetWebhookDelivery returns a delivery with its payload and every attempt
+// made at it.
+func (h *Handler) GetWebhookDelivery(c *gin.Context) {
+	d, err := scanWebhookDelivery(h.DB.QueryRow(`
+		SELECT `+webhookDeliveryColumns+` FROM webhook_deliveries WHERE id = $1
+	`, c.Param("id")))
+	if err == sql.ErrNoRows {
+		writeError(c, http.StatusNotFound, "DELIVERY_NOT_FOUND", "webhook delivery not found", nil)
+		return
+	} else if err != nil {
+		writeError(c, http.StatusInternalServerError, "DELIVERIES_FETCH_FAILED", "failed to fetch delivery", err.Error())
+		return
+	}
+	var payload []byte
+	if err := h.DB.QueryRow(`SELECT payload FROM webhook_deliveries WHERE id = $1`, c.Param("id")).Scan(&payload); err != nil {
+		writeError(c, http.StatusInternalServerError, "DELIVERIES_FETCH_FAILED", "failed to fetch delivery", err.Error())
+		return
+	}
+	d["payload"] = rawJSON(payload)
+
+	rows, err := h.DB.Query(`
+		SELECT attempted_at, status_code, error, duration_ms
+		FROM webhook_attempts
+		WHERE delivery_id = $1
+		ORDER BY attempted_at
+	`, c.Param("id"))
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "DELIVERIES_FETCH_FAILED", "failed to fetch delivery attempts", err.Error())
+		return
+	}
+	defer rows.Close()
+
+	attempts := make([]gin.H, 0, 4)
+	for rows.Next() {
+		var at time.Time
+		var code sql.NullInt64
+		var errText sql.NullString
+		var ms int64
+		if err := rows.Scan(&at, &code, &errText, &ms); err != nil {
+			writeError(c, http.StatusInternalServerError, "SCAN_ERROR", "failed to read row", err.Error())
+			return
+		}
+		attempts = append(attempts, gin.H{
+			"attemptedAt": toIST(at),
+			"statusCode":  nullInt(code),
+			"error":       nullString(errText),
+			"durationMs":  ms,
+		})
+	}
+	d["attempts"] = attempts
+	writeOK(c, gin.H{"data": d})
+}
+
+// ReplayWebhookDelivery queues a delivery's payload to be sent again as a
+// new delivery, whatever became of the original. Receivers see the same
+// event ID.
+func (h *Handler) ReplayWebhookDelivery(c *gin.Context) {
+	tx, err := h.DB.Begin()
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_BEGIN_FAILED", "could not start transaction", nil)
+		return
+	}
+	defer tx.Rollback()
+
+	d, err := scanWebhookDelivery(tx.QueryRow(`
+		INSERT INTO webhook_deliveries (subscription_id, event_id, event_type, payload, replay_of)
+		SELECT subscription_id, event_id, event_type, payload, id FROM webhook_deliveries WHERE id = $1
+		RETURNING `+webhookDeliveryColumns,
+		c.Param("id")))
+	if err == sql.ErrNoRows {
+		writeError(c, http.StatusNotFound, "DELIVERY_NOT_FOUND", "webhook delivery not found", nil)
+		return
+	} else if err != nil {
+		writeError(c, http.StatusInternalServerError, "REPLAY_FAILED", "failed to replay delivery", err.Error())
+		return
+	}
+	id := d["id"].(string)
+	if err := auditRow(tx, c, "webhook_delivery.replay", "webhook_delivery", id, nil); err != nil {
+		writeAuditError(c, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_COMMIT_FAILED", "failed to commit replay", err.Error())
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"data": d})
+}
+
+// ReplayDeadWebhooks queues every dead-lettered delivery of a subscription
+// to be sent again, e.g. once a partner's endpoint is back. The dead ones
+// are kept as they were.
+func (h *Handler) ReplayDeadWebhooks(c *gin.Context) {
+	w, ok := h.findWebhook(c)
+	if !ok {
+		return
+	}
+	tx, err := h.DB.Begin()
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_BEGIN_FAILED", "could not start transaction", nil)
+		return
+	}
+	defer tx.Rollback()
+
+	// a dead delivery already replayed is not replayed twice
+	res, err := tx.Exec(`
+		INSERT INTO webhook_deliveries (subscription_id, event_id, event_type, payload, replay_of)
+		SELECT d.subscription_id, d.event_id, d.event_type, d.payload, d.id
+		FROM webhook_deliveries d
+		WHERE d.subscription_id = $1 AND d.status = 'DEAD'
+		  AND NOT EXISTS (SELECT 1 FROM webhook_deliveries r WHERE r.replay_of = d.id)
+	`, w.ID)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "REPLAY_FAILED", "failed to replay deliveries", err.Error())
+		return
+	}
+	n, _ := res.RowsAffected()
+	if err := audit(tx, c, "webhook.replay_dead", "webhook", w.ID, nil, gin.H{"replayed": n}); err != nil {
+		writeAuditError(c, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(c, http.StatusInternalServerError, "TX_COMMIT_FAILED", "failed to commit replay", err.Error())
+		return
+	}
+	writeOK(c, gin.H{"data": gin.H{"subscriptionId": w.ID, "replayed": n}})
+}
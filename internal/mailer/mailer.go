@@ -0,0 +1,176 @@
+// Package mailer abstracts how the service sends email. Mailers are looked
+// up by name from configuration.
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+type Message struct {
+	To          []string
+	Subject     string
+	Body        string // plain text
+	Attachments []Attachment
+}
+
+type Mailer interface {
+	Name() string
+	Send(ctx context.Context, m Message) error
+}
+
+var ErrUnknownMailer = errors.New("unknown mailer")
+
+// SMTPOptions configures the smtp mailer.
+type SMTPOptions struct {
+	Addr     string // host:port
+	Username string // optional; PLAIN auth when set
+	Password string
+	From     string
+}
+
+// New returns the mailer registered under name.
+func New(name string, opts SMTPOptions) (Mailer, error) {
+	switch name {
+	case "", "log":
+		return Log{}, nil
+	case "smtp":
+		if opts.Addr == "" || opts.From == "" {
+			return nil, errors.New("smtp mailer needs an address and a from address")
+		}
+		return &SMTP{opts}, nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownMailer, name)
+	}
+}
+
+// Log writes a line per message to the log instead of sending it, for
+// development and deployments without email.
+type Log struct{}
+
+func (Log) Name() string { return "log" }
+
+func (Log) Send(_ context.Context, m Message) error {
+	names := make([]string, len(m.Attachments))
+	for i, a := range m.Attachments {
+		names[i] = fmt.Sprintf("%s (%d bytes)", a.Filename, len(a.Data))
+	}
+	log.Printf("mailer: to %s: %q, attachments: %s", strings.Join(m.To, ", "), m.Subject, strings.Join(names, ", "))
+	return nil
+}
+
+// SMTP sends through a relay, upgrading to TLS when the server offers
+// STARTTLS.
+type SMTP struct{ opts SMTPOptions }
+
+func (*SMTP) Name() string { return "smtp" }
+
+func (s *SMTP) Send(ctx context.Context, m Message) error {
+	if len(m.To) == 0 {
+		return errors.New("message has no recipients")
+	}
+	body, err := s.compose(m)
+	if err != nil {
+		return err
+	}
+
+	conn, err := (&net.Dialer{Timeout: 30 * time.Second}).DialContext(ctx, "tcp", s.opts.Addr)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	host, _, _ := net.SplitHostPort(s.opts.Addr)
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if s.opts.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", s.opts.Username, s.opts.Password, host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(s.opts.From); err != nil {
+		return err
+	}
+	for _, to := range m.To {
+		if err := c.Rcpt(to); err != nil {
+			return fmt.Errorf("recipient %s: %w", to, err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(body); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// compose renders m as a MIME message: the text body, then each attachment
+// base64-encoded.
+func (s *SMTP) compose(m Message) ([]byte, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	boundary := "b_" + hex.EncodeToString(b)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", s.opts.From)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(m.To, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&buf, "Content-Type: multipart/mixed; boundary=%q\r\n\r\n", boundary)
+
+	fmt.Fprintf(&buf, "--%s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n", boundary)
+	buf.WriteString(strings.ReplaceAll(m.Body, "\n", "\r\n"))
+	buf.WriteString("\r\n")
+	for _, a := range m.Attachments {
+		fmt.Fprintf(&buf, "--%s\r\n", boundary)
+		fmt.Fprintf(&buf, "Content-Type: %s\r\n", a.ContentType)
+		buf.WriteString("Content-Transfer-Encoding: base64\r\n")
+		fmt.Fprintf(&buf, "Content-Disposition: %s\r\n\r\n",
+			mime.FormatMediaType("attachment", map[string]string{"filename": a.Filename}))
+		enc := base64.StdEncoding.EncodeToString(a.Data)
+		for len(enc) > 76 {
+			buf.WriteString(enc[:76] + "\r\n")
+			enc = enc[76:]
+		}
+		buf.WriteString(enc + "\r\n")
+	}
+	fmt.Fprintf(&buf, "--%s--\r\n", boundary)
+	return buf.Bytes(), nil
+}
@@ -6,12 +6,17 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-func RequireRole(role string) gin.HandlerFunc {
+// RequireRole admits requests whose JWT carries any of roles.
+func RequireRole(roles ...string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		rv, _ := c.Get("role")
-		if s, ok := rv.(string); ok && s == role {
-			c.Next()
-			return
+		if s, ok := rv.(string); ok {
+			for _, role := range roles {
+				if s == role {
+					c.Next()
+					return
+				}
+			}
 		}
 		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": gin.H{"code": "FORBIDDEN", "message": "insufficient role"}})
 	}
@@ -0,0 +1,226 @@
+// Package mqttbridge connects the service to bay sensors and barrier
+// controllers over MQTT.
+//
+// Topics, under a configurable prefix (default "parking"):
+//
+//	{prefix}/lots/{lotId}/spots/{spotId}/occupancy   sensor -> server  {"occupied": true, "deviceId": "...", "at": "..."}
+//	{prefix}/devices/{deviceId}/heartbeat             device -> server  {"kind": "SENSOR", "lotId": "...", "firmware": "..."}
+//	{prefix}/gates/{gateId}/barrier/command           server -> barrier devices.Command
+package mqttbridge
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"Backend-Go/internal/devices"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Options configures the broker connection.
+type Options struct {
+	URL string // e.g. tcp://localhost:1883
+	// ClientID is the start of the client ID; the host name and process ID
+	// are added so that replicas do not take over each other's session.
+	ClientID    string
+	Username    string
+	Password    string
+	TopicPrefix string
+	// ShareGroup, when set, subscribes through the shared subscription
+	// $share/{ShareGroup}/..., so each sensor reading and heartbeat goes to
+	// one replica of the group rather than all of them. The broker must
+	// support shared subscriptions (Mosquitto 1.6+, EMQX, HiveMQ, mochi).
+	ShareGroup string
+}
+
+// Bridge is a connected MQTT client. It implements devices.Barriers.
+type Bridge struct {
+	client mqtt.Client
+	prefix string
+	share  string
+
+	mu   sync.Mutex
+	sink devices.Sink
+}
+
+var errTimeout = errors.New("mqtt: timed out waiting for broker")
+
+// Connect dials the broker. The client reconnects on its own and
+// resubscribes after every reconnect.
+func Connect(o Options) (*Bridge, error) {
+	b := &Bridge{prefix: strings.TrimSuffix(o.TopicPrefix, "/"), share: o.ShareGroup}
+	if b.prefix == "" {
+		b.prefix = "parking"
+	}
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(o.URL).
+		SetClientID(ClientID(o.ClientID)).
+		SetUsername(o.Username).
+		SetPassword(o.Password).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetOrderMatters(false).
+		SetOnConnectHandler(func(mqtt.Client) {
+			if err := b.subscribe(); err != nil {
+				log.Printf("mqtt: resubscribe: %v", err)
+			}
+		}).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			log.Printf("mqtt: connection lost: %v", err)
+		})
+	b.client = mqtt.NewClient(opts)
+
+	t := b.client.Connect()
+	if !t.WaitTimeout(10 * time.Second) {
+		return nil, errTimeout
+	}
+	if err := t.Error(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// ClientID is prefix (default "parking-backend") followed by the host name
+// and process ID, unique to this process. A broker drops the older of two
+// connections with the same ID, so replicas sharing one would keep
+// disconnecting each other.
+func ClientID(prefix string) string {
+	if prefix == "" {
+		prefix = "parking-backend"
+	}
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s-%s-%d", prefix, host, os.Getpid())
+}
+
+// Subscribe starts delivering sensor readings and heartbeats to sink.
+func (b *Bridge) Subscribe(sink devices.Sink) error {
+	b.mu.Lock()
+	b.sink = sink
+	b.mu.Unlock()
+	return b.subscribe()
+}
+
+func (b *Bridge) subscribe() error {
+	b.mu.Lock()
+	sink := b.sink
+	b.mu.Unlock()
+	if sink == nil || !b.client.IsConnected() {
+		return nil
+	}
+	t := b.client.SubscribeMultiple(map[string]byte{
+		b.filter("/lots/+/spots/+/occupancy"): 1,
+		b.filter("/devices/+/heartbeat"):      0,
+	}, func(_ mqtt.Client, m mqtt.Message) { b.handle(sink, m) })
+	if !t.WaitTimeout(10 * time.Second) {
+		return errTimeout
+	}
+	return t.Error()
+}
+
+// filter is the subscription for topic under the prefix, shared when a
+// share group is set.
+func (b *Bridge) filter(topic string) string {
+	if b.share == "" {
+		return b.prefix + topic
+	}
+	return "$share/" + b.share + "/" + b.prefix + topic
+}
+
+// Send publishes a barrier command for its gate.
+func (b *Bridge) Send(ctx context.Context, cmd devices.Command) error {
+	payload, err := json.Marshal(cmd)
+	if err != nil {
+		return err
+	}
+	t := b.client.Publish(b.prefix+"/gates/"+cmd.GateID+"/barrier/command", 1, false, payload)
+	select {
+	case <-t.Done():
+		return t.Error()
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(5 * time.Second):
+		return errTimeout
+	}
+}
+
+// Close disconnects, giving in-flight messages a moment to finish.
+func (b *Bridge) Close() { b.client.Disconnect(250) }
+
+type occupancyMsg struct {
+	Occupied bool       `json:"occupied"`
+	DeviceID string     `json:"deviceId"`
+	At       *time.Time `json:"at"`
+}
+
+type heartbeatMsg struct {
+	Kind     string     `json:"kind"`
+	LotID    string     `json:"lotId"`
+	SpotID   string     `json:"spotId"`
+	GateID   string     `json:"gateId"`
+	Firmware string     `json:"firmware"`
+	At       *time.Time `json:"at"`
+}
+
+func (b *Bridge) handle(sink devices.Sink, m mqtt.Message) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := b.dispatch(ctx, sink, m.Topic(), m.Payload()); err != nil {
+		log.Printf("mqtt: %s: %v", m.Topic(), err)
+	}
+}
+
+func (b *Bridge) dispatch(ctx context.Context, sink devices.Sink, topic string, payload []byte) error {
+	parts := strings.Split(strings.TrimPrefix(topic, b.prefix+"/"), "/")
+	switch {
+	case len(parts) == 5 && parts[0] == "lots" && parts[2] == "spots" && parts[4] == "occupancy":
+		var msg occupancyMsg
+		if err := json.Unmarshal(payload, &msg); err != nil {
+			return fmt.Errorf("bad occupancy payload: %w", err)
+		}
+		return sink.SpotSensor(ctx, devices.SensorReading{
+			LotID:    parts[1],
+			SpotID:   parts[3],
+			DeviceID: msg.DeviceID,
+			Occupied: msg.Occupied,
+			At:       timeOrNow(msg.At),
+		})
+
+	case len(parts) == 3 && parts[0] == "devices" && parts[2] == "heartbeat":
+		var msg heartbeatMsg
+		if len(payload) > 0 {
+			if err := json.Unmarshal(payload, &msg); err != nil {
+				return fmt.Errorf("bad heartbeat payload: %w", err)
+			}
+		}
+		return sink.Heartbeat(ctx, devices.Heartbeat{
+			DeviceID: parts[1],
+			Kind:     strings.ToUpper(msg.Kind),
+			LotID:    msg.LotID,
+			SpotID:   msg.SpotID,
+			GateID:   msg.GateID,
+			Firmware: msg.Firmware,
+			At:       timeOrNow(msg.At),
+		})
+	}
+	return fmt.Errorf("unexpected topic")
+}
+
+// timeOrNow trusts a device clock only when it is roughly right.
+func timeOrNow(t *time.Time) time.Time {
+	now := time.Now()
+	if t == nil || t.After(now.Add(time.Minute)) || t.Before(now.Add(-24*time.Hour)) {
+		return now
+	}
+	return *t
+}
@@ -0,0 +1,203 @@
+package mqttbridge
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"Backend-Go/internal/devices"
+
+	mochi "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+	"github.com/mochi-mqtt/server/v2/packets"
+)
+
+// startBroker runs an in-process mochi broker on a free local port and
+// returns it with its URL.
+func startBroker(t *testing.T) (*mochi.Server, string) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	server := mochi.New(&mochi.Options{InlineClient: true, Logger: slog.New(slog.NewTextHandler(io.Discard, nil))})
+	if err := server.AddHook(new(auth.AllowHook), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.AddListener(listeners.NewTCP(listeners.Config{ID: "tcp", Address: addr})); err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve()
+	t.Cleanup(func() { server.Close() })
+	return server, "tcp://" + addr
+}
+
+// recorder is a devices.Sink that keeps what it is given.
+type recorder struct {
+	mu         sync.Mutex
+	readings   []devices.SensorReading
+	heartbeats []devices.Heartbeat
+	got        chan struct{}
+}
+
+func newRecorder() *recorder { return &recorder{got: make(chan struct{}, 100)} }
+
+func (r *recorder) SpotSensor(_ context.Context, sr devices.SensorReading) error {
+	r.mu.Lock()
+	r.readings = append(r.readings, sr)
+	r.mu.Unlock()
+	r.got <- struct{}{}
+	return nil
+}
+
+func (r *recorder) Heartbeat(_ context.Context, hb devices.Heartbeat) error {
+	r.mu.Lock()
+	r.heartbeats = append(r.heartbeats, hb)
+	r.mu.Unlock()
+	r.got <- struct{}{}
+	return nil
+}
+
+func (r *recorder) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.readings) + len(r.heartbeats)
+}
+
+// connect stands in for one replica; replicas are separate processes, so
+// each is given its own client ID prefix.
+func connect(t *testing.T, url, replica string) *Bridge {
+	t.Helper()
+	b, err := Connect(Options{URL: url, ClientID: replica, TopicPrefix: "test", ShareGroup: "backend"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(b.Close)
+	return b
+}
+
+func TestClientID(t *testing.T) {
+	id := ClientID("")
+	if !strings.HasPrefix(id, "parking-backend-") {
+		t.Errorf("ClientID(\"\") = %q, want the default prefix", id)
+	}
+	if got := ClientID("lot7"); !strings.HasPrefix(got, "lot7-") || got == "lot7-" {
+		t.Errorf("ClientID(\"lot7\") = %q", got)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	b := &Bridge{prefix: "parking"}
+	if got, want := b.filter("/devices/+/heartbeat"), "parking/devices/+/heartbeat"; got != want {
+		t.Errorf("filter = %q, want %q", got, want)
+	}
+	b.share = "backend"
+	if got, want := b.filter("/devices/+/heartbeat"), "$share/backend/parking/devices/+/heartbeat"; got != want {
+		t.Errorf("shared filter = %q, want %q", got, want)
+	}
+}
+
+// TestBridge runs two replicas' bridges against a real broker: both stay
+// connected, each sensor message reaches exactly one of them, and barrier
+// commands reach the barrier topic.
+func TestBridge(t *testing.T) {
+	server, url := startBroker(t)
+
+	a, b := connect(t, url, "replica-a"), connect(t, url, "replica-b")
+	ra, rb := newRecorder(), newRecorder()
+	if err := a.Subscribe(ra); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Subscribe(rb); err != nil {
+		t.Fatal(err)
+	}
+	if !a.client.IsConnected() || !b.client.IsConnected() {
+		t.Fatal("replicas displaced each other")
+	}
+
+	const messages = 10
+	for i := 0; i < messages; i++ {
+		err := server.Publish("test/lots/L1/spots/S1/occupancy", []byte(`{"occupied": true, "deviceId": "d1"}`), false, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := server.Publish("test/devices/d1/heartbeat", []byte(`{"kind": "sensor", "lotId": "L1"}`), false, 0); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.After(5 * time.Second)
+	for ra.count()+rb.count() < messages+1 {
+		select {
+		case <-ra.got:
+		case <-rb.got:
+		case <-deadline:
+			t.Fatalf("got %d messages, want %d", ra.count()+rb.count(), messages+1)
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n := ra.count() + rb.count(); n != messages+1 {
+		t.Fatalf("got %d messages across replicas, want %d (each delivered once)", n, messages+1)
+	}
+
+	var readings []devices.SensorReading
+	var heartbeats []devices.Heartbeat
+	for _, r := range []*recorder{ra, rb} {
+		readings = append(readings, r.readings...)
+		heartbeats = append(heartbeats, r.heartbeats...)
+	}
+	if r := readings[0]; r.LotID != "L1" || r.SpotID != "S1" || r.DeviceID != "d1" || !r.Occupied || r.At.IsZero() {
+		t.Errorf("reading = %+v", r)
+	}
+	if len(heartbeats) != 1 || heartbeats[0].DeviceID != "d1" || heartbeats[0].Kind != "SENSOR" || heartbeats[0].LotID != "L1" {
+		t.Errorf("heartbeats = %+v", heartbeats)
+	}
+
+	commands := make(chan devices.Command, 1)
+	err := server.Subscribe("test/gates/+/barrier/command", 1, func(_ *mochi.Client, _ packets.Subscription, pk packets.Packet) {
+		var cmd devices.Command
+		if err := json.Unmarshal(pk.Payload, &cmd); err == nil && strings.HasSuffix(pk.TopicName, "/G1/barrier/command") {
+			commands <- cmd
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := a.Send(ctx, devices.Command{GateID: "G1", Action: devices.Open, BookingID: "B1"}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case cmd := <-commands:
+		if cmd.Action != devices.Open || cmd.BookingID != "B1" {
+			t.Errorf("command = %+v", cmd)
+		}
+	case <-ctx.Done():
+		t.Fatal("barrier command never arrived")
+	}
+}
+
+func TestDispatchRejects(t *testing.T) {
+	b := &Bridge{prefix: "test"}
+	ctx := context.Background()
+	for _, tt := range []struct{ topic, payload string }{
+		{"test/lots/L1/spots/S1/occupancy", "not json"},
+		{"test/devices/d1/heartbeat", "{"},
+		{"test/lots/L1/occupancy", "{}"},
+		{"test/gates/G1/barrier/command", "{}"},
+	} {
+		if err := b.dispatch(ctx, newRecorder(), tt.topic, []byte(tt.payload)); err == nil {
+			t.Errorf("dispatch(%q, %q) succeeded, want an error", tt.topic, tt.payload)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+// Package notify sends notifications to users over pluggable channels.
+//
+// A notification is rendered from a localised template (see Render) and
+// handed to the Sender configured for its channel: email through the
+// mailer, SMS and push through an HTTP gateway, or the log and a local file
+// for development. Senders are looked up by name from configuration.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"Backend-Go/internal/mailer"
+)
+
+// Channels.
+const (
+	ChannelEmail = "EMAIL"
+	ChannelSMS   = "SMS"
+	ChannelPush  = "PUSH"
+)
+
+// Channels lists every channel.
+var Channels = []string{ChannelEmail, ChannelSMS, ChannelPush}
+
+// Kinds of notification.
+const (
+	BookingConfirmed = "BOOKING_CONFIRMED"
+	// SpotHeld tells a waitlisted user a spot is being held for them.
+	SpotHeld        = "SPOT_HELD"
+	OverstayWarning = "OVERSTAY_WARNING"
+	PassExpiring    = "PASS_EXPIRING"
+	PaymentReceipt  = "PAYMENT_RECEIPT"
+)
+
+// Kinds lists every kind of notification.
+var Kinds = []string{BookingConfirmed, SpotHeld, OverstayWarning, PassExpiring, PaymentReceipt}
+
+// Message is one notification to one destination: an email address, a
+// phone number or a push token.
+type Message struct {
+	ID      string `json:"id"`
+	Kind    string `json:"kind"`
+	Channel string `json:"channel"`
+	To      string `json:"to"`
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
+
+// Sender delivers messages on one channel. Send returning an error has the
+// message retried later, so it may arrive twice; gateways can dedupe on its
+// ID.
+type Sender interface {
+	Name() string
+	Send(ctx context.Context, m Message) error
+}
+
+var ErrUnknownSender = errors.New("unknown notification sender")
+
+// Options configures the senders that need it.
+type Options struct {
+	Mailer mailer.Mailer // for "mailer"
+	URL    string        // gateway for "http"
+	File   string        // path for "file"
+}
+
+// NewSender returns the sender registered under name.
+func NewSender(name string, opts Options) (Sender, error) {
+	switch name {
+	case "log":
+		return Log{}, nil
+	case "file":
+		if opts.File == "" {
+			return nil, errors.New("file sender needs a path")
+		}
+		return &File{Path: opts.File}, nil
+	case "mailer":
+		if opts.Mailer == nil {
+			return nil, errors.New("mailer sender needs a mailer")
+		}
+		return Mail{opts.Mailer}, nil
+	case "http":
+		if opts.URL == "" {
+			return nil, errors.New("http sender needs a URL")
+		}
+		return &HTTP{URL: opts.URL}, nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownSender, name)
+	}
+}
+
+// Log writes a line per message to the log instead of sending it, for
+// development.
+type Log struct{}
+
+func (Log) Name() string { return "log" }
+
+func (Log) Send(_ context.Context, m Message) error {
+	log.Printf("notify: %s %s to %s: %q", m.Channel, m.Kind, m.To, m.Subject)
+	return nil
+}
+
+// File appends each message to Path as a line of JSON, so what would have
+// been sent can be read back during development.
+type File struct {
+	Path string
+	mu   sync.Mutex
+}
+
+func (*File) Name() string { return "file" }
+
+func (f *File) Send(_ context.Context, m Message) error {
+	line, err := json.Marshal(struct {
+		Message
+		At time.Time `json:"at"`
+	}{m, time.Now()})
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out, err := os.OpenFile(f.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := out.Write(append(line, '\n')); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// Mail sends email through a mailer.
+type Mail struct{ Mailer mailer.Mailer }
+
+func (m Mail) Name() string { return "mailer:" + m.Mailer.Name() }
+
+func (m Mail) Send(ctx context.Context, msg Message) error {
+	return m.Mailer.Send(ctx, mailer.Message{To: []string{msg.To}, Subject: msg.Subject, Body: msg.Body})
+}
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+// HTTP POSTs each message as JSON to an SMS or push gateway, which does the
+// actual sending. Any 2xx response counts as sent.
+type HTTP struct{ URL string }
+
+func (*HTTP) Name() string { return "http" }
+
+func (s *HTTP) Send(ctx context.Context, m Message) error {
+	body, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", m.ID)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.New("gateway responded " + resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package notify
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// DefaultLocale is used for users without a locale and for kinds a locale
+// has no template for.
+const DefaultLocale = "en"
+
+//go:embed templates/*.tmpl
+var templateFS embed.FS
+
+// templates holds one template set per locale, from templates/<locale>.tmpl.
+// Each set defines "<KIND>.subject", "<KIND>.body" for email and
+// "<KIND>.short" for SMS and push.
+var templates = map[string]*template.Template{}
+
+var templateFuncs = template.FuncMap{
+	"rupees": func(paise int64) string {
+		sign := ""
+		if paise < 0 {
+			sign, paise = "-", -paise
+		}
+		return fmt.Sprintf("%s₹%d.%02d", sign, paise/100, paise%100)
+	},
+}
+
+func init() {
+	files, err := templateFS.ReadDir("templates")
+	if err != nil {
+		panic("notify: " + err.Error())
+	}
+	for _, f := range files {
+		locale := strings.TrimSuffix(f.Name(), ".tmpl")
+		t, err := template.New(locale).Funcs(templateFuncs).ParseFS(templateFS, path.Join("templates", f.Name()))
+		if err != nil {
+			panic("notify: " + err.Error())
+		}
+		templates[locale] = t
+	}
+}
+
+// HasLocale reports whether notifications can be written in locale.
+func HasLocale(locale string) bool {
+	_, ok := templates[locale]
+	return ok
+}
+
+// Locales lists the locales notifications can be written in.
+func Locales() []string {
+	out := make([]string, 0, len(templates))
+	for locale := range templates {
+		out = append(out, locale)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Data fills in a template. Times are in the lot's timezone. At is when the
+// session started (BOOKING_CONFIRMED, OVERSTAY_WARNING), when the hold ends
+// (SPOT_HELD), when the pass expires (PASS_EXPIRING) or when the payment was
+// taken (PAYMENT_RECEIPT).
+type Data struct {
+	Name        string
+	Lot         string
+	Spot        string
+	Plate       string
+	At          time.Time
+	Hours       int // how long the session has run, for OVERSTAY_WARNING
+	Pass        string
+	AmountPaise int64
+	InvoiceNo   string
+	Reference   string // the payment provider's reference
+}
+
+// Rendered is a notification's text: Subject and Body for email, Subject
+// and Short for SMS and push.
+type Rendered struct {
+	Subject string
+	Body    string
+	Short   string
+}
+
+// Text is what is sent on channel.
+func (r Rendered) Text(channel string) (subject, body string) {
+	if channel == ChannelEmail {
+		return r.Subject, r.Body
+	}
+	return r.Subject, r.Short
+}
+
+// Render writes a notification of kind in locale, falling back to the
+// default locale when it has no template for kind.
+func Render(kind, locale string, d Data) (Rendered, error) {
+	t, ok := templates[locale]
+	if !ok || t.Lookup(kind+".subject") == nil {
+		t = templates[DefaultLocale]
+	}
+	if t.Lookup(kind+".subject") == nil {
+		return Rendered{}, fmt.Errorf("notify: no template for %s", kind)
+	}
+	var r Rendered
+	for _, part := range []struct {
+		name string
+		out  *string
+	}{{"subject", &r.Subject}, {"body", &r.Body}, {"short", &r.Short}} {
+		var buf bytes.Buffer
+		if err := t.ExecuteTemplate(&buf, kind+"."+part.name, d); err != nil {
+			return Rendered{}, err
+		}
+		*part.out = strings.TrimSpace(buf.String())
+	}
+	return r, nil
+}
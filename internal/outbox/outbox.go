@@ -0,0 +1,381 @@
+// Package outbox delivers domain events at least once.
+//
+// An event is written with Write inside the transaction that causes it, so
+// it exists exactly when the change does. A Dispatcher running on every
+// replica claims undelivered events and hands them to its sinks: in-process
+// subscribers and external systems. An event is only marked dispatched after
+// every sink has taken it, and a claim is a lease, so events claimed by a
+// process that dies are picked up again once the lease runs out. Sinks can
+// therefore see an event more than once and should key on its ID.
+//
+// Events for the same aggregate (one booking, one spot) are delivered in the
+// order their transactions committed: Write numbers them per aggregate under
+// a row lock held until commit, and an event waits while an earlier one for
+// its aggregate is being retried, though not once that one has been given up
+// on.
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event types.
+const (
+	BookingStarted    = "booking.started"
+	BookingEnded      = "booking.ended"
+	SpotStatusChanged = "spot.status_changed"
+	PaymentCaptured   = "payment.captured"
+	PaymentRefunded   = "payment.refunded"
+	WaitlistOffered   = "waitlist.offered"
+)
+
+// Types lists every event type, e.g. for validating subscriptions.
+var Types = []string{BookingStarted, BookingEnded, SpotStatusChanged, PaymentCaptured, PaymentRefunded, WaitlistOffered}
+
+// BookingData is the payload of booking events. The end time and invoice
+// are set on BookingEnded; InvoiceNo is empty for lots without billing.
+type BookingData struct {
+	BookingID  string     `json:"bookingId"`
+	LotID      string     `json:"lotId"`
+	SpotID     string     `json:"spotId"`
+	UserID     string     `json:"userId,omitempty"`
+	VehicleID  string     `json:"vehicleId,omitempty"`
+	Plate      string     `json:"plate,omitempty"`
+	OrgID      string     `json:"orgId,omitempty"`
+	PassID     string     `json:"passId,omitempty"`
+	StartTime  time.Time  `json:"startTime"`
+	EndTime    *time.Time `json:"endTime,omitempty"`
+	InvoiceNo  string     `json:"invoiceNo,omitempty"`
+	TotalPaise int64      `json:"totalPaise"`
+}
+
+// SpotData is the payload of SpotStatusChanged. Status is DELETED when the
+// spot was removed.
+type SpotData struct {
+	SpotID    string `json:"spotId"`
+	LotID     string `json:"lotId"`
+	Status    string `json:"status"`
+	BookingID string `json:"bookingId,omitempty"`
+}
+
+// PaymentData is the payload of payment events: a capture for a booking or
+// a pass, or a refund (RefundID, ReasonCode) against a booking's payment.
+type PaymentData struct {
+	PaymentID   string `json:"paymentId"`
+	RefundID    string `json:"refundId,omitempty"`
+	BookingID   string `json:"bookingId,omitempty"`
+	PassID      string `json:"passId,omitempty"`
+	Provider    string `json:"provider"`
+	ProviderRef string `json:"providerRef"`
+	AmountPaise int64  `json:"amountPaise"`
+	ReasonCode  string `json:"reasonCode,omitempty"`
+}
+
+// WaitlistData is the payload of WaitlistOffered: a freed spot held for a
+// waitlisted vehicle until ExpiresAt.
+type WaitlistData struct {
+	EntryID   string    `json:"entryId"`
+	LotID     string    `json:"lotId"`
+	SpotID    string    `json:"spotId"`
+	UserID    string    `json:"userId"`
+	VehicleID string    `json:"vehicleId"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// Event is one domain event. Data is the type's JSON payload.
+type Event struct {
+	ID            int64           `json:"id"`
+	Type          string          `json:"type"`
+	AggregateType string          `json:"aggregateType"`
+	AggregateID   string          `json:"aggregateId"`
+	Data          json.RawMessage `json:"data"`
+	OccurredAt    time.Time       `json:"occurredAt"`
+}
+
+// Execer is satisfied by *sql.DB and *sql.Tx.
+type Execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// Write records an event about aggregateType aggregateID. data is
+// marshalled to JSON. Given a transaction, the event is only delivered if
+// the transaction commits, and other transactions writing events for the
+// same aggregate wait until it ends.
+func Write(x Execer, typ, aggregateType, aggregateID string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = x.Exec(`
+		WITH seq AS (
+			INSERT INTO outbox_sequences (aggregate_type, aggregate_id, last_seq)
+			VALUES ($2, $3, 1)
+			ON CONFLICT (aggregate_type, aggregate_id) DO UPDATE SET last_seq = outbox_sequences.last_seq + 1
+			RETURNING last_seq
+		)
+		INSERT INTO outbox_events (type, aggregate_type, aggregate_id, aggregate_seq, data)
+		SELECT $1, $2, $3, last_seq, $4 FROM seq
+	`, typ, aggregateType, aggregateID, string(payload))
+	return err
+}
+
+// Sink takes delivery of events. Deliver returning an error has the event
+// retried later; a sink that has taken an event is not given it again
+// unless its dispatcher dies before recording that.
+type Sink interface {
+	Name() string
+	Deliver(ctx context.Context, e Event) error
+}
+
+var ErrUnknownSink = errors.New("unknown outbox sink")
+
+// NewSink returns the external sink registered under name.
+func NewSink(name string) (Sink, error) {
+	switch name {
+	case "log":
+		return Log{}, nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownSink, name)
+	}
+}
+
+// Log writes a line per event to the log, for development.
+type Log struct{}
+
+func (Log) Name() string { return "log" }
+
+func (Log) Deliver(_ context.Context, e Event) error {
+	log.Printf("outbox: event %d %s %s/%s %s", e.ID, e.Type, e.AggregateType, e.AggregateID, e.Data)
+	return nil
+}
+
+// HandlerFunc handles one event in process.
+type HandlerFunc func(ctx context.Context, e Event) error
+
+// Subscribers is the in-process sink: handlers registered with On are run
+// in turn for each event of their type. If one fails the event is retried,
+// including for the handlers that succeeded.
+type Subscribers struct {
+	mu       sync.RWMutex
+	handlers map[string][]HandlerFunc
+}
+
+func NewSubscribers() *Subscribers {
+	return &Subscribers{handlers: make(map[string][]HandlerFunc)}
+}
+
+// On registers fn for events of typ, or of every type when typ is "".
+func (s *Subscribers) On(typ string, fn HandlerFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[typ] = append(s.handlers[typ], fn)
+}
+
+func (s *Subscribers) Name() string { return "in-process" }
+
+func (s *Subscribers) Deliver(ctx context.Context, e Event) error {
+	s.mu.RLock()
+	fns := append(append([]HandlerFunc(nil), s.handlers[""]...), s.handlers[e.Type]...)
+	s.mu.RUnlock()
+	for _, fn := range fns {
+		if err := fn(ctx, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Dispatcher defaults.
+const (
+	DefaultBatch       = 100
+	DefaultLease       = time.Minute
+	DefaultMaxAttempts = 20
+
+	retryBase = 5 * time.Second
+	retryCap  = time.Hour
+)
+
+// Dispatcher delivers undelivered events to its sinks. Run Dispatch as a
+// background job; any number of replicas can run it at once.
+type Dispatcher struct {
+	DB    *sql.DB
+	Sinks []Sink
+	// Batch is how many events are claimed at a time, and Lease how long a
+	// claim holds before another dispatcher may take the events over.
+	Batch int
+	Lease time.Duration
+	// MaxAttempts is how many failed deliveries an event gets before it is
+	// marked failed and left for an operator to retry.
+	MaxAttempts int
+}
+
+// Dispatch delivers events until none are due.
+func (d *Dispatcher) Dispatch(ctx context.Context) error {
+	batch := d.Batch
+	if batch <= 0 {
+		batch = DefaultBatch
+	}
+	for ctx.Err() == nil {
+		events, err := d.claim(ctx, batch)
+		if err != nil {
+			return err
+		}
+		for _, c := range events {
+			if err := d.deliver(ctx, c); err != nil {
+				return err
+			}
+		}
+		if len(events) < batch {
+			return nil
+		}
+	}
+	return nil
+}
+
+// claimed is an event with the sinks that have already taken it.
+type claimed struct {
+	Event
+	attempts    int
+	deliveredTo []string
+}
+
+// claim leases up to n due events, each the oldest undelivered one for its
+// aggregate.
+func (d *Dispatcher) claim(ctx context.Context, n int) ([]claimed, error) {
+	lease := d.Lease
+	if lease <= 0 {
+		lease = DefaultLease
+	}
+	rows, err := d.DB.QueryContext(ctx, `
+		UPDATE outbox_events
+		SET locked_until = now() + $2 * interval '1 second', attempts = attempts + 1
+		WHERE id IN (
+			SELECT e.id FROM outbox_events e
+			WHERE e.dispatched_at IS NULL AND e.failed_at IS NULL
+			  AND e.next_attempt_at <= now()
+			  AND (e.locked_until IS NULL OR e.locked_until < now())
+			  AND NOT EXISTS (
+				SELECT 1 FROM outbox_events p
+				WHERE p.aggregate_type = e.aggregate_type AND p.aggregate_id = e.aggregate_id
+				  AND p.dispatched_at IS NULL AND p.failed_at IS NULL AND p.aggregate_seq < e.aggregate_seq
+			  )
+			ORDER BY e.id
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, type, aggregate_type, aggregate_id, data, occurred_at, attempts,
+		          array_to_string(delivered_to, ',')
+	`, n, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []claimed
+	for rows.Next() {
+		var c claimed
+		var data []byte
+		var delivered string
+		if err := rows.Scan(&c.ID, &c.Type, &c.AggregateType, &c.AggregateID, &data, &c.OccurredAt, &c.attempts,
+			&delivered); err != nil {
+			return nil, err
+		}
+		c.Data = data
+		if delivered != "" {
+			c.deliveredTo = strings.Split(delivered, ",")
+		}
+		out = append(out, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out, nil
+}
+
+// deliver hands c to each sink that has not taken it yet and records the
+// outcome. Only a failure to record is returned.
+func (d *Dispatcher) deliver(ctx context.Context, c claimed) error {
+	done := make(map[string]bool, len(c.deliveredTo))
+	for _, name := range c.deliveredTo {
+		done[name] = true
+	}
+	for _, s := range d.Sinks {
+		if done[s.Name()] {
+			continue
+		}
+		if err := s.Deliver(ctx, c.Event); err != nil {
+			if ctx.Err() != nil {
+				// shutting down; the lease runs out and the event is retried
+				return nil
+			}
+			return d.fail(c, s.Name(), err)
+		}
+		if _, err := d.DB.Exec(`
+			UPDATE outbox_events SET delivered_to = array_append(delivered_to, $2) WHERE id = $1
+		`, c.ID, s.Name()); err != nil {
+			return err
+		}
+	}
+	_, err := d.DB.Exec(`
+		UPDATE outbox_events SET dispatched_at = now(), locked_until = NULL, last_error = NULL WHERE id = $1
+	`, c.ID)
+	return err
+}
+
+// fail releases c for a retry with exponential backoff, or marks it failed
+// once it has used its attempts.
+func (d *Dispatcher) fail(c claimed, sink string, cause error) error {
+	max := d.MaxAttempts
+	if max <= 0 {
+		max = DefaultMaxAttempts
+	}
+	msg := sink + ": " + cause.Error()
+	if c.attempts >= max {
+		log.Printf("outbox: event %d %s failed after %d attempts: %s", c.ID, c.Type, c.attempts, msg)
+		_, err := d.DB.Exec(`
+			UPDATE outbox_events SET failed_at = now(), locked_until = NULL, last_error = $2 WHERE id = $1
+		`, c.ID, msg)
+		return err
+	}
+	_, err := d.DB.Exec(`
+		UPDATE outbox_events
+		SET next_attempt_at = now() + $2 * interval '1 second', locked_until = NULL, last_error = $3
+		WHERE id = $1
+	`, c.ID, Backoff(c.attempts).Seconds(), msg)
+	return err
+}
+
+// Backoff is the wait before retrying after the given number of failed
+// attempts: 5s, 10s, 20s… up to an hour.
+func Backoff(attempts int) time.Duration {
+	wait := retryBase
+	for i := 1; i < attempts && wait < retryCap; i++ {
+		wait *= 2
+	}
+	if wait > retryCap {
+		wait = retryCap
+	}
+	return wait
+}
+
+// Retention is how long dispatched events are kept.
+const Retention = 7 * 24 * time.Hour
+
+// Prune deletes events dispatched longer ago than Retention. Failed events
+// are kept until retried.
+func (d *Dispatcher) Prune(ctx context.Context) error {
+	_, err := d.DB.ExecContext(ctx, `
+		DELETE FROM outbox_events WHERE dispatched_at < now() - $1 * interval '1 second'
+	`, Retention.Seconds())
+	return err
+}
@@ -0,0 +1,80 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{0, 5 * time.Second},
+		{1, 5 * time.Second},
+		{2, 10 * time.Second},
+		{3, 20 * time.Second},
+		{5, 80 * time.Second},
+		{10, 2560 * time.Second},
+		{11, time.Hour},
+		{20, time.Hour},
+		{1000, time.Hour},
+	}
+	for _, tt := range tests {
+		if got := Backoff(tt.attempts); got != tt.want {
+			t.Errorf("Backoff(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestNewSink(t *testing.T) {
+	s, err := NewSink("log")
+	if err != nil || s.Name() != "log" {
+		t.Errorf("NewSink(log) = %v, %v", s, err)
+	}
+	if _, err := NewSink("kafka"); !errors.Is(err, ErrUnknownSink) {
+		t.Errorf("NewSink(kafka) error = %v, want ErrUnknownSink", err)
+	}
+}
+
+func TestSubscribers(t *testing.T) {
+	subs := NewSubscribers()
+	var got []string
+	record := func(name string) HandlerFunc {
+		return func(_ context.Context, e Event) error {
+			got = append(got, name+":"+e.Type)
+			return nil
+		}
+	}
+	subs.On(BookingStarted, record("started"))
+	subs.On("", record("all"))
+	subs.On(BookingEnded, record("ended"))
+
+	for _, typ := range []string{BookingStarted, BookingEnded, SpotStatusChanged} {
+		if err := subs.Deliver(context.Background(), Event{Type: typ}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []string{
+		"all:" + BookingStarted, "started:" + BookingStarted,
+		"all:" + BookingEnded, "ended:" + BookingEnded,
+		"all:" + SpotStatusChanged,
+	}
+	if len(got) != len(want) {
+		t.Fatalf("handled %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("handled %v, want %v", got, want)
+			break
+		}
+	}
+
+	boom := errors.New("boom")
+	subs.On(SpotStatusChanged, func(context.Context, Event) error { return boom })
+	if err := subs.Deliver(context.Background(), Event{Type: SpotStatusChanged}); !errors.Is(err, boom) {
+		t.Errorf("Deliver error = %v, want the failing handler's", err)
+	}
+}
@@ -0,0 +1,87 @@
+// Package payments abstracts the gateway that collects and refunds parking
+// fees. Providers are looked up by name from configuration.
+package payments
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// CaptureRequest and RefundRequest carry an IdempotencyKey that stays the
+// same when a request is retried, e.g. after a timeout or a restart, so the
+// customer is charged or refunded once however often it is sent.
+type CaptureRequest struct {
+	IdempotencyKey string
+	BookingID      string
+	AmountPaise    int64
+	Description    string
+}
+
+type RefundRequest struct {
+	IdempotencyKey string
+	PaymentRef     string
+	AmountPaise    int64
+	Reason         string
+}
+
+// Result identifies the provider-side transaction.
+type Result struct {
+	ProviderRef string
+	Status      string
+}
+
+// Provider is a payment gateway. A repeated Capture or Refund with the same
+// IdempotencyKey must return the original Result rather than act again.
+type Provider interface {
+	Name() string
+	Capture(ctx context.Context, req CaptureRequest) (Result, error)
+	Refund(ctx context.Context, req RefundRequest) (Result, error)
+}
+
+var ErrUnknownProvider = errors.New("unknown payment provider")
+
+// New returns the provider registered under name.
+func New(name string) (Provider, error) {
+	switch name {
+	case "", "manual":
+		return Manual{}, nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownProvider, name)
+	}
+}
+
+// Manual records payments collected and refunded at the counter. Every call
+// succeeds immediately with a reference derived from its idempotency key.
+type Manual struct{}
+
+func (Manual) Name() string { return "manual" }
+
+func (Manual) Capture(_ context.Context, req CaptureRequest) (Result, error) {
+	if req.AmountPaise <= 0 {
+		return Result{}, errors.New("capture amount must be positive")
+	}
+	return Result{ProviderRef: "man_pay_" + ref(req.IdempotencyKey), Status: "CAPTURED"}, nil
+}
+
+func (Manual) Refund(_ context.Context, req RefundRequest) (Result, error) {
+	if req.AmountPaise <= 0 {
+		return Result{}, errors.New("refund amount must be positive")
+	}
+	return Result{ProviderRef: "man_ref_" + ref(req.IdempotencyKey), Status: "SUCCEEDED"}, nil
+}
+
+// ref is a reference for key, the same each time, or a random one when
+// there is no key.
+func ref(key string) string {
+	if key != "" {
+		sum := sha256.Sum256([]byte(key))
+		return hex.EncodeToString(sum[:8])
+	}
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,132 @@
+// Package pdf is a deliberately small PDF 1.4 writer: A4 pages, the built-in
+// Helvetica faces, text and straight lines. It exists so receipts can be
+// rendered in-process without pulling in a layout engine.
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// A4 page size in PDF points.
+const (
+	PageWidth  = 595.28
+	PageHeight = 841.89
+)
+
+type Document struct {
+	pages []*bytes.Buffer
+}
+
+func New() *Document { return &Document{} }
+
+// AddPage starts a new page; subsequent drawing calls go to it.
+func (d *Document) AddPage() { d.pages = append(d.pages, &bytes.Buffer{}) }
+
+func (d *Document) page() *bytes.Buffer {
+	if len(d.pages) == 0 {
+		d.AddPage()
+	}
+	return d.pages[len(d.pages)-1]
+}
+
+// Text draws s with its baseline at (x, y), measured from the top-left corner.
+func (d *Document) Text(x, y, size float64, bold bool, s string) {
+	font := "F1"
+	if bold {
+		font = "F2"
+	}
+	fmt.Fprintf(d.page(), "BT /%s %.2f Tf %.2f %.2f Td (%s) Tj ET\n", font, size, x, PageHeight-y, escape(s))
+}
+
+// TextRight draws s so that it ends at x. Widths are approximated, which is
+// good enough for right-aligning amount columns.
+func (d *Document) TextRight(x, y, size float64, bold bool, s string) {
+	d.Text(x-TextWidth(s, size), y, size, bold, s)
+}
+
+// Line draws a thin horizontal or vertical rule between two points.
+func (d *Document) Line(x1, y1, x2, y2 float64) {
+	fmt.Fprintf(d.page(), "0.5 w %.2f %.2f m %.2f %.2f l S\n", x1, PageHeight-y1, x2, PageHeight-y2)
+}
+
+// TextWidth estimates the rendered width of s in Helvetica at the given size.
+func TextWidth(s string, size float64) float64 {
+	var w float64
+	for _, r := range s {
+		switch {
+		case r == ' ' || r == '.' || r == ',' || r == ':' || r == 'i' || r == 'l' || r == 'I' || r == '/':
+			w += 0.28
+		case r >= '0' && r <= '9':
+			w += 0.556
+		case r >= 'A' && r <= 'Z':
+			w += 0.667
+		default:
+			w += 0.5
+		}
+	}
+	return w * size
+}
+
+// Bytes serialises the document.
+func (d *Document) Bytes() []byte {
+	if len(d.pages) == 0 {
+		d.AddPage()
+	}
+
+	var out bytes.Buffer
+	var offsets []int
+	obj := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	// 1: catalog, 2: pages, 3-4: fonts, then a page + content stream pair per page.
+	n := len(d.pages)
+	kids := make([]string, n)
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 5+2*i)
+	}
+	obj("<< /Type /Catalog /Pages 2 0 R >>")
+	obj(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), n))
+	obj("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	obj("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	for i, p := range d.pages {
+		obj(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+			PageWidth, PageHeight, 6+2*i))
+		obj(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", p.Len(), p.String()))
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	return out.Bytes()
+}
+
+// escape makes s safe inside a PDF literal string. The standard fonts only
+// cover Latin-1, so anything outside it is replaced.
+func escape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == '₹':
+			b.WriteString("Rs.")
+		case r < 0x20:
+			b.WriteByte(' ')
+		case r > 0xff:
+			b.WriteByte('?')
+		default:
+			b.WriteByte(byte(r))
+		}
+	}
+	return b.String()
+}
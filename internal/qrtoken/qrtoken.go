@@ -0,0 +1,145 @@
+// Package qrtoken issues and verifies the signed, short-lived tokens encoded
+// in booking QR codes. Tokens are Ed25519-signed so a gate holding only the
+// public key can check them without reaching the server.
+//
+// A token is two base64url (unpadded) segments joined by a dot: the JSON
+// claims and the signature over those encoded claims.
+package qrtoken
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	ErrMalformed    = errors.New("malformed token")
+	ErrBadSignature = errors.New("token signature is invalid")
+	ErrExpired      = errors.New("token has expired")
+	ErrNotYetValid  = errors.New("token is not valid yet")
+	ErrBadKey       = errors.New("signing key must be a base64 32-byte Ed25519 seed")
+)
+
+// clockSkew is how far a gate's clock may run behind or ahead of ours.
+const clockSkew = 30 * time.Second
+
+// Claims is what a token vouches for.
+type Claims struct {
+	KeyID     string `json:"kid"`
+	BookingID string `json:"bid"`
+	SpotID    string `json:"sid"`
+	LotID     string `json:"lid"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+	// Nonce makes every token unique so a used one can be refused.
+	Nonce string `json:"jti"`
+}
+
+// Signer issues tokens with a private key and verifies them with its public
+// half.
+type Signer struct {
+	priv  ed25519.PrivateKey
+	pub   ed25519.PublicKey
+	keyID string
+}
+
+// NewSigner builds a signer from a base64-encoded 32-byte seed.
+func NewSigner(seed string) (*Signer, error) {
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(seed))
+	if err != nil || len(b) != ed25519.SeedSize {
+		return nil, ErrBadKey
+	}
+	return newSigner(ed25519.NewKeyFromSeed(b)), nil
+}
+
+// Generate makes a signer with a fresh random key. Its tokens stop verifying
+// once the process exits.
+func Generate() (*Signer, error) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return newSigner(priv), nil
+}
+
+func newSigner(priv ed25519.PrivateKey) *Signer {
+	pub := priv.Public().(ed25519.PublicKey)
+	sum := sha256.Sum256(pub)
+	return &Signer{priv: priv, pub: pub, keyID: hex.EncodeToString(sum[:8])}
+}
+
+// KeyID identifies the public key, so gates can tell which key to check with.
+func (s *Signer) KeyID() string { return s.keyID }
+
+// PublicKey is the verification key, base64 encoded.
+func (s *Signer) PublicKey() string { return base64.StdEncoding.EncodeToString(s.pub) }
+
+// Issue signs a token for a booking, valid for ttl from now.
+func (s *Signer) Issue(bookingID, spotID, lotID string, now time.Time, ttl time.Duration) (string, Claims, error) {
+	nonce := make([]byte, 12)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", Claims{}, err
+	}
+	c := Claims{
+		KeyID:     s.keyID,
+		BookingID: bookingID,
+		SpotID:    spotID,
+		LotID:     lotID,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(ttl).Unix(),
+		Nonce:     base64.RawURLEncoding.EncodeToString(nonce),
+	}
+	body, err := json.Marshal(c)
+	if err != nil {
+		return "", Claims{}, err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(body)
+	sig := ed25519.Sign(s.priv, []byte(payload))
+	return payload + "." + base64.RawURLEncoding.EncodeToString(sig), c, nil
+}
+
+// Verify checks a token against this signer's public key.
+func (s *Signer) Verify(token string, now time.Time) (Claims, error) {
+	return Verify(s.pub, token, now)
+}
+
+// Verify checks a token's signature and validity window using only the
+// public key. It does not know whether the token was already used.
+func Verify(pub ed25519.PublicKey, token string, now time.Time) (Claims, error) {
+	var c Claims
+	payload, sigPart, ok := strings.Cut(strings.TrimSpace(token), ".")
+	if !ok {
+		return c, ErrMalformed
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(sigPart)
+	if err != nil {
+		return c, ErrMalformed
+	}
+	if !ed25519.Verify(pub, []byte(payload), sig) {
+		return c, ErrBadSignature
+	}
+	body, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return c, ErrMalformed
+	}
+	if err := json.Unmarshal(body, &c); err != nil {
+		return c, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	if c.BookingID == "" || c.Nonce == "" {
+		return c, ErrMalformed
+	}
+	if now.Add(clockSkew).Unix() < c.IssuedAt {
+		return c, ErrNotYetValid
+	}
+	if now.Add(-clockSkew).Unix() >= c.ExpiresAt {
+		return c, ErrExpired
+	}
+	return c, nil
+}
@@ -0,0 +1,106 @@
+package qrtoken
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// seed is a fixed test key.
+var seed = base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+
+func TestNewSigner(t *testing.T) {
+	tests := []struct {
+		name string
+		seed string
+		err  error
+	}{
+		{"valid", seed, nil},
+		{"surrounding whitespace", " " + seed + "\n", nil},
+		{"not base64", "not a key!", ErrBadKey},
+		{"too short", base64.StdEncoding.EncodeToString([]byte("short")), ErrBadKey},
+		{"empty", "", ErrBadKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewSigner(tt.seed); err != tt.err {
+				t.Errorf("NewSigner error = %v, want %v", err, tt.err)
+			}
+		})
+	}
+
+	a, _ := NewSigner(seed)
+	b, _ := NewSigner(seed)
+	if a.KeyID() != b.KeyID() || a.PublicKey() != b.PublicKey() {
+		t.Error("the same seed gave different keys")
+	}
+}
+
+func TestIssueVerify(t *testing.T) {
+	s, err := NewSigner(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, time.May, 4, 9, 0, 0, 0, time.UTC)
+	token, issued, err := s.Issue("b1", "s1", "l1", now, 5*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if issued.KeyID != s.KeyID() || issued.BookingID != "b1" || issued.SpotID != "s1" || issued.LotID != "l1" ||
+		issued.IssuedAt != now.Unix() || issued.ExpiresAt != now.Add(5*time.Minute).Unix() || issued.Nonce == "" {
+		t.Fatalf("Issue claims = %+v", issued)
+	}
+
+	payload, sig, _ := strings.Cut(token, ".")
+	tampered, _ := base64.RawURLEncoding.DecodeString(payload)
+	tampered = []byte(strings.Replace(string(tampered), `"bid":"b1"`, `"bid":"b2"`, 1))
+
+	tests := []struct {
+		name  string
+		token string
+		now   time.Time
+		err   error
+	}{
+		{"valid", token, now, nil},
+		{"valid with whitespace", " " + token + "\n", now, nil},
+		{"gate clock behind within skew", token, now.Add(-clockSkew + time.Second), nil},
+		{"before issue beyond skew", token, now.Add(-clockSkew - time.Second), ErrNotYetValid},
+		{"just before expiry", token, now.Add(5*time.Minute + clockSkew - time.Second), nil},
+		{"expired", token, now.Add(5*time.Minute + clockSkew), ErrExpired},
+		{"no signature", payload, now, ErrMalformed},
+		{"signature not base64", payload + ".***", now, ErrMalformed},
+		{"tampered claims", base64.RawURLEncoding.EncodeToString(tampered) + "." + sig, now, ErrBadSignature},
+		{"truncated signature", payload + "." + sig[:10], now, ErrBadSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := s.Verify(tt.token, tt.now)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Verify error = %v, want %v", err, tt.err)
+			}
+			if err == nil && c != issued {
+				t.Errorf("Verify claims = %+v, want %+v", c, issued)
+			}
+		})
+	}
+
+	if _, err := other.Verify(token, now); err != ErrBadSignature {
+		t.Errorf("Verify with another key: error = %v, want ErrBadSignature", err)
+	}
+}
+
+func TestIssueUniqueNonce(t *testing.T) {
+	s, _ := NewSigner(seed)
+	now := time.Now()
+	a, ca, _ := s.Issue("b1", "s1", "l1", now, time.Minute)
+	b, cb, _ := s.Issue("b1", "s1", "l1", now, time.Minute)
+	if a == b || ca.Nonce == cb.Nonce {
+		t.Error("two tokens for the same booking are identical")
+	}
+}
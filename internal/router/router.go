@@ -2,6 +2,7 @@ package router
 
 import (
 	"database/sql"
+	"log"
 	"time"
 
 	"Backend-Go/internal/config"
@@ -12,22 +13,26 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-func Setup(db *sql.DB, cfg *config.Config) *gin.Engine {
+func Setup(db *sql.DB, cfg *config.Config, h *handler.Handler) *gin.Engine {
 	gin.SetMode(gin.ReleaseMode)
 	r := gin.Default()
+	// client IPs (audit log) come from X-Forwarded-For only behind the
+	// proxies in TRUSTED_PROXIES; LoadConfig has checked the list
+	if err := r.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		log.Fatalf("trusted proxies: %v", err)
+	}
 
-	// CORS for local frontend
+	// CORS for the frontends in CORS_ORIGINS
 	c := cors.Config{
-		AllowOrigins:     []string{"http://localhost:3000", "http://127.0.0.1:3000"},
-		AllowMethods:     []string{"GET", "POST", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length", "Content-Type"},
+		AllowOrigins:     cfg.CORSOrigins,
+		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", middleware.RequestIDHeader},
+		ExposeHeaders:    []string{"Content-Length", "Content-Type", middleware.RequestIDHeader},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	}
 	r.Use(cors.New(c))
-
-	h := handler.New(db, cfg)
+	r.Use(middleware.RequestID())
 
 	// Health
 	r.GET("/health", func(c *gin.Context) { c.JSON(200, gin.H{"status": "ok"}) })
@@ -57,26 +62,140 @@ func Setup(db *sql.DB, cfg *config.Config) *gin.Engine {
 		auth.POST("/login", h.Login)
 	}
 
+	// Public key for verifying gate QR tokens offline
+	api.GET("/gates/qr-key", h.QRKey)
+
 	// User
 	user := api.Group("/")
 	user.Use(middleware.AuthJWT(cfg))
 	{
 		user.GET("/user/me", h.Me)
 		user.POST("/vehicles", h.AddVehicle)
+		user.GET("/parking/quote", h.Quote)
+		user.POST("/parking/quote", h.LockQuote)
+		user.GET("/parking/forecast", h.Forecast)
 		user.POST("/parking/book", h.BookSpot)
 		user.POST("/parking/release/:spotId", h.Release)
 		user.GET("/parking/history", h.UserHistory)
+		user.GET("/bookings/:id/receipt", h.Receipt)
+		user.GET("/bookings/:id/qr", h.BookingQR)
+		user.POST("/bookings/:id/dispute", h.OpenDispute)
+		user.GET("/pass-products", h.ListPassProducts)
+		user.GET("/passes", h.MyPasses)
+		user.POST("/passes", h.PurchasePass)
+		user.POST("/passes/:id/renew", h.RenewPass)
+		user.GET("/waitlist", h.MyWaitlist)
+		user.POST("/waitlist", h.JoinWaitlist)
+		user.DELETE("/waitlist/:id", h.CancelWaitlist)
+		user.POST("/waitlist/:id/confirm", h.ConfirmWaitlistOffer)
+		user.GET("/notification-preferences", h.NotificationPreferences)
+		user.PUT("/notification-preferences", h.UpdateNotificationPreferences)
+		user.POST("/push-tokens", h.RegisterPushToken)
+		user.DELETE("/push-tokens/:token", h.DeletePushToken)
+		user.GET("/notifications", h.MyNotifications)
+		user.GET("/orgs", h.MyOrgs)
+		user.POST("/orgs/:id/members", h.AddOrgMember)
+		user.DELETE("/orgs/:id/members/:userId", h.RemoveOrgMember)
+		user.POST("/orgs/:id/vehicles", h.AddFleetVehicle)
+		user.GET("/orgs/:id/bookings", h.OrgBookings)
+		user.GET("/orgs/:id/invoices", h.OrgInvoices)
+		user.GET("/orgs/:id/invoices/:invoiceId", h.OrgInvoice)
+	}
+
+	// ANPR cameras (X-API-Key)
+	cameras := api.Group("/anpr")
+	cameras.Use(h.CameraAuth())
+	{
+		cameras.POST("/reads", h.IngestANPRRead)
 	}
 
+	// Gate operators and kiosks (admins too)
+	ops := api.Group("/")
+	ops.Use(middleware.AuthJWT(cfg), middleware.RequireRole("admin", "operator"))
+	{
+		ops.POST("/tickets", h.IssueTicket)
+		ops.POST("/tickets/exit", h.CloseTicket)
+		ops.GET("/tickets/:code", h.GetTicket)
+		ops.GET("/tickets/:code/receipt", h.TicketReceipt)
+		ops.POST("/gates/verify", h.VerifyGateToken)
+		ops.POST("/gates/check-in", h.GateCheckIn)
+		ops.POST("/gates/check-out", h.GateCheckOut)
+		ops.POST("/gates/:id/events", h.RecordGateEvent)
+		ops.POST("/gates/:id/barrier", h.BarrierCommand)
+		ops.GET("/anpr/reviews", h.ANPRReviews)
+		ops.POST("/anpr/reads/:id/resolve", h.ResolveANPRRead)
+		ops.GET("/parking/analytics/heatmap", h.Heatmap)
+		ops.GET("/occupancy/discrepancies", h.ListDiscrepancies)
+		ops.POST("/occupancy/discrepancies/:id/acknowledge", h.AcknowledgeDiscrepancy)
+	}
+
+	// Live occupancy for the admin dashboard; browsers cannot set headers on
+	// EventSource/WebSocket, so they trade their token for a one-time
+	// ?ticket= first
+	stream := api.Group("/parking/occupancy/stream")
+	stream.POST("/ticket", middleware.AuthJWT(cfg), middleware.RequireRole("admin"), h.CreateStreamTicket)
+	stream.GET("", h.StreamAuth(middleware.AuthJWT(cfg)), middleware.RequireRole("admin"), h.OccupancyStream)
+
 	// Admin
 	admin := api.Group("/")
 	admin.Use(middleware.AuthJWT(cfg), middleware.RequireRole("admin"))
 	{
+		admin.PUT("/users/:id/role", h.SetUserRole)
 		admin.POST("/parking-lots", h.CreateLot)
+		admin.PUT("/parking-lots/:id/billing", h.SetLotBilling)
+		admin.PUT("/parking-lots/:id/timezone", h.SetLotTimezone)
+		admin.POST("/parking-lots/:id/gates", h.CreateGate)
+		admin.GET("/parking-lots/:id/gates", h.ListGates)
+		admin.GET("/parking-lots/:id/reconciliation", h.Reconciliation)
+		admin.PUT("/parking-lots/:id/occupancy-policy", h.SetOccupancyPolicy)
+		admin.PUT("/parking-lots/:id/pricing", h.SetLotPricing)
+		admin.GET("/parking-lots/:id/pricing", h.GetLotPricing)
+		admin.GET("/gates/events", h.ListGateEvents)
+		admin.GET("/devices", h.ListDevices)
+		admin.POST("/gates/:id/cameras", h.CreateCamera)
+		admin.GET("/gates/:id/cameras", h.ListCameras)
 		admin.POST("/parking-spots", h.CreateSpot)
 		admin.DELETE("/parking-spots/:id", h.DeleteSpot)
+		admin.POST("/pass-products", h.CreatePassProduct)
+		admin.GET("/passes/expiring", h.ExpiringPasses)
+		admin.POST("/orgs", h.CreateOrg)
+		admin.POST("/orgs/:id/invoices", h.GenerateOrgInvoices)
+		admin.POST("/promo-codes", h.CreatePromo)
+		admin.GET("/promo-codes", h.ListPromos)
+		admin.POST("/promo-codes/:id/deactivate", h.DeactivatePromo)
+		admin.POST("/bookings/:id/refunds", h.RefundBooking)
+		admin.POST("/bookings/:id/adjustments", h.AdjustBooking)
+		admin.POST("/bookings/:id/dispute/resolve", h.ResolveDispute)
+		admin.GET("/bookings/:id/pricing", h.GetBookingPricing)
 		admin.GET("/parking/occupancy", h.Occupancy)
+		admin.GET("/parking/occupancy/history", h.OccupancyHistory)
 		admin.GET("/parking/reports", h.Reports)
+		admin.GET("/exports/bookings", h.ExportBookings)
+		admin.GET("/exports/payments", h.ExportPayments)
+		admin.GET("/exports/reports", h.ExportReports)
+		admin.POST("/report-schedules", h.CreateReportSchedule)
+		admin.GET("/report-schedules", h.ListReportSchedules)
+		admin.GET("/report-schedules/:id", h.GetReportSchedule)
+		admin.PUT("/report-schedules/:id", h.UpdateReportSchedule)
+		admin.DELETE("/report-schedules/:id", h.DeleteReportSchedule)
+		admin.GET("/report-schedules/:id/runs", h.ListReportRuns)
+		admin.POST("/report-schedules/:id/run", h.RunReportNow)
+		admin.POST("/holidays", h.CreateHoliday)
+		admin.GET("/holidays", h.ListHolidays)
+		admin.DELETE("/holidays/:id", h.DeleteHoliday)
+		admin.GET("/audit-log", h.ListAuditLog)
+		admin.GET("/outbox", h.ListOutboxEvents)
+		admin.POST("/outbox/:id/retry", h.RetryOutboxEvent)
+		admin.POST("/webhooks", h.CreateWebhook)
+		admin.GET("/webhooks", h.ListWebhooks)
+		admin.GET("/webhooks/:id", h.GetWebhook)
+		admin.PUT("/webhooks/:id", h.UpdateWebhook)
+		admin.DELETE("/webhooks/:id", h.DeleteWebhook)
+		admin.POST("/webhooks/:id/rotate-secret", h.RotateWebhookSecret)
+		admin.GET("/webhooks/:id/deliveries", h.ListWebhookDeliveries)
+		admin.POST("/webhooks/:id/replay-dead", h.ReplayDeadWebhooks)
+		admin.GET("/webhook-deliveries/:id", h.GetWebhookDelivery)
+		admin.POST("/webhook-deliveries/:id/replay", h.ReplayWebhookDelivery)
 	}
 
 	r.NoRoute(func(c *gin.Context) {
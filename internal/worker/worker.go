@@ -0,0 +1,35 @@
+// Package worker runs the service's in-process background jobs.
+package worker
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Every calls fn every interval until ctx is cancelled. Errors are logged
+// and the job carries on at its next tick; a panic in fn is recovered so one
+// bad run cannot take the server down.
+func Every(ctx context.Context, name string, interval time.Duration, fn func(context.Context) error) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		run(ctx, name, fn)
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func run(ctx context.Context, name string, fn func(context.Context) error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("worker %s: panic: %v", name, r)
+		}
+	}()
+	if err := fn(ctx); err != nil && ctx.Err() == nil {
+		log.Printf("worker %s: %v", name, err)
+	}
+}
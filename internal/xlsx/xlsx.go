@@ -0,0 +1,179 @@
+// Package xlsx is a deliberately small streaming XLSX writer: one worksheet
+// of inline strings and numbers, with a bold header row. Rows go straight to
+// the underlying writer, so exports of any size use constant memory.
+package xlsx
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Writer writes a single-sheet workbook. Call Close to finish the file.
+type Writer struct {
+	zw   *zip.Writer
+	buf  *bufio.Writer
+	rows int
+	err  error
+}
+
+// NewWriter writes the workbook's fixed parts to w and opens a sheet named
+// sheet for rows.
+func NewWriter(w io.Writer, sheet string) (*Writer, error) {
+	zw := zip.NewWriter(w)
+	parts := []struct{ name, body string }{
+		{"[Content_Types].xml", contentTypes},
+		{"_rels/.rels", rootRels},
+		{"xl/workbook.xml", fmt.Sprintf(workbook, escape(sheet))},
+		{"xl/_rels/workbook.xml.rels", workbookRels},
+		{"xl/styles.xml", styles},
+	}
+	for _, p := range parts {
+		f, err := zw.Create(p.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(f, xml.Header+p.body); err != nil {
+			return nil, err
+		}
+	}
+	f, err := zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	buf := bufio.NewWriter(f)
+	buf.WriteString(xml.Header + sheetOpen)
+	return &Writer{zw: zw, buf: buf}, nil
+}
+
+// Header writes a bold row of column names.
+func (w *Writer) Header(names ...string) error {
+	cells := make([]interface{}, len(names))
+	for i, n := range names {
+		cells[i] = n
+	}
+	return w.row(cells, ` s="1"`)
+}
+
+// Row writes one row. Integers and floats become number cells, nil an empty
+// cell and anything else an inline string of its fmt representation.
+func (w *Writer) Row(cells ...interface{}) error { return w.row(cells, "") }
+
+func (w *Writer) row(cells []interface{}, style string) error {
+	if w.err != nil {
+		return w.err
+	}
+	w.rows++
+	fmt.Fprintf(w.buf, `<row r="%d">`, w.rows)
+	for i, v := range cells {
+		ref := column(i) + strconv.Itoa(w.rows)
+		var num string
+		switch v := v.(type) {
+		case nil:
+			continue
+		case int:
+			num = strconv.Itoa(v)
+		case int64:
+			num = strconv.FormatInt(v, 10)
+		case float64:
+			num = strconv.FormatFloat(v, 'f', -1, 64)
+		case string:
+			fmt.Fprintf(w.buf, `<c r="%s" t="inlineStr"%s><is><t xml:space="preserve">%s</t></is></c>`, ref, style, escape(v))
+			continue
+		default:
+			fmt.Fprintf(w.buf, `<c r="%s" t="inlineStr"%s><is><t xml:space="preserve">%s</t></is></c>`, ref, style, escape(fmt.Sprint(v)))
+			continue
+		}
+		fmt.Fprintf(w.buf, `<c r="%s"%s><v>%s</v></c>`, ref, style, num)
+	}
+	_, w.err = w.buf.WriteString(`</row>`)
+	return w.err
+}
+
+// Flush pushes buffered rows to the underlying writer.
+func (w *Writer) Flush() error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.err = w.buf.Flush(); w.err != nil {
+		return w.err
+	}
+	w.err = w.zw.Flush()
+	return w.err
+}
+
+// Close ends the sheet and writes the zip directory. It does not close the
+// underlying writer.
+func (w *Writer) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	w.buf.WriteString(sheetClose)
+	if err := w.buf.Flush(); err != nil {
+		return err
+	}
+	return w.zw.Close()
+}
+
+// column turns a zero-based index into a column name: A, B, ..., Z, AA, ...
+func column(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
+
+// escape makes s safe as XML text, dropping characters XML cannot carry.
+func escape(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r == '\t' || r == '\n' || r == '\r' || r >= 0x20 && r != 0xFFFE && r != 0xFFFF {
+			return r
+		}
+		return -1
+	}, s)
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+const contentTypes = `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+	`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+	`<Default Extension="xml" ContentType="application/xml"/>` +
+	`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+	`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+	`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>` +
+	`</Types>`
+
+const rootRels = `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+	`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+	`</Relationships>`
+
+const workbook = `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" ` +
+	`xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+	`<sheets><sheet name="%s" sheetId="1" r:id="rId1"/></sheets></workbook>`
+
+const workbookRels = `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+	`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+	`<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>` +
+	`</Relationships>`
+
+// styles defines cell format 0 (plain) and 1 (bold).
+const styles = `<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+	`<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>` +
+	`<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>` +
+	`<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>` +
+	`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>` +
+	`<cellXfs count="2"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>` +
+	`<xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/></cellXfs>` +
+	`</styleSheet>`
+
+const sheetOpen = `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+	`<sheetViews><sheetView workbookViewId="0"><pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/></sheetView></sheetViews>` +
+	`<sheetData>`
+
+const sheetClose = `</sheetData></worksheet>`
@@ -0,0 +1,133 @@
+package xlsx
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestColumn(t *testing.T) {
+	tests := map[int]string{0: "A", 1: "B", 25: "Z", 26: "AA", 27: "AB", 51: "AZ", 52: "BA", 701: "ZZ", 702: "AAA"}
+	for i, want := range tests {
+		if got := column(i); got != want {
+			t.Errorf("column(%d) = %q, want %q", i, got, want)
+		}
+	}
+}
+
+func TestEscape(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"plain", "plain"},
+		{`a & <b> "c"`, "a &amp; &lt;b&gt; &#34;c&#34;"},
+		{"bell\x07 and nul\x00 dropped", "bell and nul dropped"},
+		{"tab\tkept", "tab&#x9;kept"},
+		{"₹ 1,180.00", "₹ 1,180.00"},
+	}
+	for _, tt := range tests {
+		if got := escape(tt.in); got != tt.want {
+			t.Errorf("escape(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+type sheet struct {
+	Rows []struct {
+		R     string `xml:"r,attr"`
+		Cells []struct {
+			R      string `xml:"r,attr"`
+			T      string `xml:"t,attr"`
+			S      string `xml:"s,attr"`
+			V      string `xml:"v"`
+			Inline string `xml:"is>t"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+func TestWriter(t *testing.T) {
+	var out bytes.Buffer
+	w, err := NewWriter(&out, "Bookings & <refunds>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Header("Name", "Amount"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Row("Lot <A>", int64(118000), nil, 2.5, 7, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(out.Bytes()), int64(out.Len()))
+	if err != nil {
+		t.Fatalf("not a zip: %v", err)
+	}
+	parts := map[string][]byte{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts[f.Name] = body
+	}
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels", "xl/styles.xml", "xl/worksheets/sheet1.xml"} {
+		body, ok := parts[name]
+		if !ok {
+			t.Errorf("missing part %s", name)
+			continue
+		}
+		if err := xml.Unmarshal(body, new(struct{})); err != nil {
+			t.Errorf("%s is not well-formed XML: %v", name, err)
+		}
+	}
+	if !strings.Contains(string(parts["xl/workbook.xml"]), `name="Bookings &amp; &lt;refunds&gt;"`) {
+		t.Errorf("sheet name not escaped in workbook.xml: %s", parts["xl/workbook.xml"])
+	}
+
+	var s sheet
+	if err := xml.Unmarshal(parts["xl/worksheets/sheet1.xml"], &s); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Rows) != 2 {
+		t.Fatalf("got %d rows, want 2", len(s.Rows))
+	}
+	header := s.Rows[0]
+	if header.R != "1" || len(header.Cells) != 2 || header.Cells[1].Inline != "Amount" || header.Cells[1].S != "1" {
+		t.Errorf("header row = %+v, want bold Name, Amount", header)
+	}
+
+	row := s.Rows[1]
+	type cell struct{ ref, typ, value string }
+	want := []cell{
+		{"A2", "inlineStr", "Lot <A>"},
+		{"B2", "", "118000"},
+		// nil leaves C2 empty
+		{"D2", "", "2.5"},
+		{"E2", "", "7"},
+		{"F2", "inlineStr", "true"},
+	}
+	if len(row.Cells) != len(want) {
+		t.Fatalf("row 2 has %d cells, want %d", len(row.Cells), len(want))
+	}
+	for i, c := range row.Cells {
+		value := c.V
+		if c.T == "inlineStr" {
+			value = c.Inline
+		}
+		if got := (cell{c.R, c.T, value}); got != want[i] || c.S != "" {
+			t.Errorf("cell %d = %+v (style %q), want %+v", i, got, c.S, want[i])
+		}
+	}
+}
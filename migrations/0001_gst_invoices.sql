@@ -0,0 +1,56 @@
+-- GST tax invoices for completed bookings.
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS gstin text;
+
+ALTER TABLE bookings ADD COLUMN IF NOT EXISTS amount_paise bigint;
+
+-- Supplier details and the hourly rate each lot bills at. Lots without a
+-- profile are not invoiced.
+CREATE TABLE IF NOT EXISTS lot_billing_profiles (
+    lot_id              uuid PRIMARY KEY REFERENCES parking_lots(id) ON DELETE CASCADE,
+    legal_name          text        NOT NULL,
+    gstin               text        NOT NULL,
+    address             text        NOT NULL DEFAULT '',
+    state_code          char(2)     NOT NULL,
+    sac_code            text        NOT NULL DEFAULT '996743',
+    gst_rate_bp         integer     NOT NULL DEFAULT 1800 CHECK (gst_rate_bp >= 0),
+    rate_per_hour_paise bigint      NOT NULL CHECK (rate_per_hour_paise >= 0),
+    invoice_prefix      text        NOT NULL,
+    updated_at          timestamptz NOT NULL DEFAULT now()
+);
+
+-- Gapless per-lot, per-financial-year invoice counters. Rows are locked by the
+-- issuing transaction, so numbers are only consumed on commit.
+CREATE TABLE IF NOT EXISTS invoice_sequences (
+    lot_id         uuid    NOT NULL REFERENCES parking_lots(id) ON DELETE CASCADE,
+    financial_year text    NOT NULL,
+    last_no        bigint  NOT NULL DEFAULT 0,
+    PRIMARY KEY (lot_id, financial_year)
+);
+
+CREATE TABLE IF NOT EXISTS invoices (
+    id               uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    booking_id       uuid        NOT NULL UNIQUE REFERENCES bookings(id),
+    lot_id           uuid        NOT NULL REFERENCES parking_lots(id),
+    invoice_no       text        NOT NULL,
+    financial_year   text        NOT NULL,
+    issued_at        timestamptz NOT NULL DEFAULT now(),
+    supplier_name    text        NOT NULL,
+    supplier_gstin   text        NOT NULL,
+    supplier_address text        NOT NULL DEFAULT '',
+    supplier_state   char(2)     NOT NULL,
+    customer_name    text        NOT NULL,
+    customer_email   text        NOT NULL DEFAULT '',
+    customer_gstin   text,
+    place_of_supply  char(2)     NOT NULL,
+    sac_code         text        NOT NULL,
+    billed_hours     bigint      NOT NULL,
+    rate_paise       bigint      NOT NULL,
+    gst_rate_bp      integer     NOT NULL,
+    taxable_paise    bigint      NOT NULL,
+    cgst_paise       bigint      NOT NULL DEFAULT 0,
+    sgst_paise       bigint      NOT NULL DEFAULT 0,
+    igst_paise       bigint      NOT NULL DEFAULT 0,
+    total_paise      bigint      NOT NULL,
+    UNIQUE (lot_id, invoice_no)
+);
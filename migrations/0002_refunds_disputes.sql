@@ -0,0 +1,53 @@
+-- Payments, refunds, manual fee adjustments and booking disputes.
+
+CREATE TABLE IF NOT EXISTS payments (
+    id             uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    booking_id     uuid        NOT NULL REFERENCES bookings(id),
+    provider       text        NOT NULL,
+    provider_ref   text        NOT NULL,
+    amount_paise   bigint      NOT NULL CHECK (amount_paise > 0),
+    refunded_paise bigint      NOT NULL DEFAULT 0 CHECK (refunded_paise >= 0 AND refunded_paise <= amount_paise),
+    status         text        NOT NULL DEFAULT 'CAPTURED'
+                   CHECK (status IN ('CAPTURED', 'PARTIALLY_REFUNDED', 'REFUNDED')),
+    created_at     timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS payments_booking_idx ON payments (booking_id);
+
+CREATE TABLE IF NOT EXISTS refunds (
+    id           uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    payment_id   uuid        NOT NULL REFERENCES payments(id),
+    booking_id   uuid        NOT NULL REFERENCES bookings(id),
+    amount_paise bigint      NOT NULL CHECK (amount_paise > 0),
+    reason_code  text        NOT NULL,
+    note         text        NOT NULL DEFAULT '',
+    provider_ref text        NOT NULL,
+    status       text        NOT NULL,
+    created_by   uuid        REFERENCES users(id),
+    created_at   timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS refunds_booking_idx ON refunds (booking_id);
+
+-- Adjustments change the taxable value of a booking. Those made before
+-- release are folded into the invoice (invoice_id set on release); later
+-- reductions are paid back as refunds.
+CREATE TABLE IF NOT EXISTS booking_adjustments (
+    id           uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    booking_id   uuid        NOT NULL REFERENCES bookings(id),
+    kind         text        NOT NULL CHECK (kind IN ('ADJUSTMENT', 'WAIVER')),
+    amount_paise bigint      NOT NULL DEFAULT 0,
+    reason_code  text        NOT NULL,
+    note         text        NOT NULL DEFAULT '',
+    invoice_id   uuid        REFERENCES invoices(id),
+    refund_id    uuid        REFERENCES refunds(id),
+    created_by   uuid        REFERENCES users(id),
+    created_at   timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS booking_adjustments_booking_idx ON booking_adjustments (booking_id);
+
+ALTER TABLE bookings
+    ADD COLUMN IF NOT EXISTS dispute_status text NOT NULL DEFAULT 'NONE'
+        CHECK (dispute_status IN ('NONE', 'OPEN', 'ACCEPTED', 'REJECTED')),
+    ADD COLUMN IF NOT EXISTS dispute_reason      text,
+    ADD COLUMN IF NOT EXISTS dispute_opened_at   timestamptz,
+    ADD COLUMN IF NOT EXISTS dispute_resolved_at timestamptz,
+    ADD COLUMN IF NOT EXISTS dispute_resolution  text;
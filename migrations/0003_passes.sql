@@ -0,0 +1,46 @@
+-- Monthly passes: products sold per lot, passes held by users, and the
+-- vehicles registered on each pass.
+
+CREATE TABLE IF NOT EXISTS pass_products (
+    id            uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    lot_id        uuid        NOT NULL REFERENCES parking_lots(id) ON DELETE CASCADE,
+    name          text        NOT NULL,
+    validity_days integer     NOT NULL CHECK (validity_days > 0),
+    vehicle_type  text        NOT NULL DEFAULT '',
+    spot_mode     text        NOT NULL CHECK (spot_mode IN ('RESERVED', 'FLOATING')),
+    max_vehicles  integer     NOT NULL DEFAULT 1 CHECK (max_vehicles > 0),
+    price_paise   bigint      NOT NULL CHECK (price_paise >= 0),
+    active        boolean     NOT NULL DEFAULT true,
+    created_at    timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS passes (
+    id         uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    product_id uuid        NOT NULL REFERENCES pass_products(id),
+    user_id    uuid        NOT NULL REFERENCES users(id),
+    lot_id     uuid        NOT NULL REFERENCES parking_lots(id),
+    spot_id    uuid        REFERENCES parking_spots(id),
+    starts_at  timestamptz NOT NULL DEFAULT now(),
+    expires_at timestamptz NOT NULL,
+    status     text        NOT NULL DEFAULT 'ACTIVE' CHECK (status IN ('ACTIVE', 'CANCELLED')),
+    created_at timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS passes_user_idx ON passes (user_id);
+CREATE INDEX IF NOT EXISTS passes_expiry_idx ON passes (expires_at) WHERE status = 'ACTIVE';
+
+CREATE TABLE IF NOT EXISTS pass_vehicles (
+    pass_id    uuid NOT NULL REFERENCES passes(id) ON DELETE CASCADE,
+    vehicle_id uuid NOT NULL REFERENCES vehicles(id),
+    PRIMARY KEY (pass_id, vehicle_id)
+);
+
+ALTER TABLE bookings ADD COLUMN IF NOT EXISTS pass_id uuid REFERENCES passes(id);
+
+-- Pass purchases and renewals are paid for without a booking.
+ALTER TABLE payments ALTER COLUMN booking_id DROP NOT NULL;
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS pass_id uuid REFERENCES passes(id);
+
+-- Spots held for a reserved pass.
+ALTER TABLE parking_spots DROP CONSTRAINT IF EXISTS parking_spots_status_check;
+ALTER TABLE parking_spots ADD CONSTRAINT parking_spots_status_check
+    CHECK (status IN ('AVAILABLE', 'OCCUPIED', 'DISABLED', 'RESERVED'));
@@ -0,0 +1,33 @@
+-- Promotion codes and their redemptions.
+
+CREATE TABLE IF NOT EXISTS promo_codes (
+    id                 uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    code               text        NOT NULL,
+    kind               text        NOT NULL CHECK (kind IN ('PERCENT', 'FIXED')),
+    -- basis points for PERCENT, paise for FIXED
+    value              bigint      NOT NULL CHECK (value > 0),
+    max_discount_paise bigint      NOT NULL DEFAULT 0,
+    min_spend_paise    bigint      NOT NULL DEFAULT 0,
+    valid_from         timestamptz NOT NULL DEFAULT now(),
+    valid_to           timestamptz,
+    max_uses           integer,
+    max_uses_per_user  integer,
+    lot_id             uuid        REFERENCES parking_lots(id) ON DELETE CASCADE,
+    active             boolean     NOT NULL DEFAULT true,
+    created_at         timestamptz NOT NULL DEFAULT now()
+);
+CREATE UNIQUE INDEX IF NOT EXISTS promo_codes_code_idx ON promo_codes (upper(code));
+
+-- One row per booking the code actually discounted, written when the invoice
+-- is issued; usage limits count these.
+CREATE TABLE IF NOT EXISTS promo_redemptions (
+    id             uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    promo_id       uuid        NOT NULL REFERENCES promo_codes(id),
+    booking_id     uuid        NOT NULL UNIQUE REFERENCES bookings(id),
+    user_id        uuid        REFERENCES users(id),
+    discount_paise bigint      NOT NULL,
+    created_at     timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS promo_redemptions_promo_idx ON promo_redemptions (promo_id, user_id);
+
+ALTER TABLE bookings ADD COLUMN IF NOT EXISTS promo_code_id uuid REFERENCES promo_codes(id);
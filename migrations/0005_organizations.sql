@@ -0,0 +1,57 @@
+-- Corporate accounts: organisations, their members and fleet vehicles, and
+-- monthly consolidated invoices in place of per-session payments.
+
+CREATE TABLE IF NOT EXISTS organizations (
+    id            uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    name          text        NOT NULL,
+    gstin         text,
+    billing_email text        NOT NULL DEFAULT '',
+    created_at    timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS org_members (
+    org_id              uuid        NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    user_id             uuid        NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role                text        NOT NULL DEFAULT 'MEMBER' CHECK (role IN ('ADMIN', 'MEMBER')),
+    monthly_limit_paise bigint      CHECK (monthly_limit_paise >= 0),
+    created_at          timestamptz NOT NULL DEFAULT now(),
+    PRIMARY KEY (org_id, user_id)
+);
+CREATE INDEX IF NOT EXISTS org_members_user_idx ON org_members (user_id);
+
+-- Fleet vehicles carry the organisation; any member may book with them.
+ALTER TABLE vehicles ADD COLUMN IF NOT EXISTS org_id uuid REFERENCES organizations(id);
+
+-- One consolidated invoice per organisation, lot (supplier GSTIN) and month.
+CREATE TABLE IF NOT EXISTS org_invoices (
+    id              uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    org_id          uuid        NOT NULL REFERENCES organizations(id),
+    lot_id          uuid        NOT NULL REFERENCES parking_lots(id),
+    period          date        NOT NULL,
+    invoice_no      text        NOT NULL,
+    financial_year  text        NOT NULL,
+    issued_at       timestamptz NOT NULL DEFAULT now(),
+    supplier_name   text        NOT NULL,
+    supplier_gstin  text        NOT NULL,
+    supplier_address text       NOT NULL DEFAULT '',
+    supplier_state  char(2)     NOT NULL,
+    customer_gstin  text,
+    place_of_supply char(2)     NOT NULL,
+    sac_code        text        NOT NULL,
+    sessions        integer     NOT NULL,
+    gst_rate_bp     integer     NOT NULL,
+    taxable_paise   bigint      NOT NULL,
+    cgst_paise      bigint      NOT NULL DEFAULT 0,
+    sgst_paise      bigint      NOT NULL DEFAULT 0,
+    igst_paise      bigint      NOT NULL DEFAULT 0,
+    total_paise     bigint      NOT NULL,
+    UNIQUE (org_id, lot_id, period),
+    UNIQUE (lot_id, invoice_no)
+);
+
+ALTER TABLE bookings
+    ADD COLUMN IF NOT EXISTS org_id         uuid REFERENCES organizations(id),
+    ADD COLUMN IF NOT EXISTS org_invoice_id uuid REFERENCES org_invoices(id),
+    -- pre-tax charge of the session, set on release for every booking
+    ADD COLUMN IF NOT EXISTS taxable_paise  bigint;
+CREATE INDEX IF NOT EXISTS bookings_org_idx ON bookings (org_id, end_time) WHERE org_id IS NOT NULL;
@@ -0,0 +1,29 @@
+-- Per-lot waitlists with time-limited holds on released spots.
+
+-- Spots can be limited to one vehicle type; NULL accepts any.
+ALTER TABLE parking_spots ADD COLUMN IF NOT EXISTS vehicle_type text;
+
+ALTER TABLE parking_spots DROP CONSTRAINT IF EXISTS parking_spots_status_check;
+ALTER TABLE parking_spots ADD CONSTRAINT parking_spots_status_check
+    CHECK (status IN ('AVAILABLE', 'OCCUPIED', 'DISABLED', 'RESERVED', 'HELD'));
+
+CREATE TABLE IF NOT EXISTS waitlist_entries (
+    id               uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    lot_id           uuid        NOT NULL REFERENCES parking_lots(id) ON DELETE CASCADE,
+    user_id          uuid        NOT NULL REFERENCES users(id),
+    vehicle_id       uuid        NOT NULL REFERENCES vehicles(id),
+    status           text        NOT NULL DEFAULT 'WAITING'
+                     CHECK (status IN ('WAITING', 'OFFERED', 'CONFIRMED', 'EXPIRED', 'CANCELLED')),
+    spot_id          uuid        REFERENCES parking_spots(id) ON DELETE SET NULL,
+    offered_at       timestamptz,
+    offer_expires_at timestamptz,
+    booking_id       uuid        REFERENCES bookings(id),
+    created_at       timestamptz NOT NULL DEFAULT now()
+);
+-- a vehicle waits in at most one queue at a time
+CREATE UNIQUE INDEX IF NOT EXISTS waitlist_entries_vehicle_idx
+    ON waitlist_entries (vehicle_id) WHERE status IN ('WAITING', 'OFFERED');
+CREATE INDEX IF NOT EXISTS waitlist_entries_queue_idx
+    ON waitlist_entries (lot_id, created_at) WHERE status = 'WAITING';
+CREATE INDEX IF NOT EXISTS waitlist_entries_offer_idx
+    ON waitlist_entries (offer_expires_at) WHERE status = 'OFFERED';
@@ -0,0 +1,21 @@
+-- Walk-in tickets: sessions for drivers without an account, identified by
+-- plate and a printed ticket code instead of a user and vehicle.
+
+ALTER TABLE bookings ALTER COLUMN user_id DROP NOT NULL;
+ALTER TABLE bookings ALTER COLUMN vehicle_id DROP NOT NULL;
+ALTER TABLE bookings ADD COLUMN IF NOT EXISTS plate text;
+ALTER TABLE bookings ADD COLUMN IF NOT EXISTS ticket_code text;
+
+ALTER TABLE bookings DROP CONSTRAINT IF EXISTS bookings_party_check;
+ALTER TABLE bookings ADD CONSTRAINT bookings_party_check
+    CHECK ((user_id IS NOT NULL AND vehicle_id IS NOT NULL)
+        OR (plate IS NOT NULL AND ticket_code IS NOT NULL));
+
+CREATE UNIQUE INDEX IF NOT EXISTS bookings_ticket_code_idx ON bookings (ticket_code);
+-- one open walk-in session per plate
+CREATE UNIQUE INDEX IF NOT EXISTS bookings_active_plate_idx
+    ON bookings (upper(plate)) WHERE end_time IS NULL AND ticket_code IS NOT NULL;
+
+-- gate/kiosk operators issue and close tickets
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_role_check;
+ALTER TABLE users ADD CONSTRAINT users_role_check CHECK (role IN ('user', 'admin', 'operator'));
@@ -0,0 +1,14 @@
+-- Gate check-in/check-out with signed QR tokens.
+
+ALTER TABLE bookings ADD COLUMN IF NOT EXISTS checked_in_at timestamptz;
+ALTER TABLE bookings ADD COLUMN IF NOT EXISTS checked_out_at timestamptz;
+
+-- every token is accepted online at most once
+CREATE TABLE IF NOT EXISTS qr_token_uses (
+    jti        text PRIMARY KEY,
+    booking_id uuid        NOT NULL REFERENCES bookings(id) ON DELETE CASCADE,
+    action     text        NOT NULL CHECK (action IN ('CHECK_IN', 'CHECK_OUT')),
+    gate_id    text,
+    used_at    timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS qr_token_uses_booking_idx ON qr_token_uses (booking_id);
@@ -0,0 +1,27 @@
+-- Gates and the entry/exit events they record, correlated to bookings.
+
+CREATE TABLE IF NOT EXISTS gates (
+    id         uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    lot_id     uuid        NOT NULL REFERENCES parking_lots(id) ON DELETE CASCADE,
+    name       text        NOT NULL,
+    direction  text        NOT NULL CHECK (direction IN ('ENTRY', 'EXIT', 'BOTH')),
+    active     boolean     NOT NULL DEFAULT true,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    UNIQUE (lot_id, name)
+);
+
+CREATE TABLE IF NOT EXISTS gate_events (
+    id          uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    gate_id     uuid        NOT NULL REFERENCES gates(id) ON DELETE CASCADE,
+    lot_id      uuid        NOT NULL REFERENCES parking_lots(id) ON DELETE CASCADE,
+    direction   text        NOT NULL CHECK (direction IN ('ENTRY', 'EXIT')),
+    method      text        NOT NULL CHECK (method IN ('QR', 'ANPR', 'MANUAL')),
+    plate       text,
+    occurred_at timestamptz NOT NULL,
+    booking_id  uuid        REFERENCES bookings(id) ON DELETE SET NULL,
+    recorded_by uuid        REFERENCES users(id),
+    created_at  timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS gate_events_lot_time_idx ON gate_events (lot_id, occurred_at);
+CREATE INDEX IF NOT EXISTS gate_events_booking_idx ON gate_events (booking_id, direction);
+CREATE INDEX IF NOT EXISTS gate_events_plate_idx ON gate_events (plate, occurred_at);
@@ -0,0 +1,35 @@
+-- Licence-plate recognition cameras and the reads they post.
+
+CREATE TABLE IF NOT EXISTS anpr_cameras (
+    id         uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    gate_id    uuid        NOT NULL REFERENCES gates(id) ON DELETE CASCADE,
+    name       text        NOT NULL,
+    -- sha256 of the camera's API key; the key itself is shown once
+    key_hash   text        NOT NULL UNIQUE,
+    active     boolean     NOT NULL DEFAULT true,
+    created_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS anpr_reads (
+    id            uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    camera_id     uuid          NOT NULL REFERENCES anpr_cameras(id) ON DELETE CASCADE,
+    lot_id        uuid          NOT NULL REFERENCES parking_lots(id) ON DELETE CASCADE,
+    plate         text          NOT NULL,
+    confidence    numeric(4, 3) NOT NULL CHECK (confidence >= 0 AND confidence <= 1),
+    image_ref     text,
+    direction     text          NOT NULL CHECK (direction IN ('ENTRY', 'EXIT')),
+    captured_at   timestamptz   NOT NULL,
+    status        text          NOT NULL
+                  CHECK (status IN ('PROCESSED', 'DUPLICATE', 'REVIEW', 'RESOLVED', 'DISMISSED')),
+    action        text          NOT NULL DEFAULT 'NONE'
+                  CHECK (action IN ('NONE', 'STARTED', 'ENDED', 'EVENT_ONLY')),
+    review_reason text,
+    vehicle_id    uuid          REFERENCES vehicles(id) ON DELETE SET NULL,
+    booking_id    uuid          REFERENCES bookings(id) ON DELETE SET NULL,
+    gate_event_id uuid          REFERENCES gate_events(id) ON DELETE SET NULL,
+    reviewed_by   uuid          REFERENCES users(id),
+    reviewed_at   timestamptz,
+    created_at    timestamptz   NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS anpr_reads_review_idx ON anpr_reads (created_at) WHERE status = 'REVIEW';
+CREATE INDEX IF NOT EXISTS anpr_reads_dedupe_idx ON anpr_reads (camera_id, plate, captured_at);
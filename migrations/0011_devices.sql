@@ -0,0 +1,17 @@
+-- Field devices (bay sensors, barrier controllers) reporting over MQTT.
+
+-- What the bay sensor last saw, independent of the booking-driven status.
+ALTER TABLE parking_spots ADD COLUMN IF NOT EXISTS sensor_occupied boolean;
+ALTER TABLE parking_spots ADD COLUMN IF NOT EXISTS sensor_updated_at timestamptz;
+
+CREATE TABLE IF NOT EXISTS devices (
+    id           text PRIMARY KEY,
+    kind         text        NOT NULL CHECK (kind IN ('SENSOR', 'BARRIER', 'OTHER')),
+    lot_id       uuid        REFERENCES parking_lots(id) ON DELETE SET NULL,
+    spot_id      uuid        REFERENCES parking_spots(id) ON DELETE SET NULL,
+    gate_id      uuid        REFERENCES gates(id) ON DELETE SET NULL,
+    firmware     text,
+    last_seen_at timestamptz NOT NULL,
+    created_at   timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS devices_lot_idx ON devices (lot_id);
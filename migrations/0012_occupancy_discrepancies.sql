@@ -0,0 +1,35 @@
+-- Physical (sensor/gate) vs booking-derived occupancy reconciliation.
+
+-- Per-lot reconciliation policy. auto_close_empty_minutes, when set, ends an
+-- open booking once its bay has read empty (or the vehicle has exited) for
+-- that long; NULL only reports it.
+CREATE TABLE IF NOT EXISTS lot_occupancy_policies (
+    lot_id                   uuid PRIMARY KEY REFERENCES parking_lots(id) ON DELETE CASCADE,
+    auto_close_empty_minutes integer CHECK (auto_close_empty_minutes > 0),
+    updated_at               timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS occupancy_discrepancies (
+    id               uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    lot_id           uuid        NOT NULL REFERENCES parking_lots(id) ON DELETE CASCADE,
+    spot_id          uuid        NOT NULL REFERENCES parking_spots(id) ON DELETE CASCADE,
+    kind             text        NOT NULL
+                     CHECK (kind IN ('UNBOOKED_VEHICLE', 'EMPTY_OCCUPIED_SPOT', 'EXITED_OPEN_BOOKING')),
+    source           text        NOT NULL CHECK (source IN ('SENSOR', 'GATE')),
+    booking_id       uuid        REFERENCES bookings(id) ON DELETE SET NULL,
+    -- when the physical state that disagrees with the booking began
+    since            timestamptz NOT NULL,
+    detected_at      timestamptz NOT NULL DEFAULT now(),
+    last_seen_at     timestamptz NOT NULL DEFAULT now(),
+    acknowledged_at  timestamptz,
+    acknowledged_by  uuid        REFERENCES users(id),
+    note             text,
+    auto_close_error text,
+    resolved_at      timestamptz,
+    resolution       text        CHECK (resolution IN ('CLEARED', 'AUTO_CLOSED'))
+);
+-- one open discrepancy of each kind per spot
+CREATE UNIQUE INDEX IF NOT EXISTS occupancy_discrepancies_open_idx
+    ON occupancy_discrepancies (spot_id, kind) WHERE resolved_at IS NULL;
+CREATE INDEX IF NOT EXISTS occupancy_discrepancies_lot_idx
+    ON occupancy_discrepancies (lot_id, detected_at);
@@ -0,0 +1,35 @@
+-- Occupancy time series: raw per-level snapshots plus hourly and daily
+-- rollups kept for longer.
+
+CREATE TABLE IF NOT EXISTS occupancy_snapshots (
+    taken_at  timestamptz NOT NULL,
+    lot_id    uuid        NOT NULL REFERENCES parking_lots(id) ON DELETE CASCADE,
+    level_id  uuid        NOT NULL,
+    total     integer     NOT NULL,
+    available integer     NOT NULL,
+    occupied  integer     NOT NULL,
+    reserved  integer     NOT NULL,
+    held      integer     NOT NULL,
+    disabled  integer     NOT NULL,
+    PRIMARY KEY (lot_id, level_id, taken_at)
+);
+CREATE INDEX IF NOT EXISTS occupancy_snapshots_taken_idx ON occupancy_snapshots (taken_at);
+
+-- level_id is NULL on the row for the whole lot. Counts are averages over
+-- the bucket's snapshots; peaks are the busiest snapshot.
+CREATE TABLE IF NOT EXISTS occupancy_rollups (
+    granularity   text             NOT NULL CHECK (granularity IN ('HOUR', 'DAY')),
+    bucket_start  timestamptz      NOT NULL,
+    lot_id        uuid             NOT NULL REFERENCES parking_lots(id) ON DELETE CASCADE,
+    level_id      uuid,
+    samples       integer          NOT NULL,
+    total         double precision NOT NULL,
+    available     double precision NOT NULL,
+    occupied      double precision NOT NULL,
+    reserved      double precision NOT NULL,
+    held          double precision NOT NULL,
+    disabled      double precision NOT NULL,
+    peak_occupied integer          NOT NULL,
+    peak_rate     double precision NOT NULL,
+    UNIQUE NULLS NOT DISTINCT (granularity, lot_id, level_id, bucket_start)
+);
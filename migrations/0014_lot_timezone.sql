@@ -0,0 +1,2 @@
+-- Lots report in their own timezone.
+ALTER TABLE parking_lots ADD COLUMN IF NOT EXISTS timezone text NOT NULL DEFAULT 'Asia/Kolkata';
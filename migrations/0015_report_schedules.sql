@@ -0,0 +1,44 @@
+-- Recurring reports generated by the in-process scheduler.
+
+CREATE TABLE IF NOT EXISTS report_schedules (
+    id          uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    name        text        NOT NULL,
+    report      text        NOT NULL
+                CHECK (report IN ('DAILY_OCCUPANCY', 'WEEKLY_REVENUE', 'MONTHLY_INVOICE_REGISTER')),
+    -- five-field cron expression, evaluated in timezone
+    cron        text        NOT NULL,
+    timezone    text        NOT NULL DEFAULT 'Asia/Kolkata',
+    lot_id      uuid        REFERENCES parking_lots(id) ON DELETE CASCADE,
+    format      text        NOT NULL DEFAULT 'xlsx' CHECK (format IN ('csv', 'xlsx')),
+    channel     text        NOT NULL CHECK (channel IN ('EMAIL', 'WEBHOOK')),
+    recipients  text[]      NOT NULL DEFAULT '{}',
+    webhook_url text,
+    active      boolean     NOT NULL DEFAULT true,
+    next_run_at timestamptz,
+    created_by  uuid        REFERENCES users(id),
+    created_at  timestamptz NOT NULL DEFAULT now(),
+    updated_at  timestamptz NOT NULL DEFAULT now(),
+    CHECK (channel <> 'EMAIL' OR cardinality(recipients) > 0),
+    CHECK (channel <> 'WEBHOOK' OR webhook_url IS NOT NULL)
+);
+CREATE INDEX IF NOT EXISTS report_schedules_due_idx ON report_schedules (next_run_at) WHERE active;
+
+-- One row per run; scheduled_for is the cron time the run is for, so a run
+-- is recorded once however many replicas are up.
+CREATE TABLE IF NOT EXISTS report_runs (
+    id            uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    schedule_id   uuid        NOT NULL REFERENCES report_schedules(id) ON DELETE CASCADE,
+    scheduled_for timestamptz NOT NULL,
+    manual        boolean     NOT NULL DEFAULT false,
+    period_from   date        NOT NULL,
+    period_to     date        NOT NULL,
+    status        text        NOT NULL DEFAULT 'RUNNING'
+                  CHECK (status IN ('RUNNING', 'SUCCEEDED', 'FAILED')),
+    rows          integer,
+    bytes         integer,
+    error         text,
+    started_at    timestamptz NOT NULL DEFAULT now(),
+    finished_at   timestamptz,
+    UNIQUE (schedule_id, scheduled_for)
+);
+CREATE INDEX IF NOT EXISTS report_runs_schedule_idx ON report_runs (schedule_id, started_at DESC);
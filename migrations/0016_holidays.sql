@@ -0,0 +1,11 @@
+-- Public and local holidays, which occupancy forecasts treat as their own
+-- season. lot_id NULL applies to every lot.
+
+CREATE TABLE IF NOT EXISTS holidays (
+    id         uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    day        date        NOT NULL,
+    lot_id     uuid        REFERENCES parking_lots(id) ON DELETE CASCADE,
+    name       text        NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    UNIQUE NULLS NOT DISTINCT (day, lot_id)
+);
@@ -0,0 +1,52 @@
+-- Optional occupancy-driven pricing per lot. Multipliers are basis points
+-- of the lot's hourly rate (10000 = 1x).
+
+CREATE TABLE IF NOT EXISTS lot_pricing_policies (
+    lot_id            uuid PRIMARY KEY REFERENCES parking_lots(id) ON DELETE CASCADE,
+    enabled           boolean     NOT NULL DEFAULT false,
+    -- OCCUPANCY prices on spots in use now; FORECAST on the busier of now
+    -- and the forecast for the coming hour
+    basis             text        NOT NULL DEFAULT 'OCCUPANCY' CHECK (basis IN ('OCCUPANCY', 'FORECAST')),
+    min_multiplier_bp integer     NOT NULL DEFAULT 10000 CHECK (min_multiplier_bp > 0),
+    max_multiplier_bp integer     NOT NULL DEFAULT 20000,
+    quote_ttl_seconds integer     NOT NULL DEFAULT 600 CHECK (quote_ttl_seconds > 0),
+    updated_at        timestamptz NOT NULL DEFAULT now(),
+    CHECK (max_multiplier_bp >= min_multiplier_bp)
+);
+
+CREATE TABLE IF NOT EXISTS lot_pricing_steps (
+    lot_id        uuid    NOT NULL REFERENCES lot_pricing_policies(lot_id) ON DELETE CASCADE,
+    threshold_pct integer NOT NULL CHECK (threshold_pct BETWEEN 0 AND 100),
+    multiplier_bp integer NOT NULL CHECK (multiplier_bp > 0),
+    PRIMARY KEY (lot_id, threshold_pct)
+);
+
+-- A price offered by a quote, which a booking can claim until it expires.
+CREATE TABLE IF NOT EXISTS price_quotes (
+    id              uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    lot_id          uuid             NOT NULL REFERENCES parking_lots(id) ON DELETE CASCADE,
+    spot_id         uuid             NOT NULL REFERENCES parking_spots(id) ON DELETE CASCADE,
+    user_id         uuid             NOT NULL REFERENCES users(id),
+    basis           text             NOT NULL,
+    occupancy_pct   double precision NOT NULL,
+    multiplier_bp   integer          NOT NULL,
+    base_rate_paise bigint           NOT NULL,
+    rate_paise      bigint           NOT NULL,
+    created_at      timestamptz      NOT NULL DEFAULT now(),
+    expires_at      timestamptz      NOT NULL,
+    booking_id      uuid             REFERENCES bookings(id) ON DELETE SET NULL
+);
+
+-- Which multiplier each booking at a dynamically priced lot was charged.
+CREATE TABLE IF NOT EXISTS booking_pricing (
+    booking_id      uuid PRIMARY KEY REFERENCES bookings(id) ON DELETE CASCADE,
+    lot_id          uuid             NOT NULL REFERENCES parking_lots(id) ON DELETE CASCADE,
+    quote_id        uuid             REFERENCES price_quotes(id) ON DELETE SET NULL,
+    basis           text             NOT NULL,
+    occupancy_pct   double precision NOT NULL,
+    multiplier_bp   integer          NOT NULL,
+    base_rate_paise bigint           NOT NULL,
+    rate_paise      bigint           NOT NULL,
+    decided_at      timestamptz      NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS booking_pricing_lot_idx ON booking_pricing (lot_id, decided_at);
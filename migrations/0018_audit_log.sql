@@ -0,0 +1,37 @@
+-- Append-only record of who changed what: administrative changes and
+-- booking state changes, written in the same transaction as the change.
+
+CREATE TABLE IF NOT EXISTS audit_log (
+    id          bigserial PRIMARY KEY,
+    at          timestamptz NOT NULL DEFAULT now(),
+    -- NULL for unauthenticated callers (signup), cameras and background jobs
+    actor_id    uuid,
+    actor_role  text        NOT NULL,
+    action      text        NOT NULL,
+    entity_type text        NOT NULL,
+    entity_id   text        NOT NULL,
+    before      jsonb,
+    after       jsonb,
+    request_id  text,
+    ip          text
+);
+CREATE INDEX IF NOT EXISTS audit_log_entity_idx ON audit_log (entity_type, entity_id, at);
+CREATE INDEX IF NOT EXISTS audit_log_actor_idx ON audit_log (actor_id, at);
+CREATE INDEX IF NOT EXISTS audit_log_at_idx ON audit_log (at);
+CREATE INDEX IF NOT EXISTS audit_log_request_idx ON audit_log (request_id);
+
+CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_log_no_update ON audit_log;
+CREATE TRIGGER audit_log_no_update
+    BEFORE UPDATE OR DELETE ON audit_log
+    FOR EACH ROW EXECUTE FUNCTION audit_log_append_only();
+
+DROP TRIGGER IF EXISTS audit_log_no_truncate ON audit_log;
+CREATE TRIGGER audit_log_no_truncate
+    BEFORE TRUNCATE ON audit_log
+    FOR EACH STATEMENT EXECUTE FUNCTION audit_log_append_only();
@@ -0,0 +1,26 @@
+-- Domain events written in the transaction that causes them and delivered
+-- afterwards by the outbox dispatcher.
+
+CREATE TABLE IF NOT EXISTS outbox_events (
+    id              bigserial PRIMARY KEY,
+    type            text        NOT NULL,
+    aggregate_type  text        NOT NULL,
+    aggregate_id    text        NOT NULL,
+    data            jsonb       NOT NULL,
+    occurred_at     timestamptz NOT NULL DEFAULT now(),
+    -- sinks that have taken the event, so a retry skips them
+    delivered_to    text[]      NOT NULL DEFAULT '{}',
+    attempts        integer     NOT NULL DEFAULT 0,
+    next_attempt_at timestamptz NOT NULL DEFAULT now(),
+    -- set while a dispatcher holds the event; a lapsed lease frees it again
+    locked_until    timestamptz,
+    last_error      text,
+    dispatched_at   timestamptz,
+    -- set once the event has used its attempts; cleared by a manual retry
+    failed_at       timestamptz
+);
+CREATE INDEX IF NOT EXISTS outbox_events_pending_idx ON outbox_events (id)
+    WHERE dispatched_at IS NULL AND failed_at IS NULL;
+CREATE INDEX IF NOT EXISTS outbox_events_aggregate_idx ON outbox_events (aggregate_type, aggregate_id, id)
+    WHERE dispatched_at IS NULL;
+CREATE INDEX IF NOT EXISTS outbox_events_dispatched_idx ON outbox_events (dispatched_at);
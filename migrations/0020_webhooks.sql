@@ -0,0 +1,56 @@
+-- Outbound webhooks: partner subscriptions to domain events, one delivery
+-- per subscription and event, and a log of every attempt.
+
+CREATE TABLE IF NOT EXISTS webhook_subscriptions (
+    id          uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    name        text        NOT NULL,
+    url         text        NOT NULL,
+    -- HMAC-SHA256 key payloads are signed with; shown on create and rotate
+    secret      text        NOT NULL,
+    -- event types delivered; empty for all
+    event_types text[]      NOT NULL DEFAULT '{}',
+    active      boolean     NOT NULL DEFAULT true,
+    created_by  uuid        REFERENCES users(id),
+    created_at  timestamptz NOT NULL DEFAULT now(),
+    updated_at  timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id               uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    subscription_id  uuid        NOT NULL REFERENCES webhook_subscriptions(id) ON DELETE CASCADE,
+    event_id         bigint      NOT NULL,
+    event_type       text        NOT NULL,
+    -- the event as sent: {id, type, occurredAt, data}
+    payload          jsonb       NOT NULL,
+    -- the delivery this one replays, if any
+    replay_of        uuid        REFERENCES webhook_deliveries(id) ON DELETE SET NULL,
+    status           text        NOT NULL DEFAULT 'PENDING'
+                     CHECK (status IN ('PENDING', 'SUCCEEDED', 'DEAD')),
+    attempts         integer     NOT NULL DEFAULT 0,
+    next_attempt_at  timestamptz NOT NULL DEFAULT now(),
+    locked_until     timestamptz,
+    last_status_code integer,
+    last_error       text,
+    created_at       timestamptz NOT NULL DEFAULT now(),
+    delivered_at     timestamptz
+);
+-- the outbox delivers at least once; an event is queued once per subscription
+CREATE UNIQUE INDEX IF NOT EXISTS webhook_deliveries_event_idx
+    ON webhook_deliveries (subscription_id, event_id) WHERE replay_of IS NULL;
+CREATE INDEX IF NOT EXISTS webhook_deliveries_due_idx
+    ON webhook_deliveries (next_attempt_at) WHERE status = 'PENDING';
+CREATE INDEX IF NOT EXISTS webhook_deliveries_subscription_idx
+    ON webhook_deliveries (subscription_id, created_at DESC);
+
+CREATE TABLE IF NOT EXISTS webhook_attempts (
+    id           bigserial PRIMARY KEY,
+    delivery_id  uuid        NOT NULL REFERENCES webhook_deliveries(id) ON DELETE CASCADE,
+    attempted_at timestamptz NOT NULL DEFAULT now(),
+    -- NULL when no response came back
+    status_code  integer,
+    error        text,
+    duration_ms  integer     NOT NULL,
+    -- the start of the response body, for debugging
+    response     text
+);
+CREATE INDEX IF NOT EXISTS webhook_attempts_delivery_idx ON webhook_attempts (delivery_id, attempted_at);
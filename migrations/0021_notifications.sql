@@ -0,0 +1,55 @@
+-- User notifications: language and phone for each user, per-kind channel
+-- preferences, push tokens, and a queue of notifications to send.
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS locale text NOT NULL DEFAULT 'en';
+-- E.164, for SMS
+ALTER TABLE users ADD COLUMN IF NOT EXISTS phone text;
+
+CREATE TABLE IF NOT EXISTS notification_preferences (
+    user_id  uuid   NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    -- a notification kind, or DEFAULT for kinds without their own row
+    kind     text   NOT NULL,
+    -- EMAIL, SMS and/or PUSH; empty turns the kind off
+    channels text[] NOT NULL,
+    PRIMARY KEY (user_id, kind)
+);
+
+CREATE TABLE IF NOT EXISTS push_tokens (
+    token      text PRIMARY KEY,
+    user_id    uuid        NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    platform   text        NOT NULL CHECK (platform IN ('ANDROID', 'IOS', 'WEB')),
+    created_at timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS push_tokens_user_idx ON push_tokens (user_id);
+
+-- What has already been notified, e.g. booking:<id>:confirmed, so that
+-- events seen twice and reminder scans notify once, whatever the channels.
+CREATE TABLE IF NOT EXISTS notification_triggers (
+    dedupe_key text PRIMARY KEY,
+    user_id    uuid        NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    kind       text        NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS notifications (
+    id              uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id         uuid        NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    dedupe_key      text        NOT NULL REFERENCES notification_triggers(dedupe_key) ON DELETE CASCADE,
+    kind            text        NOT NULL,
+    channel         text        NOT NULL CHECK (channel IN ('EMAIL', 'SMS', 'PUSH')),
+    -- email address, phone number or push token
+    destination     text        NOT NULL,
+    locale          text        NOT NULL,
+    subject         text        NOT NULL,
+    body            text        NOT NULL,
+    status          text        NOT NULL DEFAULT 'PENDING'
+                    CHECK (status IN ('PENDING', 'SENT', 'FAILED')),
+    attempts        integer     NOT NULL DEFAULT 0,
+    next_attempt_at timestamptz NOT NULL DEFAULT now(),
+    locked_until    timestamptz,
+    last_error      text,
+    created_at      timestamptz NOT NULL DEFAULT now(),
+    sent_at         timestamptz
+);
+CREATE INDEX IF NOT EXISTS notifications_due_idx ON notifications (next_attempt_at) WHERE status = 'PENDING';
+CREATE INDEX IF NOT EXISTS notifications_user_idx ON notifications (user_id, created_at DESC);
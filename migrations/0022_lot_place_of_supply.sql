@@ -0,0 +1,5 @@
+-- Parking is supplied where the lot is (IGST Act s.12(3)), which need not be
+-- the state its GSTIN was issued in.
+ALTER TABLE lot_billing_profiles ADD COLUMN IF NOT EXISTS lot_state_code char(2);
+UPDATE lot_billing_profiles SET lot_state_code = state_code WHERE lot_state_code IS NULL;
+ALTER TABLE lot_billing_profiles ALTER COLUMN lot_state_code SET NOT NULL;
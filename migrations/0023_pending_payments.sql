@@ -0,0 +1,29 @@
+-- Payments and refunds are recorded as PENDING, with an idempotency key, in
+-- the transaction that owes them, and only sent to the provider once that
+-- has committed. A background job retries those still PENDING.
+
+ALTER TABLE payments ALTER COLUMN provider_ref DROP NOT NULL;
+ALTER TABLE payments
+    ADD COLUMN IF NOT EXISTS idempotency_key text        NOT NULL DEFAULT gen_random_uuid()::text,
+    ADD COLUMN IF NOT EXISTS attempts        integer     NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS next_attempt_at timestamptz NOT NULL DEFAULT now(),
+    ADD COLUMN IF NOT EXISTS locked_until    timestamptz,
+    ADD COLUMN IF NOT EXISTS last_error      text;
+ALTER TABLE payments DROP CONSTRAINT IF EXISTS payments_status_check;
+ALTER TABLE payments ADD CONSTRAINT payments_status_check
+    CHECK (status IN ('PENDING', 'CAPTURED', 'PARTIALLY_REFUNDED', 'REFUNDED', 'FAILED'));
+CREATE UNIQUE INDEX IF NOT EXISTS payments_idempotency_key_idx ON payments (idempotency_key);
+CREATE INDEX IF NOT EXISTS payments_pending_idx ON payments (next_attempt_at) WHERE status = 'PENDING';
+
+-- status is PENDING, FAILED or the provider's status (e.g. SUCCEEDED);
+-- a PENDING refund's amount already counts in payments.refunded_paise, and
+-- is taken off again if it fails
+ALTER TABLE refunds ALTER COLUMN provider_ref DROP NOT NULL;
+ALTER TABLE refunds
+    ADD COLUMN IF NOT EXISTS idempotency_key text        NOT NULL DEFAULT gen_random_uuid()::text,
+    ADD COLUMN IF NOT EXISTS attempts        integer     NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS next_attempt_at timestamptz NOT NULL DEFAULT now(),
+    ADD COLUMN IF NOT EXISTS locked_until    timestamptz,
+    ADD COLUMN IF NOT EXISTS last_error      text;
+CREATE UNIQUE INDEX IF NOT EXISTS refunds_idempotency_key_idx ON refunds (idempotency_key);
+CREATE INDEX IF NOT EXISTS refunds_pending_idx ON refunds (next_attempt_at) WHERE status = 'PENDING';
@@ -0,0 +1,3 @@
+-- Webhook attempts keep the status code only; response bodies are dropped.
+
+ALTER TABLE webhook_attempts DROP COLUMN IF EXISTS response;
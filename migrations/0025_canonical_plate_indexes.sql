@@ -0,0 +1,15 @@
+-- Plate lookups compare plates in their canonical form (letters and digits
+-- only, uppercase, O read as 0 and I as 1; anpr.CanonicalSQL). These
+-- expression indexes let those lookups use an index instead of scanning.
+-- The expressions must stay identical to anpr.CanonicalSQL for the planner
+-- to use them.
+
+CREATE INDEX IF NOT EXISTS vehicles_plate_canonical_idx
+    ON vehicles ((translate(regexp_replace(upper(plate), '[^A-Z0-9]', '', 'g'), 'OI', '01')));
+
+-- walk-in tickets, which carry a plate instead of a vehicle
+CREATE INDEX IF NOT EXISTS bookings_plate_canonical_idx
+    ON bookings ((translate(regexp_replace(upper(plate), '[^A-Z0-9]', '', 'g'), 'OI', '01')))
+    WHERE vehicle_id IS NULL;
+
+CREATE INDEX IF NOT EXISTS bookings_vehicle_idx ON bookings (vehicle_id, start_time DESC);
@@ -0,0 +1,11 @@
+-- One-time tickets for opening occupancy streams. Browsers cannot set an
+-- Authorization header on EventSource/WebSocket, so they trade their JWT for
+-- a ticket (kept here only as a sha256 hash) and pass it as ?ticket=.
+CREATE TABLE IF NOT EXISTS stream_tickets (
+    token_hash text        PRIMARY KEY,
+    user_id    uuid        NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    email      text        NOT NULL,
+    role       text        NOT NULL,
+    expires_at timestamptz NOT NULL
+);
+CREATE INDEX IF NOT EXISTS stream_tickets_expires_idx ON stream_tickets (expires_at);
@@ -0,0 +1,6 @@
+-- FORECAST-priced lots keep their latest forecast here, refreshed by a
+-- background job, so a booking prices from it without rebuilding the
+-- forecast from booking history inside its transaction.
+ALTER TABLE lot_pricing_policies
+    ADD COLUMN IF NOT EXISTS forecast_occupied double precision,
+    ADD COLUMN IF NOT EXISTS forecast_at       timestamptz;
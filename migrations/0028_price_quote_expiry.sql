@@ -0,0 +1,2 @@
+-- Unused quotes are deleted a day after they expire (handler.PruneQuotes).
+CREATE INDEX IF NOT EXISTS price_quotes_unused_expiry_idx ON price_quotes (expires_at) WHERE booking_id IS NULL;
@@ -0,0 +1,9 @@
+-- How far each rollup granularity has been built: the newest snapshot it
+-- has folded in. The rollup job rebuilds from the bucket holding it (or
+-- from the oldest raw snapshot kept, when it is unset), so rollups catch up
+-- after the job has been down. Like occupancy_rollups' unique key
+-- (UNIQUE NULLS NOT DISTINCT), this needs PostgreSQL 15 or later.
+CREATE TABLE IF NOT EXISTS occupancy_rollup_state (
+    granularity text        PRIMARY KEY CHECK (granularity IN ('HOUR', 'DAY')),
+    rolled_to   timestamptz
+);
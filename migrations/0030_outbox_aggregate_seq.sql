@@ -0,0 +1,29 @@
+-- Events are ordered within their aggregate by aggregate_seq, taken from
+-- outbox_sequences in the writing transaction. Taking it locks the
+-- aggregate's counter until that transaction ends, so a later number always
+-- commits later; ids come from a sequence and can commit out of order.
+-- Counters are small (one row per aggregate) and are kept.
+
+CREATE TABLE IF NOT EXISTS outbox_sequences (
+    aggregate_type text   NOT NULL,
+    aggregate_id   text   NOT NULL,
+    last_seq       bigint NOT NULL,
+    PRIMARY KEY (aggregate_type, aggregate_id)
+);
+
+ALTER TABLE outbox_events ADD COLUMN IF NOT EXISTS aggregate_seq bigint;
+UPDATE outbox_events e SET aggregate_seq = n.seq
+FROM (
+    SELECT id, row_number() OVER (PARTITION BY aggregate_type, aggregate_id ORDER BY id) AS seq
+    FROM outbox_events
+) n
+WHERE n.id = e.id AND e.aggregate_seq IS NULL;
+ALTER TABLE outbox_events ALTER COLUMN aggregate_seq SET NOT NULL;
+
+INSERT INTO outbox_sequences (aggregate_type, aggregate_id, last_seq)
+SELECT aggregate_type, aggregate_id, MAX(aggregate_seq) FROM outbox_events GROUP BY aggregate_type, aggregate_id
+ON CONFLICT (aggregate_type, aggregate_id) DO UPDATE SET last_seq = GREATEST(outbox_sequences.last_seq, EXCLUDED.last_seq);
+
+DROP INDEX IF EXISTS outbox_events_aggregate_idx;
+CREATE INDEX IF NOT EXISTS outbox_events_aggregate_idx ON outbox_events (aggregate_type, aggregate_id, aggregate_seq)
+    WHERE dispatched_at IS NULL;